@@ -16,7 +16,10 @@ type ContactsDeprecated struct {
 
 // SetBSON to unmarshal BSON in a Person struct while migrating old objects into new person struct
 func (p *Person) SetBSON(raw bson.Raw) error {
-	return bsonDecodeNewPersonStruct(p, raw)
+	if err := bsonDecodeNewPersonStruct(p, raw); err != nil {
+		return err
+	}
+	return p.decryptFields()
 }
 
 func bsonDecodeOldPersonStruct(p *Person, raw bson.Raw) error {
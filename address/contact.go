@@ -5,6 +5,8 @@ type Contact struct {
 	ExternalID string
 	Type       ContactType
 	Value      string
+	// Display is the formatted value for humans, e.g. +41 79 123 45 67 for the phone number +41791234567
+	Display string
 }
 
 // GetContactByType will search for given contact type
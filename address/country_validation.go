@@ -0,0 +1,190 @@
+package address
+
+import (
+	"strconv"
+	"strings"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+const (
+	FieldErrorCodeRequired       FieldErrorCode = "required"
+	FieldErrorCodeInvalidFormat  FieldErrorCode = "invalidFormat"
+	FieldErrorCodeUnknownCountry FieldErrorCode = "unknownCountry"
+	FieldErrorCodeImplausible    FieldErrorCode = "implausible"
+)
+
+// Names of the validated fields
+const (
+	FieldStreet       = "Street"
+	FieldStreetNumber = "StreetNumber"
+	FieldZIP          = "ZIP"
+	FieldCity         = "City"
+	FieldCountry      = "Country"
+	FieldCountryCode  = "CountryCode"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type FieldErrorCode string
+
+// FieldError describes why a field of an address is invalid
+type FieldError struct {
+	Field   string
+	Code    FieldErrorCode
+	Message string
+}
+
+// ValidationError is returned by Check, if an address has invalid fields
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+func (e *FieldError) Error() string {
+	return "address " + strings.ToLower(e.Field) + ": " + e.Message
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// GetFieldErrors returns the errors of field
+func (e *ValidationError) GetFieldErrors(field string) []*FieldError {
+	fieldErrors := []*FieldError{}
+	for _, fieldError := range e.Errors {
+		if fieldError.Field == field {
+			fieldErrors = append(fieldErrors, fieldError)
+		}
+	}
+	return fieldErrors
+}
+
+// Normalize trims all fields, sets Country and CountryCode to the ISO 3166-1 alpha-2 code, removes country prefixes
+// and spaces from the postal code and splits the number from the street, if StreetNumber is empty.
+// Fields with unknown values are not changed.
+func (addr *Address) Normalize() {
+	if addr == nil {
+		return
+	}
+	addr.TrimSpace()
+	countryCode, countryCodeOk := GetCountryCode(addr.CountryCode)
+	country, countryOk := GetCountryCode(addr.Country)
+	if countryCodeOk {
+		addr.CountryCode = countryCode
+	}
+	if countryOk {
+		addr.Country = country
+	}
+	if addr.CountryCode == "" && countryOk {
+		addr.CountryCode = country
+	}
+	if addr.Country == "" && countryCodeOk {
+		addr.Country = countryCode
+	}
+	addr.ZIP = NormalizePostalCode(addr.CountryCode, addr.ZIP)
+	if addr.StreetNumber == "" && addr.Street != "" {
+		addr.Street, addr.StreetNumber = SplitStreet(addr.Street, addr.CountryCode)
+	}
+}
+
+// Validate returns the errors of all invalid fields. Postal code formats and the plausibility of postal code and city
+// are only checked for supported countries. Call Normalize before, or use Check.
+func (addr *Address) Validate() []*FieldError {
+	fieldErrors := []*FieldError{}
+	add := func(field string, code FieldErrorCode, message string) {
+		fieldErrors = append(fieldErrors, &FieldError{Field: field, Code: code, Message: message})
+	}
+	if addr == nil {
+		add(FieldCountryCode, FieldErrorCodeRequired, "address is nil")
+		return fieldErrors
+	}
+	// a post office box replaces the street
+	if addr.PostOfficeBox == "" {
+		if addr.Street == "" {
+			add(FieldStreet, FieldErrorCodeRequired, "street is empty")
+		}
+		if addr.StreetNumber == "" {
+			add(FieldStreetNumber, FieldErrorCodeRequired, "street number is empty")
+		}
+	}
+	if addr.City == "" {
+		add(FieldCity, FieldErrorCodeRequired, "city is empty")
+	}
+
+	countryCode, countryCodeOk := GetCountryCode(addr.CountryCode)
+	country, countryOk := GetCountryCode(addr.Country)
+	switch {
+	case addr.CountryCode == "":
+		add(FieldCountryCode, FieldErrorCodeRequired, "country code is empty")
+	case !countryCodeOk || len(addr.CountryCode) != 2:
+		add(FieldCountryCode, FieldErrorCodeUnknownCountry, "unknown country code "+addr.CountryCode)
+	}
+	switch {
+	case addr.Country == "":
+		add(FieldCountry, FieldErrorCodeRequired, "country is empty")
+	case !countryOk:
+		add(FieldCountry, FieldErrorCodeUnknownCountry, "unknown country "+addr.Country)
+	case countryCodeOk && country != countryCode:
+		add(FieldCountry, FieldErrorCodeImplausible, "country "+addr.Country+" does not match country code "+addr.CountryCode)
+	}
+
+	if addr.ZIP == "" {
+		add(FieldZIP, FieldErrorCodeRequired, "zip is empty")
+		return fieldErrors
+	}
+	format, ok := postalCodeFormats[countryCode]
+	if !countryCodeOk || !ok {
+		return fieldErrors
+	}
+	postalCode, ok := parsePostalCode(format, addr.ZIP)
+	if !ok {
+		add(FieldZIP, FieldErrorCodeInvalidFormat, "zip "+addr.ZIP+" must have "+strconv.Itoa(format.Digits)+" digits in "+countryCode)
+		return fieldErrors
+	}
+	if postalCode < format.Min || postalCode > format.Max || isLiechtensteinPostalCode(countryCode, postalCode) {
+		add(FieldZIP, FieldErrorCodeImplausible, "zip "+addr.ZIP+" does not exist in "+countryCode)
+		return fieldErrors
+	}
+	if addr.City != "" {
+		if ok, expected := isPlausibleCity(countryCode, postalCode, addr.City); !ok {
+			message := "city " + addr.City + " does not match zip " + addr.ZIP
+			if len(expected) > 0 {
+				message += ", expected " + strings.Join(expected, " or ")
+			}
+			add(FieldCity, FieldErrorCodeImplausible, message)
+		}
+	}
+	return fieldErrors
+}
+
+// Check normalizes and validates the address. It returns a *ValidationError, if fields are invalid.
+func (addr *Address) Check() error {
+	addr.Normalize()
+	fieldErrors := addr.Validate()
+	if len(fieldErrors) > 0 {
+		return &ValidationError{Errors: fieldErrors}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// isLiechtensteinPostalCode returns true for Swiss postal codes, which belong to Liechtenstein
+func isLiechtensteinPostalCode(countryCode string, postalCode int) bool {
+	lf := postalCodeFormats[CountryCodeLiechtenstein]
+	return countryCode == CountryCodeSwitzerland && postalCode >= lf.Min && postalCode <= lf.Max
+}
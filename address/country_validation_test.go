@@ -0,0 +1,202 @@
+package address
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getValidAddress() *Address {
+	return &Address{
+		Street:       "Bahnhofstrasse",
+		StreetNumber: "12",
+		ZIP:          "8001",
+		City:         "Zürich",
+		Country:      "Schweiz",
+		CountryCode:  "ch",
+	}
+}
+
+func getFieldErrorCodes(fieldErrors []*FieldError) map[string]FieldErrorCode {
+	codes := map[string]FieldErrorCode{}
+	for _, fieldError := range fieldErrors {
+		codes[fieldError.Field] = fieldError.Code
+	}
+	return codes
+}
+
+func TestGetCountryCode(t *testing.T) {
+	for country, expected := range map[string]string{
+		"Schweiz":                  CountryCodeSwitzerland,
+		"Suisse":                   CountryCodeSwitzerland,
+		"CHE":                      CountryCodeSwitzerland,
+		"Österreich":               CountryCodeAustria,
+		"Allemagne":                CountryCodeGermany,
+		"Italia":                   CountryCodeItaly,
+		"france":                   CountryCodeFrance,
+		"Fürstentum Liechtenstein": CountryCodeLiechtenstein,
+		"nl":                       "NL",
+	} {
+		code, ok := GetCountryCode(country)
+		assert.True(t, ok, country)
+		assert.Equal(t, expected, code, country)
+	}
+	for _, country := range []string{"Atlantis", "ZZ", "xx"} {
+		_, ok := GetCountryCode(country)
+		assert.False(t, ok, country)
+	}
+}
+
+func TestSplitStreet(t *testing.T) {
+	for _, test := range []struct {
+		street, countryCode, name, number string
+	}{
+		{"Bahnhofstrasse 12a", CountryCodeSwitzerland, "Bahnhofstrasse", "12a"},
+		{"Hauptstraße 12 / 3", CountryCodeGermany, "Hauptstraße", "12/3"},
+		{"Strasse des 17. Juni 5", CountryCodeGermany, "Strasse des 17. Juni", "5"},
+		{"Via Roma, 12", CountryCodeItaly, "Via Roma", "12"},
+		{"12 bis rue de Rivoli", CountryCodeFrance, "rue de Rivoli", "12 bis"},
+		{"5, avenue des Champs-Élysées", CountryCodeFrance, "avenue des Champs-Élysées", "5"},
+		{"Rue du Rhône 48", CountryCodeFrance, "Rue du Rhône", "48"},
+		{"Dorfplatz", CountryCodeAustria, "Dorfplatz", ""},
+	} {
+		name, number := SplitStreet(test.street, test.countryCode)
+		assert.Equal(t, test.name, name, test.street)
+		assert.Equal(t, test.number, number, test.street)
+	}
+}
+
+func TestAddressNormalize(t *testing.T) {
+	addr := &Address{
+		Street:      " Mariahilfer Straße 3/5 ",
+		ZIP:         "A-1060",
+		City:        "Wien",
+		Country:     "Österreich",
+		CountryCode: "",
+	}
+	addr.Normalize()
+	assert.Equal(t, "Mariahilfer Straße", addr.Street)
+	assert.Equal(t, "3/5", addr.StreetNumber)
+	assert.Equal(t, "1060", addr.ZIP)
+	assert.Equal(t, CountryCodeAustria, addr.Country)
+	assert.Equal(t, CountryCodeAustria, addr.CountryCode)
+	assert.Empty(t, addr.Validate())
+}
+
+func TestAddressCheck(t *testing.T) {
+	assert.NoError(t, getValidAddress().Check())
+
+	addr := getValidAddress()
+	addr.ZIP = "CH-8 001"
+	addr.City = "zurich"
+	assert.NoError(t, addr.Check())
+	assert.Equal(t, "8001", addr.ZIP)
+
+	addr = &Address{PostOfficeBox: "Postfach 1234", ZIP: "9000", City: "Sankt Gallen", CountryCode: "CH"}
+	assert.NoError(t, addr.Check())
+
+	err := (&Address{}).Check()
+	validationError, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]FieldErrorCode{
+		FieldStreet:       FieldErrorCodeRequired,
+		FieldStreetNumber: FieldErrorCodeRequired,
+		FieldZIP:          FieldErrorCodeRequired,
+		FieldCity:         FieldErrorCodeRequired,
+		FieldCountry:      FieldErrorCodeRequired,
+		FieldCountryCode:  FieldErrorCodeRequired,
+	}, getFieldErrorCodes(validationError.Errors))
+}
+
+func TestAddressValidateCountry(t *testing.T) {
+	addr := getValidAddress()
+	addr.Country = "Atlantis"
+	addr.CountryCode = "XXX"
+	addr.Normalize()
+	assert.Equal(t, map[string]FieldErrorCode{
+		FieldCountry:     FieldErrorCodeUnknownCountry,
+		FieldCountryCode: FieldErrorCodeUnknownCountry,
+	}, getFieldErrorCodes(addr.Validate()))
+
+	addr = getValidAddress()
+	addr.Country = "ZZ"
+	addr.CountryCode = "ZZ"
+	addr.Normalize()
+	assert.Equal(t, map[string]FieldErrorCode{
+		FieldCountry:     FieldErrorCodeUnknownCountry,
+		FieldCountryCode: FieldErrorCodeUnknownCountry,
+	}, getFieldErrorCodes(addr.Validate()))
+
+	addr = getValidAddress()
+	addr.Country = "Deutschland"
+	addr.Normalize()
+	assert.Equal(t, map[string]FieldErrorCode{
+		FieldCountry: FieldErrorCodeImplausible,
+	}, getFieldErrorCodes(addr.Validate()))
+
+	// postal codes of unsupported countries are not checked
+	addr = &Address{Street: "Damrak", StreetNumber: "1", ZIP: "1012 LG", City: "Amsterdam", Country: "NL", CountryCode: "NL"}
+	assert.NoError(t, addr.Check())
+}
+
+func TestAddressValidatePostalCode(t *testing.T) {
+	for _, test := range []struct {
+		countryCode, zip, city string
+		expected               map[string]FieldErrorCode
+	}{
+		{CountryCodeGermany, "10115", "Berlin", map[string]FieldErrorCode{}},
+		{CountryCodeGermany, "D-80331", "München", map[string]FieldErrorCode{}},
+		{CountryCodeGermany, "60311", "Frankfurt", map[string]FieldErrorCode{}},
+		{CountryCodeGermany, "1011", "Berlin", map[string]FieldErrorCode{FieldZIP: FieldErrorCodeInvalidFormat}},
+		{CountryCodeGermany, "00999", "Dresden", map[string]FieldErrorCode{FieldZIP: FieldErrorCodeImplausible}},
+		{CountryCodeGermany, "10115", "Hamburg", map[string]FieldErrorCode{FieldCity: FieldErrorCodeImplausible}},
+		{CountryCodeGermany, "01067", "Berlin", map[string]FieldErrorCode{FieldCity: FieldErrorCodeImplausible}},
+		{CountryCodeGermany, "01067", "Dresden", map[string]FieldErrorCode{}},
+		{CountryCodeSwitzerland, "1201", "Genf", map[string]FieldErrorCode{}},
+		{CountryCodeSwitzerland, "9490", "Vaduz", map[string]FieldErrorCode{FieldZIP: FieldErrorCodeImplausible}},
+		{CountryCodeLiechtenstein, "FL-9490", "Vaduz", map[string]FieldErrorCode{}},
+		{CountryCodeLiechtenstein, "8001", "Zürich", map[string]FieldErrorCode{FieldZIP: FieldErrorCodeImplausible}},
+		{CountryCodeAustria, "1010", "Wien", map[string]FieldErrorCode{}},
+		{CountryCodeAustria, "10100", "Wien", map[string]FieldErrorCode{FieldZIP: FieldErrorCodeInvalidFormat}},
+		{CountryCodeAustria, "6020", "Innsbruck", map[string]FieldErrorCode{}},
+		{CountryCodeAustria, "6060", "Innsbruck", map[string]FieldErrorCode{FieldCity: FieldErrorCodeImplausible}},
+		{CountryCodeFrance, "75008", "Paris", map[string]FieldErrorCode{}},
+		{CountryCodeFrance, "75008", "Lyon", map[string]FieldErrorCode{FieldCity: FieldErrorCodeImplausible}},
+		{CountryCodeFrance, "67100", "Strasbourg", map[string]FieldErrorCode{}},
+		{CountryCodeFrance, "67120", "Strasbourg", map[string]FieldErrorCode{FieldCity: FieldErrorCodeImplausible}},
+		{CountryCodeItaly, "00184", "Roma", map[string]FieldErrorCode{}},
+		{CountryCodeItaly, "20121", "Mailand", map[string]FieldErrorCode{}},
+		{CountryCodeItaly, "2012a", "Milano", map[string]FieldErrorCode{FieldZIP: FieldErrorCodeInvalidFormat}},
+	} {
+		addr := &Address{Street: "Teststrasse 1", ZIP: test.zip, City: test.city, CountryCode: test.countryCode}
+		addr.Normalize()
+		assert.Equal(t, test.expected, getFieldErrorCodes(addr.Validate()), test.countryCode+" "+test.zip+" "+test.city)
+	}
+}
+
+func TestLoadPostalCodeRanges(t *testing.T) {
+	file, err := ioutil.TempFile("", "postal-codes")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("# country;from;to;city\nDE;01067;01328;Dresden\n")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	defer func(ranges []*PostalCodeRange) {
+		postalCodeRanges = ranges
+	}(postalCodeRanges)
+	assert.NoError(t, LoadPostalCodeRanges(file.Name()))
+
+	addr := &Address{Street: "Prager Straße 2", ZIP: "01069", City: "Berlin", CountryCode: "DE"}
+	err = addr.Check()
+	assert.Error(t, err)
+	assert.Equal(t, FieldErrorCodeImplausible, err.(*ValidationError).GetFieldErrors(FieldCity)[0].Code)
+
+	addr.City = "Dresden"
+	assert.NoError(t, addr.Check())
+
+	assert.NoError(t, ioutil.WriteFile(file.Name(), []byte("DE;01067\n"), 0600))
+	assert.Error(t, LoadPostalCodeRanges(file.Name()))
+}
@@ -0,0 +1,85 @@
+package address
+
+import (
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/crypto"
+)
+
+// Personal data of addresses, persons and contacts is encrypted, if crypto.SetFieldEncryption has been called.
+// Types, country and ids are stored in plaintext.
+
+// bsonKindNull is the bson type of null values
+const bsonKindNull = 0x0A
+
+type addressDocument Address
+type personDocument Person
+type contactDocument Contact
+
+// GetBSON implements bson.Getter
+func (address *Address) GetBSON() (interface{}, error) {
+	if address == nil {
+		return nil, nil
+	}
+	if crypto.GetFieldEncryption() == nil {
+		return (*addressDocument)(address), nil
+	}
+	document := addressDocument(*address)
+	err := crypto.EncryptFields(&document.Street, &document.StreetNumber, &document.ZIP, &document.City, &document.Company, &document.Department, &document.Building, &document.PostOfficeBox)
+	return &document, err
+}
+
+// SetBSON implements bson.Setter
+func (address *Address) SetBSON(raw bson.Raw) error {
+	if raw.Kind == bsonKindNull {
+		return bson.SetZero
+	}
+	document := (*addressDocument)(address)
+	if err := raw.Unmarshal(document); err != nil {
+		return err
+	}
+	return crypto.DecryptFields(&document.Street, &document.StreetNumber, &document.ZIP, &document.City, &document.Company, &document.Department, &document.Building, &document.PostOfficeBox)
+}
+
+// GetBSON implements bson.Getter
+func (person *Person) GetBSON() (interface{}, error) {
+	if person == nil {
+		return nil, nil
+	}
+	if crypto.GetFieldEncryption() == nil {
+		return (*personDocument)(person), nil
+	}
+	document := personDocument(*person)
+	err := crypto.EncryptFields(&document.FirstName, &document.MiddleName, &document.LastName, &document.Birthday)
+	return &document, err
+}
+
+// decryptFields decrypts the personal data after decoding, see SetBSON
+func (person *Person) decryptFields() error {
+	return crypto.DecryptFields(&person.FirstName, &person.MiddleName, &person.LastName, &person.Birthday)
+}
+
+// GetBSON implements bson.Getter
+func (contact *Contact) GetBSON() (interface{}, error) {
+	if contact == nil {
+		return nil, nil
+	}
+	if crypto.GetFieldEncryption() == nil {
+		return (*contactDocument)(contact), nil
+	}
+	document := contactDocument(*contact)
+	err := crypto.EncryptFields(&document.Value, &document.Display)
+	return &document, err
+}
+
+// SetBSON implements bson.Setter
+func (contact *Contact) SetBSON(raw bson.Raw) error {
+	if raw.Kind == bsonKindNull {
+		return bson.SetZero
+	}
+	document := (*contactDocument)(contact)
+	if err := raw.Unmarshal(document); err != nil {
+		return err
+	}
+	return crypto.DecryptFields(&document.Value, &document.Display)
+}
@@ -7,7 +7,8 @@ func (c *Contact) IsPhone() bool {
 	return c.Type == ContactTypePhone || c.Type == ContactTypePhoneMobile || c.Type == ContactTypePhoneLandline
 }
 
-// CreatePhoneContact will return a phone contact for given phone number, a unique ID is generated automatically
+// CreatePhoneContact will return a phone contact for given phone number, a unique ID is generated automatically.
+// The number is stored as given, use CreatePhoneNumberContact to validate it.
 func CreatePhoneContact(phone string) *Contact {
 	return &Contact{
 		ID:    unique.GetNewIDShortID(),
@@ -17,6 +18,7 @@ func CreatePhoneContact(phone string) *Contact {
 }
 
 // SetPhone will set or replace the default phone. If no default phone is present it will replace first phone contact. Otherwise a new default phone contact is created.
+// The number is stored as given, use SetPhoneNumber or Address.SetPhoneNumber to validate it.
 func (p *Person) SetPhone(phone string) *Contact {
 	contact := p.GetContactByType(ContactTypePhone)
 	if contact == nil {
@@ -34,6 +36,48 @@ func (p *Person) SetPhone(phone string) *Contact {
 	return contact
 }
 
+// CreatePhoneNumberContact parses phone with ParsePhoneNumber and returns a mobile, landline or phone contact
+// with the E.164 number as value, a unique ID is generated automatically
+func CreatePhoneNumberContact(phone string, defaultCountry string) (*Contact, error) {
+	number, err := ParsePhoneNumber(phone, defaultCountry)
+	if err != nil {
+		return nil, err
+	}
+	return &Contact{
+		ID:      unique.GetNewIDShortID(),
+		Type:    number.Type,
+		Value:   number.E164(),
+		Display: number.Display(),
+	}, nil
+}
+
+// SetPhoneNumber parses phone with ParsePhoneNumber and sets or replaces the default contact of its type (mobile, landline or phone).
+// Invalid numbers are rejected and do not change the person.
+func (p *Person) SetPhoneNumber(phone string, defaultCountry string) (*Contact, error) {
+	number, err := ParsePhoneNumber(phone, defaultCountry)
+	if err != nil {
+		return nil, err
+	}
+	contact := p.GetContactByType(number.Type)
+	if contact == nil {
+		if p.Contacts == nil {
+			p.Contacts = map[string]*Contact{}
+		}
+		if p.DefaultContacts == nil {
+			p.DefaultContacts = map[ContactType]string{}
+		}
+		contact = &Contact{
+			ID:   unique.GetNewIDShortID(),
+			Type: number.Type,
+		}
+		p.Contacts[contact.ID] = contact
+		p.DefaultContacts[number.Type] = contact.ID
+	}
+	contact.Value = number.E164()
+	contact.Display = number.Display()
+	return contact, nil
+}
+
 // GetPhoneNumber will return the default phone number or an empty string if not yet set
 func (p *Person) GetPhoneNumber() string {
 	phone := p.GetPhoneContact()
@@ -0,0 +1,308 @@
+package address
+
+import (
+	"errors"
+	"strings"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+const (
+	e164Prefix            = "+"
+	e164MaxDigits         = 15
+	internationalPrefix   = "00"
+	minNationalNumberSize = 4
+)
+
+var (
+	ErrorInvalidPhoneNumber = errors.New("invalid phone number")
+	ErrorPhoneNumberRegion  = errors.New("phone number has no country calling code and no default country")
+)
+
+// phoneNumberRules contains the numbering plans of the supported countries
+var phoneNumberRules = map[string]*phoneNumberRule{
+	CountryCodeSwitzerland: {
+		CallingCode:  "41",
+		TrunkPrefix:  "0",
+		MinLength:    9,
+		MaxLength:    9,
+		Mobile:       []string{"75", "76", "77", "78", "79"},
+		Landline:     []string{"2", "3", "4", "5", "6", "71", "81", "91"},
+		Service:      []string{"800", "84", "90"},
+		MobileGroups: []int{2, 3, 2, 2},
+		Groups:       []int{2, 3, 2, 2},
+	},
+	CountryCodeLiechtenstein: {
+		CallingCode:  "423",
+		MinLength:    7,
+		MaxLength:    9,
+		Mobile:       []string{"6", "7"},
+		Landline:     []string{"2", "3"},
+		Service:      []string{"8", "9"},
+		MobileGroups: []int{3, 2, 2},
+		Groups:       []int{3, 2, 2},
+	},
+	CountryCodeGermany: {
+		CallingCode:     "49",
+		TrunkPrefix:     "0",
+		MinLength:       6,
+		MaxLength:       11,
+		MobileMinLength: 10,
+		Mobile:          []string{"15", "16", "17"},
+		Landline:        []string{"2", "3", "4", "5", "6", "7", "8", "9"},
+		Service:         []string{"1"},
+		MobileGroups:    []int{3},
+	},
+	CountryCodeAustria: {
+		CallingCode:     "43",
+		TrunkPrefix:     "0",
+		MinLength:       4,
+		MaxLength:       13,
+		MobileMinLength: 10,
+		Mobile:          []string{"65", "66", "67", "68", "69"},
+		Landline:        []string{"1", "2", "3", "4", "5", "7"},
+		Service:         []string{"6", "8", "9"},
+		MobileGroups:    []int{3},
+	},
+	CountryCodeFrance: {
+		CallingCode:  "33",
+		TrunkPrefix:  "0",
+		MinLength:    9,
+		MaxLength:    9,
+		Mobile:       []string{"6", "7"},
+		Landline:     []string{"1", "2", "3", "4", "5"},
+		Service:      []string{"8", "9"},
+		MobileGroups: []int{1, 2, 2, 2, 2},
+		Groups:       []int{1, 2, 2, 2, 2},
+	},
+	// italian landline numbers keep the leading 0 in international format
+	CountryCodeItaly: {
+		CallingCode:     "39",
+		MinLength:       6,
+		MaxLength:       11,
+		MobileMinLength: 9,
+		Mobile:          []string{"3"},
+		Landline:        []string{"0"},
+		Service:         []string{"8"},
+		MobileGroups:    []int{3, 3},
+	},
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// PhoneNumber is a parsed phone number
+type PhoneNumber struct {
+	// CountryCode is the ISO 3166-1 alpha-2 code of a supported country or empty for other countries
+	CountryCode string
+	// CallingCode is the country calling code without +, e.g. 41
+	CallingCode string
+	// NationalNumber are the digits after the calling code
+	NationalNumber string
+	// Type is ContactTypePhoneMobile, ContactTypePhoneLandline or ContactTypePhone, if the type is unknown
+	Type ContactType
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE TYPES
+//------------------------------------------------------------------
+
+type phoneNumberRule struct {
+	CallingCode string
+	// TrunkPrefix is dialed before national numbers within the country and omitted in international format
+	TrunkPrefix string
+	// MinLength and MaxLength of the national number
+	MinLength int
+	MaxLength int
+	// MobileMinLength is the minimum length of mobile numbers, if it is greater than MinLength
+	MobileMinLength int
+	// Mobile, Landline and Service are the prefixes of the national numbers of mobile networks, fixed networks
+	// and other services like toll free numbers. Numbers with other prefixes are invalid.
+	Mobile   []string
+	Landline []string
+	Service  []string
+	// MobileGroups and Groups are the sizes of the digit groups in the display format, the rest is the last group
+	MobileGroups []int
+	Groups       []int
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// ParsePhoneNumber parses phone, e.g. "079 123 45 67", "+41 (0)79 123 45 67" or "0041791234567".
+// Numbers without country calling code are numbers of defaultCountry, which may be a country code or name, e.g. the country of an address.
+// Numbers of supported countries are validated against their numbering plan, other numbers are only checked for the length of E.164.
+func ParsePhoneNumber(phone string, defaultCountry string) (*PhoneNumber, error) {
+	digits, international, err := cleanPhoneNumber(phone)
+	if err != nil {
+		return nil, err
+	}
+	if !international {
+		countryCode, ok := GetCountryCode(defaultCountry)
+		if !ok {
+			return nil, ErrorPhoneNumberRegion
+		}
+		rule, ok := phoneNumberRules[countryCode]
+		if !ok {
+			return nil, ErrorPhoneNumberRegion
+		}
+		if rule.TrunkPrefix != "" {
+			if !strings.HasPrefix(digits, rule.TrunkPrefix) {
+				return nil, ErrorInvalidPhoneNumber
+			}
+			digits = strings.TrimPrefix(digits, rule.TrunkPrefix)
+		}
+		return rule.parse(countryCode, digits)
+	}
+	if len(digits) > e164MaxDigits {
+		return nil, ErrorInvalidPhoneNumber
+	}
+	for countryCode, rule := range phoneNumberRules {
+		if strings.HasPrefix(digits, rule.CallingCode) {
+			nationalNumber := strings.TrimPrefix(digits, rule.CallingCode)
+			// tolerate the trunk prefix in international format, e.g. +49 030 1234567
+			if rule.TrunkPrefix != "" && strings.HasPrefix(nationalNumber, rule.TrunkPrefix) {
+				nationalNumber = strings.TrimPrefix(nationalNumber, rule.TrunkPrefix)
+			}
+			return rule.parse(countryCode, nationalNumber)
+		}
+	}
+	// calling codes have 1 to 3 digits, without numbering plan the split is unknown
+	if len(digits) < 1+minNationalNumberSize || digits[0] == '0' {
+		return nil, ErrorInvalidPhoneNumber
+	}
+	return &PhoneNumber{
+		NationalNumber: digits,
+		Type:           ContactTypePhone,
+	}, nil
+}
+
+// E164 returns the canonical format, e.g. +41791234567
+func (n *PhoneNumber) E164() string {
+	return e164Prefix + n.CallingCode + n.NationalNumber
+}
+
+// Display returns the international format with grouped digits, e.g. +41 79 123 45 67
+func (n *PhoneNumber) Display() string {
+	if n.CallingCode == "" {
+		return n.E164()
+	}
+	groups := []string{e164Prefix + n.CallingCode}
+	nationalNumber := n.NationalNumber
+	sizes := []int{}
+	if rule, ok := phoneNumberRules[n.CountryCode]; ok {
+		sizes = rule.Groups
+		if n.Type == ContactTypePhoneMobile {
+			sizes = rule.MobileGroups
+		}
+	}
+	for _, size := range sizes {
+		if len(nationalNumber) <= size {
+			break
+		}
+		groups = append(groups, nationalNumber[:size])
+		nationalNumber = nationalNumber[size:]
+	}
+	groups = append(groups, nationalNumber)
+	return strings.Join(groups, " ")
+}
+
+// IsMobile returns true for numbers of mobile networks
+func (n *PhoneNumber) IsMobile() bool {
+	return n.Type == ContactTypePhoneMobile
+}
+
+// ParsePhoneNumber parses phone with the country of the address as default
+func (addr *Address) ParsePhoneNumber(phone string) (*PhoneNumber, error) {
+	return ParsePhoneNumber(phone, addr.getPhoneRegion())
+}
+
+// SetPhoneNumber sets phone as mobile, landline or phone contact of the person of the address with Person.SetPhoneNumber.
+// National numbers are parsed for the country of the address.
+func (addr *Address) SetPhoneNumber(phone string) (*Contact, error) {
+	if addr.Person == nil {
+		addr.Person = &Person{}
+	}
+	return addr.Person.SetPhoneNumber(phone, addr.getPhoneRegion())
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// getPhoneRegion returns the country national numbers of the address belong to
+func (addr *Address) getPhoneRegion() string {
+	if addr.CountryCode != "" {
+		return addr.CountryCode
+	}
+	return addr.Country
+}
+
+// cleanPhoneNumber returns the digits of phone and if it has an international prefix.
+// Spaces, dashes, dots, slashes, parentheses and the trunk prefix (0) after a calling code are removed.
+func cleanPhoneNumber(phone string) (digits string, international bool, err error) {
+	phone = strings.TrimSpace(phone)
+	if strings.HasPrefix(phone, e164Prefix) {
+		international = true
+		phone = phone[len(e164Prefix):]
+	}
+	if international || strings.HasPrefix(phone, internationalPrefix) {
+		phone = strings.Replace(phone, "(0)", "", 1)
+	}
+	var sb strings.Builder
+	for _, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '/' || r == '(' || r == ')':
+		default:
+			return "", false, ErrorInvalidPhoneNumber
+		}
+	}
+	digits = sb.String()
+	if !international && strings.HasPrefix(digits, internationalPrefix) {
+		international = true
+		digits = digits[len(internationalPrefix):]
+	}
+	if digits == "" {
+		return "", false, ErrorInvalidPhoneNumber
+	}
+	return digits, international, nil
+}
+
+func (rule *phoneNumberRule) parse(countryCode string, nationalNumber string) (*PhoneNumber, error) {
+	if len(nationalNumber) < rule.MinLength || len(nationalNumber) > rule.MaxLength || len(rule.CallingCode+nationalNumber) > e164MaxDigits {
+		return nil, ErrorInvalidPhoneNumber
+	}
+	number := &PhoneNumber{
+		CountryCode:    countryCode,
+		CallingCode:    rule.CallingCode,
+		NationalNumber: nationalNumber,
+		Type:           ContactTypePhone,
+	}
+	switch {
+	case hasAnyPrefix(nationalNumber, rule.Mobile):
+		if len(nationalNumber) < rule.MobileMinLength {
+			return nil, ErrorInvalidPhoneNumber
+		}
+		number.Type = ContactTypePhoneMobile
+	case hasAnyPrefix(nationalNumber, rule.Landline):
+		number.Type = ContactTypePhoneLandline
+	case !hasAnyPrefix(nationalNumber, rule.Service):
+		return nil, ErrorInvalidPhoneNumber
+	}
+	return number, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package address
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePhoneNumber(t *testing.T) {
+	for _, test := range []struct {
+		phone, defaultCountry, e164, display string
+		contactType                          ContactType
+	}{
+		{"079 123 45 67", "CH", "+41791234567", "+41 79 123 45 67", ContactTypePhoneMobile},
+		{"+41 (0)44 668 18 00", "", "+41446681800", "+41 44 668 18 00", ContactTypePhoneLandline},
+		{"0041 79 123 45 67", "DE", "+41791234567", "+41 79 123 45 67", ContactTypePhoneMobile},
+		{"0800 800 800", "Schweiz", "+41800800800", "+41 80 080 08 00", ContactTypePhone},
+		{"0171/1234567", "Deutschland", "+491711234567", "+49 171 1234567", ContactTypePhoneMobile},
+		{"030 12345678", "DE", "+493012345678", "+49 3012345678", ContactTypePhoneLandline},
+		{"+43 664 1234567", "", "+436641234567", "+43 664 1234567", ContactTypePhoneMobile},
+		{"01 5889 0", "AT", "+43158890", "+43 158890", ContactTypePhoneLandline},
+		{"06.12.34.56.78", "FR", "+33612345678", "+33 6 12 34 56 78", ContactTypePhoneMobile},
+		{"01 42 68 53 00", "France", "+33142685300", "+33 1 42 68 53 00", ContactTypePhoneLandline},
+		{"312 345 6789", "IT", "+393123456789", "+39 312 345 6789", ContactTypePhoneMobile},
+		{"06 6982", "IT", "+39066982", "+39 066982", ContactTypePhoneLandline},
+		{"+423 235 12 34", "", "+4232351234", "+423 235 12 34", ContactTypePhoneLandline},
+		{"790 12 34", "LI", "+4237901234", "+423 790 12 34", ContactTypePhoneMobile},
+		{"+1 212 555 0100", "CH", "+12125550100", "+12125550100", ContactTypePhone},
+	} {
+		number, err := ParsePhoneNumber(test.phone, test.defaultCountry)
+		if !assert.NoError(t, err, test.phone) {
+			continue
+		}
+		assert.Equal(t, test.e164, number.E164(), test.phone)
+		assert.Equal(t, test.display, number.Display(), test.phone)
+		assert.Equal(t, test.contactType, number.Type, test.phone)
+	}
+}
+
+func TestParsePhoneNumberInvalid(t *testing.T) {
+	for _, test := range []struct {
+		phone, defaultCountry string
+		err                   error
+	}{
+		{"", "CH", ErrorInvalidPhoneNumber},
+		{"079 123 45 6", "CH", ErrorInvalidPhoneNumber},
+		{"079 123 45 678", "CH", ErrorInvalidPhoneNumber},
+		{"012 345 67 89", "CH", ErrorInvalidPhoneNumber},
+		{"079 123 45 67 ext. 5", "CH", ErrorInvalidPhoneNumber},
+		{"0171 12345", "DE", ErrorInvalidPhoneNumber},
+		{"+33 6 12 34 56", "", ErrorInvalidPhoneNumber},
+		{"+1234567890123456", "", ErrorInvalidPhoneNumber},
+		{"79 123 45 67", "CH", ErrorInvalidPhoneNumber},
+		{"079 123 45 67", "", ErrorPhoneNumberRegion},
+		{"020 1234 5678", "GB", ErrorPhoneNumberRegion},
+	} {
+		_, err := ParsePhoneNumber(test.phone, test.defaultCountry)
+		assert.Equal(t, test.err, err, test.phone)
+	}
+}
+
+func TestPersonSetPhoneNumber(t *testing.T) {
+	addr := &Address{Person: &Person{}, CountryCode: "CH"}
+	number, err := addr.ParsePhoneNumber("044 668 18 00")
+	assert.NoError(t, err)
+	assert.Equal(t, ContactTypePhoneLandline, number.Type)
+
+	person := &Person{}
+	mobile, err := person.SetPhoneNumber("079 123 45 67", addr.CountryCode)
+	assert.NoError(t, err)
+	assert.Equal(t, ContactTypePhoneMobile, mobile.Type)
+	assert.Equal(t, "+41791234567", mobile.Value)
+	assert.Equal(t, "+41 79 123 45 67", mobile.Display)
+
+	landline, err := person.SetPhoneNumber("044 668 18 00", addr.CountryCode)
+	assert.NoError(t, err)
+	assert.NotEqual(t, mobile.ID, landline.ID)
+
+	replaced, err := person.SetPhoneNumber("+41 78 765 43 21", addr.CountryCode)
+	assert.NoError(t, err)
+	assert.Equal(t, mobile.ID, replaced.ID)
+	assert.Equal(t, "+41787654321", person.GetContactByType(ContactTypePhoneMobile).Value)
+	assert.Len(t, person.Contacts, 2)
+
+	_, err = person.SetPhoneNumber("12345", addr.CountryCode)
+	assert.Equal(t, ErrorInvalidPhoneNumber, err)
+	assert.Len(t, person.Contacts, 2)
+
+	contact, err := CreatePhoneNumberContact("0171 1234567", "DE")
+	assert.NoError(t, err)
+	assert.Equal(t, ContactTypePhoneMobile, contact.Type)
+	assert.Equal(t, "+491711234567", contact.Value)
+	assert.NotEmpty(t, contact.ID)
+}
+
+func TestPersonSetPhone(t *testing.T) {
+	person := &Person{}
+	phone := person.SetPhone("044 668 18 00")
+	assert.Equal(t, ContactTypePhone, phone.Type)
+	assert.Equal(t, "044 668 18 00", phone.Value, "stored as given")
+	assert.Equal(t, phone.ID, person.SetPhone("+41 44 668 18 01").ID)
+	assert.Equal(t, "+41 44 668 18 01", person.GetPhoneNumber())
+	assert.Equal(t, "12345", CreatePhoneContact("12345").Value)
+}
+
+func TestAddressSetPhoneNumber(t *testing.T) {
+	addr := &Address{Country: "Deutschland"}
+	mobile, err := addr.SetPhoneNumber("0171 1234567")
+	assert.NoError(t, err, "national number of the address country")
+	assert.Equal(t, ContactTypePhoneMobile, mobile.Type)
+	assert.Equal(t, "+491711234567", mobile.Value)
+
+	addr.CountryCode = "CH"
+	landline, err := addr.SetPhoneNumber("044 668 18 00")
+	assert.NoError(t, err)
+	assert.Equal(t, ContactTypePhoneLandline, landline.Type)
+	assert.Equal(t, "+41446681800", landline.Value)
+	assert.Len(t, addr.Person.Contacts, 2)
+
+	_, err = addr.SetPhoneNumber("12345")
+	assert.Equal(t, ErrorInvalidPhoneNumber, err)
+}
@@ -0,0 +1,245 @@
+package address
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// postalCodeFormats contains the rules of the supported countries
+var postalCodeFormats = map[string]*postalCodeFormat{
+	CountryCodeSwitzerland:   {Digits: 4, Min: 1000, Max: 9699},
+	CountryCodeLiechtenstein: {Digits: 4, Min: 9485, Max: 9498},
+	CountryCodeAustria:       {Digits: 4, Min: 1010, Max: 9992},
+	CountryCodeGermany:       {Digits: 5, Min: 1001, Max: 99998},
+	CountryCodeFrance:        {Digits: 5, Min: 1000, Max: 98999},
+	CountryCodeItaly:         {Digits: 5, Min: 10, Max: 98168},
+}
+
+// postalCodePrefix matches country prefixes of postal codes, e.g. CH-8001, D-10115 or FL-9490
+var postalCodePrefix = regexp.MustCompile(`^[A-Za-z]{1,2}\s*-\s*`)
+
+var (
+	postalCodeRangesMutex sync.RWMutex
+	// postalCodeRanges is the bundled table of the postal codes of larger cities. It is used to check the plausibility
+	// of postal code and city, but does not contain all postal codes. Add complete tables with LoadPostalCodeRanges.
+	postalCodeRanges = []*PostalCodeRange{
+		{CountryCodeSwitzerland, 8001, 8099, "Zürich"},
+		{CountryCodeSwitzerland, 3000, 3030, "Bern"},
+		{CountryCodeSwitzerland, 4001, 4059, "Basel"},
+		{CountryCodeSwitzerland, 1200, 1209, "Genève"},
+		{CountryCodeSwitzerland, 1200, 1209, "Genf"},
+		{CountryCodeSwitzerland, 1200, 1209, "Geneva"},
+		{CountryCodeSwitzerland, 1211, 1211, "Genève"},
+		{CountryCodeSwitzerland, 1211, 1211, "Genf"},
+		{CountryCodeSwitzerland, 1211, 1211, "Geneva"},
+		{CountryCodeSwitzerland, 1000, 1018, "Lausanne"},
+		{CountryCodeSwitzerland, 6002, 6006, "Luzern"},
+		{CountryCodeSwitzerland, 6002, 6006, "Lucerne"},
+		{CountryCodeSwitzerland, 9000, 9016, "St. Gallen"},
+		{CountryCodeSwitzerland, 8400, 8411, "Winterthur"},
+		{CountryCodeLiechtenstein, 9485, 9485, "Nendeln"},
+		{CountryCodeLiechtenstein, 9486, 9486, "Schaanwald"},
+		{CountryCodeLiechtenstein, 9487, 9487, "Gamprin-Bendern"},
+		{CountryCodeLiechtenstein, 9488, 9488, "Schellenberg"},
+		{CountryCodeLiechtenstein, 9490, 9490, "Vaduz"},
+		{CountryCodeLiechtenstein, 9491, 9491, "Ruggell"},
+		{CountryCodeLiechtenstein, 9492, 9492, "Eschen"},
+		{CountryCodeLiechtenstein, 9493, 9493, "Mauren"},
+		{CountryCodeLiechtenstein, 9494, 9494, "Schaan"},
+		{CountryCodeLiechtenstein, 9495, 9495, "Triesen"},
+		{CountryCodeLiechtenstein, 9496, 9496, "Balzers"},
+		{CountryCodeLiechtenstein, 9497, 9497, "Triesenberg"},
+		{CountryCodeLiechtenstein, 9498, 9498, "Planken"},
+		{CountryCodeAustria, 1010, 1239, "Wien"},
+		{CountryCodeAustria, 1010, 1239, "Vienna"},
+		{CountryCodeAustria, 8010, 8055, "Graz"},
+		{CountryCodeAustria, 4020, 4040, "Linz"},
+		{CountryCodeAustria, 5020, 5026, "Salzburg"},
+		{CountryCodeAustria, 6020, 6020, "Innsbruck"},
+		{CountryCodeAustria, 6080, 6080, "Innsbruck"},
+		{CountryCodeGermany, 10115, 10999, "Berlin"},
+		{CountryCodeGermany, 20095, 20539, "Hamburg"},
+		{CountryCodeGermany, 80331, 81929, "München"},
+		{CountryCodeGermany, 80331, 81929, "Munich"},
+		{CountryCodeGermany, 50667, 50999, "Köln"},
+		{CountryCodeGermany, 50667, 50999, "Cologne"},
+		{CountryCodeGermany, 60306, 60599, "Frankfurt am Main"},
+		{CountryCodeGermany, 70173, 70599, "Stuttgart"},
+		{CountryCodeGermany, 40210, 40629, "Düsseldorf"},
+		{CountryCodeFrance, 75000, 75999, "Paris"},
+		{CountryCodeFrance, 69001, 69009, "Lyon"},
+		{CountryCodeFrance, 13001, 13016, "Marseille"},
+		{CountryCodeFrance, 67000, 67000, "Strasbourg"},
+		{CountryCodeFrance, 67100, 67100, "Strasbourg"},
+		{CountryCodeFrance, 67200, 67200, "Strasbourg"},
+		{CountryCodeItaly, 118, 199, "Roma"},
+		{CountryCodeItaly, 118, 199, "Rom"},
+		{CountryCodeItaly, 118, 199, "Rome"},
+		{CountryCodeItaly, 20121, 20162, "Milano"},
+		{CountryCodeItaly, 20121, 20162, "Mailand"},
+		{CountryCodeItaly, 20121, 20162, "Milan"},
+		{CountryCodeItaly, 80121, 80147, "Napoli"},
+		{CountryCodeItaly, 80121, 80147, "Neapel"},
+		{CountryCodeItaly, 80121, 80147, "Naples"},
+		{CountryCodeItaly, 10121, 10156, "Torino"},
+		{CountryCodeItaly, 10121, 10156, "Turin"},
+		{CountryCodeItaly, 50121, 50145, "Firenze"},
+		{CountryCodeItaly, 50121, 50145, "Florenz"},
+		{CountryCodeItaly, 50121, 50145, "Florence"},
+	}
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// PostalCodeRange assigns the postal codes From to To of a country to a city. A city may have several ranges and names.
+type PostalCodeRange struct {
+	CountryCode string
+	From        int
+	To          int
+	City        string
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE TYPES
+//------------------------------------------------------------------
+
+type postalCodeFormat struct {
+	Digits int
+	Min    int
+	Max    int
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// AddPostalCodeRanges adds ranges to the table, which is used to check the plausibility of postal code and city
+func AddPostalCodeRanges(ranges ...*PostalCodeRange) {
+	postalCodeRangesMutex.Lock()
+	defer postalCodeRangesMutex.Unlock()
+	postalCodeRanges = append(postalCodeRanges, ranges...)
+}
+
+// LoadPostalCodeRanges adds the ranges in filename, one per line: <country code>;<from>;<to>;<city>.
+// Empty lines and lines starting with # are ignored.
+func LoadPostalCodeRanges(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ranges := []*PostalCodeRange{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		if len(fields) != 4 {
+			return errors.New("line " + strconv.Itoa(lineNumber) + ": expected 4 fields")
+		}
+		from, errFrom := strconv.Atoi(strings.TrimSpace(fields[1]))
+		to, errTo := strconv.Atoi(strings.TrimSpace(fields[2]))
+		if errFrom != nil || errTo != nil || from > to {
+			return errors.New("line " + strconv.Itoa(lineNumber) + ": invalid postal code range")
+		}
+		ranges = append(ranges, &PostalCodeRange{
+			CountryCode: strings.ToUpper(strings.TrimSpace(fields[0])),
+			From:        from,
+			To:          to,
+			City:        strings.TrimSpace(fields[3]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	AddPostalCodeRanges(ranges...)
+	return nil
+}
+
+// NormalizePostalCode removes whitespace and country prefixes like CH- or D- from the postal code of a supported country
+func NormalizePostalCode(countryCode string, postalCode string) string {
+	if !IsSupportedCountry(countryCode) {
+		return strings.TrimSpace(postalCode)
+	}
+	postalCode = postalCodePrefix.ReplaceAllString(strings.TrimSpace(postalCode), "")
+	return strings.Join(strings.Fields(postalCode), "")
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// parsePostalCode returns the postal code as number, if it has the format of the country
+func parsePostalCode(format *postalCodeFormat, postalCode string) (int, bool) {
+	if len(postalCode) != format.Digits {
+		return 0, false
+	}
+	for _, r := range postalCode {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(postalCode)
+	return n, err == nil
+}
+
+// isPlausibleCity checks city against the table. If the postal code is in the table, the city must have one of its names.
+// If the city is in the table, the postal code must be in one of its ranges. Other combinations are plausible.
+func isPlausibleCity(countryCode string, postalCode int, city string) (bool, []string) {
+	postalCodeRangesMutex.RLock()
+	defer postalCodeRangesMutex.RUnlock()
+	folded := foldCity(city)
+	expected := []string{}
+	cityKnown, cityInRange := false, false
+	for _, r := range postalCodeRanges {
+		if r.CountryCode != countryCode {
+			continue
+		}
+		name := foldCity(r.City)
+		inRange := postalCode >= r.From && postalCode <= r.To
+		if inRange {
+			expected = append(expected, r.City)
+			if matchesCityName(folded, name) {
+				return true, nil
+			}
+		}
+		if folded == name {
+			cityKnown = true
+			cityInRange = cityInRange || inRange
+		}
+	}
+	if len(expected) > 0 {
+		return false, expected
+	}
+	return !cityKnown || cityInRange, nil
+}
+
+// foldCity folds city and abbreviates saint, e.g. "Sankt Gallen" => "st gallen"
+func foldCity(city string) string {
+	words := strings.Fields(fold(city))
+	for i, word := range words {
+		if word == "sankt" || word == "saint" {
+			words[i] = "st"
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// matchesCityName returns true if the names are equal or one of them is the other one with further words,
+// e.g. "zurich" and "zurich altstetten" or "frankfurt" and "frankfurt am main"
+func matchesCityName(a string, b string) bool {
+	return a == b || strings.HasPrefix(a, b+" ") || strings.HasPrefix(b, a+" ")
+}
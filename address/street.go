@@ -0,0 +1,57 @@
+package address
+
+import (
+	"regexp"
+	"strings"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var (
+	// trailingStreetNumber matches "Bahnhofstrasse 12a", "Via Roma, 12" or "Hauptstraße 12/3/5"
+	trailingStreetNumber = regexp.MustCompile(`^(.*?[^\s,])(?:\s*,\s*|\s+)(\d+(?:\s?[a-zA-Z])?(?:\s*[-/]\s*\d+[a-zA-Z]?)*)$`)
+	// leadingStreetNumber matches "12 rue de la Paix", "12bis, rue de Rivoli" or "12 bis rue de Rivoli"
+	leadingStreetNumber = regexp.MustCompile(`^(\d+(?:\s?(?:bis|ter|quater|[a-zA-Z]))?(?:\s*-\s*\d+)?)\b(?:\s*,\s*|\s+)(.*\S)$`)
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// SplitStreet splits street into street name and number. In France the number usually precedes the street name,
+// in the other countries it follows. If street does not contain a number, it is returned unchanged with an empty number.
+func SplitStreet(street string, countryCode string) (name string, number string) {
+	street = strings.Join(strings.Fields(street), " ")
+	matchers := []func(string) (string, string, bool){matchTrailingStreetNumber, matchLeadingStreetNumber}
+	if countryCode == CountryCodeFrance {
+		matchers[0], matchers[1] = matchers[1], matchers[0]
+	}
+	for _, match := range matchers {
+		if name, number, ok := match(street); ok {
+			return name, number
+		}
+	}
+	return street, ""
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func matchTrailingStreetNumber(street string) (string, string, bool) {
+	matches := trailingStreetNumber.FindStringSubmatch(street)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], strings.Replace(matches[2], " ", "", -1), true
+}
+
+func matchLeadingStreetNumber(street string) (string, string, bool) {
+	matches := leadingStreetNumber.FindStringSubmatch(street)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[2], matches[1], true
+}
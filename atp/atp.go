@@ -47,6 +47,10 @@ func CreateATPRequestFromOrder(order *order.Order) *ATPRequest {
 			QuantityUnit:        position.QuantityUnit,
 			DesiredDeliveryDate: order.GetLastModifiedAt().Add(time.Duration(1) * time.Hour * time.Duration(24)), // set day after orderdate as default for DesiredDeliveryDate
 		}
+		// use the date of the delivery promise engine, if available
+		if !position.PromisedDate.IsZero() {
+			reqItem.DesiredDeliveryDate = position.PromisedDate
+		}
 		requestItems = append(requestItems, reqItem)
 	}
 
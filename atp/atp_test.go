@@ -0,0 +1,69 @@
+package shop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/order"
+)
+
+func newATPTestOrder() *order.Order {
+	o := &order.Order{
+		Id:    "atp-test",
+		Flags: &order.Flags{},
+		Positions: []*order.Position{
+			{ItemID: "shirt", Quantity: 3},
+			{ItemID: "shoes", Quantity: 1},
+			{ItemID: "socks", Quantity: 2},
+			{ItemID: "shipping", Quantity: 1, IsShipping: true},
+		},
+	}
+	o.UnlinkFromDB()
+	return o
+}
+
+func TestMemoryProviderGetATP(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := NewMemoryProvider()
+	provider.now = func() time.Time { return now }
+	provider.SetStock("shirt", 5, 72*time.Hour)
+
+	response, err := provider.GetATP(&ATPRequest{Items: []*RequestItem{
+		{ItemNumber: "shirt", DesiredQuantity: 3, DesiredDeliveryDate: now.Add(24 * time.Hour)},
+		{ItemNumber: "shirt", DesiredQuantity: 3, DesiredDeliveryDate: now.Add(96 * time.Hour)},
+		{ItemNumber: "unknown", DesiredQuantity: 1},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, response.Items[0].ApprovedQuantity)
+	assert.Equal(t, now.Add(72*time.Hour), response.Items[0].DeliveryDate, "lead time")
+	assert.Equal(t, 2.0, response.Items[1].ApprovedQuantity, "stock is shared")
+	assert.Equal(t, ErrorCodeInsufficientStock, response.Items[1].ErrorCode)
+	assert.Equal(t, now.Add(96*time.Hour), response.Items[1].DeliveryDate)
+	assert.Equal(t, ErrorCodeUnknownItem, response.Items[2].ErrorCode)
+	assert.Equal(t, 5.0, provider.GetStock("shirt").Quantity, "stock is not changed")
+}
+
+func TestApplyATPResponse(t *testing.T) {
+	provider := NewMemoryProvider()
+	provider.SetStock("shirt", 2, 0)
+	provider.SetStock("shoes", 0, 0)
+	o := newATPTestOrder()
+
+	adjustments, err := RequestATP(provider, o)
+	assert.NoError(t, err)
+	assert.Len(t, adjustments, 3)
+	assert.False(t, o.ATPAt.IsZero())
+
+	shirt := o.GetPositionByItemId("shirt")
+	assert.Equal(t, 2.0, shirt.Quantity)
+	assert.True(t, shirt.IsATPApplied)
+	assert.False(t, shirt.DeliveryDate.IsZero())
+	assert.Nil(t, o.GetPositionByItemId("shoes"), "position without stock is removed")
+	assert.Nil(t, o.GetPositionByItemId("socks"), "unknown item is removed")
+	assert.False(t, o.GetPositionByItemId("shipping").IsATPApplied)
+
+	_, err = ApplyATPResponse(o, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package shop
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var (
+	HolidayNewYear              = fixedHoliday(time.January, 1)
+	HolidayBerchtoldstag        = fixedHoliday(time.January, 2)
+	HolidayEpiphany             = fixedHoliday(time.January, 6)
+	HolidayCandlemas            = fixedHoliday(time.February, 2)
+	HolidayStJoseph             = fixedHoliday(time.March, 19)
+	HolidayGoodFriday           = easterHoliday(-2)
+	HolidayEasterMonday         = easterHoliday(1)
+	HolidayLiberationDay        = fixedHoliday(time.April, 25) // Italy
+	HolidayLabourDay            = fixedHoliday(time.May, 1)
+	HolidayVictoryDay           = fixedHoliday(time.May, 8) // France
+	HolidayAscension            = easterHoliday(39)
+	HolidayWhitMonday           = easterHoliday(50)
+	HolidayCorpusChristi        = easterHoliday(60)
+	HolidayRepublicDay          = fixedHoliday(time.June, 2)  // Italy
+	HolidayBastilleDay          = fixedHoliday(time.July, 14) // France
+	HolidaySwissNationalDay     = fixedHoliday(time.August, 1)
+	HolidayAssumption           = fixedHoliday(time.August, 15)
+	HolidayNativityOfMary       = fixedHoliday(time.September, 8)
+	HolidayGermanUnityDay       = fixedHoliday(time.October, 3)
+	HolidayAustrianNationalDay  = fixedHoliday(time.October, 26)
+	HolidayAllSaints            = fixedHoliday(time.November, 1)
+	HolidayArmisticeDay         = fixedHoliday(time.November, 11) // France
+	HolidayImmaculateConception = fixedHoliday(time.December, 8)
+	HolidayChristmas            = fixedHoliday(time.December, 25)
+	HolidayStStephen            = fixedHoliday(time.December, 26)
+)
+
+// NationalHolidays are the public holidays observed in the whole country per country code.
+// Regional holidays are not included and must be added with AddHolidays():
+//   - CH: all holidays except Neujahr, Auffahrt, Bundesfeier and Weihnachten are cantonal, e.g. Berchtoldstag, Karfreitag, Ostermontag, Pfingstmontag and Stephanstag
+//   - DE: the holidays of the states, e.g. Heilige Drei Könige, Fronleichnam, Reformationstag and Allerheiligen
+//   - AT: holidays of single provinces, e.g. the days of their patron saints
+//   - FR: Karfreitag and Stephanstag in Alsace-Moselle and the holidays of the overseas departments
+//   - IT: the days of the patron saints of the cities
+//   - LI: customary days off, e.g. Berchtoldstag, Fasnachtsdienstag, Karfreitag, Heiligabend and Silvester
+var NationalHolidays = map[string][]Holiday{
+	"CH": {HolidayNewYear, HolidayAscension, HolidaySwissNationalDay, HolidayChristmas},
+	"DE": {HolidayNewYear, HolidayGoodFriday, HolidayEasterMonday, HolidayLabourDay, HolidayAscension, HolidayWhitMonday, HolidayGermanUnityDay, HolidayChristmas, HolidayStStephen},
+	"AT": {HolidayNewYear, HolidayEpiphany, HolidayEasterMonday, HolidayLabourDay, HolidayAscension, HolidayWhitMonday, HolidayCorpusChristi, HolidayAssumption, HolidayAustrianNationalDay, HolidayAllSaints, HolidayImmaculateConception, HolidayChristmas, HolidayStStephen},
+	"FR": {HolidayNewYear, HolidayEasterMonday, HolidayLabourDay, HolidayVictoryDay, HolidayAscension, HolidayWhitMonday, HolidayBastilleDay, HolidayAssumption, HolidayAllSaints, HolidayArmisticeDay, HolidayChristmas},
+	"IT": {HolidayNewYear, HolidayEpiphany, HolidayEasterMonday, HolidayLiberationDay, HolidayLabourDay, HolidayRepublicDay, HolidayAssumption, HolidayAllSaints, HolidayImmaculateConception, HolidayChristmas, HolidayStStephen},
+	"LI": {HolidayNewYear, HolidayEpiphany, HolidayCandlemas, HolidayStJoseph, HolidayEasterMonday, HolidayLabourDay, HolidayAscension, HolidayWhitMonday, HolidayCorpusChristi, HolidayAssumption, HolidayNativityOfMary, HolidayAllSaints, HolidayImmaculateConception, HolidayChristmas, HolidayStStephen},
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// HolidayCalendar knows the public holidays per country
+type HolidayCalendar interface {
+	IsHoliday(countryCode string, date time.Time) bool
+}
+
+// Holiday returns the date of a holiday in year
+type Holiday func(year int) time.Time
+
+// StaticHolidayCalendar is a HolidayCalendar with explicitly added holidays
+type StaticHolidayCalendar struct {
+	mutex    sync.RWMutex
+	holidays map[string]map[string]bool // country code => date YYYY-MM-DD
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewStaticHolidayCalendar constructor
+func NewStaticHolidayCalendar() *StaticHolidayCalendar {
+	return &StaticHolidayCalendar{holidays: map[string]map[string]bool{}}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// AddHoliday adds a holiday in countryCode, only the date of date is used
+func (c *StaticHolidayCalendar) AddHoliday(countryCode string, date time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.holidays[countryCode]; !ok {
+		c.holidays[countryCode] = map[string]bool{}
+	}
+	c.holidays[countryCode][date.Format("2006-01-02")] = true
+}
+
+// AddHolidays adds holidays of year in countryCode, e.g. regional holidays which are observed by a carrier
+func (c *StaticHolidayCalendar) AddHolidays(countryCode string, year int, holidays ...Holiday) {
+	for _, holiday := range holidays {
+		c.AddHoliday(countryCode, holiday(year))
+	}
+}
+
+// AddNationalHolidays adds the NationalHolidays of countryCode in year
+func (c *StaticHolidayCalendar) AddNationalHolidays(countryCode string, year int) error {
+	holidays, ok := NationalHolidays[countryCode]
+	if !ok {
+		return errors.New("no national holidays known for country " + countryCode)
+	}
+	c.AddHolidays(countryCode, year, holidays...)
+	return nil
+}
+
+// AddSwissHolidays adds the holidays observed in all cantons of Switzerland in year and the cantonal holidays,
+// e.g. AddSwissHolidays(year, HolidayBerchtoldstag, HolidayGoodFriday, HolidayEasterMonday, HolidayWhitMonday, HolidayStStephen).
+func (c *StaticHolidayCalendar) AddSwissHolidays(year int, cantonal ...Holiday) {
+	c.AddHolidays("CH", year, NationalHolidays["CH"]...)
+	c.AddHolidays("CH", year, cantonal...)
+}
+
+// IsHoliday implements HolidayCalendar
+func (c *StaticHolidayCalendar) IsHoliday(countryCode string, date time.Time) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.holidays[countryCode][date.Format("2006-01-02")]
+}
+
+// GetEasterSunday returns the date of easter sunday in year (gregorian calendar)
+func GetEasterSunday(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func fixedHoliday(month time.Month, day int) Holiday {
+	return func(year int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// easterHoliday returns a holiday days after easter sunday
+func easterHoliday(days int) Holiday {
+	return func(year int) time.Time {
+		return GetEasterSunday(year).AddDate(0, 0, days)
+	}
+}
@@ -0,0 +1,100 @@
+package shop
+
+import (
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// MemoryProvider is an ATPProvider with stock levels held in memory, e.g. for tests and development.
+// Answering a request does not change the stock.
+type MemoryProvider struct {
+	mutex sync.RWMutex
+	stock map[string]*MemoryStock
+	now   func() time.Time
+}
+
+// MemoryStock is the stock of an item
+type MemoryStock struct {
+	Quantity float64
+	LeadTime time.Duration // time until the item can be delivered
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMemoryProvider constructor
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		stock: map[string]*MemoryStock{},
+		now:   time.Now,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// SetStock sets the stock of itemNumber
+func (p *MemoryProvider) SetStock(itemNumber string, quantity float64, leadTime time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stock[itemNumber] = &MemoryStock{Quantity: quantity, LeadTime: leadTime}
+}
+
+// GetStock returns the stock of itemNumber, nil if unknown
+func (p *MemoryProvider) GetStock(itemNumber string) *MemoryStock {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	stock, ok := p.stock[itemNumber]
+	if !ok {
+		return nil
+	}
+	copied := *stock
+	return &copied
+}
+
+// GetATP approves the desired quantity up to the stock. Items requested more than once share the stock.
+// The delivery date is the desired delivery date, unless the lead time does not allow it.
+func (p *MemoryProvider) GetATP(request *ATPRequest) (*ATPResponse, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	now := p.now()
+	approved := map[string]float64{}
+	response := &ATPResponse{Items: []*ResponseItem{}}
+	for _, requestItem := range request.Items {
+		item := &ResponseItem{
+			ItemNumber:          requestItem.ItemNumber,
+			QuantityUnit:        requestItem.QuantityUnit,
+			DesiredDeliveryDate: requestItem.DesiredDeliveryDate,
+			ShippingProvider:    requestItem.ShippingProvider,
+		}
+		stock, ok := p.stock[requestItem.ItemNumber]
+		if !ok {
+			item.ErrorCode = ErrorCodeUnknownItem
+			response.Items = append(response.Items, item)
+			continue
+		}
+		available := stock.Quantity - approved[requestItem.ItemNumber]
+		if available < 0 {
+			available = 0
+		}
+		item.ApprovedQuantity = requestItem.DesiredQuantity
+		if available < requestItem.DesiredQuantity {
+			item.ApprovedQuantity = available
+			item.ErrorCode = ErrorCodeInsufficientStock
+		}
+		approved[requestItem.ItemNumber] += item.ApprovedQuantity
+
+		item.DeliveryDate = requestItem.DesiredDeliveryDate
+		if earliest := now.Add(stock.LeadTime); item.DeliveryDate.Before(earliest) {
+			item.DeliveryDate = earliest
+		}
+		response.Items = append(response.Items, item)
+	}
+	return response, nil
+}
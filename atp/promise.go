@@ -0,0 +1,235 @@
+package shop
+
+import (
+	"errors"
+	"time"
+
+	"github.com/foomo/shop/inventory"
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var ErrorNoDeliveryPromise = errors.New("no delivery date can be promised")
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Warehouse ships items to customers
+type Warehouse struct {
+	Id           string
+	CountryCode  string         // holidays of this country are not working days
+	Location     *time.Location // time zone of the cut-off, utils.CET if nil
+	CutOff       time.Duration  // time after midnight until which orders are handled on the same day, zero if there is no cut-off
+	HandlingDays int            // working days from the order until the handover to the carrier
+}
+
+// Carrier delivers shipments
+type Carrier struct {
+	Id                 string
+	TransitDays        int  // working days from the handover until the delivery
+	DeliversOnSaturday bool // saturdays are working days for deliveries
+}
+
+// StockAvailability is the stock of an item in a warehouse
+type StockAvailability struct {
+	Warehouse   string
+	Quantity    float64
+	RestockDate time.Time // date from which more stock is available, zero if unknown
+}
+
+// StockLocator returns the stock of an item in all warehouses
+type StockLocator interface {
+	GetStockAvailability(itemID string) ([]*StockAvailability, error)
+}
+
+// InventoryStockLocator is a StockLocator for the stock levels of package inventory
+type InventoryStockLocator struct {
+	Inventory *inventory.Inventory
+}
+
+// DeliveryPromise is the earliest delivery date of an item
+type DeliveryPromise struct {
+	ItemID       string
+	Quantity     float64
+	Warehouse    string
+	Carrier      string
+	InStock      bool // false if the delivery waits for restock
+	ShipDate     time.Time
+	DeliveryDate time.Time
+}
+
+// DeliveryPromiseEngine computes the earliest delivery date of items considering stock, warehouse lead times and cut-offs,
+// carrier transit days and public holidays. All dates are midnight in the time zone of the shipping warehouse.
+type DeliveryPromiseEngine struct {
+	Stock      StockLocator
+	Holidays   HolidayCalendar // optional
+	Warehouses map[string]*Warehouse
+	Carriers   map[string]*Carrier
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewDeliveryPromiseEngine constructor
+func NewDeliveryPromiseEngine(stock StockLocator, holidays HolidayCalendar) *DeliveryPromiseEngine {
+	return &DeliveryPromiseEngine{
+		Stock:      stock,
+		Holidays:   holidays,
+		Warehouses: map[string]*Warehouse{},
+		Carriers:   map[string]*Carrier{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// AddWarehouse registers a warehouse
+func (e *DeliveryPromiseEngine) AddWarehouse(warehouse *Warehouse) {
+	e.Warehouses[warehouse.Id] = warehouse
+}
+
+// AddCarrier registers a carrier
+func (e *DeliveryPromiseEngine) AddCarrier(carrier *Carrier) {
+	e.Carriers[carrier.Id] = carrier
+}
+
+// PromiseDelivery returns the earliest delivery of quantity of itemID with carrierId to countryCode for an order placed at now.
+// Warehouses with enough stock are preferred to warehouses waiting for restock on the same delivery date.
+// It fails with ErrorNoDeliveryPromise, if no warehouse has or will have enough stock.
+func (e *DeliveryPromiseEngine) PromiseDelivery(itemID string, quantity float64, carrierId string, countryCode string, now time.Time) (*DeliveryPromise, error) {
+	carrier, ok := e.Carriers[carrierId]
+	if !ok {
+		return nil, errors.New("unknown carrier " + carrierId)
+	}
+	availabilities, err := e.Stock.GetStockAvailability(itemID)
+	if err != nil {
+		return nil, err
+	}
+	var best *DeliveryPromise
+	for _, availability := range availabilities {
+		warehouse, ok := e.Warehouses[availability.Warehouse]
+		if !ok {
+			continue
+		}
+		promise := &DeliveryPromise{
+			ItemID:    itemID,
+			Quantity:  quantity,
+			Warehouse: warehouse.Id,
+			Carrier:   carrier.Id,
+			InStock:   availability.Quantity >= quantity,
+		}
+		start := now
+		if !promise.InStock {
+			if availability.RestockDate.IsZero() {
+				continue
+			}
+			if availability.RestockDate.After(start) {
+				start = availability.RestockDate
+			}
+		}
+		promise.ShipDate = e.getShipDate(warehouse, start)
+		promise.DeliveryDate = promise.ShipDate
+		destination := countryCode
+		if destination == "" {
+			destination = warehouse.CountryCode
+		}
+		for i := 0; i < carrier.TransitDays; i++ {
+			promise.DeliveryDate = e.getNextWorkingDay(destination, promise.DeliveryDate, carrier.DeliversOnSaturday)
+		}
+		if best == nil || promise.DeliveryDate.Before(best.DeliveryDate) ||
+			(promise.DeliveryDate.Equal(best.DeliveryDate) && promise.InStock && !best.InStock) {
+			best = promise
+		}
+	}
+	if best == nil {
+		return nil, ErrorNoDeliveryPromise
+	}
+	return best, nil
+}
+
+// PromiseOrderDelivery promises the delivery of all positions of o to its shipping address and sets Position.PromisedDate.
+// The promised dates are used by CreateATPRequestFromOrder. Shipping positions are skipped.
+func (e *DeliveryPromiseEngine) PromiseOrderDelivery(o *order.Order, carrierId string, now time.Time) ([]*DeliveryPromise, error) {
+	countryCode := ""
+	if o.CustomerData != nil && o.CustomerData.ShippingAddress != nil {
+		countryCode = o.CustomerData.ShippingAddress.CountryCode
+	}
+	promises := []*DeliveryPromise{}
+	for _, position := range o.GetPositions() {
+		if position.IsShipping {
+			continue
+		}
+		promise, err := e.PromiseDelivery(position.ItemID, position.Quantity, carrierId, countryCode, now)
+		if err != nil {
+			return nil, errors.New("position " + position.ItemID + ": " + err.Error())
+		}
+		position.PromisedDate = promise.DeliveryDate
+		promises = append(promises, promise)
+	}
+	return promises, o.Upsert()
+}
+
+// GetStockAvailability implements StockLocator
+func (l *InventoryStockLocator) GetStockAvailability(itemID string) ([]*StockAvailability, error) {
+	stockLevels, err := l.Inventory.GetStockLevels(itemID)
+	if err != nil {
+		return nil, err
+	}
+	availabilities := []*StockAvailability{}
+	for _, stockLevel := range stockLevels {
+		availabilities = append(availabilities, &StockAvailability{
+			Warehouse: stockLevel.Warehouse,
+			Quantity:  stockLevel.Available,
+		})
+	}
+	return availabilities, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// getShipDate returns the day on which the warehouse hands over an order placed at start to the carrier
+func (e *DeliveryPromiseEngine) getShipDate(warehouse *Warehouse, start time.Time) time.Time {
+	location := warehouse.Location
+	if location == nil {
+		location = utils.CET
+	}
+	local := start.In(location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	if !e.isWorkingDay(warehouse.CountryCode, day, false) || (warehouse.CutOff > 0 && local.Sub(day) >= warehouse.CutOff) {
+		day = e.getNextWorkingDay(warehouse.CountryCode, day, false)
+	}
+	for i := 0; i < warehouse.HandlingDays; i++ {
+		day = e.getNextWorkingDay(warehouse.CountryCode, day, false)
+	}
+	return day
+}
+
+func (e *DeliveryPromiseEngine) getNextWorkingDay(countryCode string, day time.Time, saturday bool) time.Time {
+	for {
+		day = day.AddDate(0, 0, 1)
+		if e.isWorkingDay(countryCode, day, saturday) {
+			return day
+		}
+	}
+}
+
+func (e *DeliveryPromiseEngine) isWorkingDay(countryCode string, day time.Time, saturday bool) bool {
+	switch day.Weekday() {
+	case time.Sunday:
+		return false
+	case time.Saturday:
+		if !saturday {
+			return false
+		}
+	}
+	return e.Holidays == nil || !e.Holidays.IsHoliday(countryCode, day)
+}
@@ -0,0 +1,128 @@
+package shop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/inventory"
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/utils"
+)
+
+type testStockLocator map[string][]*StockAvailability
+
+func (l testStockLocator) GetStockAvailability(itemID string) ([]*StockAvailability, error) {
+	return l[itemID], nil
+}
+
+func newTestDeliveryPromiseEngine(stock StockLocator) *DeliveryPromiseEngine {
+	holidays := NewStaticHolidayCalendar()
+	holidays.AddSwissHolidays(2020, HolidayBerchtoldstag, HolidayGoodFriday, HolidayEasterMonday, HolidayLabourDay, HolidayWhitMonday, HolidayStStephen)
+	engine := NewDeliveryPromiseEngine(stock, holidays)
+	engine.AddWarehouse(&Warehouse{Id: "zurich", CountryCode: "CH", CutOff: 15 * time.Hour})
+	engine.AddWarehouse(&Warehouse{Id: "basel", CountryCode: "CH", HandlingDays: 2})
+	engine.AddCarrier(&Carrier{Id: "post", TransitDays: 1})
+	return engine
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, utils.CET)
+}
+
+func TestEasterSunday(t *testing.T) {
+	assert.Equal(t, "2020-04-12", GetEasterSunday(2020).Format("2006-01-02"))
+	assert.Equal(t, "2021-04-04", GetEasterSunday(2021).Format("2006-01-02"))
+	assert.Equal(t, "2024-03-31", GetEasterSunday(2024).Format("2006-01-02"))
+}
+
+func TestNationalHolidays(t *testing.T) {
+	holidays := NewStaticHolidayCalendar()
+	for countryCode := range NationalHolidays {
+		assert.NoError(t, holidays.AddNationalHolidays(countryCode, 2020))
+	}
+	assert.Error(t, holidays.AddNationalHolidays("XX", 2020))
+	assert.True(t, holidays.IsHoliday("CH", date(2020, 8, 1)))
+	assert.False(t, holidays.IsHoliday("CH", date(2020, 4, 10)), "good friday is cantonal")
+	assert.True(t, holidays.IsHoliday("DE", date(2020, 10, 3)))
+	assert.True(t, holidays.IsHoliday("AT", date(2020, 6, 11)), "corpus christi")
+	assert.True(t, holidays.IsHoliday("FR", date(2020, 7, 14)))
+	assert.True(t, holidays.IsHoliday("IT", date(2020, 6, 2)))
+	assert.True(t, holidays.IsHoliday("LI", date(2020, 3, 19)))
+	assert.False(t, holidays.IsHoliday("FR", date(2020, 12, 26)))
+}
+
+func TestPromiseDeliveryCutOffAndHolidays(t *testing.T) {
+	engine := newTestDeliveryPromiseEngine(testStockLocator{
+		"shirt": {{Warehouse: "zurich", Quantity: 10}},
+	})
+
+	// thursday before easter: good friday and easter monday are holidays
+	promise, err := engine.PromiseDelivery("shirt", 1, "post", "CH", time.Date(2020, 4, 9, 14, 0, 0, 0, utils.CET))
+	assert.NoError(t, err)
+	assert.Equal(t, date(2020, 4, 9), promise.ShipDate)
+	assert.Equal(t, date(2020, 4, 14), promise.DeliveryDate)
+	assert.True(t, promise.InStock)
+
+	promise, err = engine.PromiseDelivery("shirt", 1, "post", "CH", time.Date(2020, 4, 9, 15, 30, 0, 0, utils.CET))
+	assert.NoError(t, err)
+	assert.Equal(t, date(2020, 4, 14), promise.ShipDate, "after cut-off")
+	assert.Equal(t, date(2020, 4, 15), promise.DeliveryDate)
+
+	// holidays of the destination country only apply to the transit
+	promise, err = engine.PromiseDelivery("shirt", 1, "post", "DE", time.Date(2020, 4, 9, 14, 0, 0, 0, utils.CET))
+	assert.NoError(t, err)
+	assert.Equal(t, date(2020, 4, 10), promise.DeliveryDate)
+
+	_, err = engine.PromiseDelivery("shirt", 11, "post", "CH", time.Date(2020, 4, 9, 14, 0, 0, 0, utils.CET))
+	assert.Equal(t, ErrorNoDeliveryPromise, err)
+	_, err = engine.PromiseDelivery("shirt", 1, "unknown", "CH", time.Now())
+	assert.Error(t, err)
+}
+
+func TestPromiseDeliveryRestock(t *testing.T) {
+	engine := newTestDeliveryPromiseEngine(testStockLocator{
+		"shoes": {
+			{Warehouse: "zurich", Quantity: 0, RestockDate: date(2020, 6, 15)},
+			{Warehouse: "basel", Quantity: 1},
+		},
+	})
+	now := time.Date(2020, 6, 8, 10, 0, 0, 0, utils.CET) // monday
+
+	promise, err := engine.PromiseDelivery("shoes", 1, "post", "CH", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "basel", promise.Warehouse)
+	assert.Equal(t, date(2020, 6, 10), promise.ShipDate, "two handling days")
+	assert.Equal(t, date(2020, 6, 11), promise.DeliveryDate)
+
+	promise, err = engine.PromiseDelivery("shoes", 2, "post", "CH", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "zurich", promise.Warehouse)
+	assert.False(t, promise.InStock)
+	assert.Equal(t, date(2020, 6, 16), promise.DeliveryDate)
+}
+
+func TestPromiseOrderDelivery(t *testing.T) {
+	inv := inventory.NewInventory(inventory.NewMemoryStore(), 0)
+	_, err := inv.AddStock("shirt", "zurich", 5)
+	assert.NoError(t, err)
+	_, err = inv.AddStock("shoes", "zurich", 5)
+	assert.NoError(t, err)
+	engine := newTestDeliveryPromiseEngine(&InventoryStockLocator{Inventory: inv})
+
+	o := newATPTestOrder()
+	o.Positions = o.Positions[:2]
+	o.Positions = append(o.Positions, &order.Position{ItemID: "shipping", Quantity: 1, IsShipping: true})
+	o.CustomerData = &order.CustomerData{ShippingAddress: &address.Address{CountryCode: "CH"}}
+
+	promises, err := engine.PromiseOrderDelivery(o, "post", time.Date(2020, 4, 9, 14, 0, 0, 0, utils.CET))
+	assert.NoError(t, err)
+	assert.Len(t, promises, 2)
+	assert.Equal(t, date(2020, 4, 14), o.GetPositionByItemId("shirt").PromisedDate)
+	assert.True(t, o.GetPositionByItemId("shipping").PromisedDate.IsZero())
+
+	request := CreateATPRequestFromOrder(o)
+	assert.Equal(t, date(2020, 4, 14), request.Items[0].DesiredDeliveryDate)
+}
@@ -0,0 +1,91 @@
+package shop
+
+import (
+	"errors"
+
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	ErrorCodeUnknownItem       = "unknown_item"
+	ErrorCodeInsufficientStock = "insufficient_stock"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// ATPProvider answers available to promise requests, e.g. an ERP or a warehouse system
+type ATPProvider interface {
+	GetATP(request *ATPRequest) (*ATPResponse, error)
+}
+
+// Adjustment is a position whose quantity has been changed by ApplyATPResponse
+type Adjustment struct {
+	ItemNumber       string
+	DesiredQuantity  float64
+	ApprovedQuantity float64
+	ErrorCode        string
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// RequestATP gets the ATP response for all positions of order from provider and applies it
+func RequestATP(provider ATPProvider, o *order.Order) ([]*Adjustment, error) {
+	response, err := provider.GetATP(CreateATPRequestFromOrder(o))
+	if err != nil {
+		return nil, err
+	}
+	return ApplyATPResponse(o, response)
+}
+
+// ApplyATPResponse reduces the quantity of every position to its approved quantity, positions without approved quantity are removed.
+// The delivery date is set on the positions, which are marked with IsATPApplied, and order.ATPAt is set.
+// Shipping positions and positions which are not part of the response are not changed.
+// The adjusted positions are returned.
+func ApplyATPResponse(o *order.Order, response *ATPResponse) ([]*Adjustment, error) {
+	if response == nil {
+		return nil, errors.New("no ATP response for order " + o.GetID())
+	}
+	responseItems := map[string]*ResponseItem{}
+	for _, item := range response.Items {
+		if _, ok := responseItems[item.ItemNumber]; !ok {
+			responseItems[item.ItemNumber] = item
+		}
+	}
+
+	adjustments := []*Adjustment{}
+	positions := []*order.Position{}
+	for _, position := range o.GetPositions() {
+		item, ok := responseItems[position.ItemID]
+		if position.IsShipping || !ok {
+			positions = append(positions, position)
+			continue
+		}
+		if item.ApprovedQuantity < position.Quantity {
+			adjustments = append(adjustments, &Adjustment{
+				ItemNumber:       position.ItemID,
+				DesiredQuantity:  position.Quantity,
+				ApprovedQuantity: item.ApprovedQuantity,
+				ErrorCode:        item.ErrorCode,
+			})
+			if item.ApprovedQuantity <= 0 {
+				continue
+			}
+			position.Quantity = item.ApprovedQuantity
+		}
+		position.IsATPApplied = true
+		position.DeliveryDate = item.DeliveryDate
+		positions = append(positions, position)
+	}
+	o.Positions = positions
+	o.ATPAt = utils.TimeNow()
+	return adjustments, o.Upsert()
+}
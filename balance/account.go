@@ -0,0 +1,95 @@
+package balance
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	AccountTypeGiftCard    AccountType = "giftCard"
+	AccountTypeStoreCredit AccountType = "storeCredit"
+
+	EntryTypeIssue  EntryType = "issue"  // initial value of an account
+	EntryTypeDebit  EntryType = "debit"  // (partial) use of the balance
+	EntryTypeCredit EntryType = "credit" // credit-back on refund or top-up
+	EntryTypeExpire EntryType = "expire" // remaining balance forfeited on expiry
+)
+
+// amountEpsilon is used to compare amounts
+const amountEpsilon = 0.0001
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type AccountType string
+type EntryType string
+
+// Account is a gift card or the store credit of a customer. Its balance is only changed by appending entries.
+type Account struct {
+	BsonId         bson.ObjectId `bson:"_id,omitempty"`
+	Id             string
+	Type           AccountType
+	Code           string `bson:"code,omitempty"` // gift card code, empty for store credit
+	CustomerID     string // owner of store credit, optional for gift cards
+	Currency       string
+	Balance        float64
+	ExpiresAt      time.Time // zero if the account does not expire
+	ExpiredAt      time.Time // set when the remaining balance has been forfeited
+	CreatedAt      time.Time
+	LastModifiedAt time.Time
+	Entries        []*Entry
+}
+
+// Entry is a single balance change of an account
+type Entry struct {
+	Type      EntryType
+	Amount    float64 // signed, debits and expiries are negative
+	Reference string  // unique per account, an entry with a known reference is never applied twice
+	Comment   string
+	CreatedAt time.Time
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// IsExpired returns true if the account has expired at now
+func (account *Account) IsExpired(now time.Time) bool {
+	return !account.ExpiredAt.IsZero() || (!account.ExpiresAt.IsZero() && !now.Before(account.ExpiresAt))
+}
+
+// GetEntryByReference returns the entry with reference or nil
+func (account *Account) GetEntryByReference(reference string) *Entry {
+	for _, entry := range account.Entries {
+		if entry.Reference == reference {
+			return entry
+		}
+	}
+	return nil
+}
+
+// GetCreditedBack returns the sum of all credits which reference a debit, see Ledger.CreditBack
+func (account *Account) GetCreditedBack(debitReference string) float64 {
+	sum := 0.0
+	prefix := creditBackReferencePrefix(debitReference)
+	for _, entry := range account.Entries {
+		if entry.Type == EntryTypeCredit && len(entry.Reference) > len(prefix) && entry.Reference[:len(prefix)] == prefix {
+			sum += entry.Amount
+		}
+	}
+	return sum
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func creditBackReferencePrefix(debitReference string) string {
+	return debitReference + "/credit/"
+}
@@ -0,0 +1,284 @@
+package balance
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/foomo/shop/unique"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// giftCardCodeAlphabet omits characters which are easily confused, e.g. 0 and O
+const giftCardCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// maxExpireRetries limits the retries of Expire, if the balance changes concurrently
+const maxExpireRetries = 5
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Ledger issues gift cards and store credit accounts and changes their balances.
+// Every change is an entry with a reference. Applying an entry with a known reference again is a no-op,
+// which protects against double-spending when a request is retried.
+type Ledger struct {
+	store Store
+	now   func() time.Time
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewLedger constructor. If store is nil, accounts are stored in mongo.
+func NewLedger(store Store) *Ledger {
+	if store == nil {
+		store = NewMongoStore()
+	}
+	return &Ledger{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// IssueGiftCard creates a gift card with value. If code is empty, a random code is generated.
+// A zero expiresAt issues a gift card which does not expire.
+func (l *Ledger) IssueGiftCard(code string, value float64, currency string, expiresAt time.Time, customerID string) (*Account, error) {
+	if value <= 0 {
+		return nil, errors.New("gift card value must be positive")
+	}
+	if code == "" {
+		var err error
+		code, err = NewGiftCardCode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	now := l.now()
+	account := &Account{
+		Id:             unique.GetNewID(),
+		Type:           AccountTypeGiftCard,
+		Code:           code,
+		CustomerID:     customerID,
+		Currency:       currency,
+		Balance:        roundAmount(value),
+		ExpiresAt:      expiresAt,
+		CreatedAt:      now,
+		LastModifiedAt: now,
+		Entries: []*Entry{
+			{Type: EntryTypeIssue, Amount: roundAmount(value), Reference: "issue", CreatedAt: now},
+		},
+	}
+	if err := l.store.Insert(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// GetStoreCreditAccount returns the store credit account of a customer and creates it, if it does not exist
+func (l *Ledger) GetStoreCreditAccount(customerID string, currency string) (*Account, error) {
+	if customerID == "" {
+		return nil, errors.New("store credit requires a customer id")
+	}
+	id := storeCreditAccountId(customerID, currency)
+	account, err := l.store.Get(id)
+	if err != ErrorAccountNotFound {
+		return account, err
+	}
+	now := l.now()
+	account = &Account{
+		Id:             id,
+		Type:           AccountTypeStoreCredit,
+		CustomerID:     customerID,
+		Currency:       currency,
+		CreatedAt:      now,
+		LastModifiedAt: now,
+		Entries:        []*Entry{},
+	}
+	err = l.store.Insert(account)
+	if err == ErrorAccountExists {
+		// created concurrently
+		return l.store.Get(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// GetAccount returns the account with id
+func (l *Ledger) GetAccount(id string) (*Account, error) {
+	return l.store.Get(id)
+}
+
+// GetGiftCard returns the gift card with code
+func (l *Ledger) GetGiftCard(code string) (*Account, error) {
+	return l.store.GetByCode(code)
+}
+
+// GetAvailableBalance returns the balance which can be debited now, which is zero for expired accounts
+func (l *Ledger) GetAvailableBalance(id string) (float64, error) {
+	account, err := l.store.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	if account.IsExpired(l.now()) {
+		return 0, nil
+	}
+	return account.Balance, nil
+}
+
+// Debit takes amount from the balance. Repeating a debit with the same reference returns the account unchanged,
+// unless the debit differs or has been credited back since, which returns ErrorReferenceMismatch or ErrorReferenceCreditedBack.
+func (l *Ledger) Debit(id string, amount float64, reference string, comment string) (*Account, error) {
+	if amount <= 0 {
+		return nil, errors.New("debit amount must be positive")
+	}
+	return l.apply(id, &Entry{Type: EntryTypeDebit, Amount: -roundAmount(amount), Reference: reference, Comment: comment}, nil)
+}
+
+// Credit adds amount to the balance, e.g. a top-up of store credit
+func (l *Ledger) Credit(id string, amount float64, reference string, comment string) (*Account, error) {
+	if amount <= 0 {
+		return nil, errors.New("credit amount must be positive")
+	}
+	return l.apply(id, &Entry{Type: EntryTypeCredit, Amount: roundAmount(amount), Reference: reference, Comment: comment}, nil)
+}
+
+// CreditBack gives back amount of the debit with debitReference, e.g. on refund.
+// The credited amount of all credit-backs of a debit cannot exceed the debit.
+func (l *Ledger) CreditBack(id string, debitReference string, amount float64, reference string) (*Account, error) {
+	if amount <= 0 {
+		return nil, errors.New("credit amount must be positive")
+	}
+	account, err := l.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	debit := account.GetEntryByReference(debitReference)
+	if debit == nil || debit.Type != EntryTypeDebit {
+		return nil, errors.New("no debit with reference " + debitReference)
+	}
+	entryReference := creditBackReferencePrefix(debitReference) + reference
+	if account.GetEntryByReference(entryReference) != nil {
+		return account, nil
+	}
+	open := -debit.Amount - account.GetCreditedBack(debitReference)
+	if amount > open+amountEpsilon {
+		return nil, fmt.Errorf("credit-back %.2f exceeds open debit %.2f", amount, open)
+	}
+	// the balance is expected unchanged, so that concurrent credit-backs cannot exceed the debit
+	return l.apply(id, &Entry{Type: EntryTypeCredit, Amount: roundAmount(amount), Reference: entryReference, Comment: "credit-back " + debitReference}, &account.Balance)
+}
+
+// Expire forfeits the remaining balance of an account
+func (l *Ledger) Expire(id string, reference string) (*Account, error) {
+	for i := 0; i < maxExpireRetries; i++ {
+		account, err := l.store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if !account.ExpiredAt.IsZero() {
+			return account, nil
+		}
+		balance := account.Balance
+		account, err = l.apply(id, &Entry{Type: EntryTypeExpire, Amount: -balance, Reference: reference}, &balance)
+		if err != ErrorBalanceChanged {
+			return account, err
+		}
+	}
+	return nil, ErrorBalanceChanged
+}
+
+// ExpireDue expires all accounts whose expiry date has passed and returns them
+func (l *Ledger) ExpireDue() ([]*Account, error) {
+	due, err := l.store.GetDueForExpiry(l.now())
+	if err != nil {
+		return nil, err
+	}
+	expired := []*Account{}
+	for _, account := range due {
+		account, err := l.Expire(account.Id, "expire")
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, account)
+	}
+	return expired, nil
+}
+
+// NewGiftCardCode returns a random code of the form XXXX-XXXX-XXXX-XXXX
+func NewGiftCardCode() (string, error) {
+	groups := []string{}
+	max := big.NewInt(int64(len(giftCardCodeAlphabet)))
+	for g := 0; g < 4; g++ {
+		group := make([]byte, 4)
+		for i := range group {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			group[i] = giftCardCodeAlphabet[n.Int64()]
+		}
+		groups = append(groups, string(group))
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// apply applies entry. An entry whose reference exists is not applied again and the account is returned unchanged.
+// A repeated entry must equal the existing one and a repeated debit must not have been credited back,
+// otherwise the caller would assume a balance change which did not happen.
+func (l *Ledger) apply(id string, entry *Entry, expectedBalance *float64) (*Account, error) {
+	if entry.Reference == "" {
+		return nil, errors.New("balance entry requires a reference")
+	}
+	entry.CreatedAt = l.now()
+	account, err := l.store.ApplyEntry(id, entry, expectedBalance, entry.CreatedAt)
+	if err == ErrorDuplicateReference {
+		return l.checkRepeatedEntry(id, entry)
+	}
+	if err != nil {
+		return nil, err
+	}
+	account.Balance = roundAmount(account.Balance)
+	return account, nil
+}
+
+// checkRepeatedEntry returns the account, if entry has already been applied and is still in effect
+func (l *Ledger) checkRepeatedEntry(id string, entry *Entry) (*Account, error) {
+	account, err := l.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	existing := account.GetEntryByReference(entry.Reference)
+	if existing == nil {
+		return nil, ErrorDuplicateReference
+	}
+	if existing.Type != entry.Type || math.Abs(existing.Amount-entry.Amount) > amountEpsilon {
+		return nil, ErrorReferenceMismatch
+	}
+	if existing.Type == EntryTypeDebit && account.GetCreditedBack(entry.Reference) > amountEpsilon {
+		return nil, ErrorReferenceCreditedBack
+	}
+	return account, nil
+}
+
+func storeCreditAccountId(customerID string, currency string) string {
+	return "storecredit-" + customerID + "-" + currency
+}
@@ -0,0 +1,199 @@
+package balance
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestLedgerGiftCard(t *testing.T) {
+	ledger := NewLedger(NewMemoryStore())
+	card, err := ledger.IssueGiftCard("", 100, "CHF", time.Time{}, "")
+	assert.NoError(t, err)
+	assert.Len(t, card.Code, 19)
+
+	card, err = ledger.Debit(card.Id, 30, "order-1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 70.0, card.Balance)
+
+	card, err = ledger.Debit(card.Id, 30, "order-1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 70.0, card.Balance, "repeated debit is not applied twice")
+
+	_, err = ledger.Debit(card.Id, 70.01, "order-2", "")
+	assert.Equal(t, ErrorInsufficientBalance, err)
+
+	card, err = ledger.CreditBack(card.Id, "order-1", 20, "return-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 90.0, card.Balance)
+	_, err = ledger.CreditBack(card.Id, "order-1", 20, "return-2")
+	assert.Error(t, err, "credit-back exceeds debit")
+	card, err = ledger.CreditBack(card.Id, "order-1", 10, "return-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, card.Balance)
+
+	byCode, err := ledger.GetGiftCard(card.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, card.Id, byCode.Id)
+	assert.Len(t, byCode.Entries, 4)
+
+	_, err = ledger.Debit(card.Id, 30, "order-1", "")
+	assert.Equal(t, ErrorReferenceCreditedBack, err, "a credited back debit cannot be repeated")
+	_, err = ledger.Debit(card.Id, 40, "order-2", "")
+	assert.NoError(t, err)
+	_, err = ledger.Debit(card.Id, 50, "order-2", "")
+	assert.Equal(t, ErrorReferenceMismatch, err)
+	card, err = ledger.GetAccount(card.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 60.0, card.Balance)
+}
+
+func TestLedgerExpiry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ledger := NewLedger(NewMemoryStore())
+	ledger.now = func() time.Time { return now }
+
+	card, err := ledger.IssueGiftCard("CODE", 50, "CHF", now.AddDate(1, 0, 0), "")
+	assert.NoError(t, err)
+	_, err = ledger.Debit(card.Id, 10, "order-1", "")
+	assert.NoError(t, err)
+
+	now = now.AddDate(1, 0, 1)
+	available, err := ledger.GetAvailableBalance(card.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, available)
+	_, err = ledger.Debit(card.Id, 10, "order-2", "")
+	assert.Equal(t, ErrorAccountExpired, err)
+
+	expired, err := ledger.ExpireDue()
+	assert.NoError(t, err)
+	assert.Len(t, expired, 1)
+	assert.Equal(t, 0.0, expired[0].Balance)
+	assert.Equal(t, -40.0, expired[0].Entries[2].Amount)
+
+	expired, err = ledger.ExpireDue()
+	assert.NoError(t, err)
+	assert.Empty(t, expired)
+}
+
+func TestLedgerStoreCredit(t *testing.T) {
+	ledger := NewLedger(NewMemoryStore())
+	account, err := ledger.GetStoreCreditAccount("customer", "CHF")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, account.Balance)
+	_, err = ledger.Credit(account.Id, 25, "return-1", "returned shoes")
+	assert.NoError(t, err)
+
+	again, err := ledger.GetStoreCreditAccount("customer", "CHF")
+	assert.NoError(t, err)
+	assert.Equal(t, account.Id, again.Id)
+	assert.Equal(t, 25.0, again.Balance)
+}
+
+func TestLedgerConcurrentDebits(t *testing.T) {
+	ledger := NewLedger(NewMemoryStore())
+	card, err := ledger.IssueGiftCard("", 100, "CHF", time.Time{}, "")
+	assert.NoError(t, err)
+
+	// 50 concurrent debits of 3 on a balance of 100: exactly 33 may succeed
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	succeeded := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := ledger.Debit(card.Id, 3, "order-"+strconv.Itoa(i), ""); err == nil {
+				mutex.Lock()
+				succeeded++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	card, err = ledger.GetAccount(card.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 33, succeeded)
+	assert.InDelta(t, 1.0, card.Balance, amountEpsilon)
+}
+
+func TestMongoStoreApplyEntry(t *testing.T) {
+	session, collection := GetAccountPersistor().GetCollection()
+	defer session.Close()
+
+	assert.NoError(t, collection.DropCollection(), "clean up")
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMongoStore()
+	ledger := NewLedger(store)
+	ledger.now = func() time.Time { return now }
+	card, err := ledger.IssueGiftCard("", 100, "CHF", now.AddDate(1, 0, 0), "")
+	assert.NoError(t, err)
+
+	card, err = store.ApplyEntry(card.Id, &Entry{Type: EntryTypeDebit, Amount: -30, Reference: "order-1", CreatedAt: now}, nil, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 70.0, card.Balance)
+	_, err = store.ApplyEntry(card.Id, &Entry{Type: EntryTypeDebit, Amount: -30, Reference: "order-1", CreatedAt: now}, nil, now)
+	assert.Equal(t, ErrorDuplicateReference, err)
+	_, err = store.ApplyEntry(card.Id, &Entry{Type: EntryTypeDebit, Amount: -70.01, Reference: "order-2", CreatedAt: now}, nil, now)
+	assert.Equal(t, ErrorInsufficientBalance, err)
+	expectedBalance := 100.0
+	_, err = store.ApplyEntry(card.Id, &Entry{Type: EntryTypeDebit, Amount: -10, Reference: "order-2", CreatedAt: now}, &expectedBalance, now)
+	assert.Equal(t, ErrorBalanceChanged, err)
+	_, err = store.ApplyEntry(card.Id, &Entry{Type: EntryTypeDebit, Amount: -10, Reference: "order-2", CreatedAt: now}, nil, now.AddDate(1, 0, 0))
+	assert.Equal(t, ErrorAccountExpired, err)
+
+	// 50 concurrent debits of 3 on a balance of 70: exactly 23 may succeed
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	succeeded := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := ledger.Debit(card.Id, 3, "order-"+strconv.Itoa(i+10), ""); err == nil {
+				mutex.Lock()
+				succeeded++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	card, err = store.Get(card.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 23, succeeded)
+	assert.InDelta(t, 1.0, card.Balance, amountEpsilon)
+	assert.Len(t, card.Entries, 25)
+}
+
+func TestMongoStoreStoreCredit(t *testing.T) {
+	session, collection := GetAccountPersistor().GetCollection()
+	defer session.Close()
+
+	assert.NoError(t, collection.DropCollection(), "clean up")
+	for _, index := range accountEnsuredIndexes {
+		assert.NoError(t, collection.EnsureIndex(index))
+	}
+
+	ledger := NewLedger(NewMongoStore())
+	for _, customerID := range []string{"alice", "bob"} {
+		account, err := ledger.GetStoreCreditAccount(customerID, "CHF")
+		assert.NoError(t, err, customerID)
+		assert.Equal(t, customerID, account.CustomerID)
+		again, err := ledger.GetStoreCreditAccount(customerID, "CHF")
+		assert.NoError(t, err, customerID)
+		assert.Equal(t, account.Id, again.Id)
+	}
+	_, err := ledger.IssueGiftCard("CODE", 50, "CHF", time.Time{}, "")
+	assert.NoError(t, err)
+	_, err = ledger.IssueGiftCard("CODE", 50, "CHF", time.Time{}, "")
+	assert.Equal(t, ErrorAccountExists, err, "gift card codes are unique")
+
+	count, err := collection.Find(bson.M{"code": bson.M{"$exists": true}}).Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "store credit accounts have no code")
+}
@@ -0,0 +1,321 @@
+package balance
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/configuration"
+	"github.com/foomo/shop/persistence"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var (
+	ErrorAccountNotFound    = errors.New("balance account not found")
+	ErrorAccountExists      = errors.New("balance account exists")
+	ErrorDuplicateReference = errors.New("balance entry with same reference exists")
+	// ErrorReferenceMismatch is returned by Ledger, if an entry with the same reference but another type or amount exists
+	ErrorReferenceMismatch = errors.New("balance entry with same reference differs")
+	// ErrorReferenceCreditedBack is returned by Ledger, if a debit is repeated after it has been credited back
+	ErrorReferenceCreditedBack = errors.New("balance entry with same reference has been credited back")
+	ErrorInsufficientBalance   = errors.New("insufficient balance")
+	ErrorAccountExpired        = errors.New("balance account expired")
+	ErrorBalanceChanged        = errors.New("balance changed concurrently")
+)
+
+var (
+	globalAccountPersistor *persistence.Persistor
+
+	accountEnsuredIndexes = []mgo.Index{
+		{
+			Name:   "id",
+			Key:    []string{"id"},
+			Unique: true,
+		},
+		{
+			Name:   "code",
+			Key:    []string{"code"},
+			Unique: true,
+			Sparse: true,
+		},
+		{
+			Name:       "customerid",
+			Key:        []string{"customerid", "type"},
+			Unique:     false,
+			Background: true,
+		},
+	}
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Store persists accounts. ApplyEntry must be atomic, it is the only way to change a balance.
+type Store interface {
+	// Insert stores a new account and returns ErrorAccountExists if id or code are taken
+	Insert(account *Account) error
+	// Get returns the account with id or ErrorAccountNotFound
+	Get(id string) (*Account, error)
+	// GetByCode returns the gift card with code or ErrorAccountNotFound
+	GetByCode(code string) (*Account, error)
+	// GetDueForExpiry returns all accounts which expire before now and have not been expired yet
+	GetDueForExpiry(now time.Time) ([]*Account, error)
+	// ApplyEntry atomically adds entry.Amount to the balance and appends entry. It fails with
+	// ErrorDuplicateReference, ErrorInsufficientBalance, ErrorAccountExpired or, if expectedBalance is not nil
+	// and differs from the balance, ErrorBalanceChanged.
+	ApplyEntry(id string, entry *Entry, expectedBalance *float64, now time.Time) (*Account, error)
+}
+
+// MongoStore stores accounts in configuration.MONGO_COLLECTION_BALANCE_ACCOUNTS
+type MongoStore struct{}
+
+// MemoryStore holds accounts in a map, nothing survives the process
+type MemoryStore struct {
+	sync.Mutex
+	accounts map[string]*Account
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMongoStore constructor
+func NewMongoStore() *MongoStore {
+	return &MongoStore{}
+}
+
+// NewMemoryStore constructor
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{accounts: map[string]*Account{}}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// GetAccountPersistor will return a singleton instance of a balance account mongo persistor
+func GetAccountPersistor() *persistence.Persistor {
+	url := configuration.GetMongoURL()
+	collection := configuration.MONGO_COLLECTION_BALANCE_ACCOUNTS
+	if globalAccountPersistor == nil {
+		p, err := persistence.NewPersistorWithIndexes(url, collection, accountEnsuredIndexes)
+		if err != nil || p == nil {
+			panic(errors.New("failed to create mongoDB balance account persistor: " + err.Error()))
+		}
+		globalAccountPersistor = p
+		return globalAccountPersistor
+	}
+
+	if url == globalAccountPersistor.GetURL() && collection == globalAccountPersistor.GetCollectionName() {
+		return globalAccountPersistor
+	}
+
+	p, err := persistence.NewPersistorWithIndexes(url, collection, accountEnsuredIndexes)
+	if err != nil || p == nil {
+		panic(err)
+	}
+	globalAccountPersistor = p
+	return globalAccountPersistor
+}
+
+func (s *MongoStore) Insert(account *Account) error {
+	session, collection := GetAccountPersistor().GetCollection()
+	defer session.Close()
+	err := collection.Insert(account)
+	if mgo.IsDup(err) {
+		return ErrorAccountExists
+	}
+	return err
+}
+
+func (s *MongoStore) Get(id string) (*Account, error) {
+	return s.findOne(&bson.M{"id": id})
+}
+
+func (s *MongoStore) GetByCode(code string) (*Account, error) {
+	return s.findOne(&bson.M{"code": code})
+}
+
+func (s *MongoStore) GetDueForExpiry(now time.Time) ([]*Account, error) {
+	session, collection := GetAccountPersistor().GetCollection()
+	defer session.Close()
+	accounts := []*Account{}
+	err := collection.Find(&bson.M{
+		"expiredat": time.Time{},
+		"expiresat": &bson.M{"$gt": time.Time{}, "$lte": now},
+	}).All(&accounts)
+	return accounts, err
+}
+
+func (s *MongoStore) ApplyEntry(id string, entry *Entry, expectedBalance *float64, now time.Time) (*Account, error) {
+	session, collection := GetAccountPersistor().GetCollection()
+	defer session.Close()
+
+	// the account must be unexpired, must not have the reference yet and must cover the amount,
+	// an entry which fails any condition matches no document and is not applied
+	query := bson.M{
+		"id":                id,
+		"expiredat":         time.Time{},
+		"entries.reference": &bson.M{"$ne": entry.Reference},
+	}
+	if entry.Type != EntryTypeExpire {
+		query["$or"] = []bson.M{{"expiresat": time.Time{}}, {"expiresat": &bson.M{"$gt": now}}}
+	}
+	if expectedBalance != nil {
+		query["balance"] = *expectedBalance
+	} else if entry.Amount < 0 {
+		query["balance"] = &bson.M{"$gte": -entry.Amount - amountEpsilon}
+	}
+	set := bson.M{"lastmodifiedat": now}
+	if entry.Type == EntryTypeExpire {
+		set["expiredat"] = now
+	}
+	account := &Account{}
+	_, err := collection.Find(query).Apply(mgo.Change{
+		Update: &bson.M{
+			"$inc":  &bson.M{"balance": entry.Amount},
+			"$push": &bson.M{"entries": entry},
+			"$set":  set,
+		},
+		ReturnNew: true,
+	}, account)
+	if err == mgo.ErrNotFound {
+		// find out which condition failed
+		current, errGet := s.Get(id)
+		if errGet != nil {
+			return nil, errGet
+		}
+		if errCheck := checkEntry(current, entry, expectedBalance, now); errCheck != nil {
+			return nil, errCheck
+		}
+		return nil, ErrorBalanceChanged
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *MemoryStore) Insert(account *Account) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, existing := range s.accounts {
+		if existing.Id == account.Id || (account.Code != "" && existing.Code == account.Code) {
+			return ErrorAccountExists
+		}
+	}
+	s.accounts[account.Id] = copyAccount(account)
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (*Account, error) {
+	s.Lock()
+	defer s.Unlock()
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, ErrorAccountNotFound
+	}
+	return copyAccount(account), nil
+}
+
+func (s *MemoryStore) GetByCode(code string) (*Account, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, account := range s.accounts {
+		if account.Code == code {
+			return copyAccount(account), nil
+		}
+	}
+	return nil, ErrorAccountNotFound
+}
+
+func (s *MemoryStore) GetDueForExpiry(now time.Time) ([]*Account, error) {
+	s.Lock()
+	defer s.Unlock()
+	accounts := []*Account{}
+	for _, account := range s.accounts {
+		if account.ExpiredAt.IsZero() && !account.ExpiresAt.IsZero() && !now.Before(account.ExpiresAt) {
+			accounts = append(accounts, copyAccount(account))
+		}
+	}
+	return accounts, nil
+}
+
+func (s *MemoryStore) ApplyEntry(id string, entry *Entry, expectedBalance *float64, now time.Time) (*Account, error) {
+	s.Lock()
+	defer s.Unlock()
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, ErrorAccountNotFound
+	}
+	if err := checkEntry(account, entry, expectedBalance, now); err != nil {
+		return nil, err
+	}
+	copied := *entry
+	account.Balance += entry.Amount
+	account.Entries = append(account.Entries, &copied)
+	account.LastModifiedAt = now
+	if entry.Type == EntryTypeExpire {
+		account.ExpiredAt = now
+	}
+	return copyAccount(account), nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func (s *MongoStore) findOne(find *bson.M) (*Account, error) {
+	session, collection := GetAccountPersistor().GetCollection()
+	defer session.Close()
+	account := &Account{}
+	err := collection.Find(find).One(account)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// checkEntry returns the reason why entry cannot be applied to account or nil
+func checkEntry(account *Account, entry *Entry, expectedBalance *float64, now time.Time) error {
+	if account.GetEntryByReference(entry.Reference) != nil {
+		return ErrorDuplicateReference
+	}
+	if !account.ExpiredAt.IsZero() || (entry.Type != EntryTypeExpire && account.IsExpired(now)) {
+		return ErrorAccountExpired
+	}
+	if expectedBalance != nil {
+		if account.Balance != *expectedBalance {
+			return ErrorBalanceChanged
+		}
+	} else if entry.Amount < 0 && account.Balance < -entry.Amount-amountEpsilon {
+		return ErrorInsufficientBalance
+	}
+	return nil
+}
+
+func copyAccount(account *Account) *Account {
+	copied := *account
+	copied.Entries = make([]*Entry, len(account.Entries))
+	for i, entry := range account.Entries {
+		copiedEntry := *entry
+		copied.Entries[i] = &copiedEntry
+	}
+	return &copied
+}
+
+// roundAmount rounds to a precision which avoids floating point residues in balances
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*10000) / 10000
+}
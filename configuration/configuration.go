@@ -24,9 +24,20 @@ var (
 	MONGO_COLLECTION_CUSTOMERS      = "customerscrm"
 	MONGO_COLLECTION_WATCHLISTS     = "watchlists"
 
+	MONGO_COLLECTION_CUSTOMER_CREDENTIALS = "customer_credentials"
+	MONGO_COLLECTION_CUSTOMER_TOKENS      = "customer_tokens"
+	MONGO_COLLECTION_CUSTOMER_MERGES      = "customer_merges"
+	MONGO_COLLECTION_GDPR_ERASURES        = "gdpr_erasures"
+
 	MONGO_COLLECTION_PRICERULES          = "pricerules"
 	MONGO_COLLECTION_PRICERULES_VOUCHERS = "pricerules_vouchers"
 	MONGO_COLLECTION_PRICERULES_GROUPS   = "pricerules_groups"
+
+	MONGO_COLLECTION_PAYMENT_TRANSACTIONS = "payment_transactions"
+	MONGO_COLLECTION_BALANCE_ACCOUNTS     = "balance_accounts"
+
+	MONGO_COLLECTION_INVENTORY_STOCK        = "inventory_stock"
+	MONGO_COLLECTION_INVENTORY_RESERVATIONS = "inventory_reservations"
 )
 
 // AllowedLanguages contains language codes for all allowed languages
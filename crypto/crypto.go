@@ -10,9 +10,12 @@ import (
 // ~ PUBLIC TYPES
 //------------------------------------------------------------------
 
+// Crypto holds a password hash. Hash is self describing, e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+// HashedPassword and Salt are only set for legacy hashes, which are bcrypt hashes of the password with appended salt.
 type Crypto struct {
 	HashedPassword []byte
 	Salt           []byte
+	Hash           string
 }
 
 //------------------------------------------------------------------
@@ -40,31 +43,14 @@ func CreateHash(s string) (string, error) {
 // ~ PUBLIC METHODS
 //------------------------------------------------------------------
 
-// HashPassword returns a Crypto struct containing the hashed password and salt
+// HashPassword returns a Crypto struct containing a self describing hash of password created with the current policy
 func HashPassword(password string) (*Crypto, error) {
-	salt, err := newSalt()
-	if err != nil {
-		return nil, err
-	}
-	passwordBytes := []byte(password)
-	// Add salt to password
-	passwordBytes = append(passwordBytes, salt...)
-	hash, err := bcrypt.GenerateFromPassword(passwordBytes, bcrypt.DefaultCost+2)
-	if err != nil {
-		return nil, err
-	}
-
-	crypto := &Crypto{
-		HashedPassword: hash,
-		Salt:           salt,
-	}
-	return crypto, nil
+	return HashPasswordWithPolicy(password, hashPolicy)
 }
 
-// VerifyPassword returns true if the salted password matches against the hash.
+// VerifyPassword returns true if the password matches against the hash.
+// Use VerifyPasswordAndCheckRehash to find out whether the hash should be upgraded.
 func VerifyPassword(crypto *Crypto, password string) bool {
-	passwordBytes := []byte(password)
-	// Add salt to password
-	passwordBytes = append(passwordBytes, crypto.Salt...)
-	return bcrypt.CompareHashAndPassword(crypto.HashedPassword, passwordBytes) == nil
+	ok, _ := VerifyPasswordAndCheckRehash(crypto, password)
+	return ok
 }
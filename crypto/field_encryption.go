@@ -0,0 +1,377 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// EncryptedValuePrefix marks an encrypted value: enc1:<key id>:<wrapped data key>:<ciphertext>
+const EncryptedValuePrefix = "enc1" + encryptedValueSeparator
+
+// BlindIndexSuffix is appended to the name of an encrypted field to get the name of its blind index field, e.g. emailindex
+const BlindIndexSuffix = "index"
+
+const encryptedValueSeparator = ":"
+
+var (
+	ErrorFieldEncryptionDisabled = errors.New("field encryption is not configured")
+	ErrorInvalidEncryptedValue   = errors.New("invalid encrypted value")
+)
+
+// fieldEncryption is used to encrypt and decrypt fields when documents are stored and loaded. If nil, fields are stored in plaintext.
+var fieldEncryption *FieldEncryption
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// FieldEncryption encrypts single values with envelope encryption: every value is encrypted with a new data key,
+// which is encrypted (wrapped) with the current key of the KeyProvider. Rotating keys only requires to rewrap the data keys.
+// Blind indexes are keyed hashes of values, which allow equality lookups of encrypted fields.
+type FieldEncryption struct {
+	Keys KeyProvider
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewFieldEncryption constructor
+func NewFieldEncryption(keys KeyProvider) *FieldEncryption {
+	return &FieldEncryption{
+		Keys: keys,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// SetFieldEncryption enables field encryption for customers, addresses and orders. nil disables it.
+// Encrypted values can only be read while field encryption is enabled.
+func SetFieldEncryption(fe *FieldEncryption) {
+	fieldEncryption = fe
+}
+
+// GetFieldEncryption returns the current field encryption or nil
+func GetFieldEncryption() *FieldEncryption {
+	return fieldEncryption
+}
+
+// IsEncrypted returns true if value has been encrypted by FieldEncryption
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedValuePrefix)
+}
+
+// EncryptField encrypts value if field encryption is enabled. Empty values are not encrypted.
+func EncryptField(value string) (string, error) {
+	if fieldEncryption == nil {
+		return value, nil
+	}
+	return fieldEncryption.Encrypt(value)
+}
+
+// DecryptField decrypts value if it is encrypted. Plaintext values are returned unchanged, so that existing data can be read.
+func DecryptField(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if fieldEncryption == nil {
+		return "", ErrorFieldEncryptionDisabled
+	}
+	return fieldEncryption.Decrypt(value)
+}
+
+// EncryptFields encrypts the given fields in place, if field encryption is enabled
+func EncryptFields(fields ...*string) error {
+	for _, field := range fields {
+		encrypted, err := EncryptField(*field)
+		if err != nil {
+			return err
+		}
+		*field = encrypted
+	}
+	return nil
+}
+
+// DecryptFields decrypts the given fields in place. Plaintext fields are not changed.
+func DecryptFields(fields ...*string) error {
+	for _, field := range fields {
+		decrypted, err := DecryptField(*field)
+		if err != nil {
+			return err
+		}
+		*field = decrypted
+	}
+	return nil
+}
+
+// BlindIndex returns the blind index of value for the field name or an empty string, if field encryption is disabled
+func BlindIndex(name string, value string) string {
+	if fieldEncryption == nil {
+		return ""
+	}
+	return fieldEncryption.BlindIndex(name, value)
+}
+
+// EqualityQuery returns a query for documents with field == value. If field encryption is enabled, the blind index
+// of the last path element of field is queried, e.g. customerdata.emailindex for customerdata.email.
+// Documents which have been stored before field encryption was enabled are matched by their plaintext value.
+func EqualityQuery(field string, value string) bson.M {
+	if fieldEncryption == nil {
+		return bson.M{field: value}
+	}
+	name := field[strings.LastIndex(field, ".")+1:]
+	return bson.M{"$or": []bson.M{
+		{field + BlindIndexSuffix: fieldEncryption.BlindIndex(name, value)},
+		{field: value},
+	}}
+}
+
+// DecryptDocument decrypts all encrypted string values of a decoded bson document in place, e.g. for exports
+func DecryptDocument(document interface{}) error {
+	_, _, err := transformDocument(document, func(value string) (string, bool, error) {
+		if !IsEncrypted(value) {
+			return value, false, nil
+		}
+		decrypted, err := DecryptField(value)
+		return decrypted, true, err
+	})
+	return err
+}
+
+// RewrapDocument rewraps all encrypted string values of a decoded bson document in place with the current key
+// and returns the number of changed values
+func RewrapDocument(document interface{}) (int, error) {
+	if fieldEncryption == nil {
+		return 0, ErrorFieldEncryptionDisabled
+	}
+	_, n, err := transformDocument(document, fieldEncryption.Rewrap)
+	return n, err
+}
+
+// Encrypt encrypts value with a new data key. Empty values are not encrypted.
+func (fe *FieldEncryption) Encrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	dataKey, err := newKey()
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+	keyId := fe.Keys.GetCurrentKeyId()
+	wrappedKey, err := fe.wrapKey(keyId, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return EncryptedValuePrefix + keyId + encryptedValueSeparator + wrappedKey + encryptedValueSeparator + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts value. Plaintext values are returned unchanged.
+func (fe *FieldEncryption) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	keyId, wrappedKey, encodedCiphertext, err := splitEncryptedValue(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := fe.unwrapKey(keyId, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(encodedCiphertext)
+	if err != nil {
+		return "", ErrorInvalidEncryptedValue
+	}
+	plaintext, err := open(dataKey, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRewrap returns true, if value is encrypted with another than the current key
+func (fe *FieldEncryption) NeedsRewrap(value string) bool {
+	if !IsEncrypted(value) {
+		return false
+	}
+	keyId, _, _, err := splitEncryptedValue(value)
+	return err == nil && keyId != fe.Keys.GetCurrentKeyId()
+}
+
+// Rewrap returns value with its data key wrapped by the current key. The ciphertext is not changed.
+// The bool is true, if value has been changed.
+func (fe *FieldEncryption) Rewrap(value string) (string, bool, error) {
+	if !fe.NeedsRewrap(value) {
+		return value, false, nil
+	}
+	keyId, wrappedKey, ciphertext, err := splitEncryptedValue(value)
+	if err != nil {
+		return "", false, err
+	}
+	dataKey, err := fe.unwrapKey(keyId, wrappedKey)
+	if err != nil {
+		return "", false, err
+	}
+	currentKeyId := fe.Keys.GetCurrentKeyId()
+	rewrappedKey, err := fe.wrapKey(currentKeyId, dataKey)
+	if err != nil {
+		return "", false, err
+	}
+	return EncryptedValuePrefix + currentKeyId + encryptedValueSeparator + rewrappedKey + encryptedValueSeparator + ciphertext, true, nil
+}
+
+// BlindIndex returns the HMAC-SHA256 of value for the field name. Values of different fields have different indexes.
+// Emails are compared case insensitive. Empty values have an empty index.
+func (fe *FieldEncryption) BlindIndex(name string, value string) string {
+	if value == "" {
+		return ""
+	}
+	if name == "email" {
+		value = strings.ToLower(strings.TrimSpace(value))
+	}
+	return KeyedHash(fe.Keys, name, []byte(value))
+}
+
+// KeyedHash returns the HMAC-SHA256 of data with the index key of keys. name separates hashes for different purposes.
+func KeyedHash(keys KeyProvider, name string, data []byte) string {
+	mac := hmac.New(sha256.New, keys.GetIndexKey())
+	mac.Write([]byte(name + "\x00"))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// wrapKey encrypts dataKey with the key with keyId. The key id is authenticated, so that it cannot be swapped.
+func (fe *FieldEncryption) wrapKey(keyId string, dataKey []byte) (string, error) {
+	key, err := fe.Keys.GetKey(keyId)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(key, dataKey, []byte(keyId))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(wrapped), nil
+}
+
+func (fe *FieldEncryption) unwrapKey(keyId string, wrappedKey string) ([]byte, error) {
+	key, err := fe.Keys.GetKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, ErrorInvalidEncryptedValue
+	}
+	return open(key, wrapped, []byte(keyId))
+}
+
+func splitEncryptedValue(value string) (keyId string, wrappedKey string, ciphertext string, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, EncryptedValuePrefix), encryptedValueSeparator)
+	if len(parts) != 3 {
+		return "", "", "", ErrorInvalidEncryptedValue
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// seal encrypts plaintext with AES-GCM and returns nonce and ciphertext
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrorInvalidEncryptedValue
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrorInvalidEncryptedValue
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// transformDocument applies transform to all string values of a decoded bson document.
+// Maps and slices are changed in place. It returns the transformed value and the number of changed strings.
+func transformDocument(value interface{}, transform func(string) (string, bool, error)) (interface{}, int, error) {
+	switch typed := value.(type) {
+	case string:
+		transformed, changed, err := transform(typed)
+		if err != nil || !changed {
+			return value, 0, err
+		}
+		return transformed, 1, nil
+	case bson.M:
+		n, err := transformMap(typed, transform)
+		return value, n, err
+	case map[string]interface{}:
+		n, err := transformMap(typed, transform)
+		return value, n, err
+	case []interface{}:
+		n := 0
+		for i, element := range typed {
+			transformed, changed, err := transformDocument(element, transform)
+			if err != nil {
+				return value, n, err
+			}
+			typed[i] = transformed
+			n += changed
+		}
+		return typed, n, nil
+	}
+	return value, 0, nil
+}
+
+func transformMap(m map[string]interface{}, transform func(string) (string, bool, error)) (int, error) {
+	n := 0
+	for key, element := range m {
+		transformed, changed, err := transformDocument(element, transform)
+		if err != nil {
+			return n, err
+		}
+		m[key] = transformed
+		n += changed
+	}
+	return n, nil
+}
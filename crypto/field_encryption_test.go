@@ -0,0 +1,199 @@
+package crypto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// newTestKeyFile creates a key file in a temporary directory, the returned function removes it
+func newTestKeyFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "shop-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "keys.json")
+	if err := GenerateKeyFile(path, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func newTestFieldEncryption(t *testing.T) (*FieldEncryption, *FileKeyProvider, string, func()) {
+	path, cleanup := newTestKeyFile(t)
+	keys, err := NewFileKeyProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFieldEncryption(keys), keys, path, cleanup
+}
+
+func TestFieldEncryption(t *testing.T) {
+	fe, _, path, cleanup := newTestFieldEncryption(t)
+	defer cleanup()
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatal("key file must only be readable by its owner", err)
+	}
+	if GenerateKeyFile(path, "k2") != ErrorKeyFileExist {
+		t.Fatal("an existing key file must not be overwritten")
+	}
+
+	encrypted, err := fe.Encrypt("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "alice") || !strings.HasPrefix(encrypted, EncryptedValuePrefix+"k1:") {
+		t.Fatal("unexpected encrypted value", encrypted)
+	}
+	again, _ := fe.Encrypt("alice@example.com")
+	if again == encrypted {
+		t.Fatal("every value must be encrypted with a new data key")
+	}
+	decrypted, err := fe.Decrypt(encrypted)
+	if err != nil || decrypted != "alice@example.com" {
+		t.Fatal("decryption failed", decrypted, err)
+	}
+	if empty, _ := fe.Encrypt(""); empty != "" {
+		t.Fatal("empty values are not encrypted")
+	}
+	if plain, err := fe.Decrypt("plain"); err != nil || plain != "plain" {
+		t.Fatal("plaintext values must be returned unchanged")
+	}
+
+	parts := strings.Split(encrypted, encryptedValueSeparator)
+	tampered := strings.Join([]string{parts[0], parts[1], parts[2], parts[3][:len(parts[3])-2] + "AA"}, encryptedValueSeparator)
+	if _, err := fe.Decrypt(tampered); err != ErrorInvalidEncryptedValue {
+		t.Fatal("modified ciphertexts must be rejected", err)
+	}
+	if _, err := fe.Decrypt(strings.Replace(encrypted, ":k1:", ":k2:", 1)); err != ErrorUnknownKey {
+		t.Fatal("unknown keys must be rejected", err)
+	}
+}
+
+func TestFieldEncryptionKeyRotation(t *testing.T) {
+	fe, keys, path, cleanup := newTestFieldEncryption(t)
+	defer cleanup()
+
+	encrypted, _ := fe.Encrypt("Bahnhofstrasse 1")
+	index := fe.BlindIndex("street", "Bahnhofstrasse 1")
+	if fe.NeedsRewrap(encrypted) {
+		t.Fatal("value is encrypted with the current key")
+	}
+
+	if err := RotateKeyFile(path, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if RotateKeyFile(path, "k2") == nil {
+		t.Fatal("key ids must be unique")
+	}
+	if err := keys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if keys.GetCurrentKeyId() != "k2" || !fe.NeedsRewrap(encrypted) {
+		t.Fatal("k2 must be the current key")
+	}
+	if decrypted, err := fe.Decrypt(encrypted); err != nil || decrypted != "Bahnhofstrasse 1" {
+		t.Fatal("values encrypted with an old key must still be readable", err)
+	}
+	if fe.BlindIndex("street", "Bahnhofstrasse 1") != index {
+		t.Fatal("blind indexes must not change on key rotation")
+	}
+
+	rewrapped, changed, err := fe.Rewrap(encrypted)
+	if err != nil || !changed || fe.NeedsRewrap(rewrapped) {
+		t.Fatal("rewrap failed", err)
+	}
+	if strings.Split(rewrapped, encryptedValueSeparator)[3] != strings.Split(encrypted, encryptedValueSeparator)[3] {
+		t.Fatal("rewrapping must not change the ciphertext")
+	}
+	if decrypted, _ := fe.Decrypt(rewrapped); decrypted != "Bahnhofstrasse 1" {
+		t.Fatal("rewrapped value must be readable")
+	}
+	if _, changed, _ := fe.Rewrap(rewrapped); changed {
+		t.Fatal("current values must not be rewrapped")
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	fe, _, _, cleanup := newTestFieldEncryption(t)
+	defer cleanup()
+
+	index := fe.BlindIndex("email", "alice@example.com")
+	if index == "" || index != fe.BlindIndex("email", " Alice@Example.com ") {
+		t.Fatal("email indexes must be case insensitive")
+	}
+	if index == fe.BlindIndex("addrkey", "alice@example.com") {
+		t.Fatal("indexes of different fields must differ")
+	}
+	if fe.BlindIndex("addrkey", "Alice") == fe.BlindIndex("addrkey", "alice") {
+		t.Fatal("only emails are case insensitive")
+	}
+	if fe.BlindIndex("email", "") != "" {
+		t.Fatal("empty values have no index")
+	}
+}
+
+func TestFieldEncryptionDocuments(t *testing.T) {
+	if q := EqualityQuery("customerdata.email", "alice@example.com"); q["customerdata.email"] != "alice@example.com" {
+		t.Fatal("without field encryption plaintext is queried", q)
+	}
+	if value, _ := EncryptField("alice"); value != "alice" {
+		t.Fatal("without field encryption values are stored in plaintext")
+	}
+
+	fe, keys, path, cleanup := newTestFieldEncryption(t)
+	defer cleanup()
+	SetFieldEncryption(fe)
+	defer SetFieldEncryption(nil)
+
+	query := EqualityQuery("customerdata.email", "alice@example.com")
+	expected := bson.M{"$or": []bson.M{
+		{"customerdata.emailindex": fe.BlindIndex("email", "alice@example.com")},
+		{"customerdata.email": "alice@example.com"},
+	}}
+	if !bsonEqual(query, expected) {
+		t.Fatal("unexpected query", query)
+	}
+
+	street, _ := EncryptField("Bahnhofstrasse")
+	name, _ := EncryptField("Alice")
+	document := bson.M{
+		"id":        "customer",
+		"addresses": []interface{}{bson.M{"street": street, "country": "CH"}},
+		"person":    bson.M{"firstname": name},
+	}
+	if n, err := RewrapDocument(document); err != nil || n != 0 {
+		t.Fatal("values with the current key must not be rewrapped", n, err)
+	}
+	RotateKeyFile(path, "k2")
+	keys.Reload()
+	if n, err := RewrapDocument(document); err != nil || n != 2 {
+		t.Fatal("expected two rewrapped values", n, err)
+	}
+	if !strings.HasPrefix(document["person"].(bson.M)["firstname"].(string), EncryptedValuePrefix+"k2:") {
+		t.Fatal("nested values must be rewrapped in place")
+	}
+
+	if err := DecryptDocument(document); err != nil {
+		t.Fatal(err)
+	}
+	if document["addresses"].([]interface{})[0].(bson.M)["street"] != "Bahnhofstrasse" || document["person"].(bson.M)["firstname"] != "Alice" {
+		t.Fatal("document must be decrypted in place", document)
+	}
+
+	SetFieldEncryption(nil)
+	if _, err := DecryptField(street); err != ErrorFieldEncryptionDisabled {
+		t.Fatal("encrypted values cannot be read without field encryption", err)
+	}
+}
+
+func bsonEqual(a interface{}, b interface{}) bool {
+	dataA, _ := bson.Marshal(a)
+	dataB, _ := bson.Marshal(b)
+	return string(dataA) == string(dataB)
+}
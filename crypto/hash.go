@@ -0,0 +1,282 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+const (
+	AlgorithmBcrypt   Algorithm = "bcrypt"
+	AlgorithmArgon2id Algorithm = "argon2id"
+	AlgorithmScrypt   Algorithm = "scrypt"
+)
+
+var ErrorInvalidHash = errors.New("invalid password hash")
+
+// DefaultHashPolicy follows the OWASP recommendations for argon2id
+var DefaultHashPolicy = HashPolicy{
+	Algorithm:     AlgorithmArgon2id,
+	BcryptCost:    bcrypt.DefaultCost + 2,
+	Argon2Time:    3,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 2,
+	ScryptLogN:    15,
+	ScryptR:       8,
+	ScryptP:       1,
+	SaltLength:    16,
+	KeyLength:     32,
+}
+
+var hashPolicy = DefaultHashPolicy
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type Algorithm string
+
+// HashPolicy defines how new passwords are hashed. Hashes which have been created with other parameters
+// keep verifying, but need to be rehashed.
+type HashPolicy struct {
+	Algorithm     Algorithm
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32 // KiB
+	Argon2Threads uint8
+	ScryptLogN    uint8 // N = 2^ScryptLogN
+	ScryptR       int
+	ScryptP       int
+	SaltLength    int // argon2id and scrypt only, bcrypt has a fixed salt
+	KeyLength     int // argon2id and scrypt only
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// SetHashPolicy sets the policy for new password hashes
+func SetHashPolicy(policy HashPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	hashPolicy = policy
+	return nil
+}
+
+// GetHashPolicy returns the policy for new password hashes
+func GetHashPolicy() HashPolicy {
+	return hashPolicy
+}
+
+// HashPasswordWithPolicy returns a Crypto struct containing a self describing hash of password
+func HashPasswordWithPolicy(password string, policy HashPolicy) (*Crypto, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	var hash string
+	switch policy.Algorithm {
+	case AlgorithmBcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), policy.BcryptCost)
+		if err != nil {
+			return nil, err
+		}
+		hash = string(hashed)
+	case AlgorithmArgon2id:
+		salt, err := randomBytes(policy.SaltLength)
+		if err != nil {
+			return nil, err
+		}
+		key := argon2.IDKey([]byte(password), salt, policy.Argon2Time, policy.Argon2Memory, policy.Argon2Threads, uint32(policy.KeyLength))
+		hash = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, policy.Argon2Memory, policy.Argon2Time, policy.Argon2Threads, encode(salt), encode(key))
+	case AlgorithmScrypt:
+		salt, err := randomBytes(policy.SaltLength)
+		if err != nil {
+			return nil, err
+		}
+		key, err := scrypt.Key([]byte(password), salt, 1<<policy.ScryptLogN, policy.ScryptR, policy.ScryptP, policy.KeyLength)
+		if err != nil {
+			return nil, err
+		}
+		hash = fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", policy.ScryptLogN, policy.ScryptR, policy.ScryptP, encode(salt), encode(key))
+	}
+	return &Crypto{Hash: hash}, nil
+}
+
+// VerifyPasswordAndCheckRehash returns true if password matches against the hash and whether the hash needs to be
+// replaced by a hash created with HashPassword, because it does not comply with the current policy
+func VerifyPasswordAndCheckRehash(crypto *Crypto, password string) (ok bool, needsRehash bool) {
+	if crypto == nil {
+		return false, false
+	}
+	if crypto.Hash == "" {
+		// legacy hash: bcrypt with an appended salt
+		ok = bcrypt.CompareHashAndPassword(crypto.HashedPassword, append([]byte(password), crypto.Salt...)) == nil
+		return ok, ok
+	}
+	params, err := parseHash(crypto.Hash)
+	if err != nil {
+		return false, false
+	}
+	if !params.verify(password) {
+		return false, false
+	}
+	return true, params.needsRehash(hashPolicy)
+}
+
+// NeedsRehash returns true if crypto does not comply with the current policy
+func NeedsRehash(crypto *Crypto) bool {
+	if crypto.Hash == "" {
+		return true
+	}
+	params, err := parseHash(crypto.Hash)
+	if err != nil {
+		return true
+	}
+	return params.needsRehash(hashPolicy)
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// hashParams are the parameters decoded from a self describing hash
+type hashParams struct {
+	HashPolicy
+	hash string
+	salt []byte
+	key  []byte
+}
+
+func parseHash(hash string) (*hashParams, error) {
+	if strings.HasPrefix(hash, "$2") {
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, ErrorInvalidHash
+		}
+		return &hashParams{HashPolicy: HashPolicy{Algorithm: AlgorithmBcrypt, BcryptCost: cost}, hash: hash}, nil
+	}
+	parts := strings.Split(hash, "$")
+	params := &hashParams{hash: hash}
+	var salt, key string
+	switch {
+	case len(parts) == 6 && parts[1] == string(AlgorithmArgon2id):
+		var version int
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return nil, ErrorInvalidHash
+		}
+		params.Algorithm = AlgorithmArgon2id
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads); err != nil {
+			return nil, ErrorInvalidHash
+		}
+		salt, key = parts[4], parts[5]
+	case len(parts) == 5 && parts[1] == string(AlgorithmScrypt):
+		params.Algorithm = AlgorithmScrypt
+		if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.ScryptLogN, &params.ScryptR, &params.ScryptP); err != nil {
+			return nil, ErrorInvalidHash
+		}
+		salt, key = parts[3], parts[4]
+	default:
+		return nil, ErrorInvalidHash
+	}
+	var err error
+	if params.salt, err = decode(salt); err != nil {
+		return nil, ErrorInvalidHash
+	}
+	if params.key, err = decode(key); err != nil || len(params.key) == 0 {
+		return nil, ErrorInvalidHash
+	}
+	params.SaltLength = len(params.salt)
+	params.KeyLength = len(params.key)
+	if err := params.validate(); err != nil {
+		return nil, ErrorInvalidHash
+	}
+	return params, nil
+}
+
+func (params *hashParams) verify(password string) bool {
+	var key []byte
+	switch params.Algorithm {
+	case AlgorithmBcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(params.hash), []byte(password)) == nil
+	case AlgorithmArgon2id:
+		key = argon2.IDKey([]byte(password), params.salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, uint32(params.KeyLength))
+	case AlgorithmScrypt:
+		var err error
+		key, err = scrypt.Key([]byte(password), params.salt, 1<<params.ScryptLogN, params.ScryptR, params.ScryptP, params.KeyLength)
+		if err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+func (params *hashParams) needsRehash(policy HashPolicy) bool {
+	if params.Algorithm != policy.Algorithm {
+		return true
+	}
+	switch params.Algorithm {
+	case AlgorithmBcrypt:
+		return params.BcryptCost != policy.BcryptCost
+	case AlgorithmArgon2id:
+		return params.Argon2Time != policy.Argon2Time || params.Argon2Memory != policy.Argon2Memory ||
+			params.Argon2Threads != policy.Argon2Threads || params.SaltLength != policy.SaltLength || params.KeyLength != policy.KeyLength
+	case AlgorithmScrypt:
+		return params.ScryptLogN != policy.ScryptLogN || params.ScryptR != policy.ScryptR ||
+			params.ScryptP != policy.ScryptP || params.SaltLength != policy.SaltLength || params.KeyLength != policy.KeyLength
+	}
+	return true
+}
+
+func (policy HashPolicy) validate() error {
+	switch policy.Algorithm {
+	case AlgorithmBcrypt:
+		if policy.BcryptCost < bcrypt.MinCost || policy.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return nil
+	case AlgorithmArgon2id:
+		if policy.Argon2Time < 1 || policy.Argon2Threads < 1 || policy.Argon2Memory < 8*uint32(policy.Argon2Threads) {
+			return errors.New("invalid argon2id parameters")
+		}
+	case AlgorithmScrypt:
+		if policy.ScryptLogN < 1 || policy.ScryptLogN > 30 || policy.ScryptR < 1 || policy.ScryptP < 1 {
+			return errors.New("invalid scrypt parameters")
+		}
+	default:
+		return errors.New("unknown password hash algorithm " + string(policy.Algorithm))
+	}
+	if policy.SaltLength < 8 || policy.KeyLength < 16 {
+		return errors.New("salt must have at least 8 bytes and key at least 16 bytes")
+	}
+	return nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
+}
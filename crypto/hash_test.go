@@ -0,0 +1,105 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testHashPolicies = []HashPolicy{
+	{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost},
+	{Algorithm: AlgorithmArgon2id, Argon2Time: 1, Argon2Memory: 1024, Argon2Threads: 1, SaltLength: 16, KeyLength: 32},
+	{Algorithm: AlgorithmScrypt, ScryptLogN: 10, ScryptR: 8, ScryptP: 1, SaltLength: 16, KeyLength: 32},
+}
+
+func setTestHashPolicy(t *testing.T, policy HashPolicy) {
+	if err := SetHashPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCryptoHashAlgorithms(t *testing.T) {
+	defer SetHashPolicy(DefaultHashPolicy)
+	password := "totallySafeP@??m0rd!11"
+	prefixes := []string{"$2a$04$", "$argon2id$v=19$m=1024,t=1,p=1$", "$scrypt$ln=10,r=8,p=1$"}
+	for i, policy := range testHashPolicies {
+		setTestHashPolicy(t, policy)
+		crypto, err := HashPassword(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(crypto.Hash, prefixes[i]) {
+			t.Fatal("unexpected hash format", crypto.Hash)
+		}
+		ok, needsRehash := VerifyPasswordAndCheckRehash(crypto, password)
+		if !ok || needsRehash {
+			t.Fatal(policy.Algorithm, "correct password must match without rehash")
+		}
+		if VerifyPassword(crypto, "thisissototallywrong") {
+			t.Fatal(policy.Algorithm, "wrong password matched against hash")
+		}
+
+		// hashes of all other algorithms keep verifying, but need to be rehashed
+		setTestHashPolicy(t, testHashPolicies[(i+1)%len(testHashPolicies)])
+		ok, needsRehash = VerifyPasswordAndCheckRehash(crypto, password)
+		if !ok || !needsRehash || !NeedsRehash(crypto) {
+			t.Fatal(policy.Algorithm, "hash of another algorithm must verify and need a rehash")
+		}
+	}
+}
+
+func TestCryptoRehashOnChangedParameters(t *testing.T) {
+	defer SetHashPolicy(DefaultHashPolicy)
+	policy := testHashPolicies[1]
+	setTestHashPolicy(t, policy)
+	crypto, err := HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.Argon2Time = 2
+	setTestHashPolicy(t, policy)
+	if ok, needsRehash := VerifyPasswordAndCheckRehash(crypto, "password"); !ok || !needsRehash {
+		t.Fatal("hash with fewer iterations must need a rehash")
+	}
+}
+
+func TestCryptoLegacyHash(t *testing.T) {
+	password := "totallySafeP@??m0rd!11"
+	salt, err := newSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, err := bcrypt.GenerateFromPassword(append([]byte(password), salt...), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := &Crypto{HashedPassword: hashed, Salt: salt}
+	ok, needsRehash := VerifyPasswordAndCheckRehash(legacy, password)
+	if !ok || !needsRehash {
+		t.Fatal("legacy hash must verify and need a rehash")
+	}
+	if VerifyPassword(legacy, "thisissototallywrong") {
+		t.Fatal("wrong password matched against legacy hash")
+	}
+}
+
+func TestCryptoInvalidHashAndPolicy(t *testing.T) {
+	for _, hash := range []string{"", "plain", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$", "$scrypt$ln=x$a$b", "$md5$abc"} {
+		if VerifyPassword(&Crypto{Hash: hash}, "") {
+			t.Fatal("invalid hash matched", hash)
+		}
+	}
+	if VerifyPassword(nil, "") {
+		t.Fatal("nil crypto matched")
+	}
+	if SetHashPolicy(HashPolicy{Algorithm: "md5"}) == nil {
+		t.Fatal("unknown algorithm must be refused")
+	}
+	if SetHashPolicy(HashPolicy{Algorithm: AlgorithmScrypt, ScryptLogN: 10, ScryptR: 8, ScryptP: 1, SaltLength: 4, KeyLength: 32}) == nil {
+		t.Fatal("short salt must be refused")
+	}
+	if GetHashPolicy() != DefaultHashPolicy {
+		t.Fatal("refused policy must not be set")
+	}
+}
@@ -0,0 +1,233 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// KeyLength is the length of key encryption keys, data encryption keys and the blind index key (AES-256)
+const KeyLength = 32
+
+var (
+	ErrorUnknownKey   = errors.New("unknown encryption key")
+	ErrorInvalidKey   = errors.New("invalid encryption key")
+	ErrorKeyFileExist = errors.New("key file exists")
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// KeyProvider provides the key encryption keys for field encryption.
+// Keys which have been replaced must be kept until all values have been rewrapped with the current key.
+type KeyProvider interface {
+	// GetCurrentKeyId returns the id of the key which is used to encrypt new values
+	GetCurrentKeyId() string
+	// GetKey returns the key with id or ErrorUnknownKey
+	GetKey(id string) ([]byte, error)
+	// GetIndexKey returns the key for blind indexes. It is not rotated, because all blind indexes depend on it.
+	GetIndexKey() []byte
+}
+
+// KeyFile is the content of the file of a FileKeyProvider
+type KeyFile struct {
+	CurrentKeyId string
+	Keys         map[string]string // key id => base64 encoded key
+	IndexKey     string            // base64 encoded key
+}
+
+// FileKeyProvider reads the keys from a local json file
+type FileKeyProvider struct {
+	sync.RWMutex
+	path         string
+	currentKeyId string
+	keys         map[string][]byte
+	indexKey     []byte
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewFileKeyProvider constructor, reads the keys from path
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// GenerateKeyFile creates a key file with a new key with keyId and a new index key. An existing file is not overwritten.
+func GenerateKeyFile(path string, keyId string) error {
+	if _, err := os.Stat(path); err == nil {
+		return ErrorKeyFileExist
+	}
+	indexKey, err := newKey()
+	if err != nil {
+		return err
+	}
+	keyFile := &KeyFile{
+		Keys:     map[string]string{},
+		IndexKey: base64.StdEncoding.EncodeToString(indexKey),
+	}
+	if err := keyFile.addKey(keyId); err != nil {
+		return err
+	}
+	return keyFile.write(path)
+}
+
+// RotateKeyFile adds a new key with keyId to the key file and makes it the current key.
+// Running FileKeyProvider instances use it after Reload.
+func RotateKeyFile(path string, keyId string) error {
+	keyFile, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := keyFile.Keys[keyId]; ok {
+		return errors.New("key " + keyId + " exists")
+	}
+	if err := keyFile.addKey(keyId); err != nil {
+		return err
+	}
+	return keyFile.write(path)
+}
+
+// Reload reads the key file again, e.g. after RotateKeyFile
+func (p *FileKeyProvider) Reload() error {
+	keyFile, err := readKeyFile(p.path)
+	if err != nil {
+		return err
+	}
+	keys := map[string][]byte{}
+	for id, encoded := range keyFile.Keys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return errors.New("key " + id + ": " + err.Error())
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[keyFile.CurrentKeyId]; !ok {
+		return errors.New("current key " + keyFile.CurrentKeyId + " not found")
+	}
+	indexKey, err := decodeKey(keyFile.IndexKey)
+	if err != nil {
+		return errors.New("index key: " + err.Error())
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.currentKeyId = keyFile.CurrentKeyId
+	p.keys = keys
+	p.indexKey = indexKey
+	return nil
+}
+
+func (p *FileKeyProvider) GetCurrentKeyId() string {
+	p.RLock()
+	defer p.RUnlock()
+	return p.currentKeyId
+}
+
+func (p *FileKeyProvider) GetKey(id string) ([]byte, error) {
+	p.RLock()
+	defer p.RUnlock()
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, ErrorUnknownKey
+	}
+	return key, nil
+}
+
+func (p *FileKeyProvider) GetIndexKey() []byte {
+	p.RLock()
+	defer p.RUnlock()
+	return p.indexKey
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func readKeyFile(path string) (*KeyFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keyFile := &KeyFile{}
+	if err := json.Unmarshal(data, keyFile); err != nil {
+		return nil, err
+	}
+	return keyFile, nil
+}
+
+// write replaces the key file atomically, it is only readable by its owner
+func (keyFile *KeyFile) write(path string) error {
+	data, err := json.MarshalIndent(keyFile, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (keyFile *KeyFile) addKey(keyId string) error {
+	if keyId == "" || strings.Contains(keyId, encryptedValueSeparator) {
+		return errors.New("invalid key id " + keyId)
+	}
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	keyFile.Keys[keyId] = base64.StdEncoding.EncodeToString(key)
+	keyFile.CurrentKeyId = keyId
+	return nil
+}
+
+func newKey() ([]byte, error) {
+	key := make([]byte, KeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeyLength {
+		return nil, ErrorInvalidKey
+	}
+	return key, nil
+}
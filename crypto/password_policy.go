@@ -0,0 +1,194 @@
+package crypto
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	PasswordViolationTooShort          PasswordViolationCode = "tooShort"
+	PasswordViolationTooLong           PasswordViolationCode = "tooLong"
+	PasswordViolationTooWeak           PasswordViolationCode = "tooWeak"
+	PasswordViolationContainsUserInput PasswordViolationCode = "containsUserInput"
+	PasswordViolationBanned            PasswordViolationCode = "banned"
+
+	// user input shorter than this, e.g. a two letter last name, is not banned from passwords
+	minUserInputLength = 3
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type PasswordViolationCode string
+
+// PasswordViolation describes why a password has been refused. Code and Limit are meant for UI translations.
+type PasswordViolation struct {
+	Code    PasswordViolationCode
+	Limit   int // min or max length, or min score
+	Message string
+}
+
+// PasswordPolicyError is returned by PasswordPolicy.Check for refused passwords
+type PasswordPolicyError struct {
+	Violations []*PasswordViolation
+}
+
+// PasswordPolicy defines the requirements for new passwords
+type PasswordPolicy struct {
+	MinLength       int // characters, zero if there is no limit
+	MaxLength       int // characters, zero if there is no limit
+	MinScore        int // zxcvbn score of 0 (poor), 1, 2, 3 or 4 (excellent)
+	bannedPasswords map[string]bool
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewPasswordPolicy constructor
+func NewPasswordPolicy(minLength int, maxLength int, minScore int) *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:       minLength,
+		MaxLength:       maxLength,
+		MinScore:        minScore,
+		bannedPasswords: map[string]bool{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "password refused: " + strings.Join(messages, ", ")
+}
+
+// AddBannedPasswords bans passwords, regardless of case
+func (p *PasswordPolicy) AddBannedPasswords(passwords ...string) {
+	if p.bannedPasswords == nil {
+		p.bannedPasswords = map[string]bool{}
+	}
+	for _, password := range passwords {
+		p.bannedPasswords[strings.ToLower(password)] = true
+	}
+}
+
+// LoadBannedPasswords bans the passwords in filename, one per line. Empty lines and lines starting with # are ignored.
+func (p *PasswordPolicy) LoadBannedPasswords(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.AddBannedPasswords(line)
+	}
+	return scanner.Err()
+}
+
+// Validate returns all violations of password, which is empty if password complies with the policy.
+// userInput, e.g. email and name of the customer, must not be part of the password.
+func (p *PasswordPolicy) Validate(password string, userInput []string) []*PasswordViolation {
+	violations := []*PasswordViolation{}
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, &PasswordViolation{
+			Code:    PasswordViolationTooShort,
+			Limit:   p.MinLength,
+			Message: "password must have at least " + strconv.Itoa(p.MinLength) + " characters",
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		// the score of very long passwords is not determined, because it is expensive
+		return append(violations, &PasswordViolation{
+			Code:    PasswordViolationTooLong,
+			Limit:   p.MaxLength,
+			Message: "password must not be longer than " + strconv.Itoa(p.MaxLength) + " characters",
+		})
+	}
+	if p.bannedPasswords[strings.ToLower(password)] {
+		violations = append(violations, &PasswordViolation{
+			Code:    PasswordViolationBanned,
+			Message: "password is too common",
+		})
+	}
+	userInput = expandUserInput(userInput)
+	if containsUserInput(password, userInput) {
+		violations = append(violations, &PasswordViolation{
+			Code:    PasswordViolationContainsUserInput,
+			Message: "password must not contain your email address or name",
+		})
+	}
+	if p.MinScore > 0 && determinePasswordStrength(password, userInput).Score < p.MinScore {
+		violations = append(violations, &PasswordViolation{
+			Code:    PasswordViolationTooWeak,
+			Limit:   p.MinScore,
+			Message: "password is too weak",
+		})
+	}
+	return violations
+}
+
+// Check returns a *PasswordPolicyError, if password does not comply with the policy
+func (p *PasswordPolicy) Check(password string, userInput []string) error {
+	violations := p.Validate(password, userInput)
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// expandUserInput adds the local part of email addresses and the parts of names,
+// e.g. alice.smith and alice and smith for alice.smith@example.com
+func expandUserInput(userInput []string) []string {
+	expanded := []string{}
+	for _, input := range userInput {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input == "" {
+			continue
+		}
+		expanded = append(expanded, input)
+		if at := strings.LastIndex(input, "@"); at >= 0 {
+			input = input[:at]
+			expanded = append(expanded, input)
+		}
+		parts := strings.FieldsFunc(input, func(r rune) bool {
+			return r == '.' || r == '-' || r == '_' || r == '+' || r == ' '
+		})
+		if len(parts) > 1 {
+			expanded = append(expanded, parts...)
+		}
+	}
+	return expanded
+}
+
+func containsUserInput(password string, userInput []string) bool {
+	password = strings.ToLower(password)
+	for _, input := range userInput {
+		if utf8.RuneCountInString(input) >= minUserInputLength && strings.Contains(password, input) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func violationCodes(violations []*PasswordViolation) []PasswordViolationCode {
+	codes := []PasswordViolationCode{}
+	for _, violation := range violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := NewPasswordPolicy(10, 64, 3)
+	policy.AddBannedPasswords("Correct Horse Battery Staple")
+	userInput := []string{"Alice.Smith@example.com", "Alice", "", "Li"}
+
+	tests := []struct {
+		password string
+		codes    []PasswordViolationCode
+	}{
+		{"osome+#,,brassford", []PasswordViolationCode{}},
+		{"quartz-Nebula-kettle-79!", []PasswordViolationCode{}},
+		{"short", []PasswordViolationCode{PasswordViolationTooShort, PasswordViolationTooWeak}},
+		{"correct horse battery staple", []PasswordViolationCode{PasswordViolationBanned}},
+		{"cactus+SMITH+osome#,", []PasswordViolationCode{PasswordViolationContainsUserInput}},
+		{"äöüäöüäöü", []PasswordViolationCode{PasswordViolationTooShort}}, // characters, not bytes
+		{string(make([]byte, 65)), []PasswordViolationCode{PasswordViolationTooLong}},
+	}
+	for _, test := range tests {
+		violations := policy.Validate(test.password, userInput)
+		if !reflect.DeepEqual(test.codes, violationCodes(violations)) {
+			t.Fatal(test.password, "expected", test.codes, "got", violationCodes(violations))
+		}
+		err := policy.Check(test.password, userInput)
+		if (err == nil) != (len(test.codes) == 0) {
+			t.Fatal(test.password, "unexpected error", err)
+		}
+		if err != nil && len(err.(*PasswordPolicyError).Violations) != len(test.codes) {
+			t.Fatal(test.password, "error must contain all violations")
+		}
+	}
+	if violations := policy.Validate("short", nil); violations[0].Limit != 10 {
+		t.Fatal("min length expected as limit")
+	}
+}
+
+func TestPasswordPolicyBannedPasswordsFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "banned-passwords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("# most common passwords\n\nsummertablecactus+\n  Osome+#,,Brassford  \n")
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &PasswordPolicy{}
+	if err := policy.LoadBannedPasswords(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"summertablecactus+", "osome+#,,brassford"} {
+		if codes := violationCodes(policy.Validate(password, nil)); !reflect.DeepEqual([]PasswordViolationCode{PasswordViolationBanned}, codes) {
+			t.Fatal(password, "must be banned, got", codes)
+		}
+	}
+	if len(policy.Validate("# most common passwords", nil)) != 0 {
+		t.Fatal("comments must not be banned")
+	}
+	if policy.LoadBannedPasswords(file.Name()+"-missing") == nil {
+		t.Fatal("missing file must fail")
+	}
+}
@@ -0,0 +1,381 @@
+package customer
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/crypto"
+	"github.com/foomo/shop/unique"
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+const (
+	DefaultMaxFailedAttempts = 5
+	DefaultLockoutDuration   = 15 * time.Minute
+
+	maxCredentialsUpdateRetries = 3
+)
+
+var (
+	// dummyCrypto is verified for unknown emails and for wrong passwords of legacy hashes, so that they take as long as known emails with a current hash
+	dummyCrypto     *crypto.Crypto
+	dummyCryptoOnce sync.Once
+	// dummyCustomerId is the customer id of the credentials, which are updated for unknown emails
+	dummyCustomerId = "login-dummy-" + unique.GetNewID()
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// AuthService registers and authenticates customers by email and password
+type AuthService struct {
+	Store                     CredentialsStore
+	Tokens                    *TokenService
+	MaxFailedAttempts         int                              // failed logins until login is locked, DefaultMaxFailedAttempts if zero
+	LockoutDuration           time.Duration                    // DefaultLockoutDuration if zero
+	PasswordResetTokenTTL     time.Duration                    // DefaultPasswordResetTokenTTL if zero
+	EmailVerificationTokenTTL time.Duration                    // DefaultEmailVerificationTokenTTL if zero
+	PasswordPolicy            *crypto.PasswordPolicy           // optional, checked for new passwords
+	UserInput                 func(customerId string) []string // optional, e.g. Customer.GetPasswordUserInput, the email is always included
+	now                       func() time.Time
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewAuthService constructor
+func NewAuthService(store CredentialsStore, tokens TokenStore) *AuthService {
+	return &AuthService{
+		Store:  store,
+		Tokens: NewTokenService(tokens),
+		now:    time.Now,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// Register creates the credentials of customerId. The email must not be registered for another customer.
+func (s *AuthService) Register(customerId string, email string, password string) (*Credentials, error) {
+	email = normalizeEmail(email)
+	if customerId == "" {
+		return nil, errors.New("required customerId is empty")
+	}
+	if !strings.ContainsRune(email, '@') {
+		return nil, errors.New("invalid email address " + email)
+	}
+	if err := s.checkPassword(customerId, email, password); err != nil {
+		return nil, err
+	}
+	hash, err := crypto.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	credentials := &Credentials{
+		CustomerId:     customerId,
+		Email:          email,
+		Crypto:         hash,
+		CreatedAt:      now,
+		LastModifiedAt: now,
+	}
+	if err := s.Store.InsertCredentials(credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// Login returns the credentials of email, if password is correct. Otherwise it returns ErrInvalidCredentials,
+// regardless of whether the email is registered or locked. ErrAccountLocked is only returned for the correct password
+// after too many failed attempts, so that a lockout does not reveal a registered email.
+// For unknown emails a dummy password is verified and credentials which do not exist are updated,
+// so that the response takes as long as for a failed attempt of a registered email.
+func (s *AuthService) Login(email string, password string) (*Credentials, error) {
+	email = normalizeEmail(email)
+	credentials, err := s.Store.GetCredentialsByEmail(email)
+	if err == ErrCredentialsNotFound {
+		verifyDummyPassword(password)
+		// the update of the failed attempts matches no credentials, but takes the same store round trip
+		s.Store.UpdateCredentials(&Credentials{CustomerId: dummyCustomerId, Email: email, FailedAttempts: 1, LastModifiedAt: s.now()})
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.authenticate(credentials, password)
+}
+
+// ChangePassword replaces the password of customerId. A wrong currentPassword counts as failed login attempt.
+func (s *AuthService) ChangePassword(customerId string, currentPassword string, newPassword string) error {
+	credentials, err := s.Store.GetCredentialsByCustomerId(customerId)
+	if err != nil {
+		return err
+	}
+	if err := s.checkPassword(customerId, credentials.Email, newPassword); err != nil {
+		return err
+	}
+	credentials, err = s.authenticate(credentials, currentPassword)
+	if err != nil {
+		return err
+	}
+	hash, err := crypto.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	credentials.Crypto = hash
+	credentials.LastModifiedAt = s.now()
+	return s.Store.UpdateCredentials(credentials)
+}
+
+// RequestPasswordReset returns a password reset token for email, which is to be sent to email.
+// It returns ErrCredentialsNotFound for unknown emails, which must not be revealed to the requester.
+func (s *AuthService) RequestPasswordReset(email string) (string, error) {
+	credentials, err := s.Store.GetCredentialsByEmail(normalizeEmail(email))
+	if err != nil {
+		return "", err
+	}
+	return s.Tokens.IssueToken(TokenPurposePasswordReset, credentials.CustomerId, credentials.Email, getDuration(s.PasswordResetTokenTTL, DefaultPasswordResetTokenTTL))
+}
+
+// ResetPassword sets newPassword for the customer token has been issued for and unlocks login.
+// If newPassword is refused by the policy, the token remains valid. Tokens which have been issued for another
+// email than the current login email are refused with ErrTokenInvalid.
+func (s *AuthService) ResetPassword(token string, newPassword string) error {
+	// check the password before the token is consumed, so that the customer can try another password
+	resetToken, err := s.Tokens.GetToken(TokenPurposePasswordReset, token)
+	if err != nil {
+		return err
+	}
+	credentials, err := s.Store.GetCredentialsByCustomerId(resetToken.CustomerId)
+	if err != nil {
+		return err
+	}
+	if credentials.Email != resetToken.Email {
+		return ErrTokenInvalid
+	}
+	if err := s.checkPassword(resetToken.CustomerId, resetToken.Email, newPassword); err != nil {
+		return err
+	}
+	resetToken, err = s.Tokens.ConsumeToken(TokenPurposePasswordReset, token, "")
+	if err != nil {
+		return err
+	}
+	hash, err := crypto.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	_, err = s.updateCredentials(resetToken.CustomerId, func(credentials *Credentials) error {
+		// the email may have been changed since the token has been checked
+		if credentials.Email != resetToken.Email {
+			return ErrTokenInvalid
+		}
+		credentials.Crypto = hash
+		credentials.FailedAttempts = 0
+		credentials.LockedUntil = time.Time{}
+		return nil
+	})
+	return err
+}
+
+// RequestEmailChange returns an email verification token, which is to be sent to newEmail.
+// The email is changed by ConfirmEmailChange.
+func (s *AuthService) RequestEmailChange(customerId string, newEmail string) (string, error) {
+	newEmail = normalizeEmail(newEmail)
+	if !strings.ContainsRune(newEmail, '@') {
+		return "", errors.New("invalid email address " + newEmail)
+	}
+	if _, err := s.Store.GetCredentialsByCustomerId(customerId); err != nil {
+		return "", err
+	}
+	other, err := s.Store.GetCredentialsByEmail(newEmail)
+	if err == nil && other.CustomerId != customerId {
+		return "", ErrCredentialsExist
+	}
+	if err != nil && err != ErrCredentialsNotFound {
+		return "", err
+	}
+	return s.Tokens.IssueToken(TokenPurposeEmailVerification, customerId, newEmail, getDuration(s.EmailVerificationTokenTTL, DefaultEmailVerificationTokenTTL))
+}
+
+// ConfirmEmailChange changes the login email of customerId to the email token has been issued for.
+// Password reset tokens, which have been sent to the old email, are invalidated.
+func (s *AuthService) ConfirmEmailChange(customerId string, token string) (*Credentials, error) {
+	verificationToken, err := s.Tokens.ConsumeToken(TokenPurposeEmailVerification, token, customerId)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := s.updateCredentials(customerId, func(credentials *Credentials) error {
+		credentials.Email = verificationToken.Email
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Tokens.Store.InvalidateTokens(customerId, TokenPurposePasswordReset, s.now()); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON CUSTOMER
+//------------------------------------------------------------------
+
+// ConfirmEmailChange sets the email confirmed by token as login email and as email of the customer
+func (customer *Customer) ConfirmEmailChange(auth *AuthService, token string) error {
+	credentials, err := auth.ConfirmEmailChange(customer.GetID(), token)
+	if err != nil {
+		return err
+	}
+	customer.Email = credentials.Email
+	if customer.Person != nil {
+		if contact, ok := customer.Person.Contacts[customer.Person.DefaultContacts[address.ContactTypeEmail]]; ok {
+			contact.Value = credentials.Email
+		}
+	}
+	customer.LastModifiedAt = utils.TimeNow()
+	return customer.Upsert()
+}
+
+// GetPasswordUserInput returns email and names of the customer, which must not be used in passwords
+func (customer *Customer) GetPasswordUserInput() []string {
+	userInput := []string{customer.Email}
+	if customer.Person != nil {
+		userInput = append(userInput, customer.Person.FirstName, customer.Person.MiddleName, customer.Person.LastName)
+	}
+	if customer.Company != nil {
+		userInput = append(userInput, customer.Company.Name)
+	}
+	return userInput
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// checkPassword checks password against the policy, email and the user input of customerId must not be used
+func (s *AuthService) checkPassword(customerId string, email string, password string) error {
+	if password == "" {
+		return errors.New("password must not be empty")
+	}
+	if s.PasswordPolicy == nil {
+		return nil
+	}
+	userInput := []string{email}
+	if s.UserInput != nil {
+		userInput = append(userInput, s.UserInput(customerId)...)
+	}
+	return s.PasswordPolicy.Check(password, userInput)
+}
+
+// updateCredentials applies update to the stored credentials of customerId and retries on concurrent changes
+func (s *AuthService) updateCredentials(customerId string, update func(credentials *Credentials) error) (*Credentials, error) {
+	for i := 0; ; i++ {
+		credentials, err := s.Store.GetCredentialsByCustomerId(customerId)
+		if err != nil {
+			return nil, err
+		}
+		if err := update(credentials); err != nil {
+			return nil, err
+		}
+		credentials.LastModifiedAt = s.now()
+		err = s.Store.UpdateCredentials(credentials)
+		if err == ErrCredentialsChanged && i < maxCredentialsUpdateRetries {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return credentials, nil
+	}
+}
+
+// authenticate verifies password, records the attempt and rehashes the password if necessary. Concurrent attempts are retried on the stored credentials,
+// so that no failed attempt is lost. The password is verified even if the account is locked, so that the response time does not reveal the lock.
+func (s *AuthService) authenticate(credentials *Credentials, password string) (*Credentials, error) {
+	for i := 0; ; i++ {
+		now := s.now()
+		ok, needsRehash := crypto.VerifyPasswordAndCheckRehash(credentials.Crypto, password)
+		if !ok && (credentials.Crypto == nil || crypto.NeedsRehash(credentials.Crypto)) {
+			// a legacy hash is verified faster than the dummy of unknown emails
+			verifyDummyPassword(password)
+		}
+		if credentials.IsLocked(now) {
+			if ok {
+				return nil, ErrAccountLocked
+			}
+			return nil, ErrInvalidCredentials
+		}
+		if ok {
+			credentials.FailedAttempts = 0
+			credentials.LastLoginAt = now
+			// upgrade the hash to the current policy, while the password is known
+			if needsRehash {
+				hash, err := crypto.HashPassword(password)
+				if err != nil {
+					return nil, err
+				}
+				credentials.Crypto = hash
+			}
+		} else {
+			credentials.FailedAttempts++
+			if credentials.FailedAttempts >= s.getMaxFailedAttempts() {
+				credentials.FailedAttempts = 0
+				credentials.LockedUntil = now.Add(getDuration(s.LockoutDuration, DefaultLockoutDuration))
+			}
+		}
+		credentials.LastModifiedAt = now
+		err := s.Store.UpdateCredentials(credentials)
+		if err == ErrCredentialsChanged && i < maxCredentialsUpdateRetries {
+			credentials, err = s.Store.GetCredentialsByCustomerId(credentials.CustomerId)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return credentials, nil
+		}
+		return nil, ErrInvalidCredentials
+	}
+}
+
+func (s *AuthService) getMaxFailedAttempts() int {
+	if s.MaxFailedAttempts > 0 {
+		return s.MaxFailedAttempts
+	}
+	return DefaultMaxFailedAttempts
+}
+
+func getDuration(duration time.Duration, defaultDuration time.Duration) time.Duration {
+	if duration > 0 {
+		return duration
+	}
+	return defaultDuration
+}
+
+func verifyDummyPassword(password string) {
+	dummyCryptoOnce.Do(func() {
+		dummyCrypto, _ = crypto.HashPassword(unique.GetNewID())
+	})
+	if dummyCrypto != nil {
+		crypto.VerifyPassword(dummyCrypto, password)
+	}
+}
+
+func normalizeEmail(email string) string {
+	return lc(strings.TrimSpace(email))
+}
@@ -0,0 +1,344 @@
+package customer
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/crypto"
+)
+
+func newTestAuthService() (*AuthService, *time.Time) {
+	now := time.Date(2026, 5, 4, 12, 0, 0, 0, time.UTC)
+	service := NewAuthService(NewMemoryCredentialsStore(), NewMemoryTokenStore())
+	service.MaxFailedAttempts = 3
+	service.now = func() time.Time { return now }
+	service.Tokens.now = service.now
+	return service, &now
+}
+
+func TestAuthRegisterAndLogin(t *testing.T) {
+	service, _ := newTestAuthService()
+
+	credentials, err := service.Register("customer-1", " "+MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo@bar.com", credentials.Email)
+	assert.NotContains(t, string(credentials.Crypto.HashedPassword), MOCK_PASSWORD)
+
+	_, err = service.Register("customer-2", "FOO@bar.com", MOCK_PASSWORD2)
+	assert.Equal(t, ErrCredentialsExist, err, "email is taken")
+	_, err = service.Register("customer-1", MOCK_EMAIL2, MOCK_PASSWORD2)
+	assert.Equal(t, ErrCredentialsExist, err, "customer has credentials")
+	_, err = service.Register("customer-2", MOCK_EMAIL2, "")
+	assert.Error(t, err)
+
+	credentials, err = service.Login("foo@BAR.com", MOCK_PASSWORD)
+	assert.NoError(t, err)
+	assert.Equal(t, "customer-1", credentials.CustomerId)
+	assert.False(t, credentials.LastLoginAt.IsZero())
+
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD2)
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = service.Login(MOCK_EMAIL2, MOCK_PASSWORD)
+	assert.Equal(t, ErrInvalidCredentials, err, "unknown email is not revealed")
+}
+
+func TestAuthLockout(t *testing.T) {
+	service, now := newTestAuthService()
+	_, err := service.Register("customer-1", MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+
+	_, err = service.Login(MOCK_EMAIL, "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err, "successful login resets failed attempts")
+
+	for i := 0; i < 2; i++ {
+		_, err = service.Login(MOCK_EMAIL, "wrong")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+	_, err = service.Login(MOCK_EMAIL, "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err, "third failed attempt locks the account without revealing it")
+	_, err = service.Login(MOCK_EMAIL, "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err, "the lock is not revealed to wrong passwords")
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD)
+	assert.Equal(t, ErrAccountLocked, err, "correct password is refused while locked")
+
+	*now = now.Add(DefaultLockoutDuration)
+	credentials, err := service.Login(MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, credentials.FailedAttempts)
+}
+
+func TestAuthChangePassword(t *testing.T) {
+	service, _ := newTestAuthService()
+	_, err := service.Register("customer-1", MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+
+	assert.Equal(t, ErrInvalidCredentials, service.ChangePassword("customer-1", "wrong", MOCK_PASSWORD2))
+	assert.NoError(t, service.ChangePassword("customer-1", MOCK_PASSWORD, MOCK_PASSWORD2))
+
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD)
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD2)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrCredentialsNotFound, service.ChangePassword("customer-2", MOCK_PASSWORD, MOCK_PASSWORD2))
+}
+
+func TestAuthPasswordReset(t *testing.T) {
+	service, now := newTestAuthService()
+	_, err := service.Register("customer-1", MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		service.Login(MOCK_EMAIL, "wrong")
+	}
+
+	_, err = service.RequestPasswordReset(MOCK_EMAIL2)
+	assert.Equal(t, ErrCredentialsNotFound, err)
+	expiredToken, err := service.RequestPasswordReset(MOCK_EMAIL)
+	assert.NoError(t, err)
+	*now = now.Add(DefaultPasswordResetTokenTTL)
+	assert.Equal(t, ErrTokenInvalid, service.ResetPassword(expiredToken, MOCK_PASSWORD2), "expired")
+
+	replacedToken, err := service.RequestPasswordReset(MOCK_EMAIL)
+	assert.NoError(t, err)
+	token, err := service.RequestPasswordReset(MOCK_EMAIL)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrTokenInvalid, service.ResetPassword(replacedToken, MOCK_PASSWORD2), "replaced by a newer token")
+	assert.NoError(t, service.ResetPassword(token, MOCK_PASSWORD2))
+	assert.Equal(t, ErrTokenInvalid, service.ResetPassword(token, MOCK_PASSWORD), "single use")
+
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD2)
+	assert.NoError(t, err, "reset unlocks login")
+
+	token, err = service.RequestPasswordReset(MOCK_EMAIL)
+	assert.NoError(t, err)
+	credentials, err := service.Store.GetCredentialsByCustomerId("customer-1")
+	assert.NoError(t, err)
+	credentials.Email = "new@bar.com"
+	assert.NoError(t, service.Store.UpdateCredentials(credentials))
+	assert.Equal(t, ErrTokenInvalid, service.ResetPassword(token, MOCK_PASSWORD), "token has been issued for another email")
+}
+
+// countingCredentialsStore counts the updates of credentials
+type countingCredentialsStore struct {
+	*MemoryCredentialsStore
+	updates int
+}
+
+func (s *countingCredentialsStore) UpdateCredentials(credentials *Credentials) error {
+	s.updates++
+	return s.MemoryCredentialsStore.UpdateCredentials(credentials)
+}
+
+func TestAuthLoginUnknownEmail(t *testing.T) {
+	service, _ := newTestAuthService()
+	store := &countingCredentialsStore{MemoryCredentialsStore: NewMemoryCredentialsStore()}
+	service.Store = store
+	_, err := service.Register("customer-1", MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+
+	_, err = service.Login(MOCK_EMAIL, "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	assert.Equal(t, 1, store.updates)
+	_, err = service.Login(MOCK_EMAIL2, "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	assert.Equal(t, 2, store.updates, "unknown emails take the same store round trip")
+	_, err = service.Store.GetCredentialsByEmail(MOCK_EMAIL2)
+	assert.Equal(t, ErrCredentialsNotFound, err)
+}
+
+func TestAuthEmailChange(t *testing.T) {
+	service, _ := newTestAuthService()
+	_, err := service.Register("customer-1", MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+	_, err = service.Register("customer-2", MOCK_EMAIL2, MOCK_PASSWORD2)
+	assert.NoError(t, err)
+	customer := &Customer{Id: "customer-1", Email: "foo@bar.com"}
+	customer.UnlinkFromDB()
+
+	_, err = service.RequestEmailChange("customer-1", MOCK_EMAIL2)
+	assert.Equal(t, ErrCredentialsExist, err)
+	token, err := service.RequestEmailChange("customer-1", "New@Bar.com")
+	assert.NoError(t, err)
+	_, err = service.Login("new@bar.com", MOCK_PASSWORD)
+	assert.Equal(t, ErrInvalidCredentials, err, "email is changed after confirmation only")
+
+	resetToken, err := service.RequestPasswordReset(MOCK_EMAIL)
+	assert.NoError(t, err)
+
+	_, err = service.ConfirmEmailChange("customer-2", token)
+	assert.Equal(t, ErrTokenInvalid, err, "token of another customer")
+	assert.NoError(t, customer.ConfirmEmailChange(service, token))
+	assert.Equal(t, ErrTokenInvalid, service.ResetPassword(resetToken, MOCK_PASSWORD2), "reset token of the old email")
+	assert.Equal(t, "new@bar.com", customer.Email)
+	_, err = service.Login("new@bar.com", MOCK_PASSWORD)
+	assert.NoError(t, err)
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD)
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func TestAuthRehashOnLogin(t *testing.T) {
+	service, _ := newTestAuthService()
+	outdated, err := crypto.HashPasswordWithPolicy(MOCK_PASSWORD, crypto.HashPolicy{Algorithm: crypto.AlgorithmBcrypt, BcryptCost: bcrypt.MinCost})
+	assert.NoError(t, err)
+	assert.NoError(t, service.Store.InsertCredentials(&Credentials{CustomerId: "customer-1", Email: "foo@bar.com", Crypto: outdated}))
+
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD2)
+	assert.Equal(t, ErrInvalidCredentials, err)
+	credentials, err := service.Store.GetCredentialsByCustomerId("customer-1")
+	assert.NoError(t, err)
+	assert.Equal(t, outdated.Hash, credentials.Crypto.Hash, "no rehash without the correct password")
+
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+	credentials, err = service.Store.GetCredentialsByCustomerId("customer-1")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(credentials.Crypto.Hash, "$argon2id$"))
+	assert.False(t, crypto.NeedsRehash(credentials.Crypto))
+	_, err = service.Login(MOCK_EMAIL, MOCK_PASSWORD)
+	assert.NoError(t, err)
+}
+
+func TestAuthPasswordPolicy(t *testing.T) {
+	service, _ := newTestAuthService()
+	service.PasswordPolicy = crypto.NewPasswordPolicy(10, 0, 2)
+	customer := &Customer{Id: "customer-1", Email: "foo@bar.com", Person: &address.Person{FirstName: "Gandalf", LastName: "Grey"}}
+	service.UserInput = func(customerId string) []string {
+		return customer.GetPasswordUserInput()
+	}
+
+	_, err := service.Register("customer-1", MOCK_EMAIL, "gandalf-the-wizard-123")
+	assert.IsType(t, &crypto.PasswordPolicyError{}, err)
+	assert.Equal(t, crypto.PasswordViolationContainsUserInput, err.(*crypto.PasswordPolicyError).Violations[0].Code)
+	_, err = service.Register("customer-1", MOCK_EMAIL, "quartz-Nebula-kettle-79!")
+	assert.NoError(t, err)
+
+	assert.IsType(t, &crypto.PasswordPolicyError{}, service.ChangePassword("customer-1", "quartz-Nebula-kettle-79!", "foobar"))
+
+	token, err := service.RequestPasswordReset(MOCK_EMAIL)
+	assert.NoError(t, err)
+	assert.IsType(t, &crypto.PasswordPolicyError{}, service.ResetPassword(token, "short"))
+	assert.NoError(t, service.ResetPassword(token, "osome+#,,brassford"), "token remains valid after a refused password")
+}
+
+func TestMongoCredentialsStoreUpdateCredentials(t *testing.T) {
+	session, collection := GetCredentialsPersistor().GetCollection()
+	defer session.Close()
+
+	assert.NoError(t, collection.DropCollection(), "clean up")
+	for _, index := range credentialsEnsuredIndexes {
+		assert.NoError(t, collection.EnsureIndex(index))
+	}
+	defer enableTestFieldEncryption(t)()
+
+	store := NewMongoCredentialsStore()
+	assert.NoError(t, store.InsertCredentials(&Credentials{CustomerId: "customer-1", Email: "foo@bar.com"}))
+	assert.NoError(t, store.InsertCredentials(&Credentials{CustomerId: "customer-2", Email: "alice@bar.com"}))
+	assert.Equal(t, ErrCredentialsExist, store.InsertCredentials(&Credentials{CustomerId: "customer-3", Email: "foo@bar.com"}))
+
+	credentials, err := store.GetCredentialsByEmail(MOCK_EMAIL)
+	assert.NoError(t, err)
+	assert.Equal(t, "customer-1", credentials.CustomerId)
+	stale := *credentials
+
+	credentials.FailedAttempts = 1
+	assert.NoError(t, store.UpdateCredentials(credentials))
+	assert.Equal(t, 1, credentials.Version)
+	stale.FailedAttempts = 2
+	assert.Equal(t, ErrCredentialsChanged, store.UpdateCredentials(&stale))
+	assert.Equal(t, 0, stale.Version, "the version of a failed update is not incremented")
+
+	credentials.Email = "alice@bar.com"
+	assert.Equal(t, ErrCredentialsExist, store.UpdateCredentials(credentials), "email is taken")
+	assert.Equal(t, 1, credentials.Version)
+	credentials.Email = "bar@foo.com"
+	assert.NoError(t, store.UpdateCredentials(credentials))
+	_, err = store.GetCredentialsByEmail(MOCK_EMAIL)
+	assert.Equal(t, ErrCredentialsNotFound, err)
+	credentials, err = store.GetCredentialsByEmail("Bar@Foo.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "customer-1", credentials.CustomerId)
+	assert.Equal(t, 1, credentials.FailedAttempts)
+
+	// concurrent updates of the same version: exactly one succeeds
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			copied := *credentials
+			copied.FailedAttempts = 10 + i
+			if store.UpdateCredentials(&copied) == nil {
+				mutex.Lock()
+				succeeded++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, succeeded)
+	credentials, err = store.GetCredentialsByCustomerId("customer-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, credentials.Version)
+}
+
+func TestMongoTokenStoreConsumeToken(t *testing.T) {
+	session, collection := GetTokenPersistor().GetCollection()
+	defer session.Close()
+
+	assert.NoError(t, collection.DropCollection(), "clean up")
+	for _, index := range tokenEnsuredIndexes {
+		assert.NoError(t, collection.EnsureIndex(index))
+	}
+	defer enableTestFieldEncryption(t)()
+
+	now := time.Date(2026, 5, 4, 12, 0, 0, 0, time.UTC)
+	service := NewTokenService(NewMongoTokenStore())
+	service.now = func() time.Time { return now }
+
+	first, err := service.IssueToken(TokenPurposeEmailVerification, "customer-1", "foo@bar.com", time.Hour)
+	assert.NoError(t, err)
+	token, err := service.IssueToken(TokenPurposeEmailVerification, "customer-1", "foo@bar.com", time.Hour)
+	assert.NoError(t, err)
+	_, err = service.ConsumeToken(TokenPurposeEmailVerification, first, "customer-1")
+	assert.Equal(t, ErrTokenInvalid, err, "issuing a token invalidates the earlier one")
+
+	_, err = service.ConsumeToken(TokenPurposePasswordReset, token, "customer-1")
+	assert.Equal(t, ErrTokenInvalid, err, "wrong purpose")
+	_, err = service.ConsumeToken(TokenPurposeEmailVerification, token, "customer-2")
+	assert.Equal(t, ErrTokenInvalid, err, "wrong customer")
+
+	// concurrent consumption of the same token: exactly one succeeds
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	consumed := []*Token{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if consumedToken, err := service.ConsumeToken(TokenPurposeEmailVerification, token, "customer-1"); err == nil {
+				mutex.Lock()
+				consumed = append(consumed, consumedToken)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, consumed, 1)
+	assert.True(t, consumed[0].Consumed)
+	assert.Equal(t, "foo@bar.com", consumed[0].Email, "the email is decrypted")
+
+	expiring, err := service.IssueToken(TokenPurposePasswordReset, "customer-2", "", time.Hour)
+	assert.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, err = service.ConsumeToken(TokenPurposePasswordReset, expiring, "")
+	assert.Equal(t, ErrTokenInvalid, err, "expired token")
+}
@@ -0,0 +1,196 @@
+package customer
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/crypto"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Credentials are the login data of a registered customer. They are stored separately from the customer,
+// so that password hashes are never loaded, exported or versioned together with customer data.
+type Credentials struct {
+	BsonId         bson.ObjectId `bson:"_id,omitempty"`
+	CustomerId     string
+	Email          string // lower case
+	Crypto         *crypto.Crypto
+	FailedAttempts int       // failed logins since the last successful login or lockout
+	LockedUntil    time.Time // zero if not locked
+	LastLoginAt    time.Time
+	CreatedAt      time.Time
+	LastModifiedAt time.Time
+	Version        int
+}
+
+// CredentialsStore persists credentials
+type CredentialsStore interface {
+	// InsertCredentials stores new credentials and returns ErrCredentialsExist if the customer or the email has credentials
+	InsertCredentials(credentials *Credentials) error
+	// GetCredentialsByEmail returns the credentials of email or ErrCredentialsNotFound
+	GetCredentialsByEmail(email string) (*Credentials, error)
+	// GetCredentialsByCustomerId returns the credentials of customerId or ErrCredentialsNotFound
+	GetCredentialsByCustomerId(customerId string) (*Credentials, error)
+	// UpdateCredentials stores credentials, if the stored version equals credentials.Version, and increments the version.
+	// Otherwise it fails with ErrCredentialsChanged.
+	UpdateCredentials(credentials *Credentials) error
+}
+
+// MongoCredentialsStore stores credentials in configuration.MONGO_COLLECTION_CUSTOMER_CREDENTIALS
+type MongoCredentialsStore struct{}
+
+// MemoryCredentialsStore is a CredentialsStore without persistence, e.g. for a Service in unit tests
+type MemoryCredentialsStore struct {
+	sync.Mutex
+	credentials map[string]*Credentials // customerId => credentials
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMongoCredentialsStore constructor
+func NewMongoCredentialsStore() *MongoCredentialsStore {
+	return &MongoCredentialsStore{}
+}
+
+// NewMemoryCredentialsStore constructor
+func NewMemoryCredentialsStore() *MemoryCredentialsStore {
+	return &MemoryCredentialsStore{
+		credentials: map[string]*Credentials{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// IsLocked returns true, if login is locked at now
+func (c *Credentials) IsLocked(now time.Time) bool {
+	return now.Before(c.LockedUntil)
+}
+
+func (s *MongoCredentialsStore) InsertCredentials(credentials *Credentials) error {
+	session, collection := GetCredentialsPersistor().GetCollection()
+	defer session.Close()
+	err := collection.Insert(credentials)
+	if mgo.IsDup(err) {
+		return ErrCredentialsExist
+	}
+	return err
+}
+
+func (s *MongoCredentialsStore) GetCredentialsByEmail(email string) (*Credentials, error) {
+	query := emailQuery(email)
+	return s.findOne(&query)
+}
+
+func (s *MongoCredentialsStore) GetCredentialsByCustomerId(customerId string) (*Credentials, error) {
+	return s.findOne(&bson.M{"customerid": customerId})
+}
+
+func (s *MongoCredentialsStore) UpdateCredentials(credentials *Credentials) error {
+	session, collection := GetCredentialsPersistor().GetCollection()
+	defer session.Close()
+	expectedVersion := credentials.Version
+	credentials.Version++
+	err := collection.Update(&bson.M{"customerid": credentials.CustomerId, "version": expectedVersion}, credentials)
+	if err == mgo.ErrNotFound {
+		credentials.Version = expectedVersion
+		return ErrCredentialsChanged
+	}
+	if mgo.IsDup(err) {
+		credentials.Version = expectedVersion
+		return ErrCredentialsExist
+	}
+	if err != nil {
+		credentials.Version = expectedVersion
+	}
+	return err
+}
+
+func (s *MemoryCredentialsStore) InsertCredentials(credentials *Credentials) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.credentials[credentials.CustomerId]; ok {
+		return ErrCredentialsExist
+	}
+	if s.getByEmail(credentials.Email) != nil {
+		return ErrCredentialsExist
+	}
+	copied := *credentials
+	s.credentials[credentials.CustomerId] = &copied
+	return nil
+}
+
+func (s *MemoryCredentialsStore) GetCredentialsByEmail(email string) (*Credentials, error) {
+	s.Lock()
+	defer s.Unlock()
+	credentials := s.getByEmail(email)
+	if credentials == nil {
+		return nil, ErrCredentialsNotFound
+	}
+	copied := *credentials
+	return &copied, nil
+}
+
+func (s *MemoryCredentialsStore) GetCredentialsByCustomerId(customerId string) (*Credentials, error) {
+	s.Lock()
+	defer s.Unlock()
+	credentials, ok := s.credentials[customerId]
+	if !ok {
+		return nil, ErrCredentialsNotFound
+	}
+	copied := *credentials
+	return &copied, nil
+}
+
+func (s *MemoryCredentialsStore) UpdateCredentials(credentials *Credentials) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.credentials[credentials.CustomerId]
+	if !ok || stored.Version != credentials.Version {
+		return ErrCredentialsChanged
+	}
+	if other := s.getByEmail(credentials.Email); other != nil && other.CustomerId != credentials.CustomerId {
+		return ErrCredentialsExist
+	}
+	credentials.Version++
+	copied := *credentials
+	s.credentials[credentials.CustomerId] = &copied
+	return nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func (s *MongoCredentialsStore) findOne(query *bson.M) (*Credentials, error) {
+	session, collection := GetCredentialsPersistor().GetCollection()
+	defer session.Close()
+	credentials := &Credentials{}
+	err := collection.Find(query).One(credentials)
+	if err == mgo.ErrNotFound {
+		return nil, ErrCredentialsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (s *MemoryCredentialsStore) getByEmail(email string) *Credentials {
+	email = lc(email)
+	for _, credentials := range s.credentials {
+		if credentials.Email == email {
+			return credentials
+		}
+	}
+	return nil
+}
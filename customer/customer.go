@@ -179,6 +179,14 @@ func (customer *Customer) UpsertAndGetCustomer(customProvider CustomerCustomProv
 	return UpsertAndGetCustomer(customer, customProvider)
 }
 
+// Unlinks customer from database. No peristent changes are performed until customer is linked again.
+func (customer *Customer) UnlinkFromDB() {
+	customer.unlinkDB = true
+}
+func (customer *Customer) LinkDB() {
+	customer.unlinkDB = false
+}
+
 func (customer *Customer) Delete() error {
 	return DeleteCustomer(customer)
 }
@@ -0,0 +1,152 @@
+package customer
+
+import (
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/crypto"
+)
+
+// The email and the addrKey of a customer and the emails of credentials and tokens are encrypted,
+// if crypto.SetFieldEncryption has been called.
+// Their blind indexes are stored in emailindex and addrkeyindex, see crypto.EqualityQuery.
+
+const (
+	KeyEmail        = "email"
+	KeyAddrKeyIndex = KeyAddrKey + crypto.BlindIndexSuffix
+	KeyEmailIndex   = KeyEmail + crypto.BlindIndexSuffix
+)
+
+// bsonKindNull is the bson type of null values
+const bsonKindNull = 0x0A
+
+type (
+	customerFields    Customer
+	credentialsFields Credentials
+	tokenFields       Token
+)
+
+// customerDocument is the stored representation of an encrypted customer
+type customerDocument struct {
+	customerFields `bson:",inline"`
+	AddrKeyIndex   string `bson:"addrkeyindex,omitempty"`
+	EmailIndex     string `bson:"emailindex,omitempty"`
+}
+
+// credentialsDocument is the stored representation of encrypted credentials
+type credentialsDocument struct {
+	credentialsFields `bson:",inline"`
+	EmailIndex        string `bson:"emailindex,omitempty"`
+}
+
+// tokenDocument is the stored representation of an encrypted token
+type tokenDocument struct {
+	tokenFields `bson:",inline"`
+	EmailIndex  string `bson:"emailindex,omitempty"`
+}
+
+// GetBSON implements bson.Getter
+func (customer *Customer) GetBSON() (interface{}, error) {
+	if customer == nil {
+		return nil, nil
+	}
+	if crypto.GetFieldEncryption() == nil {
+		return (*customerFields)(customer), nil
+	}
+	document := &customerDocument{
+		customerFields: customerFields(*customer),
+		AddrKeyIndex:   crypto.BlindIndex(KeyAddrKey, customer.AddrKey),
+		EmailIndex:     crypto.BlindIndex(KeyEmail, customer.Email),
+	}
+	err := crypto.EncryptFields(&document.AddrKey, &document.Email)
+	return document, err
+}
+
+// SetBSON implements bson.Setter
+func (customer *Customer) SetBSON(raw bson.Raw) error {
+	if raw.Kind == bsonKindNull {
+		return bson.SetZero
+	}
+	document := &customerDocument{}
+	if err := raw.Unmarshal(document); err != nil {
+		return err
+	}
+	if err := crypto.DecryptFields(&document.AddrKey, &document.Email); err != nil {
+		return err
+	}
+	*customer = Customer(document.customerFields)
+	return nil
+}
+
+// GetBSON implements bson.Getter
+func (credentials *Credentials) GetBSON() (interface{}, error) {
+	if credentials == nil {
+		return nil, nil
+	}
+	if crypto.GetFieldEncryption() == nil {
+		return (*credentialsFields)(credentials), nil
+	}
+	document := &credentialsDocument{
+		credentialsFields: credentialsFields(*credentials),
+		EmailIndex:        crypto.BlindIndex(KeyEmail, credentials.Email),
+	}
+	err := crypto.EncryptFields(&document.Email)
+	return document, err
+}
+
+// SetBSON implements bson.Setter
+func (credentials *Credentials) SetBSON(raw bson.Raw) error {
+	if raw.Kind == bsonKindNull {
+		return bson.SetZero
+	}
+	document := &credentialsDocument{}
+	if err := raw.Unmarshal(document); err != nil {
+		return err
+	}
+	if err := crypto.DecryptFields(&document.Email); err != nil {
+		return err
+	}
+	*credentials = Credentials(document.credentialsFields)
+	return nil
+}
+
+// GetBSON implements bson.Getter
+func (token *Token) GetBSON() (interface{}, error) {
+	if token == nil {
+		return nil, nil
+	}
+	if crypto.GetFieldEncryption() == nil {
+		return (*tokenFields)(token), nil
+	}
+	document := &tokenDocument{
+		tokenFields: tokenFields(*token),
+		EmailIndex:  crypto.BlindIndex(KeyEmail, token.Email),
+	}
+	err := crypto.EncryptFields(&document.Email)
+	return document, err
+}
+
+// SetBSON implements bson.Setter
+func (token *Token) SetBSON(raw bson.Raw) error {
+	if raw.Kind == bsonKindNull {
+		return bson.SetZero
+	}
+	document := &tokenDocument{}
+	if err := raw.Unmarshal(document); err != nil {
+		return err
+	}
+	if err := crypto.DecryptFields(&document.Email); err != nil {
+		return err
+	}
+	*token = Token(document.tokenFields)
+	return nil
+}
+
+// addrKeyQuery returns the query for the customer with addrKey
+func addrKeyQuery(addrKey string) bson.M {
+	return crypto.EqualityQuery(KeyAddrKey, addrKey)
+}
+
+// emailQuery returns the query for the credentials or tokens with email
+func emailQuery(email string) bson.M {
+	return crypto.EqualityQuery(KeyEmail, lc(email))
+}
@@ -0,0 +1,125 @@
+package customer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/crypto"
+)
+
+func enableTestFieldEncryption(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "shop-keys")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "keys.json")
+	assert.NoError(t, crypto.GenerateKeyFile(path, "k1"))
+	keys, err := crypto.NewFileKeyProvider(path)
+	assert.NoError(t, err)
+	crypto.SetFieldEncryption(crypto.NewFieldEncryption(keys))
+	return func() {
+		crypto.SetFieldEncryption(nil)
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestEncryptionCustomer() *Customer {
+	return &Customer{
+		Id:      "customer",
+		AddrKey: "alice-addrkey",
+		Email:   "alice@example.com",
+		Person: &address.Person{
+			FirstName: "Alice",
+			LastName:  "Smith",
+			Contacts: map[string]*address.Contact{
+				"phone": {ID: "phone", Type: address.ContactTypePhoneMobile, Value: "+41791234567"},
+			},
+			DefaultContacts: map[address.ContactType]string{address.ContactTypePhoneMobile: "phone"},
+		},
+		Addresses: []*address.Address{
+			{Id: "billing", Type: address.AddressDefaultBilling, Person: &address.Person{FirstName: "Alice", LastName: "Smith", Contacts: map[string]*address.Contact{}, DefaultContacts: map[address.ContactType]string{}}, Street: "Bahnhofstrasse", StreetNumber: "1", ZIP: "8001", City: "Zurich", Country: "Switzerland", CountryCode: "CH"},
+		},
+	}
+}
+
+func TestCustomerFieldEncryption(t *testing.T) {
+	c := newTestEncryptionCustomer()
+
+	data, err := bson.Marshal(c)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "alice@example.com", "without field encryption customers are stored in plaintext")
+	assert.NotContains(t, string(data), KeyEmailIndex)
+
+	defer enableTestFieldEncryption(t)()
+
+	data, err = bson.Marshal(c)
+	assert.NoError(t, err)
+	for _, plaintext := range []string{"alice", "Alice", "Smith", "Bahnhofstrasse", "8001", "Zurich", "+41791234567"} {
+		assert.NotContains(t, string(data), plaintext)
+	}
+	document := bson.M{}
+	assert.NoError(t, bson.Unmarshal(data, &document))
+	assert.Equal(t, crypto.BlindIndex(KeyEmail, "alice@example.com"), document[KeyEmailIndex])
+	assert.Equal(t, crypto.BlindIndex(KeyAddrKey, "alice-addrkey"), document[KeyAddrKeyIndex])
+	assert.Equal(t, "CH", document["addresses"].([]interface{})[0].(bson.M)["countrycode"], "country is not encrypted")
+	assert.Equal(t, "alice@example.com", c.Email, "the customer itself is not changed")
+
+	decoded := &Customer{}
+	assert.NoError(t, bson.Unmarshal(data, decoded))
+	assert.Equal(t, c, decoded)
+
+	// documents stored before field encryption was enabled can still be read
+	crypto.SetFieldEncryption(nil)
+	plain, _ := bson.Marshal(c)
+	defer enableTestFieldEncryption(t)()
+	decoded = &Customer{}
+	assert.NoError(t, bson.Unmarshal(plain, decoded))
+	assert.Equal(t, c, decoded)
+}
+
+func TestCustomerBlindIndexQueries(t *testing.T) {
+	assert.Equal(t, bson.M{KeyAddrKey: "alice-addrkey"}, addrKeyQuery("alice-addrkey"))
+
+	defer enableTestFieldEncryption(t)()
+	query := addrKeyQuery("alice-addrkey")
+	conditions := query["$or"].([]bson.M)
+	assert.Equal(t, bson.M{KeyAddrKeyIndex: crypto.BlindIndex(KeyAddrKey, "alice-addrkey")}, conditions[0])
+	assert.True(t, strings.HasSuffix(KeyEmailIndex, crypto.BlindIndexSuffix))
+}
+
+func TestCredentialsAndTokenFieldEncryption(t *testing.T) {
+	credentials := &Credentials{CustomerId: "customer", Email: "alice@example.com", Version: 1}
+	token := &Token{Id: "token", Purpose: TokenPurposeEmailVerification, CustomerId: "customer", Email: "alice@example.com"}
+	assert.Equal(t, bson.M{KeyEmail: "alice@example.com"}, emailQuery("Alice@Example.com"))
+
+	defer enableTestFieldEncryption(t)()
+
+	for _, value := range []interface{}{credentials, token} {
+		data, err := bson.Marshal(value)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "alice")
+		document := bson.M{}
+		assert.NoError(t, bson.Unmarshal(data, &document))
+		assert.Equal(t, crypto.BlindIndex(KeyEmail, "alice@example.com"), document[KeyEmailIndex])
+		assert.Equal(t, "customer", document["customerid"], "the customer id is not encrypted")
+	}
+
+	data, _ := bson.Marshal(credentials)
+	decodedCredentials := &Credentials{}
+	assert.NoError(t, bson.Unmarshal(data, decodedCredentials))
+	assert.Equal(t, credentials, decodedCredentials)
+
+	data, _ = bson.Marshal(token)
+	decodedToken := &Token{}
+	assert.NoError(t, bson.Unmarshal(data, decodedToken))
+	assert.Equal(t, token, decodedToken)
+
+	conditions := emailQuery("Alice@Example.com")["$or"].([]bson.M)
+	assert.Equal(t, bson.M{KeyEmailIndex: crypto.BlindIndex(KeyEmail, "alice@example.com")}, conditions[0])
+	assert.Equal(t, bson.M{KeyEmail: "alice@example.com"}, conditions[1], "credentials stored in plaintext are still found")
+}
@@ -8,3 +8,18 @@ import (
 
 // ErrCustomerNotFound if customer not found
 var ErrCustomerNotFound = errors.New(shop_error.ErrorNotInDatabase)
+
+var (
+	// ErrCredentialsNotFound if a customer has no credentials
+	ErrCredentialsNotFound = errors.New("credentials not found")
+	// ErrCredentialsExist if the customer or the email already has credentials
+	ErrCredentialsExist = errors.New("credentials already exist")
+	// ErrCredentialsChanged if credentials have been updated concurrently
+	ErrCredentialsChanged = errors.New("credentials changed concurrently")
+	// ErrInvalidCredentials if email or password are wrong. It does not tell which one.
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrAccountLocked if there have been too many failed login attempts and the password is correct
+	ErrAccountLocked = errors.New("account locked")
+	// ErrTokenInvalid if a token does not exist, has expired or has already been used
+	ErrTokenInvalid = errors.New("token invalid, expired or already used")
+)
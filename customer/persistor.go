@@ -4,6 +4,7 @@ import (
 	stderr "errors"
 
 	"github.com/foomo/shop/configuration"
+	"github.com/foomo/shop/crypto"
 	"github.com/foomo/shop/persistence"
 	"github.com/foomo/shop/shop_error"
 	"github.com/mitchellh/mapstructure"
@@ -20,6 +21,7 @@ import (
 var (
 	globalCustomerPersistor    *persistence.Persistor
 	globalCredentialsPersistor *persistence.Persistor
+	globalTokenPersistor       *persistence.Persistor
 
 	customerEnsuredIndexes = []mgo.Index{
 		{
@@ -42,10 +44,24 @@ var (
 		},
 		mgo.Index{
 			Name:       "mail",
-			Key:        []string{"email"},
+			Key:        []string{KeyEmail},
 			Unique:     false,
 			Background: true,
 		},
+		{
+			Name:       "addrkeyindex",
+			Key:        []string{KeyAddrKeyIndex},
+			Unique:     true,
+			Sparse:     true,
+			Background: true,
+		},
+		{
+			Name:       "emailindex",
+			Key:        []string{KeyEmailIndex},
+			Unique:     false,
+			Sparse:     true,
+			Background: true,
+		},
 		mgo.Index{
 			Name:       "externalid",
 			Key:        []string{"externalid"},
@@ -53,6 +69,39 @@ var (
 			Background: true,
 		},
 	}
+
+	credentialsEnsuredIndexes = []mgo.Index{
+		{
+			Name:   "customerid",
+			Key:    []string{"customerid"},
+			Unique: true,
+		},
+		{
+			Name:   "email",
+			Key:    []string{KeyEmail},
+			Unique: true,
+		},
+		{
+			Name:   "emailindex",
+			Key:    []string{KeyEmailIndex},
+			Unique: true,
+			Sparse: true,
+		},
+	}
+
+	tokenEnsuredIndexes = []mgo.Index{
+		{
+			Name:   "id",
+			Key:    []string{"id"},
+			Unique: true,
+		},
+		{
+			Name:       "customerid",
+			Key:        []string{"customerid", "purpose"},
+			Unique:     false,
+			Background: true,
+		},
+	}
 )
 
 //------------------------------------------------------------------
@@ -84,6 +133,36 @@ func GetCustomerPersistor() *persistence.Persistor {
 	return globalCustomerPersistor
 }
 
+// GetCredentialsPersistor will return a singleton instance of a credentials mongo persistor
+func GetCredentialsPersistor() *persistence.Persistor {
+	url := configuration.GetMongoURL()
+	collection := configuration.MONGO_COLLECTION_CUSTOMER_CREDENTIALS
+	if globalCredentialsPersistor != nil && url == globalCredentialsPersistor.GetURL() && collection == globalCredentialsPersistor.GetCollectionName() {
+		return globalCredentialsPersistor
+	}
+	p, err := persistence.NewPersistorWithIndexes(url, collection, credentialsEnsuredIndexes)
+	if err != nil || p == nil {
+		panic(errors.New("failed to create mongoDB credentials persistor: " + err.Error()))
+	}
+	globalCredentialsPersistor = p
+	return globalCredentialsPersistor
+}
+
+// GetTokenPersistor will return a singleton instance of a token mongo persistor
+func GetTokenPersistor() *persistence.Persistor {
+	url := configuration.GetMongoURL()
+	collection := configuration.MONGO_COLLECTION_CUSTOMER_TOKENS
+	if globalTokenPersistor != nil && url == globalTokenPersistor.GetURL() && collection == globalTokenPersistor.GetCollectionName() {
+		return globalTokenPersistor
+	}
+	p, err := persistence.NewPersistorWithIndexes(url, collection, tokenEnsuredIndexes)
+	if err != nil || p == nil {
+		panic(errors.New("failed to create mongoDB token persistor: " + err.Error()))
+	}
+	globalTokenPersistor = p
+	return globalTokenPersistor
+}
+
 // AlreadyExistsInDB checks if a customer with given customerID already exists in the database
 func AlreadyExistsInDB(customerID string) (bool, error) {
 	session, collection := GetCustomerPersistor().GetCollection()
@@ -130,6 +209,10 @@ func Find(query *bson.M, customProvider CustomerCustomProvider) (iter func() (cu
 
 // UpsertCustomer will save a given customer in mongo collection
 func UpsertCustomer(c *Customer) error {
+	// customer is unlinked
+	if c.unlinkDB {
+		return nil
+	}
 
 	session, collection := GetCustomerPersistor().GetCollection()
 	defer session.Close()
@@ -148,7 +231,7 @@ func UpsertCustomer(c *Customer) error {
 	// upsert existing customer
 	err := collection.Update(bson.M{
 		"$and": []bson.M{
-			{KeyAddrKey: c.AddrKey},
+			addrKeyQuery(c.AddrKey),
 			{"version.current": currentVersion},
 		}}, c)
 	if stderr.Is(err, mgo.ErrNotFound) {
@@ -186,7 +269,14 @@ func DeleteCustomerById(id string) error {
 }
 
 func GetCustomerByAddrKey(addrKey string, customProvider CustomerCustomProvider) (*Customer, error) {
-	return GetCustomerByQuery(&bson.M{KeyAddrKey: addrKey}, customProvider)
+	query := addrKeyQuery(addrKey)
+	return GetCustomerByQuery(&query, customProvider)
+}
+
+// GetCustomerByEmail returns the customer with email. The email is compared case insensitive.
+func GetCustomerByEmail(email string, customProvider CustomerCustomProvider) (*Customer, error) {
+	query := crypto.EqualityQuery(KeyEmail, lc(email))
+	return GetCustomerByQuery(&query, customProvider)
 }
 
 func GetCustomerByAddrKeyHash(addrKeyHash string, customProvider CustomerCustomProvider) (*Customer, error) {
@@ -0,0 +1,261 @@
+package customer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	TokenPurposePasswordReset     TokenPurpose = "passwordReset"
+	TokenPurposeEmailVerification TokenPurpose = "emailVerification"
+
+	DefaultPasswordResetTokenTTL     = time.Hour
+	DefaultEmailVerificationTokenTTL = 24 * time.Hour
+
+	tokenBytes = 32
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type TokenPurpose string
+
+// Token is a single use token, which is sent to a customer by email. Only its hash is stored.
+type Token struct {
+	BsonId     bson.ObjectId `bson:"_id,omitempty"`
+	Id         string        // sha256 of the token
+	Purpose    TokenPurpose
+	CustomerId string
+	Email      string // address to be confirmed by an email verification token
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	Consumed   bool
+	ConsumedAt time.Time
+}
+
+// TokenStore persists tokens
+type TokenStore interface {
+	// InsertToken stores a new token
+	InsertToken(token *Token) error
+	// ConsumeToken atomically marks the token with id and purpose as consumed and returns it, if it has not been
+	// consumed and has not expired at now. If customerId is not empty, the token must belong to it.
+	// Otherwise it fails with ErrTokenInvalid.
+	ConsumeToken(id string, purpose TokenPurpose, customerId string, now time.Time) (*Token, error)
+	// GetToken returns the token with id or ErrTokenInvalid
+	GetToken(id string) (*Token, error)
+	// InvalidateTokens marks all unconsumed tokens of customerId with purpose as consumed
+	InvalidateTokens(customerId string, purpose TokenPurpose, now time.Time) error
+}
+
+// MongoTokenStore stores tokens in configuration.MONGO_COLLECTION_CUSTOMER_TOKENS
+type MongoTokenStore struct{}
+
+// MemoryTokenStore holds issued tokens in a map, tokens are lost on restart
+type MemoryTokenStore struct {
+	sync.Mutex
+	tokens map[string]*Token
+}
+
+// TokenService issues and verifies single use tokens
+type TokenService struct {
+	Store TokenStore
+	now   func() time.Time
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMongoTokenStore constructor
+func NewMongoTokenStore() *MongoTokenStore {
+	return &MongoTokenStore{}
+}
+
+// NewMemoryTokenStore constructor
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: map[string]*Token{},
+	}
+}
+
+// NewTokenService constructor
+func NewTokenService(store TokenStore) *TokenService {
+	return &TokenService{
+		Store: store,
+		now:   time.Now,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// IssueToken creates a token for customerId, which is valid for ttl. Earlier tokens of customerId with the same
+// purpose are invalidated. The returned token is to be sent to the customer, it cannot be recovered later.
+func (s *TokenService) IssueToken(purpose TokenPurpose, customerId string, email string, ttl time.Duration) (string, error) {
+	random := make([]byte, tokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	now := s.now()
+	if err := s.Store.InvalidateTokens(customerId, purpose, now); err != nil {
+		return "", err
+	}
+	err := s.Store.InsertToken(&Token{
+		Id:         hashToken(token),
+		Purpose:    purpose,
+		CustomerId: customerId,
+		Email:      email,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeToken verifies token and invalidates it. If customerId is not empty, the token must have been issued for it.
+func (s *TokenService) ConsumeToken(purpose TokenPurpose, token string, customerId string) (*Token, error) {
+	if token == "" {
+		return nil, ErrTokenInvalid
+	}
+	return s.Store.ConsumeToken(hashToken(token), purpose, customerId, s.now())
+}
+
+// GetToken returns the valid token with purpose without consuming it
+func (s *TokenService) GetToken(purpose TokenPurpose, token string) (*Token, error) {
+	if token == "" {
+		return nil, ErrTokenInvalid
+	}
+	stored, err := s.Store.GetToken(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if stored.Purpose != purpose || stored.Consumed || !s.now().Before(stored.ExpiresAt) {
+		return nil, ErrTokenInvalid
+	}
+	return stored, nil
+}
+
+func (s *MongoTokenStore) InsertToken(token *Token) error {
+	session, collection := GetTokenPersistor().GetCollection()
+	defer session.Close()
+	return collection.Insert(token)
+}
+
+func (s *MongoTokenStore) ConsumeToken(id string, purpose TokenPurpose, customerId string, now time.Time) (*Token, error) {
+	session, collection := GetTokenPersistor().GetCollection()
+	defer session.Close()
+	query := bson.M{
+		"id":        id,
+		"purpose":   purpose,
+		"consumed":  false,
+		"expiresat": &bson.M{"$gt": now},
+	}
+	if customerId != "" {
+		query["customerid"] = customerId
+	}
+	token := &Token{}
+	_, err := collection.Find(query).Apply(mgo.Change{
+		Update:    &bson.M{"$set": &bson.M{"consumed": true, "consumedat": now}},
+		ReturnNew: true,
+	}, token)
+	if err == mgo.ErrNotFound {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *MongoTokenStore) GetToken(id string) (*Token, error) {
+	session, collection := GetTokenPersistor().GetCollection()
+	defer session.Close()
+	token := &Token{}
+	err := collection.Find(&bson.M{"id": id}).One(token)
+	if err == mgo.ErrNotFound {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *MongoTokenStore) InvalidateTokens(customerId string, purpose TokenPurpose, now time.Time) error {
+	session, collection := GetTokenPersistor().GetCollection()
+	defer session.Close()
+	_, err := collection.UpdateAll(
+		&bson.M{"customerid": customerId, "purpose": purpose, "consumed": false},
+		&bson.M{"$set": &bson.M{"consumed": true, "consumedat": now}},
+	)
+	return err
+}
+
+func (s *MemoryTokenStore) InsertToken(token *Token) error {
+	s.Lock()
+	defer s.Unlock()
+	copied := *token
+	s.tokens[token.Id] = &copied
+	return nil
+}
+
+func (s *MemoryTokenStore) ConsumeToken(id string, purpose TokenPurpose, customerId string, now time.Time) (*Token, error) {
+	s.Lock()
+	defer s.Unlock()
+	token, ok := s.tokens[id]
+	if !ok || token.Purpose != purpose || token.Consumed || !now.Before(token.ExpiresAt) || (customerId != "" && token.CustomerId != customerId) {
+		return nil, ErrTokenInvalid
+	}
+	token.Consumed = true
+	token.ConsumedAt = now
+	copied := *token
+	return &copied, nil
+}
+
+func (s *MemoryTokenStore) GetToken(id string) (*Token, error) {
+	s.Lock()
+	defer s.Unlock()
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (s *MemoryTokenStore) InvalidateTokens(customerId string, purpose TokenPurpose, now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, token := range s.tokens {
+		if token.CustomerId == customerId && token.Purpose == purpose && !token.Consumed {
+			token.Consumed = true
+			token.ConsumedAt = now
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
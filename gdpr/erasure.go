@@ -0,0 +1,238 @@
+package gdpr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/crypto"
+	"github.com/foomo/shop/unique"
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// PseudonymPrefix is the prefix of the pseudonyms which replace the identifiers of an erased customer
+const PseudonymPrefix = "erased-"
+
+// erasureRecordRetries is the number of attempts to append an erasure record, if records are appended concurrently
+const erasureRecordRetries = 5
+
+// erasureRecordHashName and pseudonymHashName separate the hashes of erasure records and pseudonyms from other keyed hashes
+const (
+	erasureRecordHashName = "gdpr-erasure-record"
+	pseudonymHashName     = "gdpr-pseudonym"
+)
+
+// pseudonymLength is the number of hex characters of a pseudonym after PseudonymPrefix
+const pseudonymLength = 32
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// ErasureTarget removes or pseudonymizes the records of a subject in one collection
+type ErasureTarget interface {
+	// GetName returns the unique name of the target
+	GetName() string
+	// Erase removes or pseudonymizes all records of subject and returns their number.
+	// It must be safe to call Erase again, if erasing the subject failed.
+	Erase(subject *Subject, pseudonym string) (int, error)
+}
+
+// Eraser erases all personal data of a subject and appends a record of each erasure to a hash chain
+type Eraser struct {
+	Targets []ErasureTarget
+	Records ErasureRecordStore
+	// Keys keys the hashes of the chain, e.g. the key provider of the field encryption.
+	// Without the index key, records cannot be changed and rehashed unnoticed.
+	Keys crypto.KeyProvider
+	// Anchor optionally stores the head of the chain outside the database of the records after each erasure,
+	// so that removing the latest records can be detected with VerifyErasureRecords
+	Anchor func(head *ErasureChainHead) error
+}
+
+// ErasureRecord documents an erasure without containing personal data.
+// Each record contains the keyed hash of its predecessor, so that removing or changing a record breaks the chain.
+type ErasureRecord struct {
+	BsonId       bson.ObjectId `bson:"_id,omitempty"`
+	Id           string
+	Sequence     int    // position in the chain, starting with 1
+	SubjectHash  string // sha256 of the identifiers of the subject, proves an erasure if they are known
+	Pseudonym    string // replaces the identifiers of the subject in retained records, e.g. orders
+	Targets      []*ErasureTargetResult
+	ErasedAt     time.Time
+	PreviousHash string // Hash of the record with Sequence - 1, empty for the first record
+	Hash         string
+}
+
+// ErasureChainHead identifies the latest record of the chain. The latest records can only be removed unnoticed,
+// if the head is stored in the same database as the records.
+type ErasureChainHead struct {
+	Sequence int
+	Hash     string
+}
+
+// ErasureTargetResult is the number of records erased by a target
+type ErasureTargetResult struct {
+	Name    string
+	Records int
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewEraser constructor, e.g. NewEraser(NewMongoErasureRecordStore(), keys, DefaultErasureTargets()...)
+func NewEraser(records ErasureRecordStore, keys crypto.KeyProvider, targets ...ErasureTarget) *Eraser {
+	return &Eraser{
+		Targets: targets,
+		Records: records,
+		Keys:    keys,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// Erase applies all targets to subject and returns the appended erasure record.
+// If a target fails, no record is appended. Erase can be called again, previously erased records are not touched.
+// The pseudonym is derived from the subject, so that a repeated erasure uses the pseudonym of the failed one.
+// If the head of the chain cannot be anchored, the appended record is returned with the error.
+func (e *Eraser) Erase(subject *Subject) (*ErasureRecord, error) {
+	if err := subject.Validate(); err != nil {
+		return nil, err
+	}
+	if e.Keys == nil {
+		return nil, errors.New("erasure records require a key provider")
+	}
+	names := map[string]bool{}
+	for _, target := range e.Targets {
+		if names[target.GetName()] {
+			return nil, errors.New("duplicate erasure target " + target.GetName())
+		}
+		names[target.GetName()] = true
+	}
+
+	record := &ErasureRecord{
+		Id:          unique.GetNewID(),
+		SubjectHash: subject.Hash(),
+		Pseudonym:   subject.Pseudonym(e.Keys),
+		Targets:     []*ErasureTargetResult{},
+	}
+	for _, target := range e.Targets {
+		n, err := target.Erase(subject, record.Pseudonym)
+		if err != nil {
+			return nil, errors.New("erasure target " + target.GetName() + ": " + err.Error())
+		}
+		record.Targets = append(record.Targets, &ErasureTargetResult{Name: target.GetName(), Records: n})
+	}
+	// mongo stores milliseconds, the hash must not change when the record is read again
+	record.ErasedAt = utils.TimeNow().UTC().Truncate(time.Millisecond)
+
+	for i := 0; i < erasureRecordRetries; i++ {
+		previous, err := e.Records.GetLatestErasureRecord()
+		if err != nil && err != ErrorErasureRecordNotFound {
+			return nil, err
+		}
+		record.Sequence = 1
+		record.PreviousHash = ""
+		if previous != nil {
+			record.Sequence = previous.Sequence + 1
+			record.PreviousHash = previous.Hash
+		}
+		record.Hash = record.ComputeHash(e.Keys)
+		err = e.Records.InsertErasureRecord(record)
+		if err != ErrorErasureRecordExists {
+			if err != nil {
+				return nil, err
+			}
+			if e.Anchor != nil {
+				if err := e.Anchor(record.GetChainHead()); err != nil {
+					return record, errors.New("failed to anchor erasure record " + strconv.Itoa(record.Sequence) + ": " + err.Error())
+				}
+			}
+			return record, nil
+		}
+	}
+	return nil, errors.New("failed to append erasure record after " + strconv.Itoa(erasureRecordRetries) + " attempts")
+}
+
+// Hash returns the sha256 of the identifiers of subject. The email is compared case insensitive.
+func (subject *Subject) Hash() string {
+	sum := sha256.Sum256([]byte(subject.CustomerId + "\n" + subject.AddrKey + "\n" + strings.ToLower(subject.Email)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Pseudonym returns the pseudonym of subject. It is a keyed hash of the identifiers, so that it cannot be traced back
+// to the subject without the index key.
+func (subject *Subject) Pseudonym(keys crypto.KeyProvider) string {
+	return PseudonymPrefix + crypto.KeyedHash(keys, pseudonymHashName, []byte(subject.Hash()))[:pseudonymLength]
+}
+
+// ComputeHash returns the keyed hash of all fields of the record except BsonId and Hash
+func (record *ErasureRecord) ComputeHash(keys crypto.KeyProvider) string {
+	data, _ := json.Marshal(struct {
+		Id           string
+		Sequence     int
+		SubjectHash  string
+		Pseudonym    string
+		Targets      []*ErasureTargetResult
+		ErasedAt     string
+		PreviousHash string
+	}{
+		Id:           record.Id,
+		Sequence:     record.Sequence,
+		SubjectHash:  record.SubjectHash,
+		Pseudonym:    record.Pseudonym,
+		Targets:      record.Targets,
+		ErasedAt:     record.ErasedAt.UTC().Format(time.RFC3339Nano),
+		PreviousHash: record.PreviousHash,
+	})
+	return crypto.KeyedHash(keys, erasureRecordHashName, data)
+}
+
+// GetChainHead returns the head of the chain ending with record
+func (record *ErasureRecord) GetChainHead() *ErasureChainHead {
+	return &ErasureChainHead{
+		Sequence: record.Sequence,
+		Hash:     record.Hash,
+	}
+}
+
+// VerifyErasureRecords returns an error, if the records sorted by sequence are not an intact hash chain or do not contain head.
+// Without head, removing the latest records cannot be detected.
+func VerifyErasureRecords(keys crypto.KeyProvider, records []*ErasureRecord, head *ErasureChainHead) error {
+	previousHash := ""
+	for i, record := range records {
+		if record.Sequence != i+1 {
+			return errors.New("erasure record " + strconv.Itoa(i+1) + " is missing")
+		}
+		if record.PreviousHash != previousHash {
+			return errors.New("erasure record " + strconv.Itoa(record.Sequence) + " does not reference its predecessor")
+		}
+		if record.Hash != record.ComputeHash(keys) {
+			return errors.New("erasure record " + strconv.Itoa(record.Sequence) + " has been modified")
+		}
+		previousHash = record.Hash
+	}
+	if head == nil {
+		return nil
+	}
+	if head.Sequence < 1 || head.Sequence > len(records) {
+		return errors.New("erasure record " + strconv.Itoa(head.Sequence) + " is missing")
+	}
+	if records[head.Sequence-1].Hash != head.Hash {
+		return errors.New("erasure record " + strconv.Itoa(head.Sequence) + " does not match the head of the chain")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package gdpr
+
+import (
+	"errors"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/configuration"
+	"github.com/foomo/shop/persistence"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var (
+	ErrorErasureRecordNotFound = errors.New("erasure record not found")
+	ErrorErasureRecordExists   = errors.New("erasure record exists")
+)
+
+var (
+	globalErasureRecordPersistor *persistence.Persistor
+
+	erasureRecordEnsuredIndexes = []mgo.Index{
+		{
+			Name:   "id",
+			Key:    []string{"id"},
+			Unique: true,
+		},
+		{
+			Name:   "sequence",
+			Key:    []string{"sequence"},
+			Unique: true,
+		},
+		{
+			Name:       "subjecthash",
+			Key:        []string{"subjecthash"},
+			Unique:     false,
+			Background: true,
+		},
+	}
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// ErasureRecordStore persists the chain of erasure records. Records are never updated or removed.
+type ErasureRecordStore interface {
+	// InsertErasureRecord appends record and returns ErrorErasureRecordExists if a record with its sequence exists
+	InsertErasureRecord(record *ErasureRecord) error
+	// GetLatestErasureRecord returns the record with the highest sequence or ErrorErasureRecordNotFound
+	GetLatestErasureRecord() (*ErasureRecord, error)
+	// GetErasureRecords returns all records sorted by sequence
+	GetErasureRecords() ([]*ErasureRecord, error)
+}
+
+// MongoErasureRecordStore stores records in configuration.MONGO_COLLECTION_GDPR_ERASURES
+type MongoErasureRecordStore struct{}
+
+// MemoryErasureRecordStore holds erasure records of a single process, e.g. for an Eraser in tests
+type MemoryErasureRecordStore struct {
+	sync.Mutex
+	records []*ErasureRecord
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMongoErasureRecordStore constructor
+func NewMongoErasureRecordStore() *MongoErasureRecordStore {
+	return &MongoErasureRecordStore{}
+}
+
+// NewMemoryErasureRecordStore constructor
+func NewMemoryErasureRecordStore() *MemoryErasureRecordStore {
+	return &MemoryErasureRecordStore{
+		records: []*ErasureRecord{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// GetErasureRecordPersistor will return a singleton instance of an erasure record mongo persistor
+func GetErasureRecordPersistor() *persistence.Persistor {
+	url := configuration.GetMongoURL()
+	collection := configuration.MONGO_COLLECTION_GDPR_ERASURES
+	if globalErasureRecordPersistor != nil && url == globalErasureRecordPersistor.GetURL() && collection == globalErasureRecordPersistor.GetCollectionName() {
+		return globalErasureRecordPersistor
+	}
+	p, err := persistence.NewPersistorWithIndexes(url, collection, erasureRecordEnsuredIndexes)
+	if err != nil || p == nil {
+		panic(errors.New("failed to create mongoDB erasure record persistor: " + err.Error()))
+	}
+	globalErasureRecordPersistor = p
+	return globalErasureRecordPersistor
+}
+
+func (s *MongoErasureRecordStore) InsertErasureRecord(record *ErasureRecord) error {
+	session, collection := GetErasureRecordPersistor().GetCollection()
+	defer session.Close()
+	err := collection.Insert(record)
+	if mgo.IsDup(err) {
+		return ErrorErasureRecordExists
+	}
+	return err
+}
+
+func (s *MongoErasureRecordStore) GetLatestErasureRecord() (*ErasureRecord, error) {
+	session, collection := GetErasureRecordPersistor().GetCollection()
+	defer session.Close()
+	record := &ErasureRecord{}
+	err := collection.Find(&bson.M{}).Sort("-sequence").One(record)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorErasureRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *MongoErasureRecordStore) GetErasureRecords() ([]*ErasureRecord, error) {
+	session, collection := GetErasureRecordPersistor().GetCollection()
+	defer session.Close()
+	records := []*ErasureRecord{}
+	if err := collection.Find(&bson.M{}).Sort("sequence").All(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *MemoryErasureRecordStore) InsertErasureRecord(record *ErasureRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	if record.Sequence <= len(s.records) {
+		return ErrorErasureRecordExists
+	}
+	s.records = append(s.records, copyErasureRecord(record))
+	return nil
+}
+
+func (s *MemoryErasureRecordStore) GetLatestErasureRecord() (*ErasureRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.records) == 0 {
+		return nil, ErrorErasureRecordNotFound
+	}
+	return copyErasureRecord(s.records[len(s.records)-1]), nil
+}
+
+func (s *MemoryErasureRecordStore) GetErasureRecords() ([]*ErasureRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	records := []*ErasureRecord{}
+	for _, record := range s.records {
+		records = append(records, copyErasureRecord(record))
+	}
+	return records, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// copyErasureRecord returns a deep copy of record, so that records in memory cannot be changed by callers
+func copyErasureRecord(record *ErasureRecord) *ErasureRecord {
+	copied := *record
+	copied.Targets = []*ErasureTargetResult{}
+	for _, target := range record.Targets {
+		copiedTarget := *target
+		copied.Targets = append(copied.Targets, &copiedTarget)
+	}
+	return &copied
+}
@@ -0,0 +1,366 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/balance"
+	"github.com/foomo/shop/crypto"
+	"github.com/foomo/shop/customer"
+	"github.com/foomo/shop/merge"
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/persistence"
+	"github.com/foomo/shop/pricerule"
+	"github.com/foomo/shop/version"
+	"github.com/foomo/shop/watchlist"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// orderCustomerDataPointer is the JSON pointer of the customer data in order history deltas
+const orderCustomerDataPointer = "/CustomerData"
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// MongoErasureTarget removes or pseudonymizes the documents of a subject in a collection
+type MongoErasureTarget struct {
+	Name      string
+	Persistor func() *persistence.Persistor
+	// Query returns the query for the documents of subject, nil if there can be none
+	Query func(subject *Subject) bson.M
+	// Update returns the update which pseudonymizes document, nil to leave it unchanged. If Update is nil, documents are removed.
+	Update func(subject *Subject, pseudonym string, document bson.M) (bson.M, error)
+}
+
+// OrderHistoryErasureTarget pseudonymizes the customer data of all versions of the orders of a subject in orders_history
+type OrderHistoryErasureTarget struct{}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// DefaultErasureTargets returns the targets for all collections with customer data.
+// Customer profiles, credentials, tokens and watch lists are removed.
+// Orders, merge records, voucher usages and balance accounts are kept for accounting, the subject is replaced by the pseudonym.
+func DefaultErasureTargets() []ErasureTarget {
+	return []ErasureTarget{
+		&MongoErasureTarget{
+			Name:      "orders",
+			Persistor: order.GetOrderPersistor,
+			Query:     orderQuery,
+			Update: func(subject *Subject, pseudonym string, document bson.M) (bson.M, error) {
+				return pseudonymizeOrderDocument(document, pseudonym)
+			},
+		},
+		&OrderHistoryErasureTarget{},
+		&MongoErasureTarget{
+			Name: "pricerule_usages",
+			Persistor: func() *persistence.Persistor {
+				return pricerule.GetPersistorForObject(&pricerule.PriceRule{})
+			},
+			Query:  priceRuleUsageQuery,
+			Update: pseudonymizePriceRuleUsage,
+		},
+		&MongoErasureTarget{
+			Name: "pricerule_vouchers",
+			Persistor: func() *persistence.Persistor {
+				return pricerule.GetPersistorForObject(&pricerule.Voucher{})
+			},
+			Query: func(subject *Subject) bson.M {
+				return orQuery("customerid", subject.CustomerId, "redemptions.customerid", subject.CustomerId)
+			},
+			Update: pseudonymizeVoucher,
+		},
+		&MongoErasureTarget{
+			Name:      "customer_merges",
+			Persistor: merge.GetRecordPersistor,
+			Query: func(subject *Subject) bson.M {
+				return orQuery("guestcustomerid", subject.CustomerId, "targetcustomerid", subject.CustomerId)
+			},
+			Update: pseudonymizeMergeRecord,
+		},
+		&MongoErasureTarget{
+			Name:      "balance_accounts",
+			Persistor: balance.GetAccountPersistor,
+			Query: func(subject *Subject) bson.M {
+				return orQuery("customerid", subject.CustomerId)
+			},
+			Update: func(subject *Subject, pseudonym string, document bson.M) (bson.M, error) {
+				return bson.M{"$set": bson.M{"customerid": pseudonym}}, nil
+			},
+		},
+		&MongoErasureTarget{
+			Name:      "watchlists",
+			Persistor: watchlist.GetWatchListPersistor,
+			Query: func(subject *Subject) bson.M {
+				return orQuery(watchlist.KeyAddrkey, subject.AddrKey)
+			},
+		},
+		&MongoErasureTarget{
+			Name:      "customer_tokens",
+			Persistor: customer.GetTokenPersistor,
+			Query:     credentialsQuery,
+		},
+		&MongoErasureTarget{
+			Name:      "customer_credentials",
+			Persistor: customer.GetCredentialsPersistor,
+			Query: func(subject *Subject) bson.M {
+				return credentialsQuery(subject)
+			},
+		},
+		// the customer is removed last, so that a failed erasure can be repeated with the same subject
+		&MongoErasureTarget{
+			Name:      "customers",
+			Persistor: customer.GetCustomerPersistor,
+			Query:     customerQuery,
+		},
+	}
+}
+
+func (t *MongoErasureTarget) GetName() string {
+	return t.Name
+}
+
+func (t *MongoErasureTarget) Erase(subject *Subject, pseudonym string) (int, error) {
+	query := t.Query(subject)
+	if query == nil {
+		return 0, nil
+	}
+	session, collection := t.Persistor().GetCollection()
+	defer session.Close()
+	if t.Update == nil {
+		info, err := collection.RemoveAll(query)
+		if err != nil {
+			return 0, err
+		}
+		return info.Removed, nil
+	}
+	documents := []bson.M{}
+	if err := collection.Find(query).All(&documents); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, document := range documents {
+		update, err := t.Update(subject, pseudonym, document)
+		if err != nil {
+			return n, err
+		}
+		if update == nil {
+			continue
+		}
+		if err := collection.UpdateId(document["_id"], update); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (t *OrderHistoryErasureTarget) GetName() string {
+	return "orders_history"
+}
+
+// Erase pseudonymizes full copies and removes changes of the customer data from deltas.
+// Deltas are processed first, because only full copies identify the orders of the subject.
+func (t *OrderHistoryErasureTarget) Erase(subject *Subject, pseudonym string) (int, error) {
+	session, collection := order.GetOrderVersionsPersistor().GetCollection()
+	defer session.Close()
+
+	orderIds, err := getOrderHistoryIds(collection, subject)
+	if err != nil || len(orderIds) == 0 {
+		return 0, err
+	}
+	n := 0
+	for _, isDelta := range []bool{true, false} {
+		documents := []bson.M{}
+		err := collection.Find(&bson.M{"id": &bson.M{"$in": orderIds}, "delta": &bson.M{"$exists": isDelta}}).Select(&bson.M{"customerdata": 1, "delta": 1}).All(&documents)
+		if err != nil {
+			return n, err
+		}
+		for _, document := range documents {
+			update, err := pseudonymizeOrderHistoryDocument(document, pseudonym)
+			if err != nil {
+				return n, err
+			}
+			if update == nil {
+				continue
+			}
+			if err := collection.UpdateId(document["_id"], update); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// PseudonymizeCustomerData replaces the identifiers of the customer by pseudonym and removes the personal data from the addresses.
+// Country and customer type are kept, because they are required for taxes and reporting.
+func PseudonymizeCustomerData(customerData *order.CustomerData, pseudonym string) {
+	if customerData.CustomerId != "" {
+		customerData.CustomerId = pseudonym
+	}
+	if customerData.GuestCustomerID != "" {
+		customerData.GuestCustomerID = pseudonym
+	}
+	if customerData.AddrKey != "" {
+		customerData.AddrKey = pseudonym
+	}
+	customerData.Email = ""
+	customerData.BillingAddress = pseudonymizeAddress(customerData.BillingAddress)
+	customerData.ShippingAddress = pseudonymizeAddress(customerData.ShippingAddress)
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// pseudonymizeAddress returns a copy of addr, which only contains its id, type and country
+func pseudonymizeAddress(addr *address.Address) *address.Address {
+	if addr == nil {
+		return nil
+	}
+	return &address.Address{
+		Id:          addr.Id,
+		Type:        addr.Type,
+		Person:      &address.Person{},
+		Country:     addr.Country,
+		CountryCode: addr.CountryCode,
+	}
+}
+
+func pseudonymizeOrderDocument(document bson.M, pseudonym string) (bson.M, error) {
+	raw, ok := document["customerdata"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	data, err := bson.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	customerData := &order.CustomerData{}
+	if err := bson.Unmarshal(data, customerData); err != nil {
+		return nil, err
+	}
+	PseudonymizeCustomerData(customerData, pseudonym)
+	return bson.M{"$set": bson.M{"customerdata": customerData}}, nil
+}
+
+// pseudonymizeOrderHistoryDocument pseudonymizes a full copy or removes all changes of the customer data from a delta.
+// Versions restored from a delta then contain the pseudonymized customer data of its full copy.
+func pseudonymizeOrderHistoryDocument(document bson.M, pseudonym string) (bson.M, error) {
+	delta, ok := document["delta"].(bson.M)
+	if !ok {
+		return pseudonymizeOrderDocument(document, pseudonym)
+	}
+	patchJSON, _ := delta["patch"].(string)
+	if patchJSON == "" {
+		return nil, nil
+	}
+	patchJSON, err := crypto.DecryptField(patchJSON)
+	if err != nil {
+		return nil, err
+	}
+	patch := []*version.PatchOperation{}
+	if err := json.Unmarshal([]byte(patchJSON), &patch); err != nil {
+		return nil, err
+	}
+	filtered := []*version.PatchOperation{}
+	for _, op := range patch {
+		if !isCustomerDataPointer(op.Path) && !isCustomerDataPointer(op.From) {
+			filtered = append(filtered, op)
+		}
+	}
+	if len(filtered) == len(patch) {
+		return nil, nil
+	}
+	data, err := json.Marshal(filtered)
+	if err != nil {
+		return nil, err
+	}
+	patchJSON, err = crypto.EncryptField(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$set": bson.M{"delta.patch": patchJSON}}, nil
+}
+
+func isCustomerDataPointer(pointer string) bool {
+	return pointer == orderCustomerDataPointer || strings.HasPrefix(pointer, orderCustomerDataPointer+"/")
+}
+
+// pseudonymizePriceRuleUsage renames the usages of the subject, so that the totals of the price rule remain correct.
+// The customer id is part of the renamed field paths, ids which are no valid field names are refused.
+func pseudonymizePriceRuleUsage(subject *Subject, pseudonym string, document bson.M) (bson.M, error) {
+	for _, name := range []string{subject.CustomerId, pseudonym} {
+		if !isFieldName(name) {
+			return nil, fmt.Errorf("usages of %q cannot be renamed in price rule %v, it is not a valid field name", name, document["id"])
+		}
+	}
+	rename := bson.M{}
+	for _, key := range []string{"usagespercustomer", "redeemedamountpercustomer"} {
+		usages, _ := getUsageHistory(document)[key].(bson.M)
+		if _, ok := usages[subject.CustomerId]; ok {
+			rename["usagehistory."+key+"."+subject.CustomerId] = "usagehistory." + key + "." + pseudonym
+		}
+	}
+	if len(rename) == 0 {
+		return nil, nil
+	}
+	return bson.M{"$rename": rename}, nil
+}
+
+// isFieldName returns true if name can be used as a single element of a field path
+func isFieldName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ".\x00") && !strings.HasPrefix(name, "$")
+}
+
+func getUsageHistory(document bson.M) bson.M {
+	usageHistory, _ := document["usagehistory"].(bson.M)
+	return usageHistory
+}
+
+// pseudonymizeVoucher replaces the subject as owner and in the redemptions of a voucher
+func pseudonymizeVoucher(subject *Subject, pseudonym string, document bson.M) (bson.M, error) {
+	set := bson.M{}
+	if document["customerid"] == subject.CustomerId {
+		set["customerid"] = pseudonym
+	}
+	if redemptions, ok := document["redemptions"].([]interface{}); ok {
+		changed := false
+		for _, redemption := range redemptions {
+			if r, ok := redemption.(bson.M); ok && r["customerid"] == subject.CustomerId {
+				r["customerid"] = pseudonym
+				changed = true
+			}
+		}
+		if changed {
+			set["redemptions"] = redemptions
+		}
+	}
+	if len(set) == 0 {
+		return nil, nil
+	}
+	return bson.M{"$set": set}, nil
+}
+
+func pseudonymizeMergeRecord(subject *Subject, pseudonym string, document bson.M) (bson.M, error) {
+	set := bson.M{}
+	for _, key := range []string{"guestcustomerid", "targetcustomerid"} {
+		if document[key] == subject.CustomerId {
+			set[key] = pseudonym
+		}
+	}
+	if len(set) == 0 {
+		return nil, nil
+	}
+	return bson.M{"$set": set}, nil
+}
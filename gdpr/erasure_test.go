@@ -0,0 +1,334 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/crypto"
+	"github.com/foomo/shop/customer"
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/version"
+)
+
+type testErasureTarget struct {
+	name       string
+	records    int
+	err        error
+	pseudonyms []string
+}
+
+func (t *testErasureTarget) GetName() string { return t.name }
+func (t *testErasureTarget) Erase(subject *Subject, pseudonym string) (int, error) {
+	t.pseudonyms = append(t.pseudonyms, pseudonym)
+	return t.records, t.err
+}
+
+func newTestKeyProvider(t *testing.T) (crypto.KeyProvider, func()) {
+	dir, err := ioutil.TempDir("", "shop-keys")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "keys.json")
+	assert.NoError(t, crypto.GenerateKeyFile(path, "k1"))
+	keys, err := crypto.NewFileKeyProvider(path)
+	assert.NoError(t, err)
+	return keys, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEraser(t *testing.T) {
+	keys, cleanup := newTestKeyProvider(t)
+	defer cleanup()
+	store := NewMemoryErasureRecordStore()
+	orders := &testErasureTarget{name: "orders", records: 2}
+	customers := &testErasureTarget{name: "customers", records: 1}
+	eraser := NewEraser(store, keys, orders, customers)
+	var anchored *ErasureChainHead
+	eraser.Anchor = func(head *ErasureChainHead) error {
+		anchored = head
+		return nil
+	}
+
+	first, err := eraser.Erase(&Subject{CustomerId: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, first.Sequence)
+	assert.Equal(t, "", first.PreviousHash)
+	assert.Equal(t, []*ErasureTargetResult{{Name: "orders", Records: 2}, {Name: "customers", Records: 1}}, first.Targets)
+	assert.Equal(t, first.Pseudonym, orders.pseudonyms[0])
+	assert.Equal(t, first.Pseudonym, customers.pseudonyms[0], "all targets use the same pseudonym")
+	assert.NotContains(t, first.SubjectHash, "alice")
+	assert.Equal(t, (&Subject{CustomerId: "alice"}).Hash(), first.SubjectHash)
+	assert.Equal(t, &ErasureChainHead{Sequence: 1, Hash: first.Hash}, anchored)
+
+	second, err := eraser.Erase(&Subject{CustomerId: "bob", Email: "Bob@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, second.Sequence)
+	assert.Equal(t, first.Hash, second.PreviousHash)
+	assert.NotEqual(t, first.Pseudonym, second.Pseudonym)
+	assert.Equal(t, second.GetChainHead(), anchored)
+
+	records, err := store.GetErasureRecords()
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyErasureRecords(keys, records, anchored))
+	assert.NoError(t, VerifyErasureRecords(keys, records, first.GetChainHead()), "records appended after the head")
+
+	records[0].Targets[0].Records = 0
+	assert.Error(t, VerifyErasureRecords(keys, records, nil), "modified record")
+	records, _ = store.GetErasureRecords()
+	assert.Error(t, VerifyErasureRecords(keys, records[1:], nil), "removed record")
+	assert.NoError(t, VerifyErasureRecords(keys, records[:1], nil), "the latest records can only be detected with the head")
+	assert.Error(t, VerifyErasureRecords(keys, records[:1], anchored), "removed latest record")
+	records[1].PreviousHash = ""
+	records[1].Hash = records[1].ComputeHash(keys)
+	assert.Error(t, VerifyErasureRecords(keys, records, nil))
+
+	otherKeys, cleanupOther := newTestKeyProvider(t)
+	defer cleanupOther()
+	records, _ = store.GetErasureRecords()
+	assert.Error(t, VerifyErasureRecords(otherKeys, records, nil), "the chain is keyed")
+
+	eraser.Anchor = func(head *ErasureChainHead) error {
+		return errors.New("failed")
+	}
+	third, err := eraser.Erase(&Subject{CustomerId: "carol"})
+	assert.Error(t, err)
+	assert.Equal(t, 3, third.Sequence, "the record has been appended")
+}
+
+func TestEraserRepeatedErasureUsesSamePseudonym(t *testing.T) {
+	keys, cleanup := newTestKeyProvider(t)
+	defer cleanup()
+	store := NewMemoryErasureRecordStore()
+	orders := &testErasureTarget{name: "orders", records: 2}
+	customers := &testErasureTarget{name: "customers", err: errors.New("failed")}
+	eraser := NewEraser(store, keys, orders, customers)
+
+	subject := &Subject{CustomerId: "alice", Email: "alice@example.com"}
+	_, err := eraser.Erase(subject)
+	assert.Error(t, err)
+	customers.err = nil
+	record, err := eraser.Erase(&Subject{CustomerId: "alice", Email: "Alice@Example.com"})
+	assert.NoError(t, err)
+	assert.Len(t, orders.pseudonyms, 2)
+	assert.Equal(t, orders.pseudonyms[0], orders.pseudonyms[1], "the orders of the failed erasure are not pseudonymized twice")
+	assert.Equal(t, subject.Pseudonym(keys), record.Pseudonym)
+	assert.True(t, strings.HasPrefix(record.Pseudonym, PseudonymPrefix))
+	assert.NotContains(t, record.Pseudonym, record.SubjectHash[:pseudonymLength])
+	assert.NotEqual(t, subject.Pseudonym(keys), (&Subject{CustomerId: "bob"}).Pseudonym(keys))
+}
+
+func TestEraserErrors(t *testing.T) {
+	keys, cleanup := newTestKeyProvider(t)
+	defer cleanup()
+	store := NewMemoryErasureRecordStore()
+	_, err := NewEraser(store, keys).Erase(&Subject{})
+	assert.Error(t, err)
+	_, err = NewEraser(store, nil).Erase(&Subject{CustomerId: "alice"})
+	assert.Error(t, err, "key provider is required")
+	_, err = NewEraser(store, keys, &testErasureTarget{name: "orders"}, &testErasureTarget{name: "orders"}).Erase(&Subject{CustomerId: "alice"})
+	assert.Error(t, err)
+	_, err = NewEraser(store, keys, &testErasureTarget{name: "orders", err: errors.New("failed")}).Erase(&Subject{CustomerId: "alice"})
+	assert.Error(t, err)
+	records, _ := store.GetErasureRecords()
+	assert.Len(t, records, 0, "no record is appended if a target fails")
+}
+
+func TestPseudonymizeOrderDocument(t *testing.T) {
+	customerData := &order.CustomerData{
+		CustomerId:   "alice",
+		AddrKey:      "alice-addrkey",
+		CustomerType: "Private",
+		Email:        "alice@example.com",
+		BillingAddress: &address.Address{
+			Id:     "billing",
+			Person: &address.Person{FirstName: "Alice", LastName: "Smith", Birthday: "1980-01-01"},
+			Street: "Bahnhofstrasse", StreetNumber: "1", ZIP: "8000", City: "Zurich", Country: "Switzerland", CountryCode: "CH",
+		},
+	}
+	data, err := bson.Marshal(bson.M{"_id": bson.NewObjectId(), "customerdata": customerData, "positions": []bson.M{{"itemid": "shirt", "price": 10.0}}})
+	assert.NoError(t, err)
+	document := bson.M{}
+	assert.NoError(t, bson.Unmarshal(data, &document))
+
+	update, err := pseudonymizeOrderDocument(document, "erased-1")
+	assert.NoError(t, err)
+	set := update["$set"].(bson.M)
+	assert.Len(t, set, 1, "only the customer data is changed")
+	pseudonymized := set["customerdata"].(*order.CustomerData)
+	assert.Equal(t, "erased-1", pseudonymized.CustomerId)
+	assert.Equal(t, "", pseudonymized.GuestCustomerID)
+	assert.Equal(t, "erased-1", pseudonymized.AddrKey)
+	assert.Equal(t, "", pseudonymized.Email)
+	assert.Equal(t, "Private", pseudonymized.CustomerType)
+	assert.Nil(t, pseudonymized.ShippingAddress)
+	assert.Equal(t, &address.Address{Id: "billing", Person: &address.Person{}, Country: "Switzerland", CountryCode: "CH"}, pseudonymized.BillingAddress)
+}
+
+func TestPseudonymizeOrderHistoryDelta(t *testing.T) {
+	patch := []*version.PatchOperation{
+		{Op: "replace", Path: "/CustomerData/Email", Value: "alice@example.com"},
+		{Op: "replace", Path: "/Positions/0/Quantity", Value: 2},
+		{Op: "add", Path: "/CustomerData", Value: map[string]interface{}{"Email": "alice@example.com"}},
+		{Op: "replace", Path: "/CustomerDataVersion", Value: 1},
+	}
+	data, _ := json.Marshal(patch)
+	document := bson.M{"_id": bson.NewObjectId(), "delta": bson.M{"baseversion": 1, "patch": string(data)}}
+
+	update, err := pseudonymizeOrderHistoryDocument(document, "erased-1")
+	assert.NoError(t, err)
+	filtered := []*version.PatchOperation{}
+	assert.NoError(t, json.Unmarshal([]byte(update["$set"].(bson.M)["delta.patch"].(string)), &filtered))
+	assert.Len(t, filtered, 2)
+	assert.Equal(t, "/Positions/0/Quantity", filtered[0].Path)
+	assert.Equal(t, "/CustomerDataVersion", filtered[1].Path)
+
+	data, _ = json.Marshal(filtered)
+	update, err = pseudonymizeOrderHistoryDocument(bson.M{"delta": bson.M{"patch": string(data)}}, "erased-1")
+	assert.NoError(t, err)
+	assert.Nil(t, update, "delta without customer data is not changed")
+}
+
+func TestPseudonymizeVoucherAndUsage(t *testing.T) {
+	subject := &Subject{CustomerId: "alice"}
+	voucher := bson.M{
+		"customerid": "",
+		"redemptions": []interface{}{
+			bson.M{"customerid": "alice", "amount": 10.0},
+			bson.M{"customerid": "bob", "amount": 20.0},
+		},
+	}
+	update, err := pseudonymizeVoucher(subject, "erased-1", voucher)
+	assert.NoError(t, err)
+	set := update["$set"].(bson.M)
+	_, ok := set["customerid"]
+	assert.False(t, ok)
+	redemptions := set["redemptions"].([]interface{})
+	assert.Equal(t, "erased-1", redemptions[0].(bson.M)["customerid"])
+	assert.Equal(t, "bob", redemptions[1].(bson.M)["customerid"])
+
+	priceRule := bson.M{"usagehistory": bson.M{"usagespercustomer": bson.M{"alice": 1, "bob": 2}}}
+	update, err = pseudonymizePriceRuleUsage(subject, "erased-1", priceRule)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$rename": bson.M{"usagehistory.usagespercustomer.alice": "usagehistory.usagespercustomer.erased-1"}}, update)
+	for _, customerId := range []string{"alice.smith", "$alice"} {
+		_, err = pseudonymizePriceRuleUsage(&Subject{CustomerId: customerId}, "erased-1", priceRule)
+		assert.Error(t, err, customerId+" would rename a different field")
+	}
+
+	update, err = pseudonymizeMergeRecord(subject, "erased-1", bson.M{"guestcustomerid": "alice", "targetcustomerid": "carol"})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$set": bson.M{"guestcustomerid": "erased-1"}}, update)
+}
+
+func TestDefaultErasureTargetsHaveUniqueNames(t *testing.T) {
+	names := map[string]bool{}
+	for _, target := range DefaultErasureTargets() {
+		assert.False(t, names[target.GetName()], target.GetName())
+		names[target.GetName()] = true
+	}
+}
+
+func getDefaultErasureTarget(t *testing.T, name string) ErasureTarget {
+	for _, target := range DefaultErasureTargets() {
+		if target.GetName() == name {
+			return target
+		}
+	}
+	t.Fatal("no erasure target " + name)
+	return nil
+}
+
+func TestMongoErasureTarget(t *testing.T) {
+	subject := &Subject{CustomerId: "alice", Email: "Alice@Example.com"}
+
+	// documents are pseudonymized
+	session, collection := order.GetOrderPersistor().GetCollection()
+	defer session.Close()
+	assert.NoError(t, collection.DropCollection(), "clean up")
+	aliceData := &order.CustomerData{
+		CustomerId:     "alice",
+		Email:          "alice@example.com",
+		BillingAddress: &address.Address{Id: "billing", Person: &address.Person{FirstName: "Alice"}, Street: "Bahnhofstrasse", CountryCode: "CH"},
+	}
+	assert.NoError(t, collection.Insert(
+		bson.M{"id": "order-1", "customerdata": aliceData},
+		bson.M{"id": "order-2", "customerdata": &order.CustomerData{GuestCustomerID: "alice"}},
+		bson.M{"id": "order-3", "customerdata": &order.CustomerData{CustomerId: "bob", Email: "bob@example.com"}},
+	))
+	n, err := getDefaultErasureTarget(t, "orders").Erase(subject, "erased-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	erased := &order.Order{}
+	assert.NoError(t, collection.Find(bson.M{"id": "order-1"}).One(erased))
+	assert.Equal(t, "erased-1", erased.CustomerData.CustomerId)
+	assert.Equal(t, "", erased.CustomerData.Email)
+	assert.Equal(t, "", erased.CustomerData.BillingAddress.Street)
+	assert.Equal(t, "CH", erased.CustomerData.BillingAddress.CountryCode)
+	assert.NoError(t, collection.Find(bson.M{"id": "order-2"}).One(erased))
+	assert.Equal(t, "erased-1", erased.CustomerData.GuestCustomerID)
+	assert.NoError(t, collection.Find(bson.M{"id": "order-3"}).One(erased))
+	assert.Equal(t, "bob", erased.CustomerData.CustomerId, "other customers are not changed")
+	assert.Equal(t, "bob@example.com", erased.CustomerData.Email)
+
+	// documents are removed
+	credentialsSession, credentials := customer.GetCredentialsPersistor().GetCollection()
+	defer credentialsSession.Close()
+	assert.NoError(t, credentials.DropCollection(), "clean up")
+	assert.NoError(t, credentials.Insert(
+		bson.M{"customerid": "alice", "email": "alice@example.com"},
+		bson.M{"customerid": "bob", "email": "bob@example.com"},
+	))
+	n, err = getDefaultErasureTarget(t, "customer_credentials").Erase(subject, "erased-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	count, err := credentials.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// erasure is repeatable
+	n, err = getDefaultErasureTarget(t, "customer_credentials").Erase(subject, "erased-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestMongoErasureRecordStore(t *testing.T) {
+	session, collection := GetErasureRecordPersistor().GetCollection()
+	defer session.Close()
+
+	assert.NoError(t, collection.DropCollection(), "clean up")
+	for _, index := range erasureRecordEnsuredIndexes {
+		assert.NoError(t, collection.EnsureIndex(index))
+	}
+	keys, cleanup := newTestKeyProvider(t)
+	defer cleanup()
+
+	store := NewMongoErasureRecordStore()
+	_, err := store.GetLatestErasureRecord()
+	assert.Equal(t, ErrorErasureRecordNotFound, err)
+
+	eraser := NewEraser(store, keys, &testErasureTarget{name: "orders", records: 2})
+	first, err := eraser.Erase(&Subject{CustomerId: "alice"})
+	assert.NoError(t, err)
+	second, err := eraser.Erase(&Subject{CustomerId: "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, first.Hash, second.PreviousHash)
+
+	latest, err := store.GetLatestErasureRecord()
+	assert.NoError(t, err)
+	assert.Equal(t, second.Hash, latest.Hash)
+	assert.Equal(t, ErrorErasureRecordExists, store.InsertErasureRecord(&ErasureRecord{Id: "other", Sequence: 2}), "the sequence is unique")
+
+	// records read from mongo still match their hashes
+	records, err := store.GetErasureRecords()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.NoError(t, VerifyErasureRecords(keys, records, second.GetChainHead()))
+}
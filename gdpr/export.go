@@ -0,0 +1,143 @@
+package gdpr
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const ManifestFile = "manifest.json"
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Subject identifies the customer whose data is requested. Records matching any of the non-empty fields belong to it.
+type Subject struct {
+	CustomerId string
+	AddrKey    string
+	Email      string
+}
+
+// Source collects the records of a subject from one collection
+type Source interface {
+	// GetName returns the unique name of the source, which is used as file name in the archive
+	GetName() string
+	// GetCollection returns the name of the collection the records are read from
+	GetCollection() string
+	// Collect returns all records of subject
+	Collect(subject *Subject) ([]interface{}, error)
+}
+
+// Manifest describes the content of an export archive
+type Manifest struct {
+	Subject   *Subject
+	CreatedAt time.Time
+	Sources   []*ManifestEntry
+}
+
+// ManifestEntry describes the file of a source in an export archive
+type ManifestEntry struct {
+	Name       string
+	Collection string
+	File       string
+	Records    int
+}
+
+// Exporter writes all records of a subject to a zip archive
+type Exporter struct {
+	Sources []Source
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewExporter constructor, e.g. NewExporter(DefaultSources()...)
+func NewExporter(sources ...Source) *Exporter {
+	return &Exporter{
+		Sources: sources,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// Validate returns an error, if subject cannot be identified
+func (subject *Subject) Validate() error {
+	if subject == nil || (subject.CustomerId == "" && subject.AddrKey == "" && subject.Email == "") {
+		return errors.New("subject requires a customer id, addrKey or email")
+	}
+	return nil
+}
+
+// Export writes a zip archive with one json file per source and the manifest to w.
+// Nothing is written, if a source fails, so that an incomplete export is not mistaken for a complete one.
+func (e *Exporter) Export(subject *Subject, w io.Writer) (*Manifest, error) {
+	if err := subject.Validate(); err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		Subject:   subject,
+		CreatedAt: utils.TimeNow(),
+		Sources:   []*ManifestEntry{},
+	}
+	files := map[string][]interface{}{}
+	for _, source := range e.Sources {
+		file := source.GetName() + ".json"
+		if _, ok := files[file]; ok {
+			return nil, errors.New("duplicate source " + source.GetName())
+		}
+		records, err := source.Collect(subject)
+		if err != nil {
+			return nil, errors.New("source " + source.GetName() + ": " + err.Error())
+		}
+		if records == nil {
+			records = []interface{}{}
+		}
+		files[file] = records
+		manifest.Sources = append(manifest.Sources, &ManifestEntry{
+			Name:       source.GetName(),
+			Collection: source.GetCollection(),
+			File:       file,
+			Records:    len(records),
+		})
+	}
+
+	archive := zip.NewWriter(w)
+	if err := writeJSON(archive, ManifestFile, manifest); err != nil {
+		return nil, err
+	}
+	for _, entry := range manifest.Sources {
+		if err := writeJSON(archive, entry.File, files[entry.File]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func writeJSON(archive *zip.Writer, name string, data interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
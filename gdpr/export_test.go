@@ -0,0 +1,152 @@
+package gdpr
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/version"
+)
+
+type testSource struct {
+	name    string
+	records []interface{}
+	err     error
+}
+
+func (s *testSource) GetName() string       { return s.name }
+func (s *testSource) GetCollection() string { return "collection_" + s.name }
+func (s *testSource) Collect(subject *Subject) ([]interface{}, error) {
+	return s.records, s.err
+}
+
+func readArchive(t *testing.T, data []byte) map[string][]byte {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	files := map[string][]byte{}
+	for _, file := range reader.File {
+		r, err := file.Open()
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		r.Close()
+		files[file.Name] = content
+	}
+	return files
+}
+
+func TestExport(t *testing.T) {
+	subject := &Subject{CustomerId: "customer", Email: "alice@example.com"}
+	exporter := NewExporter(
+		&testSource{name: "customers", records: []interface{}{bson.M{"id": "customer"}}},
+		&testSource{name: "orders", records: []interface{}{bson.M{"id": "order-1"}, bson.M{"id": "order-2"}}},
+		&testSource{name: "watchlists"},
+	)
+	buf := &bytes.Buffer{}
+	manifest, err := exporter.Export(subject, buf)
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Sources, 3)
+	assert.Equal(t, "collection_orders", manifest.Sources[1].Collection)
+	assert.Equal(t, 2, manifest.Sources[1].Records)
+
+	files := readArchive(t, buf.Bytes())
+	assert.Len(t, files, 4)
+	archived := &Manifest{}
+	assert.NoError(t, json.Unmarshal(files[ManifestFile], archived))
+	assert.Equal(t, "customer", archived.Subject.CustomerId)
+	assert.Equal(t, "orders.json", archived.Sources[1].File)
+
+	orders := []map[string]string{}
+	assert.NoError(t, json.Unmarshal(files["orders.json"], &orders))
+	assert.Equal(t, "order-2", orders[1]["id"])
+	watchLists := []interface{}{}
+	assert.NoError(t, json.Unmarshal(files["watchlists.json"], &watchLists))
+	assert.NotNil(t, watchLists)
+	assert.Len(t, watchLists, 0)
+}
+
+func TestExportErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	_, err := NewExporter().Export(&Subject{}, buf)
+	assert.Error(t, err, "subject cannot be identified")
+
+	_, err = NewExporter(&testSource{name: "customers"}, &testSource{name: "customers"}).Export(&Subject{CustomerId: "customer"}, buf)
+	assert.Error(t, err, "duplicate source")
+
+	_, err = NewExporter(&testSource{name: "customers"}, &testSource{name: "orders", err: errors.New("failed")}).Export(&Subject{CustomerId: "customer"}, buf)
+	assert.Error(t, err)
+	assert.Equal(t, 0, buf.Len(), "nothing is written if a source fails")
+}
+
+func TestOrQuery(t *testing.T) {
+	assert.Nil(t, orQuery("id", "", "email", ""))
+	assert.Equal(t, bson.M{"$or": []bson.M{{"email": "alice@example.com"}}}, orQuery("id", "", "email", "alice@example.com"))
+	assert.Nil(t, priceRuleUsageQuery(&Subject{Email: "alice@example.com"}))
+}
+
+func TestCredentialsQuery(t *testing.T) {
+	assert.Equal(t, bson.M{"$or": []bson.M{{"customerid": "customer"}, {"email": "alice@example.com"}}}, credentialsQuery(&Subject{CustomerId: "customer", Email: "Alice@Example.com"}))
+	assert.Nil(t, credentialsQuery(&Subject{AddrKey: "addrkey"}))
+}
+
+func TestOrderQuery(t *testing.T) {
+	assert.Equal(t, bson.M{"$or": []bson.M{
+		{"customerdata.customerid": "customer"},
+		{"customerdata.guestcustomerid": "customer"},
+		{"customerdata.email": "alice@example.com"},
+	}}, orderQuery(&Subject{CustomerId: "customer", Email: "Alice@Example.com"}))
+}
+
+func TestFilterVoucherRedemptions(t *testing.T) {
+	subject := &Subject{CustomerId: "customer"}
+	voucher := bson.M{
+		"id":             "voucher",
+		"redeemedamount": 30.0,
+		"redemptions": []interface{}{
+			bson.M{"customerid": "customer", "amount": 10.0},
+			bson.M{"customerid": "other", "amount": 20.0},
+		},
+	}
+	filterVoucherRedemptions(subject, voucher)
+	assert.Len(t, voucher["redemptions"], 1)
+	_, ok := voucher["redeemedamount"]
+	assert.False(t, ok, "the total of an anonymous voucher includes other customers")
+
+	personal := bson.M{"customerid": "customer", "redeemedamount": 10.0, "redemptions": []interface{}{bson.M{"customerid": "customer"}}}
+	filterVoucherRedemptions(subject, personal)
+	assert.Equal(t, 10.0, personal["redeemedamount"])
+}
+
+func TestDecodeOrderHistoryPatch(t *testing.T) {
+	patch := []*version.PatchOperation{
+		{Op: "replace", Path: "/CustomerData/Email", Value: "alice@example.com"},
+		{Op: "remove", Path: "/Positions/1"},
+	}
+	data, _ := json.Marshal(patch)
+	delta := bson.M{"id": "order-1", "delta": bson.M{"baseversion": 1, "patch": string(data)}}
+	assert.NoError(t, decodeOrderHistoryPatch(delta))
+	decoded := delta["delta"].(bson.M)["patch"].([]*version.PatchOperation)
+	assert.Len(t, decoded, 2)
+	assert.Equal(t, "/CustomerData/Email", decoded[0].Path)
+	assert.Equal(t, "alice@example.com", decoded[0].Value)
+
+	fullCopy := bson.M{"id": "order-1", "customerdata": bson.M{"email": "alice@example.com"}}
+	assert.NoError(t, decodeOrderHistoryPatch(fullCopy))
+	assert.Equal(t, bson.M{"id": "order-1", "customerdata": bson.M{"email": "alice@example.com"}}, fullCopy)
+
+	assert.Error(t, decodeOrderHistoryPatch(bson.M{"delta": bson.M{"patch": "not json"}}))
+}
+
+func TestDefaultSourcesHaveUniqueNames(t *testing.T) {
+	names := map[string]bool{}
+	for _, source := range DefaultSources() {
+		assert.False(t, names[source.GetName()], source.GetName())
+		names[source.GetName()] = true
+	}
+}
@@ -0,0 +1,326 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/balance"
+	"github.com/foomo/shop/crypto"
+	"github.com/foomo/shop/customer"
+	"github.com/foomo/shop/merge"
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/persistence"
+	"github.com/foomo/shop/pricerule"
+	"github.com/foomo/shop/version"
+	"github.com/foomo/shop/watchlist"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// MongoSource collects the documents of a collection
+type MongoSource struct {
+	Name      string
+	Persistor func() *persistence.Persistor
+	// Query returns the query for the documents of subject, nil if there can be none
+	Query func(subject *Subject) bson.M
+	// Selection optionally returns a projection, e.g. to exclude password hashes or data of other customers
+	Selection func(subject *Subject) bson.M
+	// Filter optionally removes data of other customers from a document, which cannot be excluded by Selection
+	Filter func(subject *Subject, document bson.M)
+}
+
+// OrderHistorySource collects all versions of the orders of a subject from orders_history.
+// Only full copies contain customer data, deltas are collected by the ids of their orders.
+type OrderHistorySource struct{}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// DefaultSources returns the sources of all collections with customer data
+func DefaultSources() []Source {
+	return []Source{
+		&MongoSource{
+			Name:      "customers",
+			Persistor: customer.GetCustomerPersistor,
+			Query:     customerQuery,
+		},
+		&MongoSource{
+			Name:      "customer_credentials",
+			Persistor: customer.GetCredentialsPersistor,
+			Query: func(subject *Subject) bson.M {
+				return credentialsQuery(subject)
+			},
+			Selection: func(subject *Subject) bson.M {
+				return bson.M{"crypto": 0}
+			},
+		},
+		&MongoSource{
+			Name:      "customer_tokens",
+			Persistor: customer.GetTokenPersistor,
+			Query: func(subject *Subject) bson.M {
+				return credentialsQuery(subject)
+			},
+			// the id is the hash of the token
+			Selection: func(subject *Subject) bson.M {
+				return bson.M{"id": 0}
+			},
+		},
+		&MongoSource{
+			Name:      "orders",
+			Persistor: order.GetOrderPersistor,
+			Query:     orderQuery,
+		},
+		&OrderHistorySource{},
+		&MongoSource{
+			Name:      "watchlists",
+			Persistor: watchlist.GetWatchListPersistor,
+			Query: func(subject *Subject) bson.M {
+				return orQuery(watchlist.KeyAddrkey, subject.AddrKey)
+			},
+		},
+		&MongoSource{
+			Name:      "customer_merges",
+			Persistor: merge.GetRecordPersistor,
+			Query: func(subject *Subject) bson.M {
+				return orQuery("guestcustomerid", subject.CustomerId, "targetcustomerid", subject.CustomerId)
+			},
+		},
+		&MongoSource{
+			Name: "pricerule_usages",
+			Persistor: func() *persistence.Persistor {
+				return pricerule.GetPersistorForObject(&pricerule.PriceRule{})
+			},
+			Query:     priceRuleUsageQuery,
+			Selection: priceRuleUsageSelection,
+		},
+		&MongoSource{
+			Name: "pricerule_vouchers",
+			Persistor: func() *persistence.Persistor {
+				return pricerule.GetPersistorForObject(&pricerule.Voucher{})
+			},
+			Query: func(subject *Subject) bson.M {
+				return orQuery("customerid", subject.CustomerId, "redemptions.customerid", subject.CustomerId)
+			},
+			Filter: filterVoucherRedemptions,
+		},
+		&MongoSource{
+			Name:      "balance_accounts",
+			Persistor: balance.GetAccountPersistor,
+			Query: func(subject *Subject) bson.M {
+				return orQuery("customerid", subject.CustomerId)
+			},
+		},
+	}
+}
+
+func (s *MongoSource) GetName() string {
+	return s.Name
+}
+
+func (s *MongoSource) GetCollection() string {
+	return s.Persistor().GetCollectionName()
+}
+
+func (s *MongoSource) Collect(subject *Subject) ([]interface{}, error) {
+	records := []interface{}{}
+	query := s.Query(subject)
+	if query == nil {
+		return records, nil
+	}
+	session, collection := s.Persistor().GetCollection()
+	defer session.Close()
+	q := collection.Find(query).Sort("_id")
+	if s.Selection != nil {
+		q = q.Select(s.Selection(subject))
+	}
+	documents := []bson.M{}
+	if err := q.All(&documents); err != nil {
+		return nil, err
+	}
+	for _, document := range documents {
+		if err := crypto.DecryptDocument(document); err != nil {
+			return nil, err
+		}
+		if s.Filter != nil {
+			s.Filter(subject, document)
+		}
+		records = append(records, document)
+	}
+	return records, nil
+}
+
+func (s *OrderHistorySource) GetName() string {
+	return "orders_history"
+}
+
+func (s *OrderHistorySource) GetCollection() string {
+	return order.GetOrderVersionsPersistor().GetCollectionName()
+}
+
+// Collect returns full copies and deltas with decrypted and decoded patches
+func (s *OrderHistorySource) Collect(subject *Subject) ([]interface{}, error) {
+	records := []interface{}{}
+	session, collection := order.GetOrderVersionsPersistor().GetCollection()
+	defer session.Close()
+
+	orderIds, err := getOrderHistoryIds(collection, subject)
+	if err != nil || len(orderIds) == 0 {
+		return records, err
+	}
+	documents := []bson.M{}
+	if err := collection.Find(bson.M{"id": bson.M{"$in": orderIds}}).Sort("_id").All(&documents); err != nil {
+		return nil, err
+	}
+	for _, document := range documents {
+		if err := crypto.DecryptDocument(document); err != nil {
+			return nil, err
+		}
+		if err := decodeOrderHistoryPatch(document); err != nil {
+			return nil, err
+		}
+		records = append(records, document)
+	}
+	return records, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// getOrderHistoryIds returns the ids of the orders of subject in orders_history.
+// Deltas do not contain the customer data, the orders are identified by their full copies.
+func getOrderHistoryIds(collection *mgo.Collection, subject *Subject) ([]string, error) {
+	query := orderQuery(subject)
+	if query == nil {
+		return nil, nil
+	}
+	var orderIds []string
+	if err := collection.Find(query).Distinct("id", &orderIds); err != nil {
+		return nil, err
+	}
+	return orderIds, nil
+}
+
+// decodeOrderHistoryPatch replaces the decrypted JSON patch of a delta by its operations
+func decodeOrderHistoryPatch(document bson.M) error {
+	delta, ok := document["delta"].(bson.M)
+	if !ok {
+		return nil
+	}
+	patchJSON, _ := delta["patch"].(string)
+	if patchJSON == "" {
+		return nil
+	}
+	patch := []*version.PatchOperation{}
+	if err := json.Unmarshal([]byte(patchJSON), &patch); err != nil {
+		return err
+	}
+	delta["patch"] = patch
+	return nil
+}
+
+// orQuery returns a query matching any of the key value pairs with a non-empty value, nil if all values are empty
+func orQuery(keyValues ...string) bson.M {
+	conditions := []bson.M{}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		if keyValues[i+1] != "" {
+			conditions = append(conditions, bson.M{keyValues[i]: keyValues[i+1]})
+		}
+	}
+	return anyOf(conditions...)
+}
+
+// encryptedOrQuery is orQuery for fields which may be encrypted, they are matched by their blind index
+func encryptedOrQuery(keyValues ...string) bson.M {
+	conditions := []bson.M{}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		if keyValues[i+1] != "" {
+			conditions = append(conditions, crypto.EqualityQuery(keyValues[i], keyValues[i+1]))
+		}
+	}
+	return anyOf(conditions...)
+}
+
+// anyOf returns a query matching any of the non-nil queries, nil if there are none
+func anyOf(queries ...bson.M) bson.M {
+	conditions := []bson.M{}
+	for _, query := range queries {
+		if query == nil {
+			continue
+		}
+		if or, ok := query["$or"].([]bson.M); ok && len(query) == 1 {
+			conditions = append(conditions, or...)
+		} else {
+			conditions = append(conditions, query)
+		}
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	return bson.M{"$or": conditions}
+}
+
+func customerQuery(subject *Subject) bson.M {
+	return anyOf(
+		orQuery("id", subject.CustomerId),
+		encryptedOrQuery(customer.KeyAddrKey, subject.AddrKey, customer.KeyEmail, strings.ToLower(subject.Email)),
+	)
+}
+
+// credentialsQuery returns the query for the credentials and tokens of subject, their emails may be encrypted
+func credentialsQuery(subject *Subject) bson.M {
+	return anyOf(
+		orQuery("customerid", subject.CustomerId),
+		encryptedOrQuery(customer.KeyEmail, strings.ToLower(subject.Email)),
+	)
+}
+
+func orderQuery(subject *Subject) bson.M {
+	return anyOf(
+		orQuery("customerdata.customerid", subject.CustomerId, "customerdata.guestcustomerid", subject.CustomerId),
+		encryptedOrQuery("customerdata."+order.KeyAddrKey, subject.AddrKey, "customerdata.email", strings.ToLower(subject.Email)),
+	)
+}
+
+// price rules contain the usages of all customers, only those of the subject are exported
+func priceRuleUsageQuery(subject *Subject) bson.M {
+	if subject.CustomerId == "" {
+		return nil
+	}
+	return bson.M{"usagehistory.usagespercustomer." + subject.CustomerId: bson.M{"$exists": true}}
+}
+
+func priceRuleUsageSelection(subject *Subject) bson.M {
+	return bson.M{
+		"id":   1,
+		"name": 1,
+		"type": 1,
+		"usagehistory.usagespercustomer." + subject.CustomerId:         1,
+		"usagehistory.redeemedamountpercustomer." + subject.CustomerId: 1,
+	}
+}
+
+// filterVoucherRedemptions removes the redemptions of other customers from anonymous vouchers
+func filterVoucherRedemptions(subject *Subject, document bson.M) {
+	redemptions, ok := document["redemptions"].([]interface{})
+	if !ok {
+		return
+	}
+	filtered := []interface{}{}
+	for _, redemption := range redemptions {
+		if r, ok := redemption.(bson.M); ok && r["customerid"] == subject.CustomerId {
+			filtered = append(filtered, redemption)
+		}
+	}
+	document["redemptions"] = filtered
+	if document["customerid"] != subject.CustomerId {
+		// the redeemed amount of an anonymous voucher includes redemptions of others
+		delete(document, "redeemedamount")
+	}
+}
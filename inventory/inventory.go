@@ -0,0 +1,363 @@
+package inventory
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/unique"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// DefaultReservationTTL is the time a cart holds its reservations, unless they are extended
+const DefaultReservationTTL = 15 * time.Minute
+
+// maxUpdateRetries limits the retries of a reservation update, if the reservation changes concurrently
+const maxUpdateRetries = 5
+
+var ErrorReservationExpired = errors.New("reservation expired")
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Inventory reserves stock for carts, allocates it to orders on confirmation and releases it on cancellation or expiry.
+// A reservation moves stock first and changes its status afterwards. If the reservation cannot be stored, the stock is moved back,
+// so that the stock levels always match the stored reservations.
+// Inventory implements order.StockReleaser.
+type Inventory struct {
+	store Store
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewInventory constructor. If store is nil, stock is stored in mongo. If ttl is zero, DefaultReservationTTL is used.
+func NewInventory(store Store, ttl time.Duration) *Inventory {
+	if store == nil {
+		store = NewMongoStore()
+	}
+	if ttl <= 0 {
+		ttl = DefaultReservationTTL
+	}
+	return &Inventory{
+		store: store,
+		ttl:   ttl,
+		now:   time.Now,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// AddStock adds quantity to the stock of sku in warehouse, e.g. on goods receipt
+func (inv *Inventory) AddStock(sku string, warehouse string, quantity float64) (*StockLevel, error) {
+	if quantity <= 0 {
+		return nil, errors.New("quantity must be positive")
+	}
+	return inv.store.MoveStock(sku, warehouse, Move{OnHand: quantity, Available: quantity}, inv.now())
+}
+
+// RemoveStock removes quantity from the available stock of sku in warehouse, e.g. for damaged goods
+func (inv *Inventory) RemoveStock(sku string, warehouse string, quantity float64) (*StockLevel, error) {
+	if quantity <= 0 {
+		return nil, errors.New("quantity must be positive")
+	}
+	return inv.store.MoveStock(sku, warehouse, Move{OnHand: -quantity, Available: -quantity}, inv.now())
+}
+
+// GetStockLevel returns the stock level of sku in warehouse
+func (inv *Inventory) GetStockLevel(sku string, warehouse string) (*StockLevel, error) {
+	return inv.store.GetStockLevel(sku, warehouse)
+}
+
+// GetStockLevels returns the stock levels of sku in all warehouses
+func (inv *Inventory) GetStockLevels(sku string) ([]*StockLevel, error) {
+	return inv.store.GetStockLevels(sku)
+}
+
+// GetAvailable returns the available stock of sku in all warehouses
+func (inv *Inventory) GetAvailable(sku string) (float64, error) {
+	stockLevels, err := inv.store.GetStockLevels(sku)
+	if err != nil {
+		return 0, err
+	}
+	available := 0.0
+	for _, stockLevel := range stockLevels {
+		available += stockLevel.Available
+	}
+	return available, nil
+}
+
+// Reserve holds quantity of sku in warehouse for cartId. It fails with ErrorInsufficientStock.
+func (inv *Inventory) Reserve(cartId string, sku string, warehouse string, quantity float64) (*Reservation, error) {
+	if cartId == "" {
+		return nil, errors.New("reservation requires a cart id")
+	}
+	if quantity <= 0 {
+		return nil, errors.New("quantity must be positive")
+	}
+	now := inv.now()
+	_, err := inv.store.MoveStock(sku, warehouse, Move{Available: -quantity, Reserved: quantity}, now)
+	if err != nil {
+		return nil, err
+	}
+	reservation := &Reservation{
+		Id:             unique.GetNewID(),
+		CartId:         cartId,
+		SKU:            sku,
+		Warehouse:      warehouse,
+		Quantity:       quantity,
+		Status:         ReservationStatusReserved,
+		ExpiresAt:      now.Add(inv.ttl),
+		CreatedAt:      now,
+		LastModifiedAt: now,
+	}
+	if err := inv.store.InsertReservation(reservation); err != nil {
+		// give the stock back, the reservation does not exist
+		if _, errMove := inv.store.MoveStock(sku, warehouse, Move{Available: quantity, Reserved: -quantity}, now); errMove != nil {
+			return nil, multierror.Append(err, errMove)
+		}
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// GetReservations returns all reservations of cartId
+func (inv *Inventory) GetReservations(cartId string) ([]*Reservation, error) {
+	return inv.store.FindReservations(&ReservationQuery{CartId: cartId})
+}
+
+// GetAllocations returns all reservations of orderId
+func (inv *Inventory) GetAllocations(orderId string) ([]*Reservation, error) {
+	return inv.store.FindReservations(&ReservationQuery{OrderId: orderId})
+}
+
+// ExtendReservations renews the expiry of all reservations of cartId, e.g. when the cart is changed
+func (inv *Inventory) ExtendReservations(cartId string) error {
+	return inv.forEachReservation(&ReservationQuery{CartId: cartId, Status: ReservationStatusReserved}, func(reservation *Reservation) error {
+		_, err := inv.update(reservation.Id, func(r *Reservation, now time.Time) (*Move, error) {
+			if r.Status != ReservationStatusReserved {
+				return nil, nil
+			}
+			if r.IsExpired(now) {
+				return nil, ErrorReservationExpired
+			}
+			r.ExpiresAt = now.Add(inv.ttl)
+			return nil, nil
+		})
+		return err
+	})
+}
+
+// ReleaseReservation gives the stock of a reserved reservation back. Releasing a reservation which is not reserved is a no-op.
+func (inv *Inventory) ReleaseReservation(id string) error {
+	_, err := inv.update(id, func(r *Reservation, now time.Time) (*Move, error) {
+		if r.Status != ReservationStatusReserved {
+			return nil, nil
+		}
+		r.Status = ReservationStatusReleased
+		return &Move{Available: r.Quantity, Reserved: -r.Quantity}, nil
+	})
+	return err
+}
+
+// ReleaseCart releases all reserved reservations of cartId, e.g. when the cart is emptied
+func (inv *Inventory) ReleaseCart(cartId string) error {
+	return inv.forEachReservation(&ReservationQuery{CartId: cartId, Status: ReservationStatusReserved}, func(reservation *Reservation) error {
+		return inv.ReleaseReservation(reservation.Id)
+	})
+}
+
+// CommitCart converts all reservations of cartId into allocations of orderId, e.g. on order confirmation.
+// Expired reservations cannot be committed, all other reservations are committed nevertheless.
+// The committed reservations are returned, callers should check them against the positions of the order.
+func (inv *Inventory) CommitCart(cartId string, orderId string) ([]*Reservation, error) {
+	if orderId == "" {
+		return nil, errors.New("commit requires an order id")
+	}
+	committed := []*Reservation{}
+	err := inv.forEachReservation(&ReservationQuery{CartId: cartId, Status: ReservationStatusReserved}, func(reservation *Reservation) error {
+		reservation, err := inv.update(reservation.Id, func(r *Reservation, now time.Time) (*Move, error) {
+			if r.Status != ReservationStatusReserved {
+				return nil, errors.New("reservation " + r.Id + " is " + string(r.Status))
+			}
+			if r.IsExpired(now) {
+				return nil, ErrorReservationExpired
+			}
+			r.Status = ReservationStatusCommitted
+			r.OrderId = orderId
+			r.ExpiresAt = time.Time{}
+			return &Move{Reserved: -r.Quantity, Allocated: r.Quantity}, nil
+		})
+		if err == nil {
+			committed = append(committed, reservation)
+		}
+		return err
+	})
+	return committed, err
+}
+
+// CommitOrder commits the reservations of the cart of o to o
+func (inv *Inventory) CommitOrder(o *order.Order) ([]*Reservation, error) {
+	return inv.CommitCart(o.CartId, o.GetID())
+}
+
+// ReleaseOrder releases all allocations of orderId, e.g. when the order is canceled
+func (inv *Inventory) ReleaseOrder(orderId string) error {
+	return inv.forEachReservation(&ReservationQuery{OrderId: orderId, Status: ReservationStatusCommitted}, func(reservation *Reservation) error {
+		_, err := inv.releaseAllocation(reservation.Id, math.MaxFloat64, "")
+		return err
+	})
+}
+
+// ReleaseOrderItem releases quantity of sku from the allocations of orderId, e.g. on a partial cancellation.
+// If less is allocated, the allocated quantity is released. The released quantities are recorded with releaseId
+// in the allocations, a repeated call with the same releaseId only releases what has not been released yet.
+func (inv *Inventory) ReleaseOrderItem(orderId string, sku string, quantity float64, releaseId string) error {
+	if releaseId == "" {
+		return errors.New("release requires a release id")
+	}
+	// released allocations are included, they may contain the quantities of a previous call
+	allocations, err := inv.store.FindReservations(&ReservationQuery{OrderId: orderId, SKU: sku})
+	if err != nil {
+		return err
+	}
+	for _, allocation := range allocations {
+		if quantity <= quantityEpsilon {
+			break
+		}
+		released, err := inv.releaseAllocation(allocation.Id, quantity, releaseId)
+		if err != nil {
+			return err
+		}
+		quantity -= released
+	}
+	return nil
+}
+
+// ReleaseStock implements order.StockReleaser, the id of the cancellation is the release id
+func (inv *Inventory) ReleaseStock(o *order.Order, cancellationId string, itemID string, quantity float64) error {
+	return inv.ReleaseOrderItem(o.GetID(), itemID, quantity, cancellationId)
+}
+
+// ExpireReservations releases all reservations whose expiry has passed and returns their number
+func (inv *Inventory) ExpireReservations() (int, error) {
+	expired := 0
+	err := inv.forEachReservation(&ReservationQuery{Status: ReservationStatusReserved, ExpiresBefore: inv.now()}, func(reservation *Reservation) error {
+		isExpired := false
+		_, err := inv.update(reservation.Id, func(r *Reservation, now time.Time) (*Move, error) {
+			isExpired = r.IsExpired(now)
+			if !isExpired {
+				return nil, nil
+			}
+			r.Status = ReservationStatusExpired
+			return &Move{Available: r.Quantity, Reserved: -r.Quantity}, nil
+		})
+		if err == nil && isExpired {
+			expired++
+		}
+		return err
+	})
+	return expired, err
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// releaseAllocation releases up to quantity of a committed allocation and returns the released quantity.
+// If the allocation contains a release with releaseId, its quantity is returned without releasing stock again.
+func (inv *Inventory) releaseAllocation(id string, quantity float64, releaseId string) (float64, error) {
+	released := 0.0
+	_, err := inv.update(id, func(r *Reservation, now time.Time) (*Move, error) {
+		released = 0
+		if release := r.getRelease(releaseId); release != nil {
+			released = release.Quantity
+			return nil, nil
+		}
+		if r.Status != ReservationStatusCommitted {
+			return nil, nil
+		}
+		released = math.Min(quantity, r.Quantity)
+		r.Quantity -= released
+		r.ReleasedQuantity += released
+		if releaseId != "" {
+			r.Releases = append(r.Releases, &Release{Id: releaseId, Quantity: released})
+		}
+		if r.Quantity <= quantityEpsilon {
+			r.Quantity = 0
+			r.Status = ReservationStatusReleased
+		}
+		return &Move{Available: released, Allocated: -released}, nil
+	})
+	return released, err
+}
+
+// update loads the reservation with id, applies change and stores it. If change returns a move, the stock is moved
+// before the reservation is stored and moved back, if storing fails. Concurrent updates are retried, so change may be called more than once.
+func (inv *Inventory) update(id string, change func(r *Reservation, now time.Time) (*Move, error)) (*Reservation, error) {
+	for i := 0; i < maxUpdateRetries; i++ {
+		reservation, err := inv.store.GetReservation(id)
+		if err != nil {
+			return nil, err
+		}
+		now := inv.now()
+		status := reservation.Status
+		expiresAt := reservation.ExpiresAt
+		move, err := change(reservation, now)
+		if err != nil {
+			return nil, err
+		}
+		if move == nil && status == reservation.Status && expiresAt.Equal(reservation.ExpiresAt) {
+			// nothing changed
+			return reservation, nil
+		}
+		if move != nil {
+			if _, err := inv.store.MoveStock(reservation.SKU, reservation.Warehouse, *move, now); err != nil {
+				return nil, err
+			}
+		}
+		reservation.LastModifiedAt = now
+		err = inv.store.UpdateReservation(reservation)
+		if err != nil && move != nil {
+			// move the stock back, the stored reservation has not changed
+			if _, errMove := inv.store.MoveStock(reservation.SKU, reservation.Warehouse, move.inverse(), now); errMove != nil {
+				return nil, multierror.Append(err, errMove)
+			}
+		}
+		if err == ErrorReservationChanged {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return reservation, nil
+	}
+	return nil, ErrorReservationChanged
+}
+
+// forEachReservation calls f for every reservation matching query and collects the errors
+func (inv *Inventory) forEachReservation(query *ReservationQuery, f func(reservation *Reservation) error) error {
+	reservations, err := inv.store.FindReservations(query)
+	if err != nil {
+		return err
+	}
+	var result *multierror.Error
+	for _, reservation := range reservations {
+		if err := f(reservation); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
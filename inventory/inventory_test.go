@@ -0,0 +1,276 @@
+package inventory
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/order"
+)
+
+func newTestInventory(t *testing.T) (*Inventory, *time.Time) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	inv := NewInventory(NewMemoryStore(), 10*time.Minute)
+	inv.now = func() time.Time { return now }
+	_, err := inv.AddStock("shirt", "zurich", 5)
+	assert.NoError(t, err)
+	_, err = inv.AddStock("shirt", "basel", 2)
+	assert.NoError(t, err)
+	return inv, &now
+}
+
+func assertStockLevel(t *testing.T, inv *Inventory, warehouse string, available, reserved, allocated float64) {
+	stockLevel, err := inv.GetStockLevel("shirt", warehouse)
+	assert.NoError(t, err)
+	assert.Equal(t, available, stockLevel.Available, "available")
+	assert.Equal(t, reserved, stockLevel.Reserved, "reserved")
+	assert.Equal(t, allocated, stockLevel.Allocated, "allocated")
+	assert.Equal(t, stockLevel.OnHand, stockLevel.Available+stockLevel.Reserved+stockLevel.Allocated)
+}
+
+func TestInventoryReserveCommitCancel(t *testing.T) {
+	inv, _ := newTestInventory(t)
+
+	_, err := inv.Reserve("cart-1", "shirt", "zurich", 3)
+	assert.NoError(t, err)
+	_, err = inv.Reserve("cart-2", "shirt", "zurich", 3)
+	assert.Equal(t, ErrorInsufficientStock, err)
+	_, err = inv.Reserve("cart-2", "shirt", "geneva", 1)
+	assert.Equal(t, ErrorInsufficientStock, err, "unknown warehouse has no stock")
+	assertStockLevel(t, inv, "zurich", 2, 3, 0)
+
+	available, err := inv.GetAvailable("shirt")
+	assert.NoError(t, err)
+	assert.Equal(t, 4.0, available)
+
+	o := &order.Order{Id: "order-1", CartId: "cart-1", Flags: &order.Flags{}}
+	o.UnlinkFromDB()
+	committed, err := inv.CommitOrder(o)
+	assert.NoError(t, err)
+	assert.Len(t, committed, 1)
+	assertStockLevel(t, inv, "zurich", 2, 0, 3)
+
+	committed, err = inv.CommitOrder(o)
+	assert.NoError(t, err)
+	assert.Empty(t, committed, "commit is not repeated")
+
+	// partial cancellation releases stock through order.StockReleaser
+	var releaser order.StockReleaser = inv
+	assert.NoError(t, releaser.ReleaseStock(o, "cancellation-1", "shirt", 1))
+	assertStockLevel(t, inv, "zurich", 3, 0, 2)
+	assert.NoError(t, releaser.ReleaseStock(o, "cancellation-1", "shirt", 1), "resumed cancellation")
+	assertStockLevel(t, inv, "zurich", 3, 0, 2)
+	assert.Error(t, inv.ReleaseOrderItem("order-1", "shirt", 1, ""))
+
+	assert.NoError(t, inv.ReleaseOrder("order-1"))
+	assertStockLevel(t, inv, "zurich", 5, 0, 0)
+	allocations, err := inv.GetAllocations("order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, ReservationStatusReleased, allocations[0].Status)
+	assert.Equal(t, 3.0, allocations[0].ReleasedQuantity)
+
+	assert.NoError(t, inv.ReleaseOrder("order-1"), "release is not repeated")
+	assertStockLevel(t, inv, "zurich", 5, 0, 0)
+	assert.NoError(t, releaser.ReleaseStock(o, "cancellation-1", "shirt", 1), "released allocations keep their releases")
+	assertStockLevel(t, inv, "zurich", 5, 0, 0)
+	assert.Equal(t, []*Release{{Id: "cancellation-1", Quantity: 1}}, allocations[0].Releases)
+}
+
+func TestInventoryExpiry(t *testing.T) {
+	inv, now := newTestInventory(t)
+
+	_, err := inv.Reserve("cart-1", "shirt", "basel", 1)
+	assert.NoError(t, err)
+	reservation, err := inv.Reserve("cart-2", "shirt", "basel", 1)
+	assert.NoError(t, err)
+
+	*now = now.Add(8 * time.Minute)
+	assert.NoError(t, inv.ExtendReservations("cart-2"))
+	*now = now.Add(5 * time.Minute)
+
+	expired, err := inv.ExpireReservations()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assertStockLevel(t, inv, "basel", 1, 1, 0)
+
+	committed, err := inv.CommitCart("cart-1", "order-1")
+	assert.NoError(t, err)
+	assert.Empty(t, committed, "expired reservation cannot be committed")
+
+	*now = now.Add(10 * time.Minute)
+	_, err = inv.CommitCart("cart-2", "order-2")
+	assert.Error(t, err, "reservation expired before the sweep")
+	assert.NoError(t, inv.ReleaseReservation(reservation.Id))
+	assertStockLevel(t, inv, "basel", 2, 0, 0)
+
+	expired, err = inv.ExpireReservations()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+}
+
+func TestInventoryConcurrentReservations(t *testing.T) {
+	inv, _ := newTestInventory(t)
+
+	// 100 carts want the 5 shirts in zurich at the same time
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	reserved := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := inv.Reserve("cart-"+strconv.Itoa(i), "shirt", "zurich", 1); err == nil {
+				mutex.Lock()
+				reserved++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 5, reserved)
+	assertStockLevel(t, inv, "zurich", 0, 5, 0)
+
+	// committing and releasing concurrently never moves stock twice
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			inv.CommitCart("cart-"+strconv.Itoa(i), "order-"+strconv.Itoa(i))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			inv.ReleaseCart("cart-" + strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	stockLevel, err := inv.GetStockLevel("shirt", "zurich")
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, stockLevel.OnHand)
+	assert.Equal(t, 0.0, stockLevel.Reserved)
+	assert.Equal(t, 5.0, stockLevel.Available+stockLevel.Allocated)
+}
+
+// failingStore fails the next updates of reservations or moves of stock
+type failingStore struct {
+	*MemoryStore
+	failUpdates int
+	failMoves   int
+}
+
+func (s *failingStore) UpdateReservation(reservation *Reservation) error {
+	if s.failUpdates > 0 {
+		s.failUpdates--
+		return errors.New("update failed")
+	}
+	return s.MemoryStore.UpdateReservation(reservation)
+}
+
+func (s *failingStore) MoveStock(sku string, warehouse string, move Move, now time.Time) (*StockLevel, error) {
+	if s.failMoves > 0 {
+		s.failMoves--
+		return nil, errors.New("move failed")
+	}
+	return s.MemoryStore.MoveStock(sku, warehouse, move, now)
+}
+
+func TestInventoryUpdateFailure(t *testing.T) {
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	inv := NewInventory(store, 10*time.Minute)
+	_, err := inv.AddStock("shirt", "zurich", 5)
+	assert.NoError(t, err)
+	reservation, err := inv.Reserve("cart-1", "shirt", "zurich", 2)
+	assert.NoError(t, err)
+
+	// the stock is moved back, if the reservation cannot be stored
+	store.failUpdates = 1
+	assert.Error(t, inv.ReleaseReservation(reservation.Id))
+	assertStockLevel(t, inv, "zurich", 3, 2, 0)
+
+	// the reservation is unchanged, if the stock cannot be moved
+	store.failMoves = 1
+	_, err = inv.CommitCart("cart-1", "order-1")
+	assert.Error(t, err)
+	assertStockLevel(t, inv, "zurich", 3, 2, 0)
+	reservations, err := inv.GetReservations("cart-1")
+	assert.NoError(t, err)
+	assert.Equal(t, ReservationStatusReserved, reservations[0].Status)
+
+	committed, err := inv.CommitCart("cart-1", "order-1")
+	assert.NoError(t, err)
+	assert.Len(t, committed, 1)
+	assertStockLevel(t, inv, "zurich", 3, 0, 2)
+}
+
+func TestInventoryMongoStore(t *testing.T) {
+	stockSession, stock := GetStockPersistor().GetCollection()
+	defer stockSession.Close()
+	reservationSession, reservations := GetReservationPersistor().GetCollection()
+	defer reservationSession.Close()
+
+	assert.NoError(t, stock.DropCollection(), "clean up")
+	assert.NoError(t, reservations.DropCollection(), "clean up")
+	for _, index := range stockEnsuredIndexes {
+		assert.NoError(t, stock.EnsureIndex(index))
+	}
+	for _, index := range reservationEnsuredIndexes {
+		assert.NoError(t, reservations.EnsureIndex(index))
+	}
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	inv := NewInventory(NewMongoStore(), 10*time.Minute)
+	inv.now = func() time.Time { return now }
+	_, err := inv.AddStock("shirt", "zurich", 5)
+	assert.NoError(t, err)
+	_, err = inv.RemoveStock("shirt", "geneva", 1)
+	assert.Equal(t, ErrorInsufficientStock, err, "unknown warehouse has no stock")
+
+	// 100 carts want the 5 shirts in zurich at the same time
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	reserved := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := inv.Reserve("cart-"+strconv.Itoa(i), "shirt", "zurich", 1); err == nil {
+				mutex.Lock()
+				reserved++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 5, reserved)
+	assertStockLevel(t, inv, "zurich", 0, 5, 0)
+
+	// committing and releasing concurrently never moves stock twice
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			inv.CommitCart("cart-"+strconv.Itoa(i), "order-"+strconv.Itoa(i))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			inv.ReleaseCart("cart-" + strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	stockLevel, err := inv.GetStockLevel("shirt", "zurich")
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, stockLevel.OnHand)
+	assert.Equal(t, 0.0, stockLevel.Reserved)
+	assert.Equal(t, 5.0, stockLevel.Available+stockLevel.Allocated)
+
+	active, err := inv.store.FindReservations(&ReservationQuery{Status: ReservationStatusCommitted})
+	assert.NoError(t, err)
+	allocated := 0.0
+	for _, reservation := range active {
+		allocated += reservation.Quantity
+	}
+	assert.Equal(t, stockLevel.Allocated, allocated, "the stock level matches the stored reservations")
+}
@@ -0,0 +1,163 @@
+package inventory
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	ReservationStatusReserved  ReservationStatus = "reserved"  // held for a cart until it expires
+	ReservationStatusCommitted ReservationStatus = "committed" // allocated to a confirmed order
+	ReservationStatusReleased  ReservationStatus = "released"  // given back, e.g. cart emptied or order canceled
+	ReservationStatusExpired   ReservationStatus = "expired"   // given back, because the cart was abandoned
+)
+
+// quantityEpsilon is used to compare quantities
+const quantityEpsilon = 0.0001
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type ReservationStatus string
+
+// StockLevel is the stock of a SKU in a warehouse. OnHand = Available + Reserved + Allocated.
+type StockLevel struct {
+	BsonId         bson.ObjectId `bson:"_id,omitempty"`
+	Id             string
+	SKU            string
+	Warehouse      string
+	OnHand         float64 // physically in the warehouse
+	Available      float64 // can be reserved
+	Reserved       float64 // held for carts
+	Allocated      float64 // committed to confirmed orders
+	LastModifiedAt time.Time
+}
+
+// Move is a change of a stock level. Each field is added to the respective quantity.
+type Move struct {
+	OnHand    float64
+	Available float64
+	Reserved  float64
+	Allocated float64
+}
+
+// Reservation holds stock of a SKU in a warehouse for a cart and, once committed, for an order
+type Reservation struct {
+	BsonId           bson.ObjectId `bson:"_id,omitempty"`
+	Id               string
+	CartId           string
+	OrderId          string
+	SKU              string
+	Warehouse        string
+	Quantity         float64 // quantity currently held
+	ReleasedQuantity float64 // quantity released from a committed allocation, e.g. by a partial cancellation
+	Releases         []*Release
+	Status           ReservationStatus
+	ExpiresAt        time.Time // reserved status only
+	CreatedAt        time.Time
+	LastModifiedAt   time.Time
+	Version          int // incremented by every update, used for optimistic locking
+}
+
+// Release is a quantity released from a committed allocation by ReleaseOrderItem
+type Release struct {
+	Id       string // e.g. the id of a cancellation, a release with a known id is never applied twice
+	Quantity float64
+}
+
+// ReservationQuery selects reservations. Empty fields are ignored.
+type ReservationQuery struct {
+	CartId        string
+	OrderId       string
+	SKU           string
+	Status        ReservationStatus
+	ExpiresBefore time.Time
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// IsActive returns true, if the reservation holds stock
+func (r *Reservation) IsActive() bool {
+	return r.Status == ReservationStatusReserved || r.Status == ReservationStatusCommitted
+}
+
+// IsExpired returns true, if the reservation is reserved and has expired at now
+func (r *Reservation) IsExpired(now time.Time) bool {
+	return r.Status == ReservationStatusReserved && !now.Before(r.ExpiresAt)
+}
+
+// Matches returns true, if r is selected by query
+func (query *ReservationQuery) Matches(r *Reservation) bool {
+	switch {
+	case query.CartId != "" && r.CartId != query.CartId:
+		return false
+	case query.OrderId != "" && r.OrderId != query.OrderId:
+		return false
+	case query.SKU != "" && r.SKU != query.SKU:
+		return false
+	case query.Status != "" && r.Status != query.Status:
+		return false
+	case !query.ExpiresBefore.IsZero() && !r.ExpiresAt.Before(query.ExpiresBefore):
+		return false
+	}
+	return true
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// getRelease returns the release with id or nil
+func (r *Reservation) getRelease(id string) *Release {
+	if id == "" {
+		return nil
+	}
+	for _, release := range r.Releases {
+		if release.Id == id {
+			return release
+		}
+	}
+	return nil
+}
+
+// inverse returns the move, which undoes m
+func (m Move) inverse() Move {
+	return Move{
+		OnHand:    -m.OnHand,
+		Available: -m.Available,
+		Reserved:  -m.Reserved,
+		Allocated: -m.Allocated,
+	}
+}
+
+func (query *ReservationQuery) toBson() bson.M {
+	find := bson.M{}
+	if query.CartId != "" {
+		find["cartid"] = query.CartId
+	}
+	if query.OrderId != "" {
+		find["orderid"] = query.OrderId
+	}
+	if query.SKU != "" {
+		find["sku"] = query.SKU
+	}
+	if query.Status != "" {
+		find["status"] = query.Status
+	}
+	if !query.ExpiresBefore.IsZero() {
+		find["expiresat"] = bson.M{"$lt": query.ExpiresBefore}
+	}
+	return find
+}
+
+func stockLevelId(sku string, warehouse string) string {
+	return sku + "@" + warehouse
+}
@@ -0,0 +1,366 @@
+package inventory
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/configuration"
+	"github.com/foomo/shop/persistence"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var (
+	ErrorStockLevelNotFound  = errors.New("stock level not found")
+	ErrorInsufficientStock   = errors.New("insufficient stock")
+	ErrorReservationNotFound = errors.New("reservation not found")
+	ErrorReservationExists   = errors.New("reservation exists")
+	ErrorReservationChanged  = errors.New("reservation changed concurrently")
+)
+
+var (
+	globalStockPersistor       *persistence.Persistor
+	globalReservationPersistor *persistence.Persistor
+
+	stockEnsuredIndexes = []mgo.Index{
+		{
+			Name:   "id",
+			Key:    []string{"id"},
+			Unique: true,
+		},
+		{
+			Name:       "sku",
+			Key:        []string{"sku"},
+			Unique:     false,
+			Background: true,
+		},
+	}
+
+	reservationEnsuredIndexes = []mgo.Index{
+		{
+			Name:   "id",
+			Key:    []string{"id"},
+			Unique: true,
+		},
+		{
+			Name:       "cartid",
+			Key:        []string{"cartid"},
+			Unique:     false,
+			Background: true,
+		},
+		{
+			Name:       "orderid",
+			Key:        []string{"orderid"},
+			Unique:     false,
+			Background: true,
+		},
+		{
+			Name:       "status",
+			Key:        []string{"status", "expiresat"},
+			Unique:     false,
+			Background: true,
+		},
+	}
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Store persists stock levels and reservations. MoveStock must be atomic, it is the only way to change a stock level.
+type Store interface {
+	// MoveStock atomically adds move to the stock level of sku in warehouse and creates it, if it does not exist.
+	// If move.Available is negative, it fails with ErrorInsufficientStock unless enough stock is available.
+	MoveStock(sku string, warehouse string, move Move, now time.Time) (*StockLevel, error)
+	// GetStockLevel returns the stock level of sku in warehouse or ErrorStockLevelNotFound
+	GetStockLevel(sku string, warehouse string) (*StockLevel, error)
+	// GetStockLevels returns the stock levels of sku in all warehouses
+	GetStockLevels(sku string) ([]*StockLevel, error)
+	// InsertReservation stores a new reservation and returns ErrorReservationExists if the id is taken
+	InsertReservation(reservation *Reservation) error
+	// GetReservation returns the reservation with id or ErrorReservationNotFound
+	GetReservation(id string) (*Reservation, error)
+	// FindReservations returns all reservations matching query ordered by creation
+	FindReservations(query *ReservationQuery) ([]*Reservation, error)
+	// UpdateReservation stores reservation, if the stored version equals reservation.Version, and increments the version.
+	// Otherwise it fails with ErrorReservationChanged.
+	UpdateReservation(reservation *Reservation) error
+}
+
+// MongoStore stores stock levels in configuration.MONGO_COLLECTION_INVENTORY_STOCK
+// and reservations in configuration.MONGO_COLLECTION_INVENTORY_RESERVATIONS
+type MongoStore struct{}
+
+// MemoryStore implements Store with maps guarded by a mutex. Stock is not persisted.
+type MemoryStore struct {
+	sync.Mutex
+	stockLevels  map[string]*StockLevel
+	reservations map[string]*Reservation
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMongoStore constructor
+func NewMongoStore() *MongoStore {
+	return &MongoStore{}
+}
+
+// NewMemoryStore constructor
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		stockLevels:  map[string]*StockLevel{},
+		reservations: map[string]*Reservation{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// GetStockPersistor will return a singleton instance of a stock level mongo persistor
+func GetStockPersistor() *persistence.Persistor {
+	globalStockPersistor = getPersistor(globalStockPersistor, configuration.MONGO_COLLECTION_INVENTORY_STOCK, stockEnsuredIndexes)
+	return globalStockPersistor
+}
+
+// GetReservationPersistor will return a singleton instance of a reservation mongo persistor
+func GetReservationPersistor() *persistence.Persistor {
+	globalReservationPersistor = getPersistor(globalReservationPersistor, configuration.MONGO_COLLECTION_INVENTORY_RESERVATIONS, reservationEnsuredIndexes)
+	return globalReservationPersistor
+}
+
+func (s *MongoStore) MoveStock(sku string, warehouse string, move Move, now time.Time) (*StockLevel, error) {
+	session, collection := GetStockPersistor().GetCollection()
+	defer session.Close()
+
+	// only match the stock level, if enough is available, so that concurrent reservations cannot oversell
+	id := stockLevelId(sku, warehouse)
+	query := bson.M{"id": id}
+	if move.Available < 0 {
+		query["available"] = &bson.M{"$gte": -move.Available - quantityEpsilon}
+	}
+	stockLevel := &StockLevel{}
+	_, err := collection.Find(query).Apply(mgo.Change{
+		Update: &bson.M{
+			"$inc": &bson.M{
+				"onhand":    move.OnHand,
+				"available": move.Available,
+				"reserved":  move.Reserved,
+				"allocated": move.Allocated,
+			},
+			"$set":         &bson.M{"lastmodifiedat": now},
+			"$setOnInsert": &bson.M{"sku": sku, "warehouse": warehouse},
+		},
+		// a stock level which does not exist has no available stock
+		Upsert:    move.Available >= 0,
+		ReturnNew: true,
+	}, stockLevel)
+	if err == mgo.ErrNotFound {
+		if _, errGet := s.GetStockLevel(sku, warehouse); errGet != nil && errGet != ErrorStockLevelNotFound {
+			return nil, errGet
+		}
+		return nil, ErrorInsufficientStock
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stockLevel, nil
+}
+
+func (s *MongoStore) GetStockLevel(sku string, warehouse string) (*StockLevel, error) {
+	session, collection := GetStockPersistor().GetCollection()
+	defer session.Close()
+	stockLevel := &StockLevel{}
+	err := collection.Find(&bson.M{"id": stockLevelId(sku, warehouse)}).One(stockLevel)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorStockLevelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stockLevel, nil
+}
+
+func (s *MongoStore) GetStockLevels(sku string) ([]*StockLevel, error) {
+	session, collection := GetStockPersistor().GetCollection()
+	defer session.Close()
+	stockLevels := []*StockLevel{}
+	err := collection.Find(&bson.M{"sku": sku}).Sort("warehouse").All(&stockLevels)
+	return stockLevels, err
+}
+
+func (s *MongoStore) InsertReservation(reservation *Reservation) error {
+	session, collection := GetReservationPersistor().GetCollection()
+	defer session.Close()
+	err := collection.Insert(reservation)
+	if mgo.IsDup(err) {
+		return ErrorReservationExists
+	}
+	return err
+}
+
+func (s *MongoStore) GetReservation(id string) (*Reservation, error) {
+	session, collection := GetReservationPersistor().GetCollection()
+	defer session.Close()
+	reservation := &Reservation{}
+	err := collection.Find(&bson.M{"id": id}).One(reservation)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorReservationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func (s *MongoStore) FindReservations(query *ReservationQuery) ([]*Reservation, error) {
+	session, collection := GetReservationPersistor().GetCollection()
+	defer session.Close()
+	reservations := []*Reservation{}
+	err := collection.Find(query.toBson()).Sort("createdat").All(&reservations)
+	return reservations, err
+}
+
+func (s *MongoStore) UpdateReservation(reservation *Reservation) error {
+	session, collection := GetReservationPersistor().GetCollection()
+	defer session.Close()
+	expectedVersion := reservation.Version
+	reservation.Version++
+	err := collection.Update(&bson.M{"id": reservation.Id, "version": expectedVersion}, reservation)
+	if err == mgo.ErrNotFound {
+		reservation.Version = expectedVersion
+		return ErrorReservationChanged
+	}
+	if err != nil {
+		reservation.Version = expectedVersion
+	}
+	return err
+}
+
+func (s *MemoryStore) MoveStock(sku string, warehouse string, move Move, now time.Time) (*StockLevel, error) {
+	s.Lock()
+	defer s.Unlock()
+	id := stockLevelId(sku, warehouse)
+	stockLevel, ok := s.stockLevels[id]
+	if !ok {
+		if move.Available < 0 {
+			return nil, ErrorInsufficientStock
+		}
+		stockLevel = &StockLevel{Id: id, SKU: sku, Warehouse: warehouse}
+		s.stockLevels[id] = stockLevel
+	}
+	if move.Available < 0 && stockLevel.Available < -move.Available-quantityEpsilon {
+		return nil, ErrorInsufficientStock
+	}
+	stockLevel.OnHand += move.OnHand
+	stockLevel.Available += move.Available
+	stockLevel.Reserved += move.Reserved
+	stockLevel.Allocated += move.Allocated
+	stockLevel.LastModifiedAt = now
+	copied := *stockLevel
+	return &copied, nil
+}
+
+func (s *MemoryStore) GetStockLevel(sku string, warehouse string) (*StockLevel, error) {
+	s.Lock()
+	defer s.Unlock()
+	stockLevel, ok := s.stockLevels[stockLevelId(sku, warehouse)]
+	if !ok {
+		return nil, ErrorStockLevelNotFound
+	}
+	copied := *stockLevel
+	return &copied, nil
+}
+
+func (s *MemoryStore) GetStockLevels(sku string) ([]*StockLevel, error) {
+	s.Lock()
+	defer s.Unlock()
+	stockLevels := []*StockLevel{}
+	for _, stockLevel := range s.stockLevels {
+		if stockLevel.SKU == sku {
+			copied := *stockLevel
+			stockLevels = append(stockLevels, &copied)
+		}
+	}
+	sort.Slice(stockLevels, func(i, j int) bool { return stockLevels[i].Warehouse < stockLevels[j].Warehouse })
+	return stockLevels, nil
+}
+
+func (s *MemoryStore) InsertReservation(reservation *Reservation) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.reservations[reservation.Id]; ok {
+		return ErrorReservationExists
+	}
+	copied := *reservation
+	s.reservations[reservation.Id] = &copied
+	return nil
+}
+
+func (s *MemoryStore) GetReservation(id string) (*Reservation, error) {
+	s.Lock()
+	defer s.Unlock()
+	reservation, ok := s.reservations[id]
+	if !ok {
+		return nil, ErrorReservationNotFound
+	}
+	copied := *reservation
+	return &copied, nil
+}
+
+func (s *MemoryStore) FindReservations(query *ReservationQuery) ([]*Reservation, error) {
+	s.Lock()
+	defer s.Unlock()
+	reservations := []*Reservation{}
+	for _, reservation := range s.reservations {
+		if query.Matches(reservation) {
+			copied := *reservation
+			reservations = append(reservations, &copied)
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		if reservations[i].CreatedAt.Equal(reservations[j].CreatedAt) {
+			return reservations[i].Id < reservations[j].Id
+		}
+		return reservations[i].CreatedAt.Before(reservations[j].CreatedAt)
+	})
+	return reservations, nil
+}
+
+func (s *MemoryStore) UpdateReservation(reservation *Reservation) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.reservations[reservation.Id]
+	if !ok || stored.Version != reservation.Version {
+		return ErrorReservationChanged
+	}
+	reservation.Version++
+	copied := *reservation
+	s.reservations[reservation.Id] = &copied
+	return nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func getPersistor(current *persistence.Persistor, collection string, indexes []mgo.Index) *persistence.Persistor {
+	url := configuration.GetMongoURL()
+	if current != nil && url == current.GetURL() && collection == current.GetCollectionName() {
+		return current
+	}
+	p, err := persistence.NewPersistorWithIndexes(url, collection, indexes)
+	if err != nil || p == nil {
+		panic(errors.New("failed to create mongoDB inventory persistor: " + err.Error()))
+	}
+	return p
+}
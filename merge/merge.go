@@ -0,0 +1,296 @@
+package merge
+
+import (
+	"errors"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/customer"
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/unique"
+	"github.com/foomo/shop/utils"
+	"github.com/foomo/shop/watchlist"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Merger merges guest customers into registered customers
+type Merger struct {
+	Records    RecordStore
+	FindOrders func(customerId string) ([]*order.Order, error) // FindOrdersOfCustomer if nil
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMerger constructor
+func NewMerger(records RecordStore) *Merger {
+	return &Merger{
+		Records: records,
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// FindOrdersOfCustomer returns all orders of customerId
+func FindOrdersOfCustomer(customerId string) ([]*order.Order, error) {
+	iter, err := order.Find(&bson.M{"customerdata.customerid": customerId}, nil)
+	if err != nil {
+		return nil, err
+	}
+	orders := []*order.Order{}
+	for {
+		o, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if o == nil {
+			return orders, nil
+		}
+		orders = append(orders, o)
+	}
+}
+
+// MergeGuest moves addresses, orders and watch lists of guest to target and returns the audit record.
+// guestLists are the watch lists of the guest, e.g. of its session, targetLists those of target. Both may be nil.
+// If guest has already been merged into target, the existing record is returned and nothing is changed.
+// The record is stored before the first step and updated before every change, so that an interrupted merge
+// can be repeated: every step only moves what is left at guest and the record keeps what has been moved before.
+func (m *Merger) MergeGuest(guest *customer.Customer, target *customer.Customer, guestLists *watchlist.CustomerWatchLists, targetLists *watchlist.CustomerWatchLists) (*Record, error) {
+	if guest.GetID() == target.GetID() {
+		return nil, errors.New("customer " + guest.GetID() + " cannot be merged into itself")
+	}
+	if !guest.IsGuest {
+		return nil, errors.New("customer " + guest.GetID() + " is not a guest")
+	}
+	if target.IsGuest {
+		return nil, errors.New("customer " + target.GetID() + " is a guest")
+	}
+	record, err := m.getRecord(guest, target)
+	if err == nil && !record.IsPending() {
+		return record, nil
+	}
+	if err == ErrorRecordNotFound {
+		record = &Record{
+			Id:                  unique.GetNewID(),
+			GuestCustomerId:     guest.GetID(),
+			TargetCustomerId:    target.GetID(),
+			AddressIds:          []string{},
+			DuplicateAddressIds: []string{},
+			OrderIds:            []string{},
+			WatchListIds:        []string{},
+		}
+		err = m.Records.InsertRecord(record)
+		if err == ErrorRecordExists {
+			return nil, errors.New("guest " + guest.GetID() + " is being merged concurrently")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.mergeAddresses(guest, target, record); err != nil {
+		return nil, err
+	}
+	if err := m.mergeOrders(guest, target, record); err != nil {
+		return nil, err
+	}
+	if err := m.mergeWatchLists(guestLists, targetLists, target, record); err != nil {
+		return nil, err
+	}
+	record.MergedAt = utils.TimeNow()
+	if err := m.Records.UpdateRecord(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func (m *Merger) getRecord(guest *customer.Customer, target *customer.Customer) (*Record, error) {
+	record, err := m.Records.GetRecordByGuestCustomerId(guest.GetID())
+	if err != nil {
+		return nil, err
+	}
+	if record.TargetCustomerId != target.GetID() {
+		return nil, errors.New("guest " + guest.GetID() + " has already been merged into customer " + record.TargetCustomerId)
+	}
+	return record, nil
+}
+
+// mergeAddresses moves the addresses of guest, which target does not have yet, to target.
+// A moved address keeps its default type only if target has no default address of that type.
+// Addresses which have been moved by an interrupted merge are at target already and are not recorded as duplicates.
+func (m *Merger) mergeAddresses(guest *customer.Customer, target *customer.Customer, record *Record) error {
+	if len(guest.Addresses) == 0 {
+		return nil
+	}
+	for _, addr := range guest.Addresses {
+		if containsString(record.AddressIds, addr.Id) && containsAddressId(target.Addresses, addr.Id) {
+			continue
+		}
+		if containsAddress(target.Addresses, addr) {
+			record.DuplicateAddressIds = appendUnique(record.DuplicateAddressIds, addr.Id)
+			continue
+		}
+		if addr.Type != address.AddressOther && hasAddressType(target.Addresses, addr.Type) {
+			addr.Type = address.AddressOther
+		}
+		target.Addresses = append(target.Addresses, addr)
+		record.AddressIds = appendUnique(record.AddressIds, addr.Id)
+	}
+	if err := m.Records.UpdateRecord(record); err != nil {
+		return err
+	}
+	target.LastModifiedAt = utils.TimeNow()
+	if err := target.Upsert(); err != nil {
+		return err
+	}
+	guest.Addresses = []*address.Address{}
+	guest.LastModifiedAt = utils.TimeNow()
+	return guest.Upsert()
+}
+
+// mergeOrders assigns the orders of guest to target. The guest is kept as CustomerData.GuestCustomerID.
+func (m *Merger) mergeOrders(guest *customer.Customer, target *customer.Customer, record *Record) error {
+	findOrders := m.FindOrders
+	if findOrders == nil {
+		findOrders = FindOrdersOfCustomer
+	}
+	orders, err := findOrders(guest.GetID())
+	if err != nil {
+		return err
+	}
+	for _, o := range orders {
+		if o.CustomerData == nil || o.CustomerData.CustomerId != guest.GetID() {
+			continue
+		}
+		record.OrderIds = appendUnique(record.OrderIds, o.GetID())
+		if err := m.Records.UpdateRecord(record); err != nil {
+			return err
+		}
+		o.CustomerData.CustomerId = target.GetID()
+		o.CustomerData.GuestCustomerID = guest.GetID()
+		if o.CustomerData.AddrKey == guest.AddrKey {
+			o.CustomerData.AddrKey = target.AddrKey
+		}
+		if err := o.Upsert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeWatchLists merges each list of guestLists into the list of targetLists with the same type and name.
+// Other lists are moved. If target has no watch lists, it adopts guestLists.
+// Each list is removed from guestLists as soon as it has been merged, so that a repeated merge does not add its items again.
+func (m *Merger) mergeWatchLists(guestLists *watchlist.CustomerWatchLists, targetLists *watchlist.CustomerWatchLists, target *customer.Customer, record *Record) error {
+	if guestLists == nil || len(guestLists.Lists) == 0 {
+		return nil
+	}
+	if targetLists == nil {
+		for _, list := range guestLists.Lists {
+			record.WatchListIds = appendUnique(record.WatchListIds, list.Id)
+		}
+		if err := m.Records.UpdateRecord(record); err != nil {
+			return err
+		}
+		guestLists.AddrKey = target.AddrKey
+		guestLists.SessionID = ""
+		return guestLists.Upsert()
+	}
+	for len(guestLists.Lists) > 0 {
+		list := guestLists.Lists[0]
+		record.WatchListIds = appendUnique(record.WatchListIds, list.Id)
+		if err := m.Records.UpdateRecord(record); err != nil {
+			return err
+		}
+		targetList := findWatchList(targetLists, list.Type, list.Name)
+		if targetList == nil {
+			targetLists.Lists = append(targetLists.Lists, list)
+			if err := targetLists.Upsert(); err != nil {
+				return err
+			}
+		} else if targetList.Id != list.Id {
+			// MergeLists stores targetLists
+			if err := watchlist.MergeLists(guestLists, list.Id, targetLists, targetList.Id); err != nil {
+				return err
+			}
+		}
+		guestLists.Lists = guestLists.Lists[1:]
+		if err := guestLists.Upsert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsAddress(addresses []*address.Address, addr *address.Address) bool {
+	for _, other := range addresses {
+		if other.Id == addr.Id || withPerson(other).Equals(withPerson(addr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withPerson returns addr with an empty person if it has none, because Address.Equals compares the persons
+func withPerson(addr *address.Address) *address.Address {
+	if addr.Person != nil {
+		return addr
+	}
+	copied := *addr
+	copied.Person = &address.Person{}
+	return &copied
+}
+
+func containsAddressId(addresses []*address.Address, id string) bool {
+	for _, addr := range addresses {
+		if addr.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(values []string, value string) []string {
+	if containsString(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+func hasAddressType(addresses []*address.Address, addressType address.AddressType) bool {
+	for _, addr := range addresses {
+		if addr.Type == addressType {
+			return true
+		}
+	}
+	return false
+}
+
+func findWatchList(lists *watchlist.CustomerWatchLists, listType string, name string) *watchlist.WatchList {
+	for _, list := range lists.Lists {
+		if list.Type == listType && list.Name == name {
+			return list
+		}
+	}
+	return nil
+}
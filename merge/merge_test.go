@@ -0,0 +1,213 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/customer"
+	"github.com/foomo/shop/order"
+	"github.com/foomo/shop/watchlist"
+)
+
+func newTestAddress(id string, addressType address.AddressType, street string) *address.Address {
+	return &address.Address{
+		Id:     id,
+		Type:   addressType,
+		Person: &address.Person{FirstName: "Alice", LastName: "Smith"},
+		Street: street, StreetNumber: "1", ZIP: "8000", City: "Zurich", Country: "Switzerland",
+	}
+}
+
+func newTestCustomer(id string, isGuest bool, addresses ...*address.Address) *customer.Customer {
+	c := &customer.Customer{Id: id, AddrKey: "addrkey-" + id, IsGuest: isGuest, Addresses: addresses}
+	c.UnlinkFromDB()
+	return c
+}
+
+func newTestOrder(id string, customerId string) *order.Order {
+	o := &order.Order{Id: id, Flags: &order.Flags{}, CustomerData: &order.CustomerData{CustomerId: customerId, AddrKey: "addrkey-" + customerId}}
+	o.UnlinkFromDB()
+	return o
+}
+
+func newTestWatchLists(lists ...*watchlist.WatchList) *watchlist.CustomerWatchLists {
+	cw := &watchlist.CustomerWatchLists{Lists: lists}
+	cw.UnlinkFromDB()
+	return cw
+}
+
+func TestMergeGuest(t *testing.T) {
+	guest := newTestCustomer("guest", true,
+		newTestAddress("guest-billing", address.AddressDefaultBilling, "Bahnhofstrasse"),
+		newTestAddress("guest-shipping", address.AddressDefaultShipping, "Seestrasse"),
+	)
+	target := newTestCustomer("target", false, newTestAddress("target-billing", address.AddressDefaultBilling, "Bahnhofstrasse"))
+	orders := []*order.Order{newTestOrder("order-1", "guest"), newTestOrder("order-2", "guest"), newTestOrder("order-3", "other")}
+	guestLists := newTestWatchLists(
+		&watchlist.WatchList{Id: "guest-wishlist", Type: "wishlist", Name: "default", Items: []*watchlist.WatchListItem{{Id: "shirt", Quantity: 1}, {Id: "shoes", Quantity: 2}}},
+		&watchlist.WatchList{Id: "guest-birthday", Type: "wishlist", Name: "birthday", Items: []*watchlist.WatchListItem{{Id: "socks", Quantity: 1}}},
+	)
+	guestLists.SessionID = "session"
+	targetLists := newTestWatchLists(&watchlist.WatchList{Id: "target-wishlist", Type: "wishlist", Name: "default", Items: []*watchlist.WatchListItem{{Id: "shirt", Quantity: 1}}})
+
+	merger := NewMerger(NewMemoryRecordStore())
+	findCalls := 0
+	merger.FindOrders = func(customerId string) ([]*order.Order, error) {
+		findCalls++
+		return orders, nil
+	}
+	record, err := merger.MergeGuest(guest, target, guestLists, targetLists)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"guest-shipping"}, record.AddressIds)
+	assert.Equal(t, []string{"guest-billing"}, record.DuplicateAddressIds)
+	assert.Equal(t, []string{"order-1", "order-2"}, record.OrderIds)
+	assert.Equal(t, []string{"guest-wishlist", "guest-birthday"}, record.WatchListIds)
+	assert.False(t, record.MergedAt.IsZero())
+
+	assert.Len(t, guest.Addresses, 0)
+	assert.Len(t, target.Addresses, 2)
+	assert.Equal(t, address.AddressDefaultShipping, target.Addresses[1].Type, "target had no default shipping address")
+	assert.Equal(t, "target", orders[0].CustomerData.CustomerId)
+	assert.Equal(t, "guest", orders[0].CustomerData.GuestCustomerID)
+	assert.Equal(t, "addrkey-target", orders[0].CustomerData.AddrKey)
+	assert.Equal(t, "other", orders[2].CustomerData.CustomerId)
+
+	assert.Len(t, guestLists.Lists, 0)
+	assert.Len(t, targetLists.Lists, 2)
+	item, err := targetLists.GetItem("target-wishlist", "shirt")
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, item.Quantity)
+	_, err = targetLists.GetItem("target-wishlist", "shoes")
+	assert.NoError(t, err)
+
+	// running it twice is safe
+	again, err := merger.MergeGuest(guest, target, guestLists, targetLists)
+	assert.NoError(t, err)
+	assert.Equal(t, record.Id, again.Id)
+	assert.Equal(t, 1, findCalls, "nothing is merged again")
+	item, _ = targetLists.GetItem("target-wishlist", "shirt")
+	assert.Equal(t, 2.0, item.Quantity)
+
+	_, err = merger.MergeGuest(guest, newTestCustomer("another", false), nil, nil)
+	assert.Error(t, err, "guest has been merged into another customer")
+}
+
+func TestMergeGuestAdoptsWatchLists(t *testing.T) {
+	guest := newTestCustomer("guest", true)
+	target := newTestCustomer("target", false)
+	guestLists := newTestWatchLists(&watchlist.WatchList{Id: "guest-wishlist", Items: []*watchlist.WatchListItem{{Id: "shirt", Quantity: 1}}})
+	guestLists.SessionID = "session"
+
+	merger := NewMerger(NewMemoryRecordStore())
+	merger.FindOrders = func(customerId string) ([]*order.Order, error) { return nil, nil }
+	record, err := merger.MergeGuest(guest, target, guestLists, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"guest-wishlist"}, record.WatchListIds)
+	assert.Equal(t, "addrkey-target", guestLists.AddrKey)
+	assert.Equal(t, "", guestLists.SessionID)
+}
+
+func TestMergeGuestValidation(t *testing.T) {
+	merger := NewMerger(NewMemoryRecordStore())
+	merger.FindOrders = func(customerId string) ([]*order.Order, error) { return nil, nil }
+	guest := newTestCustomer("guest", true)
+
+	_, err := merger.MergeGuest(guest, guest, nil, nil)
+	assert.Error(t, err)
+	_, err = merger.MergeGuest(newTestCustomer("registered", false), newTestCustomer("target", false), nil, nil)
+	assert.Error(t, err, "only guests can be merged")
+	_, err = merger.MergeGuest(guest, newTestCustomer("other-guest", true), nil, nil)
+	assert.Error(t, err, "target must be registered")
+}
+
+func TestMergeGuestResumesPendingMerge(t *testing.T) {
+	// an interrupted merge has moved the addresses and the first watch list
+	movedAddress := newTestAddress("guest-shipping", address.AddressDefaultShipping, "Seestrasse")
+	guest := newTestCustomer("guest", true)
+	target := newTestCustomer("target", false, newTestAddress("target-billing", address.AddressDefaultBilling, "Bahnhofstrasse"), movedAddress)
+	guestLists := newTestWatchLists(&watchlist.WatchList{Id: "guest-birthday", Type: "wishlist", Name: "birthday", Items: []*watchlist.WatchListItem{{Id: "socks", Quantity: 1}}})
+	targetLists := newTestWatchLists(&watchlist.WatchList{Id: "target-wishlist", Type: "wishlist", Name: "default", Items: []*watchlist.WatchListItem{{Id: "shirt", Quantity: 2}}})
+	orders := []*order.Order{newTestOrder("order-1", "guest")}
+
+	store := NewMemoryRecordStore()
+	assert.NoError(t, store.InsertRecord(&Record{
+		Id:                  "pending",
+		GuestCustomerId:     "guest",
+		TargetCustomerId:    "target",
+		AddressIds:          []string{"guest-shipping"},
+		DuplicateAddressIds: []string{"guest-billing"},
+		OrderIds:            []string{},
+		WatchListIds:        []string{"guest-wishlist"},
+	}))
+	merger := NewMerger(store)
+	merger.FindOrders = func(customerId string) ([]*order.Order, error) { return orders, nil }
+
+	record, err := merger.MergeGuest(guest, target, guestLists, targetLists)
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", record.Id)
+	assert.False(t, record.IsPending())
+	assert.Equal(t, []string{"guest-shipping"}, record.AddressIds, "moved addresses are kept")
+	assert.Equal(t, []string{"guest-billing"}, record.DuplicateAddressIds)
+	assert.Equal(t, []string{"order-1"}, record.OrderIds)
+	assert.Equal(t, []string{"guest-wishlist", "guest-birthday"}, record.WatchListIds)
+	item, _ := targetLists.GetItem("target-wishlist", "shirt")
+	assert.Equal(t, 2.0, item.Quantity, "merged items are not added again")
+	assert.Len(t, targetLists.Lists, 2)
+	assert.Len(t, guestLists.Lists, 0)
+
+	stored, err := store.GetRecordByGuestCustomerId("guest")
+	assert.NoError(t, err)
+	assert.Equal(t, record, stored)
+}
+
+func TestMergeGuestRepeatsInterruptedAddressMove(t *testing.T) {
+	// the target has been stored with the guest address, but the guest has not been cleared
+	guestAddress := newTestAddress("guest-shipping", address.AddressDefaultShipping, "Seestrasse")
+	guest := newTestCustomer("guest", true, guestAddress)
+	target := newTestCustomer("target", false, guestAddress)
+	store := NewMemoryRecordStore()
+	assert.NoError(t, store.InsertRecord(&Record{Id: "pending", GuestCustomerId: "guest", TargetCustomerId: "target", AddressIds: []string{"guest-shipping"}}))
+	merger := NewMerger(store)
+	merger.FindOrders = func(customerId string) ([]*order.Order, error) { return nil, nil }
+
+	record, err := merger.MergeGuest(guest, target, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"guest-shipping"}, record.AddressIds)
+	assert.Empty(t, record.DuplicateAddressIds)
+	assert.Len(t, target.Addresses, 1)
+	assert.Len(t, guest.Addresses, 0)
+}
+
+func TestMongoRecordStore(t *testing.T) {
+	session, collection := GetRecordPersistor().GetCollection()
+	defer session.Close()
+
+	assert.NoError(t, collection.DropCollection(), "clean up")
+	for _, index := range recordEnsuredIndexes {
+		assert.NoError(t, collection.EnsureIndex(index))
+	}
+
+	store := NewMongoRecordStore()
+	record := &Record{Id: "merge-1", GuestCustomerId: "guest", TargetCustomerId: "customer"}
+	assert.NoError(t, store.InsertRecord(record))
+	assert.Equal(t, ErrorRecordExists, store.InsertRecord(&Record{Id: "merge-2", GuestCustomerId: "guest", TargetCustomerId: "other"}), "a guest is merged once")
+
+	pending, err := store.GetRecordByGuestCustomerId("guest")
+	assert.NoError(t, err)
+	assert.True(t, pending.IsPending())
+
+	record.AddressIds = []string{"billing"}
+	assert.NoError(t, store.UpdateRecord(record))
+	assert.Equal(t, ErrorRecordNotFound, store.UpdateRecord(&Record{Id: "merge-2", GuestCustomerId: "guest"}), "only the record of the merge is updated")
+	assert.Equal(t, ErrorRecordNotFound, store.UpdateRecord(&Record{Id: "merge-3", GuestCustomerId: "unknown"}))
+
+	updated, err := store.GetRecordByGuestCustomerId("guest")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"billing"}, updated.AddressIds)
+	assert.Equal(t, "customer", updated.TargetCustomerId)
+
+	_, err = store.GetRecordByGuestCustomerId("unknown")
+	assert.Equal(t, ErrorRecordNotFound, err)
+}
@@ -0,0 +1,200 @@
+package merge
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/configuration"
+	"github.com/foomo/shop/persistence"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var (
+	ErrorRecordNotFound = errors.New("merge record not found")
+	ErrorRecordExists   = errors.New("merge record exists")
+)
+
+var (
+	globalRecordPersistor *persistence.Persistor
+
+	recordEnsuredIndexes = []mgo.Index{
+		{
+			Name:   "id",
+			Key:    []string{"id"},
+			Unique: true,
+		},
+		{
+			Name:   "guestcustomerid",
+			Key:    []string{"guestcustomerid"},
+			Unique: true,
+		},
+		{
+			Name:       "targetcustomerid",
+			Key:        []string{"targetcustomerid"},
+			Unique:     false,
+			Background: true,
+		},
+	}
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Record is the audit record of a guest customer merged into a registered customer
+type Record struct {
+	BsonId              bson.ObjectId `bson:"_id,omitempty"`
+	Id                  string
+	GuestCustomerId     string
+	TargetCustomerId    string
+	AddressIds          []string  // guest addresses moved to the target customer
+	DuplicateAddressIds []string  // guest addresses dropped, because the target customer has an equal address
+	OrderIds            []string  // orders of the guest, which now belong to the target customer
+	WatchListIds        []string  // guest watch lists merged into or adopted by the target customer
+	MergedAt            time.Time // zero while the merge is pending
+}
+
+// RecordStore persists merge records. A guest customer can only be merged once.
+type RecordStore interface {
+	// InsertRecord stores a new record and returns ErrorRecordExists if the guest customer has a record
+	InsertRecord(record *Record) error
+	// UpdateRecord stores a changed record of a pending merge or returns ErrorRecordNotFound
+	UpdateRecord(record *Record) error
+	// GetRecordByGuestCustomerId returns the record of a guest customer or ErrorRecordNotFound
+	GetRecordByGuestCustomerId(guestCustomerId string) (*Record, error)
+}
+
+// MongoRecordStore stores records in configuration.MONGO_COLLECTION_CUSTOMER_MERGES
+type MongoRecordStore struct{}
+
+// MemoryRecordStore is the non persistent RecordStore
+type MemoryRecordStore struct {
+	sync.Mutex
+	records map[string]*Record
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMongoRecordStore constructor
+func NewMongoRecordStore() *MongoRecordStore {
+	return &MongoRecordStore{}
+}
+
+// NewMemoryRecordStore constructor
+func NewMemoryRecordStore() *MemoryRecordStore {
+	return &MemoryRecordStore{
+		records: map[string]*Record{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// GetRecordPersistor will return a singleton instance of a merge record mongo persistor
+func GetRecordPersistor() *persistence.Persistor {
+	url := configuration.GetMongoURL()
+	collection := configuration.MONGO_COLLECTION_CUSTOMER_MERGES
+	if globalRecordPersistor != nil && url == globalRecordPersistor.GetURL() && collection == globalRecordPersistor.GetCollectionName() {
+		return globalRecordPersistor
+	}
+	p, err := persistence.NewPersistorWithIndexes(url, collection, recordEnsuredIndexes)
+	if err != nil || p == nil {
+		panic(errors.New("failed to create mongoDB merge record persistor: " + err.Error()))
+	}
+	globalRecordPersistor = p
+	return globalRecordPersistor
+}
+
+// IsPending returns true until all steps of the merge have been completed
+func (record *Record) IsPending() bool {
+	return record.MergedAt.IsZero()
+}
+
+func (s *MongoRecordStore) InsertRecord(record *Record) error {
+	session, collection := GetRecordPersistor().GetCollection()
+	defer session.Close()
+	err := collection.Insert(record)
+	if mgo.IsDup(err) {
+		return ErrorRecordExists
+	}
+	return err
+}
+
+func (s *MongoRecordStore) UpdateRecord(record *Record) error {
+	session, collection := GetRecordPersistor().GetCollection()
+	defer session.Close()
+	err := collection.Update(&bson.M{"guestcustomerid": record.GuestCustomerId, "id": record.Id}, record)
+	if err == mgo.ErrNotFound {
+		return ErrorRecordNotFound
+	}
+	return err
+}
+
+func (s *MongoRecordStore) GetRecordByGuestCustomerId(guestCustomerId string) (*Record, error) {
+	session, collection := GetRecordPersistor().GetCollection()
+	defer session.Close()
+	record := &Record{}
+	err := collection.Find(&bson.M{"guestcustomerid": guestCustomerId}).One(record)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *MemoryRecordStore) InsertRecord(record *Record) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.records[record.GuestCustomerId]; ok {
+		return ErrorRecordExists
+	}
+	s.records[record.GuestCustomerId] = copyRecord(record)
+	return nil
+}
+
+func (s *MemoryRecordStore) UpdateRecord(record *Record) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.records[record.GuestCustomerId]
+	if !ok || stored.Id != record.Id {
+		return ErrorRecordNotFound
+	}
+	s.records[record.GuestCustomerId] = copyRecord(record)
+	return nil
+}
+
+func (s *MemoryRecordStore) GetRecordByGuestCustomerId(guestCustomerId string) (*Record, error) {
+	s.Lock()
+	defer s.Unlock()
+	record, ok := s.records[guestCustomerId]
+	if !ok {
+		return nil, ErrorRecordNotFound
+	}
+	return copyRecord(record), nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// copyRecord returns a deep copy of record, so that records in memory cannot be changed by callers
+func copyRecord(record *Record) *Record {
+	copied := *record
+	copied.AddressIds = append([]string{}, record.AddressIds...)
+	copied.DuplicateAddressIds = append([]string{}, record.DuplicateAddressIds...)
+	copied.OrderIds = append([]string{}, record.OrderIds...)
+	copied.WatchListIds = append([]string{}, record.WatchListIds...)
+	return &copied
+}
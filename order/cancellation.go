@@ -0,0 +1,381 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+
+	"github.com/foomo/shop/pricerule"
+	"github.com/foomo/shop/unique"
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	CancellationReasonCustomerRequest CancellationReason = "customerRequest"
+	CancellationReasonOutOfStock      CancellationReason = "outOfStock"
+	CancellationReasonFraud           CancellationReason = "fraud"
+	CancellationReasonPaymentFailed   CancellationReason = "paymentFailed"
+	CancellationReasonOther           CancellationReason = "other"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type CancellationReason string
+
+// StockReleaser releases stock which has been reserved for an order, e.g. ATP reservations.
+// ReleaseStock may be called more than once for the same cancellation and item if a cancellation is resumed,
+// a repeated call with the same cancellationId and itemID must not release stock again.
+type StockReleaser interface {
+	ReleaseStock(order *Order, cancellationId string, itemID string, quantity float64) error
+}
+
+// Cancellation records a full or partial cancellation of an order.
+// A cancellation is performed in steps (release stock, revert vouchers, revert price rule usages, update positions and state).
+// The progress of each step is stored, so that a cancellation which failed half way can be resumed
+// with ResumeCancellation() without performing any step twice.
+type Cancellation struct {
+	Id                   string
+	Reason               CancellationReason
+	Comment              string
+	IsFull               bool
+	Items                []*CancellationItem
+	VoucherCodes         []string // voucher codes whose redemption is reverted (full cancellations only)
+	VoucherCodesReverted []string
+	PriceRuleIDs         []string // price rules without voucher whose usage history is reverted (full cancellations only)
+	PriceRuleIDsReverted []string
+	CreatedAt            time.Time
+	CompletedAt          time.Time
+	LastError            string
+}
+
+// CancellationItem is the canceled quantity of a position
+type CancellationItem struct {
+	ItemID        string
+	Quantity      float64
+	StockReleased bool
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON CANCELLATION
+//------------------------------------------------------------------
+
+// IsCompleted returns true if all steps of the cancellation have been performed
+func (cancellation *Cancellation) IsCompleted() bool {
+	return !cancellation.CompletedAt.IsZero()
+}
+
+// GetQuantity returns the canceled quantity of itemID
+func (cancellation *Cancellation) GetQuantity(itemID string) float64 {
+	quantity := 0.0
+	for _, item := range cancellation.Items {
+		if item.ItemID == itemID {
+			quantity += item.Quantity
+		}
+	}
+	return quantity
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON ORDER
+//------------------------------------------------------------------
+
+// Cancel cancels the complete order. Reserved stock is released with releaser (may be nil), the redemption
+// of all vouchers in Coupons and the usage of the price rules in PriceRuleIDs is reverted and the order is set to OrderStatusCanceled.
+// Orders which have already been shipped (partially) cannot be canceled completely, use CancelPositions() instead.
+func (order *Order) Cancel(reason CancellationReason, comment string, releaser StockReleaser) (*Cancellation, error) {
+	if err := order.checkCancellationAllowed(); err != nil {
+		return nil, err
+	}
+	if order.hasActiveShipments() {
+		return nil, errors.New("order " + order.GetID() + " has already been shipped and cannot be canceled completely")
+	}
+	cancellation := newCancellation(reason, comment)
+	for _, pos := range order.Positions {
+		if pos.IsShipping || pos.Quantity <= 0 {
+			continue
+		}
+		cancellation.Items = append(cancellation.Items, &CancellationItem{
+			ItemID:   pos.ItemID,
+			Quantity: pos.Quantity,
+		})
+	}
+	order.setFullCancellation(cancellation)
+	return cancellation, order.startCancellation(cancellation, releaser)
+}
+
+// CancelPositions cancels the given quantities (itemID => quantity) of the order.
+// Only quantities which have not been shipped yet can be canceled. Reserved stock is released with releaser (may be nil).
+// Vouchers remain redeemed, unless all quantities of an order without shipments are canceled, which is a full cancellation.
+func (order *Order) CancelPositions(quantities map[string]float64, reason CancellationReason, comment string, releaser StockReleaser) (*Cancellation, error) {
+	if err := order.checkCancellationAllowed(); err != nil {
+		return nil, err
+	}
+	if len(quantities) == 0 {
+		return nil, errors.New("no positions to cancel")
+	}
+	cancellation := newCancellation(reason, comment)
+	for itemID, quantity := range quantities {
+		pos := order.GetPositionByItemId(itemID)
+		if pos == nil {
+			return nil, fmt.Errorf("position with %q not found in order", itemID)
+		}
+		if pos.IsShipping {
+			return nil, fmt.Errorf("shipping position %q cannot be canceled", itemID)
+		}
+		if quantity <= 0 {
+			return nil, fmt.Errorf("canceled quantity for %q must be greater than 0", itemID)
+		}
+		if openQuantity := order.GetOpenQuantity(itemID); quantity > openQuantity+quantityEpsilon {
+			return nil, fmt.Errorf("canceled quantity %v for %q exceeds open quantity %v", quantity, itemID, openQuantity)
+		}
+		cancellation.Items = append(cancellation.Items, &CancellationItem{
+			ItemID:   itemID,
+			Quantity: quantity,
+		})
+	}
+	if order.isCanceledCompletely(cancellation) {
+		order.setFullCancellation(cancellation)
+	}
+	return cancellation, order.startCancellation(cancellation, releaser)
+}
+
+// ResumeCancellation performs the remaining steps of a cancellation which could not be completed
+func (order *Order) ResumeCancellation(id string, releaser StockReleaser) error {
+	cancellation := order.GetCancellationById(id)
+	if cancellation == nil {
+		return errors.New("cancellation " + id + " not found in order " + order.GetID())
+	}
+	if cancellation.IsCompleted() {
+		return nil
+	}
+	return order.processCancellation(cancellation, releaser)
+}
+
+// GetCancellations returns all cancellations of the order
+func (order *Order) GetCancellations() []*Cancellation {
+	return order.Cancellations
+}
+
+// GetCancellationById returns the cancellation with id or nil if it does not exist
+func (order *Order) GetCancellationById(id string) *Cancellation {
+	for _, cancellation := range order.Cancellations {
+		if cancellation.Id == id {
+			return cancellation
+		}
+	}
+	return nil
+}
+
+// GetPendingCancellation returns a cancellation which has not been completed or nil
+func (order *Order) GetPendingCancellation() *Cancellation {
+	for _, cancellation := range order.Cancellations {
+		if !cancellation.IsCompleted() {
+			return cancellation
+		}
+	}
+	return nil
+}
+
+// GetCanceledQuantity returns the quantity of itemID summed up over all completed cancellations
+func (order *Order) GetCanceledQuantity(itemID string) float64 {
+	quantity := 0.0
+	for _, cancellation := range order.Cancellations {
+		if cancellation.IsCompleted() {
+			quantity += cancellation.GetQuantity(itemID)
+		}
+	}
+	return quantity
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func newCancellation(reason CancellationReason, comment string) *Cancellation {
+	if reason == "" {
+		reason = CancellationReasonOther
+	}
+	return &Cancellation{
+		Id:        unique.GetNewID(),
+		Reason:    reason,
+		Comment:   comment,
+		Items:     []*CancellationItem{},
+		CreatedAt: utils.TimeNow(),
+	}
+}
+
+// setFullCancellation marks cancellation as full cancellation, which reverts the discounts of the order
+func (order *Order) setFullCancellation(cancellation *Cancellation) {
+	cancellation.IsFull = true
+	cancellation.VoucherCodes = append(cancellation.VoucherCodes, order.Coupons...)
+	cancellation.PriceRuleIDs = append(cancellation.PriceRuleIDs, order.PriceRuleIDs...)
+}
+
+// isCanceledCompletely returns true if cancellation cancels all quantities of an order, which has not been shipped
+func (order *Order) isCanceledCompletely(cancellation *Cancellation) bool {
+	if order.hasActiveShipments() {
+		return false
+	}
+	for _, pos := range order.Positions {
+		if pos.IsShipping || pos.Quantity <= 0 {
+			continue
+		}
+		if cancellation.GetQuantity(pos.ItemID) < pos.Quantity-quantityEpsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCancellationAllowed returns an error if the state of the order does not allow a cancellation
+// or if another cancellation is still pending
+func (order *Order) checkCancellationAllowed() error {
+	if pending := order.GetPendingCancellation(); pending != nil {
+		return errors.New("cancellation " + pending.Id + " of order " + order.GetID() + " is pending and must be resumed first")
+	}
+	if order.State == nil {
+		return errors.New("order " + order.GetID() + " has no state")
+	}
+	// perform transition on a copy to check if it would be possible
+	stateCopy := *order.State
+	if err := DefaultStateMachine.TransitionToState(&stateCopy, OrderStatusCanceled); err != nil {
+		return err
+	}
+	return nil
+}
+
+// startCancellation stores the cancellation on the order before any step is performed
+func (order *Order) startCancellation(cancellation *Cancellation, releaser StockReleaser) error {
+	order.Cancellations = append(order.Cancellations, cancellation)
+	if err := order.Upsert(); err != nil {
+		order.Cancellations = order.Cancellations[:len(order.Cancellations)-1]
+		return err
+	}
+	return order.processCancellation(cancellation, releaser)
+}
+
+func (order *Order) processCancellation(cancellation *Cancellation, releaser StockReleaser) error {
+	// Step 1: release reserved stock
+	for _, item := range cancellation.Items {
+		if item.StockReleased {
+			continue
+		}
+		if releaser != nil {
+			if err := releaser.ReleaseStock(order, cancellation.Id, item.ItemID, item.Quantity); err != nil {
+				return order.failCancellation(cancellation, err)
+			}
+		}
+		item.StockReleased = true
+	}
+
+	// Step 2: revert voucher redemptions and usage history
+	for _, code := range cancellation.VoucherCodes {
+		if isVoucherCodeReverted(cancellation, code) {
+			continue
+		}
+		err := pricerule.RevertVoucherRedemptionByCode(code, order.GetCustomerId(), order.GetID())
+		if err != nil && err != mgo.ErrNotFound {
+			return order.failCancellation(cancellation, err)
+		}
+		cancellation.VoucherCodesReverted = append(cancellation.VoucherCodesReverted, code)
+	}
+
+	// Step 3: revert usage history of price rules without voucher
+	for _, id := range cancellation.PriceRuleIDs {
+		if isPriceRuleIDReverted(cancellation, id) {
+			continue
+		}
+		err := pricerule.RevertPriceRuleUsageHistoryAtomic(id, order.GetCustomerId())
+		if err != nil && err != mgo.ErrNotFound {
+			return order.failCancellation(cancellation, err)
+		}
+		cancellation.PriceRuleIDsReverted = append(cancellation.PriceRuleIDsReverted, id)
+	}
+
+	// Step 4: update positions and state of order
+	if err := order.applyCancellation(cancellation); err != nil {
+		return order.failCancellation(cancellation, err)
+	}
+	cancellation.CompletedAt = utils.TimeNow()
+	cancellation.LastError = ""
+	return order.Upsert()
+}
+
+// failCancellation stores the progress of the cancellation, so that it can be resumed later
+func (order *Order) failCancellation(cancellation *Cancellation, err error) error {
+	cancellation.LastError = err.Error()
+	if errUpsert := order.Upsert(); errUpsert != nil {
+		return fmt.Errorf("cancellation %s failed: %v (could not store progress: %v)", cancellation.Id, err, errUpsert)
+	}
+	return fmt.Errorf("cancellation %s failed: %v", cancellation.Id, err)
+}
+
+func (order *Order) applyCancellation(cancellation *Cancellation) error {
+	if cancellation.IsFull {
+		for _, pos := range order.Positions {
+			if pos.IsShipping {
+				continue
+			}
+			if err := pos.transitionToPositionState(PositionStatusCanceled); err != nil {
+				return err
+			}
+		}
+		return DefaultStateMachine.TransitionToState(order.State, OrderStatusCanceled)
+	}
+
+	for _, item := range cancellation.Items {
+		pos := order.GetPositionByItemId(item.ItemID)
+		if pos == nil {
+			return fmt.Errorf("position with %q not found in order", item.ItemID)
+		}
+		pos.Quantity -= item.Quantity
+		if pos.Quantity < quantityEpsilon {
+			pos.Quantity = 0
+		}
+		if pos.Quantity == 0 && order.GetShippedQuantity(pos.ItemID) == 0 {
+			if err := pos.transitionToPositionState(PositionStatusCanceled); err != nil {
+				return err
+			}
+		}
+	}
+	// remaining quantities may now be shipped completely
+	return order.UpdatePositionStatesFromShipments(nil)
+}
+
+func isVoucherCodeReverted(cancellation *Cancellation, code string) bool {
+	for _, reverted := range cancellation.VoucherCodesReverted {
+		if reverted == code {
+			return true
+		}
+	}
+	return false
+}
+
+func isPriceRuleIDReverted(cancellation *Cancellation, id string) bool {
+	for _, reverted := range cancellation.PriceRuleIDsReverted {
+		if reverted == id {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionToPositionState performs a transition with the default position state machine.
+// Positions without a valid position state start from the initial state.
+func (position *Position) transitionToPositionState(targetState string) error {
+	if _, ok := DefaultPositionStateMachine.Transitions[position.GetStateKey()]; !ok {
+		position.SetInitialState(DefaultPositionStateMachine)
+	}
+	if position.State.IsState(targetState) {
+		return nil
+	}
+	return DefaultPositionStateMachine.TransitionToState(position.State, targetState)
+}
@@ -0,0 +1,127 @@
+package order
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/pricerule"
+)
+
+type testStockReleaser struct {
+	fail     bool
+	released map[string]float64
+}
+
+func (r *testStockReleaser) ReleaseStock(order *Order, cancellationId string, itemID string, quantity float64) error {
+	if r.fail {
+		return errors.New("stock service unavailable")
+	}
+	if r.released == nil {
+		r.released = map[string]float64{}
+	}
+	r.released[itemID] += quantity
+	return nil
+}
+
+func newConfirmedTestOrder(t *testing.T) *Order {
+	order := newUnlinkedTestOrder()
+	assert.NoError(t, order.SetState(nil, OrderStatusConfirmed))
+	return order
+}
+
+func TestCancellationFull(t *testing.T) {
+	order := newConfirmedTestOrder(t)
+	releaser := &testStockReleaser{}
+
+	cancellation, err := order.Cancel(CancellationReasonCustomerRequest, "changed mind", releaser)
+	assert.NoError(t, err)
+	assert.True(t, cancellation.IsCompleted())
+	assert.Equal(t, OrderStatusCanceled, order.GetState().Key)
+	assert.Equal(t, PositionStatusCanceled, order.GetPositionByItemId("shirt").GetStateKey())
+	assert.Equal(t, map[string]float64{"shirt": 3, "shoes": 1}, releaser.released, "shipping has no stock")
+
+	_, err = order.Cancel(CancellationReasonCustomerRequest, "", releaser)
+	assert.Error(t, err, "canceled order cannot be canceled again")
+}
+
+func TestCancellationPartialAndResume(t *testing.T) {
+	order := newConfirmedTestOrder(t)
+
+	shipment := NewShipment("post", "1")
+	shipment.AddItem("shirt", 1)
+	assert.NoError(t, order.AddShipment(shipment))
+
+	_, err := order.CancelPositions(map[string]float64{"shirt": 3}, CancellationReasonOutOfStock, "", nil)
+	assert.Error(t, err, "shipped quantity cannot be canceled")
+
+	releaser := &testStockReleaser{fail: true}
+	cancellation, err := order.CancelPositions(map[string]float64{"shirt": 2, "shoes": 1}, CancellationReasonOutOfStock, "", releaser)
+	assert.Error(t, err)
+	assert.False(t, cancellation.IsCompleted())
+	assert.NotEmpty(t, cancellation.LastError)
+	assert.Equal(t, 3.0, order.GetPositionByItemId("shirt").Quantity, "positions are untouched until cancellation is completed")
+
+	_, err = order.CancelPositions(map[string]float64{"shoes": 1}, CancellationReasonOutOfStock, "", releaser)
+	assert.Error(t, err, "pending cancellation must be resumed first")
+
+	releaser.fail = false
+	assert.NoError(t, order.ResumeCancellation(cancellation.Id, releaser))
+	assert.True(t, cancellation.IsCompleted())
+	assert.Equal(t, OrderStatusConfirmed, order.GetState().Key, "partial cancellation keeps order state")
+	assert.Equal(t, 1.0, order.GetPositionByItemId("shirt").Quantity)
+	assert.Equal(t, PositionStatusShipped, order.GetPositionByItemId("shirt").GetStateKey())
+	assert.Equal(t, PositionStatusCanceled, order.GetPositionByItemId("shoes").GetStateKey())
+	assert.Equal(t, 2.0, order.GetCanceledQuantity("shirt"))
+	assert.Equal(t, map[string]float64{"shirt": 2, "shoes": 1}, releaser.released)
+}
+
+func TestCancellationPartialCancelsAll(t *testing.T) {
+	order := newConfirmedTestOrder(t)
+
+	cancellation, err := order.CancelPositions(map[string]float64{"shirt": 1}, CancellationReasonOutOfStock, "", nil)
+	assert.NoError(t, err)
+	assert.False(t, cancellation.IsFull)
+
+	cancellation, err = order.CancelPositions(map[string]float64{"shirt": 2, "shoes": 1}, CancellationReasonCustomerRequest, "", nil)
+	assert.NoError(t, err)
+	assert.True(t, cancellation.IsFull, "all remaining quantities are canceled")
+	assert.Equal(t, OrderStatusCanceled, order.GetState().Key)
+	assert.Equal(t, PositionStatusCanceled, order.GetPositionByItemId("shirt").GetStateKey())
+	assert.Equal(t, PositionStatusCanceled, order.GetPositionByItemId("shoes").GetStateKey())
+	assert.Equal(t, 3.0, order.GetCanceledQuantity("shirt"))
+}
+
+func TestCancellationRevertsDiscounts(t *testing.T) {
+	assert.NoError(t, pricerule.RemoveAllPriceRules())
+	assert.NoError(t, pricerule.RemoveAllVouchers())
+	voucherRule := pricerule.NewBonusPriceRule("cancellation-bonus", 100, nil, nil, time.Now(), time.Now().AddDate(1, 0, 0))
+	voucherRule.AllowPartialRedemption = true
+	assert.NoError(t, voucherRule.Upsert())
+	voucher := pricerule.NewVoucher("cancellation-voucher", "cancellation-code", voucherRule, "")
+	assert.NoError(t, voucher.Upsert())
+	priceRule := pricerule.NewPriceRule("cancellation-rule")
+	assert.NoError(t, priceRule.Upsert())
+
+	order := newConfirmedTestOrder(t)
+	order.CustomerData = &CustomerData{CustomerId: "customer-1"}
+	order.Coupons = []string{voucher.VoucherCode}
+	order.PriceRuleIDs = []string{priceRule.ID}
+	assert.NoError(t, voucher.RedeemPartially("customer-1", order.GetID(), 30, voucherRule))
+	assert.NoError(t, pricerule.UpdatePriceRuleUsageHistoryAtomic(priceRule.ID, "customer-1"))
+
+	cancellation, err := order.Cancel(CancellationReasonCustomerRequest, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{voucher.VoucherCode}, cancellation.VoucherCodesReverted)
+	assert.Equal(t, []string{priceRule.ID}, cancellation.PriceRuleIDsReverted)
+
+	loadedVoucher, err := pricerule.GetVoucherByCode(voucher.VoucherCode, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, loadedVoucher.GetRemainingAmount(voucherRule), "the partial redemption of the order is reverted")
+	loadedRule, err := pricerule.GetPriceRuleByID(priceRule.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, loadedRule.UsageHistory.TotalUsages)
+	assert.Equal(t, 0, loadedRule.UsageHistory.UsagesPerCustomer["customer-1"])
+}
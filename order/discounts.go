@@ -0,0 +1,57 @@
+package order
+
+import (
+	"errors"
+
+	"github.com/foomo/shop/pricerule"
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// CommitDiscounts redeems the vouchers in Coupons and updates the usage history of all price rules applied to the order,
+// e.g. when the order is confirmed. The discounts are calculated like in PriceRulePricer.CalculateTotal().
+// Partial redemptions of bonus vouchers reference the order and the ids of the price rules without voucher are stored
+// in PriceRuleIDs, so that Cancel() can revert them.
+// The discounts of an order can only be committed once.
+func (order *Order) CommitDiscounts(pricer *PriceRulePricer) error {
+	if !order.DiscountsCommittedAt.IsZero() {
+		return errors.New("discounts of order " + order.GetID() + " have already been committed")
+	}
+	priceRuleIDs, err := pricerule.CommitOrderDiscountsForOrder(order.GetCustomerId(), order.GetID(), getArticleCollection(order), order.Coupons, pricer.CheckoutAttributes, pricer.RoundTo, pricer.CustomProvider)
+	if err != nil {
+		return err
+	}
+	order.PriceRuleIDs = priceRuleIDs
+	order.DiscountsCommittedAt = utils.TimeNow()
+	return order.Upsert()
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// getArticleCollection returns the positions of o with a quantity as articles for price rules
+func getArticleCollection(o *Order) *pricerule.ArticleCollection {
+	articleCollection := &pricerule.ArticleCollection{
+		Articles:   []*pricerule.Article{},
+		CustomerID: o.GetCustomerId(),
+	}
+	if o.CustomerData != nil {
+		articleCollection.CustomerType = o.CustomerData.CustomerType
+	}
+	for _, pos := range o.GetPositions() {
+		if pos.Quantity <= 0 {
+			continue
+		}
+		articleCollection.Articles = append(articleCollection.Articles, &pricerule.Article{
+			ID:         pos.ItemID,
+			Price:      pos.Price,
+			CrossPrice: pos.CrossPrice,
+			Quantity:   pos.Quantity,
+		})
+	}
+	return articleCollection
+}
@@ -0,0 +1,387 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/pricerule"
+	"github.com/foomo/shop/unique"
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	PositionChangeAdded           PositionChangeType = "added"
+	PositionChangeRemoved         PositionChangeType = "removed"
+	PositionChangeQuantityChanged PositionChangeType = "quantityChanged"
+	PositionChangePriceChanged    PositionChangeType = "priceChanged"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type PositionChangeType string
+
+// Pricer calculates the grand total of an order. It may adjust the prices of the positions.
+type Pricer interface {
+	CalculateTotal(o *Order) (float64, error)
+}
+
+// PositionsPricer sums up the totals of all positions
+type PositionsPricer struct{}
+
+// PriceRulePricer sums up the totals of all positions and subtracts the discounts of all applicable price rules and vouchers (Coupons)
+type PriceRulePricer struct {
+	CheckoutAttributes []string
+	RoundTo            float64
+	CustomProvider     pricerule.PriceRuleCustomProvider
+}
+
+// EditSession is used to change an order after it has been confirmed, e.g. by customer service.
+// All changes are performed on a copy of the order and are not persisted before Approve() is called.
+type EditSession struct {
+	Id             string
+	order          *Order // persisted order
+	referenceOrder *Order // order as submitted by customer
+	working        *Order // copy of order which is edited
+	pricer         Pricer
+	customProvider OrderCustomProvider
+	closed         bool
+}
+
+// EditResult is the outcome of an edit session
+type EditResult struct {
+	ReferenceVersion int
+	ReferenceTotal   float64           // total of the order as submitted by the customer
+	PreviousTotal    float64           // total of the order before the edit
+	NewTotal         float64           // total of the order after the edit
+	Balance          float64           // NewTotal - PreviousTotal. Positive: amount due by customer, negative: refund
+	Changes          []*PositionChange // changes compared to the reference version
+}
+
+// PositionChange describes how a position differs from the reference version
+type PositionChange struct {
+	ItemID         string
+	Type           PositionChangeType
+	QuantityBefore float64
+	QuantityAfter  float64
+	PriceBefore    float64
+	PriceAfter     float64
+}
+
+// Edit is stored on the order for each approved edit session
+type Edit struct {
+	Id               string
+	Comment          string
+	ReferenceVersion int
+	PreviousTotal    float64
+	NewTotal         float64
+	Balance          float64
+	Changes          []*PositionChange
+	ApprovedAt       time.Time
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// StartEditSession starts an edit session on a confirmed order. The reference version of the order must have been set.
+// If pricer is nil, PositionsPricer is used.
+func (order *Order) StartEditSession(pricer Pricer, customProvider OrderCustomProvider) (*EditSession, error) {
+	if order.GetReferenceVersion() == 0 {
+		return nil, errors.New("reference version of order " + order.GetID() + " has not been set")
+	}
+	referenceOrder, err := GetOrderByVersion(order.GetID(), order.GetReferenceVersion(), customProvider)
+	if err != nil {
+		return nil, err
+	}
+	return NewEditSession(order, referenceOrder, pricer, customProvider)
+}
+
+// NewEditSession creates an edit session for order. referenceOrder is the version of the order as it was submitted by the customer.
+// If pricer is nil, PositionsPricer is used.
+func NewEditSession(order *Order, referenceOrder *Order, pricer Pricer, customProvider OrderCustomProvider) (*EditSession, error) {
+	if order == nil || referenceOrder == nil {
+		return nil, errors.New("order and reference order must not be nil")
+	}
+	if !isEditable(order) {
+		return nil, errors.New("order " + order.GetID() + " in state " + order.GetState().Key + " cannot be edited")
+	}
+	if pending := order.GetPendingCancellation(); pending != nil {
+		return nil, errors.New("cancellation " + pending.Id + " of order " + order.GetID() + " is pending")
+	}
+	working, err := copyOrder(order, customProvider)
+	if err != nil {
+		return nil, err
+	}
+	working.UnlinkFromDB()
+	if pricer == nil {
+		pricer = &PositionsPricer{}
+	}
+	return &EditSession{
+		Id:             unique.GetNewID(),
+		order:          order,
+		referenceOrder: referenceOrder,
+		working:        working,
+		pricer:         pricer,
+		customProvider: customProvider,
+	}, nil
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON EDIT SESSION
+//------------------------------------------------------------------
+
+// GetOrder returns the edited copy of the order
+func (session *EditSession) GetOrder() *Order {
+	return session.working
+}
+
+// AddPosition adds a new position. If a position with the same itemID already exists, an error is returned.
+func (session *EditSession) AddPosition(pos *Position) error {
+	if err := session.checkOpen(); err != nil {
+		return err
+	}
+	if session.working.GetPositionByItemId(pos.ItemID) != nil {
+		return fmt.Errorf("position with %q already exists in order", pos.ItemID)
+	}
+	return session.working.AddPosition(pos)
+}
+
+// RemovePosition removes the position with itemID
+func (session *EditSession) RemovePosition(itemID string) error {
+	return session.SetPositionQuantity(itemID, 0)
+}
+
+// SetPositionQuantity changes the quantity of an existing position. A quantity of 0 removes the position.
+func (session *EditSession) SetPositionQuantity(itemID string, quantity float64) error {
+	if err := session.checkOpen(); err != nil {
+		return err
+	}
+	if session.working.GetPositionByItemId(itemID) == nil {
+		return fmt.Errorf("position with %q not found in order", itemID)
+	}
+	return session.working.SetPositionQuantity(itemID, quantity, -1, -1, session.customProvider)
+}
+
+// ReplacePosition replaces the itemId of a position, @see Order.ReplacePosition()
+func (session *EditSession) ReplacePosition(itemIdCurrent, itemIdNew string, crossPrice float64, price float64) error {
+	if err := session.checkOpen(); err != nil {
+		return err
+	}
+	return session.working.ReplacePosition(itemIdCurrent, itemIdNew, crossPrice, price, session.customProvider)
+}
+
+// Preview recalculates the edited order and compares it with the persisted order and the reference version
+func (session *EditSession) Preview() (*EditResult, error) {
+	if err := session.checkOpen(); err != nil {
+		return nil, err
+	}
+	if err := session.validate(); err != nil {
+		return nil, err
+	}
+	referenceTotal, err := session.pricer.CalculateTotal(session.referenceOrder)
+	if err != nil {
+		return nil, err
+	}
+	previousTotal, err := session.pricer.CalculateTotal(session.order)
+	if err != nil {
+		return nil, err
+	}
+	newTotal, err := session.pricer.CalculateTotal(session.working)
+	if err != nil {
+		return nil, err
+	}
+	return &EditResult{
+		ReferenceVersion: session.referenceOrder.GetVersion().Current,
+		ReferenceTotal:   referenceTotal,
+		PreviousTotal:    previousTotal,
+		NewTotal:         newTotal,
+		Balance:          utils.Round(newTotal-previousTotal, 2),
+		Changes:          DiffPositions(session.referenceOrder, session.working),
+	}, nil
+}
+
+// Approve applies the changes to the order and persists it. The edit is recorded in order.Edits.
+// If the order has been modified since the session was started, the upsert fails and nothing is changed.
+func (session *EditSession) Approve(comment string) (*EditResult, error) {
+	result, err := session.Preview()
+	if err != nil {
+		return nil, err
+	}
+	order := session.order
+	previousPositions := order.Positions
+	order.Positions = session.working.Positions
+	order.Edits = append(order.Edits, &Edit{
+		Id:               session.Id,
+		Comment:          comment,
+		ReferenceVersion: result.ReferenceVersion,
+		PreviousTotal:    result.PreviousTotal,
+		NewTotal:         result.NewTotal,
+		Balance:          result.Balance,
+		Changes:          result.Changes,
+		ApprovedAt:       utils.TimeNow(),
+	})
+	if err := order.Upsert(); err != nil {
+		order.Positions = previousPositions
+		order.Edits = order.Edits[:len(order.Edits)-1]
+		return nil, err
+	}
+	session.closed = true
+	return result, nil
+}
+
+// Discard closes the session without changing the order
+func (session *EditSession) Discard() {
+	session.closed = true
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON EDIT RESULT
+//------------------------------------------------------------------
+
+// IsBalanceDue returns true if the customer has to pay an additional amount
+func (result *EditResult) IsBalanceDue() bool {
+	return result.Balance > 0
+}
+
+// IsRefund returns true if the customer gets a refund
+func (result *EditResult) IsRefund() bool {
+	return result.Balance < 0
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON PRICERS
+//------------------------------------------------------------------
+
+// CalculateTotal returns the sum of all position totals
+func (pricer *PositionsPricer) CalculateTotal(o *Order) (float64, error) {
+	return getPositionsTotal(o), nil
+}
+
+// CalculateTotal returns the sum of all position totals reduced by the discounts of applicable price rules
+func (pricer *PriceRulePricer) CalculateTotal(o *Order) (float64, error) {
+	_, summary, err := pricerule.ApplyDiscounts(getArticleCollection(o), nil, o.Coupons, pricer.CheckoutAttributes, pricer.RoundTo, pricer.CustomProvider)
+	if err != nil {
+		return 0, err
+	}
+	return utils.Round(getPositionsTotal(o)-summary.TotalDiscountApplicable, 2), nil
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// DiffPositions compares the positions of two orders. Positions are matched by ItemID.
+func DiffPositions(orderA *Order, orderB *Order) []*PositionChange {
+	changes := []*PositionChange{}
+	for _, posA := range orderA.GetPositions() {
+		posB := orderB.GetPositionByItemId(posA.ItemID)
+		switch {
+		case posB == nil || (posB.Quantity == 0 && posA.Quantity > 0):
+			changes = append(changes, &PositionChange{
+				ItemID:         posA.ItemID,
+				Type:           PositionChangeRemoved,
+				QuantityBefore: posA.Quantity,
+				PriceBefore:    posA.Price,
+			})
+		case posA.Quantity != posB.Quantity:
+			changes = append(changes, &PositionChange{
+				ItemID:         posA.ItemID,
+				Type:           PositionChangeQuantityChanged,
+				QuantityBefore: posA.Quantity,
+				QuantityAfter:  posB.Quantity,
+				PriceBefore:    posA.Price,
+				PriceAfter:     posB.Price,
+			})
+		case posA.Price != posB.Price:
+			changes = append(changes, &PositionChange{
+				ItemID:         posA.ItemID,
+				Type:           PositionChangePriceChanged,
+				QuantityBefore: posA.Quantity,
+				QuantityAfter:  posB.Quantity,
+				PriceBefore:    posA.Price,
+				PriceAfter:     posB.Price,
+			})
+		}
+	}
+	for _, posB := range orderB.GetPositions() {
+		if orderA.GetPositionByItemId(posB.ItemID) == nil {
+			changes = append(changes, &PositionChange{
+				ItemID:        posB.ItemID,
+				Type:          PositionChangeAdded,
+				QuantityAfter: posB.Quantity,
+				PriceAfter:    posB.Price,
+			})
+		}
+	}
+	return changes
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func (session *EditSession) checkOpen() error {
+	if session.closed {
+		return errors.New("edit session " + session.Id + " has already been closed")
+	}
+	return nil
+}
+
+// validate makes sure that already shipped quantities are not removed
+func (session *EditSession) validate() error {
+	for _, shipment := range session.working.Shipments {
+		for _, item := range shipment.Items {
+			pos := session.working.GetPositionByItemId(item.ItemID)
+			shippedQuantity := session.working.GetShippedQuantity(item.ItemID)
+			if pos == nil || pos.Quantity+quantityEpsilon < shippedQuantity {
+				return fmt.Errorf("quantity of %q must not be lower than the shipped quantity %v", item.ItemID, shippedQuantity)
+			}
+		}
+	}
+	return nil
+}
+
+// isEditable returns true if order has been confirmed and has neither been completed nor canceled
+func isEditable(o *Order) bool {
+	if o.GetState() == nil {
+		return false
+	}
+	switch o.GetState().Key {
+	case OrderStatusConfirmed, OrderStatusTransmitted, OrderStatusInProgress, OrderStatusPartiallyShipped:
+		return true
+	}
+	return false
+}
+
+func getPositionsTotal(o *Order) float64 {
+	total := 0.0
+	for _, pos := range o.GetPositions() {
+		total += pos.GetPriceTotal()
+	}
+	return utils.Round(total, 2)
+}
+
+// copyOrder returns a deep copy of o
+func copyOrder(o *Order, customProvider OrderCustomProvider) (*Order, error) {
+	data, err := bson.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	orderCopy := &Order{}
+	if err := bson.Unmarshal(data, orderCopy); err != nil {
+		return nil, err
+	}
+	if customProvider != nil {
+		return mapDecode(orderCopy, customProvider)
+	}
+	return orderCopy, nil
+}
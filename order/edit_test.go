@@ -0,0 +1,63 @@
+package order
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/version"
+)
+
+func TestEditSessionBalance(t *testing.T) {
+	order := newConfirmedTestOrder(t)
+	order.Version = version.NewVersion()
+	order.GetPositionByItemId("shirt").Price = 20
+	order.GetPositionByItemId("shoes").Price = 100
+	order.GetPositionByItemId("shipping").Price = 9
+	referenceOrder, err := copyOrder(order, nil)
+	assert.NoError(t, err)
+
+	session, err := NewEditSession(order, referenceOrder, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, session.SetPositionQuantity("shirt", 1))
+	assert.NoError(t, session.RemovePosition("shoes"))
+	assert.NoError(t, session.AddPosition(&Position{ItemID: "hat", Quantity: 1, Price: 30}))
+	assert.Error(t, session.AddPosition(&Position{ItemID: "hat", Quantity: 1, Price: 30}), "position exists")
+	assert.Equal(t, 3.0, order.GetPositionByItemId("shirt").Quantity, "order is untouched before approval")
+
+	result, err := session.Preview()
+	assert.NoError(t, err)
+	assert.Equal(t, 169.0, result.PreviousTotal)
+	assert.Equal(t, 59.0, result.NewTotal)
+	assert.Equal(t, -110.0, result.Balance)
+	assert.True(t, result.IsRefund())
+	assert.Len(t, result.Changes, 3)
+
+	result, err = session.Approve("customer called")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, order.GetPositionByItemId("shirt").Quantity)
+	assert.Nil(t, order.GetPositionByItemId("shoes"))
+	assert.Len(t, order.Edits, 1)
+	assert.Equal(t, -110.0, order.Edits[0].Balance)
+
+	assert.Error(t, session.SetPositionQuantity("shirt", 2), "session is closed")
+}
+
+func TestEditSessionShippedQuantity(t *testing.T) {
+	order := newConfirmedTestOrder(t)
+	order.Version = version.NewVersion()
+	shipment := NewShipment("post", "1")
+	shipment.AddItem("shirt", 2)
+	assert.NoError(t, order.AddShipment(shipment))
+
+	session, err := NewEditSession(order, order, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, session.SetPositionQuantity("shirt", 1))
+	_, err = session.Preview()
+	assert.Error(t, err, "shipped quantity must not be removed")
+	session.Discard()
+
+	assert.NoError(t, order.ForceState(nil, OrderStatusComplete))
+	_, err = NewEditSession(order, order, nil, nil)
+	assert.Error(t, err, "completed orders cannot be edited")
+}
@@ -0,0 +1,78 @@
+package order
+
+import (
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/crypto"
+)
+
+// The email and the addrKey in the customer data of an order are encrypted, if crypto.SetFieldEncryption has been called.
+// Addresses are encrypted by the address package. Query encrypted fields with crypto.EqualityQuery.
+
+// bsonKindNull is the bson type of null values
+const bsonKindNull = 0x0A
+
+type customerDataFields CustomerData
+type orderHistoryDeltaFields orderHistoryDelta
+
+// customerDataDocument is the stored representation of encrypted customer data
+type customerDataDocument struct {
+	customerDataFields `bson:",inline"`
+	AddrKeyIndex       string `bson:"addrkeyindex,omitempty"`
+	EmailIndex         string `bson:"emailindex,omitempty"`
+}
+
+// GetBSON implements bson.Getter
+func (customerData *CustomerData) GetBSON() (interface{}, error) {
+	if customerData == nil {
+		return nil, nil
+	}
+	if crypto.GetFieldEncryption() == nil {
+		return (*customerDataFields)(customerData), nil
+	}
+	document := &customerDataDocument{
+		customerDataFields: customerDataFields(*customerData),
+		AddrKeyIndex:       crypto.BlindIndex(KeyAddrKey, customerData.AddrKey),
+		EmailIndex:         crypto.BlindIndex("email", customerData.Email),
+	}
+	err := crypto.EncryptFields(&document.AddrKey, &document.Email)
+	return document, err
+}
+
+// SetBSON implements bson.Setter
+func (customerData *CustomerData) SetBSON(raw bson.Raw) error {
+	if raw.Kind == bsonKindNull {
+		return bson.SetZero
+	}
+	document := &customerDataDocument{}
+	if err := raw.Unmarshal(document); err != nil {
+		return err
+	}
+	if err := crypto.DecryptFields(&document.AddrKey, &document.Email); err != nil {
+		return err
+	}
+	*customerData = CustomerData(document.customerDataFields)
+	return nil
+}
+
+// GetBSON implements bson.Getter. Patches of the customer data contain personal data, so the whole patch is encrypted.
+func (delta *orderHistoryDelta) GetBSON() (interface{}, error) {
+	if delta == nil {
+		return nil, nil
+	}
+	document := orderHistoryDeltaFields(*delta)
+	err := crypto.EncryptFields(&document.Patch)
+	return &document, err
+}
+
+// SetBSON implements bson.Setter
+func (delta *orderHistoryDelta) SetBSON(raw bson.Raw) error {
+	if raw.Kind == bsonKindNull {
+		return bson.SetZero
+	}
+	document := (*orderHistoryDeltaFields)(delta)
+	if err := raw.Unmarshal(document); err != nil {
+		return err
+	}
+	return crypto.DecryptFields(&document.Patch)
+}
@@ -0,0 +1,70 @@
+package order
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/crypto"
+)
+
+func enableTestFieldEncryption(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "shop-keys")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "keys.json")
+	assert.NoError(t, crypto.GenerateKeyFile(path, "k1"))
+	keys, err := crypto.NewFileKeyProvider(path)
+	assert.NoError(t, err)
+	crypto.SetFieldEncryption(crypto.NewFieldEncryption(keys))
+	return func() {
+		crypto.SetFieldEncryption(nil)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCustomerDataFieldEncryption(t *testing.T) {
+	defer enableTestFieldEncryption(t)()
+
+	o := &Order{
+		Id: "order",
+		CustomerData: &CustomerData{
+			CustomerId: "customer",
+			AddrKey:    "alice-addrkey",
+			Email:      "alice@example.com",
+			BillingAddress: &address.Address{
+				Id:     "billing",
+				Person: &address.Person{FirstName: "Alice", LastName: "Smith", Contacts: map[string]*address.Contact{}, DefaultContacts: map[address.ContactType]string{}},
+				Street: "Bahnhofstrasse", ZIP: "8001", City: "Zurich", CountryCode: "CH",
+			},
+		},
+	}
+	data, err := bson.Marshal(o)
+	assert.NoError(t, err)
+	for _, plaintext := range []string{"alice", "Alice", "Bahnhofstrasse", "Zurich"} {
+		assert.NotContains(t, string(data), plaintext)
+	}
+	assert.Contains(t, string(data), "customer", "customer id is not encrypted")
+
+	document := bson.M{}
+	assert.NoError(t, bson.Unmarshal(data, &document))
+	customerData := document["customerdata"].(bson.M)
+	assert.Equal(t, crypto.BlindIndex("email", "alice@example.com"), customerData["emailindex"])
+	assert.Equal(t, crypto.BlindIndex(KeyAddrKey, "alice-addrkey"), customerData["addrkeyindex"])
+
+	decoded := &Order{}
+	assert.NoError(t, bson.Unmarshal(data, decoded))
+	assert.Equal(t, o.CustomerData, decoded.CustomerData)
+
+	entry := &orderHistoryDeltaEntry{Id: "order", Delta: &orderHistoryDelta{BaseVersion: 1, Patch: `[{"op":"replace","path":"/CustomerData/Email","value":"alice@example.com"}]`}}
+	data, err = bson.Marshal(entry)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "alice", "history patches are encrypted")
+	decodedEntry := &orderHistoryDeltaEntry{}
+	assert.NoError(t, bson.Unmarshal(data, decodedEntry))
+	assert.Equal(t, entry.Delta, decodedEntry.Delta)
+}
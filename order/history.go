@@ -0,0 +1,295 @@
+package order
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/version"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// DefaultHistorySnapshotInterval is used if HistoryRetentionPolicy.SnapshotInterval is not set
+const DefaultHistorySnapshotInterval = 10
+
+// historyRetentionPolicy is applied whenever a new version is stored in orders_history. If nil, all versions are kept as full copies.
+var historyRetentionPolicy *HistoryRetentionPolicy
+
+// historyDeltaDiffOptions are used to compute deltas. The mongo id of history entries is not part of a delta.
+var historyDeltaDiffOptions = &version.DiffOptions{
+	ArrayKeys:   OrderDiffOptions.ArrayKeys,
+	IgnorePaths: []string{"BsonId"},
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// HistoryRetentionPolicy defines which versions of an order are kept in orders_history.
+// The latest version and the reference version of an order are always kept.
+// If neither KeepLatest nor DailySnapshotsOlderThan is set, all versions are kept.
+type HistoryRetentionPolicy struct {
+	KeepLatest              int           // number of latest versions which are kept
+	KeepAfterConfirmation   bool          // if true, all versions of a confirmed order are kept
+	DailySnapshotsOlderThan time.Duration // versions younger than this are kept, older versions are reduced to the last version of each day
+	StoreDeltas             bool          // if true, versions are stored as JSON Patch against the latest full copy
+	SnapshotInterval        int           // if StoreDeltas, a full copy is stored every SnapshotInterval versions
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE TYPES
+//------------------------------------------------------------------
+
+// orderHistoryDeltaEntry is stored in orders_history instead of a full copy of the order
+type orderHistoryDeltaEntry struct {
+	BsonId           bson.ObjectId `bson:"_id,omitempty"`
+	Id               string
+	Version          *version.Version
+	ReferenceVersion int
+	ConfirmedAt      time.Time
+	Delta            *orderHistoryDelta
+}
+
+type orderHistoryDelta struct {
+	BaseVersion int    // version of the full copy the patch applies to
+	Patch       string // JSON encoded RFC 6902 JSON Patch
+}
+
+// historyEntryInfo is the part of a history entry which is required to apply the retention policy
+type historyEntryInfo struct {
+	BsonId           bson.ObjectId `bson:"_id,omitempty"`
+	Version          *version.Version
+	ReferenceVersion int
+	ConfirmedAt      time.Time
+	Delta            *orderHistoryDelta
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// SetHistoryRetentionPolicy sets the policy which is applied whenever an order is upserted. nil disables pruning and deltas.
+func SetHistoryRetentionPolicy(policy *HistoryRetentionPolicy) {
+	historyRetentionPolicy = policy
+}
+
+// GetHistoryRetentionPolicy returns the current policy or nil
+func GetHistoryRetentionPolicy() *HistoryRetentionPolicy {
+	return historyRetentionPolicy
+}
+
+// CompactOrderHistory removes all versions of the order from orders_history which are not retained by policy
+// and returns the number of removed versions.
+func CompactOrderHistory(orderId string, policy *HistoryRetentionPolicy) (int, error) {
+	if policy == nil {
+		return 0, nil
+	}
+	session, collection := GetOrderVersionsPersistor().GetCollection()
+	defer session.Close()
+
+	entries := []*historyEntryInfo{}
+	err := collection.Find(&bson.M{"id": orderId}).Select(&bson.M{"version": 1, "referenceversion": 1, "confirmedat": 1, "delta.baseversion": 1}).Sort("version.current").All(&entries)
+	if err != nil {
+		return 0, err
+	}
+	ids := selectHistoryEntriesToRemove(entries, policy, time.Now())
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	info, err := collection.RemoveAll(&bson.M{"_id": &bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+// CompactAllOrderHistories applies CompactOrderHistory on every order and returns the total number of removed versions
+func CompactAllOrderHistories(policy *HistoryRetentionPolicy) (int, error) {
+	if policy == nil {
+		return 0, nil
+	}
+	session, collection := GetOrderVersionsPersistor().GetCollection()
+	defer session.Close()
+
+	var orderIds []string
+	if err := collection.Find(nil).Distinct("id", &orderIds); err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, orderId := range orderIds {
+		n, err := CompactOrderHistory(orderId, policy)
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// storeOrderHistoryEntry stores o as full copy or as delta in orders_history and applies the retention policy
+func storeOrderHistoryEntry(collection *mgo.Collection, o *Order) error {
+	policy := historyRetentionPolicy
+	if policy == nil {
+		return collection.Insert(o)
+	}
+	var err error
+	if policy.StoreDeltas {
+		err = insertOrderHistoryDelta(collection, o, policy)
+	} else {
+		err = collection.Insert(o)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = CompactOrderHistory(o.GetID(), policy)
+	return err
+}
+
+// insertOrderHistoryDelta stores a delta against the latest full copy or a new full copy if the snapshot interval has been reached
+func insertOrderHistoryDelta(collection *mgo.Collection, o *Order, policy *HistoryRetentionPolicy) error {
+	interval := policy.SnapshotInterval
+	if interval <= 0 {
+		interval = DefaultHistorySnapshotInterval
+	}
+	base := &Order{}
+	err := collection.Find(&bson.M{"id": o.GetID(), "delta": &bson.M{"$exists": false}}).Sort("-version.current").One(base)
+	if err == mgo.ErrNotFound || (err == nil && o.GetVersion().Current-base.GetVersion().Current >= interval) {
+		return collection.Insert(o)
+	}
+	if err != nil {
+		return err
+	}
+	diff, err := version.DiffVersionsStructured(base, o, historyDeltaDiffOptions)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(diff.Patch)
+	if err != nil {
+		return err
+	}
+	return collection.Insert(&orderHistoryDeltaEntry{
+		Id:               o.GetID(),
+		Version:          o.GetVersion(),
+		ReferenceVersion: o.GetReferenceVersion(),
+		ConfirmedAt:      o.ConfirmedAt,
+		Delta: &orderHistoryDelta{
+			BaseVersion: base.GetVersion().Current,
+			Patch:       string(patch),
+		},
+	})
+}
+
+// findOneOrderFromHistory returns one version of an order from orders_history. Deltas are resolved against their full copy.
+func findOneOrderFromHistory(find *bson.M, sort string, customProvider OrderCustomProvider) (*Order, error) {
+	session, collection := GetOrderVersionsPersistor().GetCollection()
+	defer session.Close()
+
+	query := collection.Find(find)
+	if sort != "" {
+		query = query.Sort(sort)
+	}
+	raw := bson.Raw{}
+	if err := query.One(&raw); err != nil {
+		return nil, err
+	}
+	entry := &orderHistoryDeltaEntry{}
+	if err := raw.Unmarshal(entry); err != nil {
+		return nil, err
+	}
+
+	order := &Order{}
+	if entry.Delta == nil {
+		if err := raw.Unmarshal(order); err != nil {
+			return nil, err
+		}
+	} else {
+		base := &Order{}
+		err := collection.Find(&bson.M{"id": entry.Id, "version.current": entry.Delta.BaseVersion, "delta": &bson.M{"$exists": false}}).One(base)
+		if err != nil {
+			return nil, err
+		}
+		patch := []*version.PatchOperation{}
+		if err := json.Unmarshal([]byte(entry.Delta.Patch), &patch); err != nil {
+			return nil, err
+		}
+		if err := version.ApplyPatch(base, patch, order); err != nil {
+			return nil, err
+		}
+		order.BsonId = entry.BsonId
+	}
+	if order.Flags == nil {
+		order.Flags = &Flags{}
+	}
+	if customProvider != nil {
+		return mapDecode(order, customProvider)
+	}
+	return order, nil
+}
+
+// selectHistoryEntriesToRemove returns the mongo ids of all entries which are not retained by policy.
+// entries must be sorted by version ascending.
+func selectHistoryEntriesToRemove(entries []*historyEntryInfo, policy *HistoryRetentionPolicy, now time.Time) []bson.ObjectId {
+	if policy == nil || len(entries) == 0 || (policy.KeepLatest <= 0 && policy.DailySnapshotsOlderThan <= 0) {
+		return []bson.ObjectId{}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Version.Current < entries[j].Version.Current
+	})
+	latest := entries[len(entries)-1]
+
+	// last version of each day, for versions older than DailySnapshotsOlderThan
+	lastOfDay := map[string]int{}
+	for i, entry := range entries {
+		lastOfDay[entry.Version.LastModifiedAt.Format("2006-01-02")] = i
+	}
+
+	keep := make([]bool, len(entries))
+	for i, entry := range entries {
+		switch {
+		case i == len(entries)-1:
+			keep[i] = true
+		case entry.Version.Current == latest.ReferenceVersion:
+			keep[i] = true
+		case policy.KeepLatest > 0 && i >= len(entries)-policy.KeepLatest:
+			keep[i] = true
+		case policy.KeepAfterConfirmation && !entry.ConfirmedAt.IsZero():
+			keep[i] = true
+		case policy.DailySnapshotsOlderThan > 0:
+			if now.Sub(entry.Version.LastModifiedAt) < policy.DailySnapshotsOlderThan {
+				keep[i] = true
+			} else if lastOfDay[entry.Version.LastModifiedAt.Format("2006-01-02")] == i {
+				keep[i] = true
+			}
+		}
+	}
+
+	// full copies which are the base of a retained delta must be kept
+	for i, entry := range entries {
+		if !keep[i] || entry.Delta == nil {
+			continue
+		}
+		for j, base := range entries {
+			if base.Delta == nil && base.Version.Current == entry.Delta.BaseVersion {
+				keep[j] = true
+			}
+		}
+	}
+
+	ids := []bson.ObjectId{}
+	for i, entry := range entries {
+		if !keep[i] {
+			ids = append(ids, entry.BsonId)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,100 @@
+package order
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/version"
+)
+
+func newTestHistoryEntries(now time.Time) []*historyEntryInfo {
+	entries := []*historyEntryInfo{}
+	// versions 1-4 ten days ago (two per day), 5-8 today
+	for i := 1; i <= 8; i++ {
+		modified := now.Add(-time.Duration(i) * time.Minute)
+		if i <= 4 {
+			modified = now.Add(-10*24*time.Hour + time.Duration((i-1)/2)*24*time.Hour + time.Duration(i)*time.Minute)
+		}
+		entries = append(entries, &historyEntryInfo{
+			BsonId:  bson.ObjectId(string(rune('a' + i))),
+			Version: &version.Version{Current: i, LastModifiedAt: modified},
+		})
+	}
+	return entries
+}
+
+func removedVersions(entries []*historyEntryInfo, ids []bson.ObjectId) []int {
+	versions := []int{}
+	for _, entry := range entries {
+		for _, id := range ids {
+			if entry.BsonId == id {
+				versions = append(versions, entry.Version.Current)
+			}
+		}
+	}
+	return versions
+}
+
+func TestHistoryRetentionKeepLatest(t *testing.T) {
+	now := time.Date(2020, 3, 20, 12, 0, 0, 0, time.UTC)
+	entries := newTestHistoryEntries(now)
+	entries[7].ReferenceVersion = 2
+
+	ids := selectHistoryEntriesToRemove(entries, &HistoryRetentionPolicy{KeepLatest: 3}, now)
+	assert.Equal(t, []int{1, 3, 4, 5}, removedVersions(entries, ids), "reference version 2 is kept")
+
+	ids = selectHistoryEntriesToRemove(entries, &HistoryRetentionPolicy{}, now)
+	assert.Empty(t, ids, "empty policy keeps everything")
+}
+
+func TestHistoryRetentionDailySnapshotsAndConfirmation(t *testing.T) {
+	now := time.Date(2020, 3, 20, 12, 0, 0, 0, time.UTC)
+	entries := newTestHistoryEntries(now)
+
+	ids := selectHistoryEntriesToRemove(entries, &HistoryRetentionPolicy{DailySnapshotsOlderThan: 24 * time.Hour}, now)
+	assert.Equal(t, []int{1, 3}, removedVersions(entries, ids), "last version of each old day is kept")
+
+	entries[0].ConfirmedAt = now
+	ids = selectHistoryEntriesToRemove(entries, &HistoryRetentionPolicy{DailySnapshotsOlderThan: 24 * time.Hour, KeepAfterConfirmation: true}, now)
+	assert.Equal(t, []int{3}, removedVersions(entries, ids))
+}
+
+func TestHistoryRetentionKeepsDeltaBase(t *testing.T) {
+	now := time.Date(2020, 3, 20, 12, 0, 0, 0, time.UTC)
+	entries := newTestHistoryEntries(now)
+	for _, entry := range entries[5:] {
+		entry.Delta = &orderHistoryDelta{BaseVersion: 5}
+	}
+	ids := selectHistoryEntriesToRemove(entries, &HistoryRetentionPolicy{KeepLatest: 2}, now)
+	assert.Equal(t, []int{1, 2, 3, 4, 6}, removedVersions(entries, ids))
+}
+
+func TestHistoryDeltaRoundTrip(t *testing.T) {
+	base := newConfirmedTestOrder(t)
+	base.Version = version.NewVersion()
+	base.BsonId = bson.NewObjectId()
+	next, err := copyOrder(base, nil)
+	assert.NoError(t, err)
+	next.BsonId = ""
+	next.Version.Increment()
+	next.GetPositionByItemId("shirt").Quantity = 1
+	next.Positions = append(next.Positions, &Position{ItemID: "hat", Quantity: 2})
+
+	diff, err := version.DiffVersionsStructured(base, next, historyDeltaDiffOptions)
+	assert.NoError(t, err)
+	data, err := json.Marshal(diff.Patch)
+	assert.NoError(t, err)
+
+	patch := []*version.PatchOperation{}
+	assert.NoError(t, json.Unmarshal(data, &patch))
+	restored := &Order{}
+	assert.NoError(t, version.ApplyPatch(base, patch, restored))
+	assert.Equal(t, next.GetVersion().Current, restored.GetVersion().Current)
+	assert.Equal(t, 1.0, restored.GetPositionByItemId("shirt").Quantity)
+	assert.Equal(t, 2.0, restored.GetPositionByItemId("hat").Quantity)
+	assert.Equal(t, base.BsonId, restored.BsonId, "mongo id is not part of the delta")
+}
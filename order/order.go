@@ -13,6 +13,7 @@ import (
 	"gopkg.in/mgo.v2/bson"
 
 	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/payment"
 	"github.com/foomo/shop/state"
 	"github.com/foomo/shop/unique"
 	"github.com/foomo/shop/utils"
@@ -84,7 +85,7 @@ type Order struct {
 	Site                                      string
 	ShopID                                    string
 	Version                                   *version.Version
-	referenceVersion                          int  // Version of final order as it was submitted by customer
+	ReferenceVersion                          int  // Version of final order as it was submitted by customer
 	unlinkDB                                  bool // if true, changes to Customer are not stored in database
 	Flags                                     *Flags
 	State                                     *state.State
@@ -101,13 +102,21 @@ type Order struct {
 	CompletedAt    time.Time
 	ATPAt          time.Time
 	Positions      []*Position
-	//	Payment          *payment.Payment
+	Shipments      []*Shipment
+	Cancellations  []*Cancellation
+	Edits          []*Edit
+	Payment        *payment.Payment `bson:",omitempty"`
+	PaymentPlan    *PaymentPlan     `bson:",omitempty"`
 	//	PriceInfo        *OrderPriceInfo
 	//	Shipping         *shipping.ShippingProperties
 	LanguageCode   LanguageCode
 	CustomProvider OrderCustomProvider
 	Coupons        []string
-	Custom         interface{} `bson:",omitempty"`
+	// DiscountsCommittedAt is set, when the discounts of Coupons and price rules have been committed with CommitDiscounts()
+	DiscountsCommittedAt time.Time
+	// PriceRuleIDs are the price rules without voucher whose usage history has been updated by CommitDiscounts()
+	PriceRuleIDs []string
+	Custom       interface{} `bson:",omitempty"`
 }
 
 type CustomerData struct {
@@ -157,6 +166,8 @@ type Position struct {
 	RawCrossPrice float64
 	RawPrice      float64
 	IsATPApplied  bool
+	DeliveryDate  time.Time // confirmed by ATP
+	PromisedDate  time.Time // earliest delivery date estimated before ATP
 	IsShipping    bool
 	Refund        bool
 	Custom        interface{}
@@ -416,6 +427,14 @@ func (position *Position) GetState() *state.State {
 	return position.State
 }
 
+// GetStateKey returns the key of the current state or an empty string if no state is set
+func (position *Position) GetStateKey() string {
+	if position.State == nil {
+		return ""
+	}
+	return position.State.Key
+}
+
 func (position *Position) SetInitialState(stateMachine *state.StateMachine) {
 	position.State = stateMachine.GetInitialState()
 }
@@ -461,3 +480,39 @@ func DiffOrderVersions(orderId string, versionA int, versionB int, customProvide
 	}
 	return html, err
 }
+
+// OrderDiffOptions define how orders are compared by DiffOrdersStructured.
+// Positions and other lists are matched by their id rather than by their index.
+var OrderDiffOptions = &version.DiffOptions{
+	ArrayKeys: map[string]string{
+		"Positions":           "ItemID",
+		"Shipments":           "Id",
+		"Shipments.Items":     "ItemID",
+		"Cancellations":       "Id",
+		"Cancellations.Items": "ItemID",
+		"Edits":               "Id",
+		"Edits.Changes":       "ItemID",
+	},
+}
+
+// DiffOrderVersionsStructured compares two versions of an order and returns a JSON Patch and a list of field level changes,
+// e.g. Positions[itemID=X].Quantity
+func DiffOrderVersionsStructured(orderId string, versionA int, versionB int, customProvider OrderCustomProvider) (*version.StructuredDiff, error) {
+	if versionA <= 0 || versionB <= 0 {
+		return nil, errors.New("Error: Version must be greater than 0")
+	}
+	orderVersionA, err := GetOrderByVersion(orderId, versionA, customProvider)
+	if err != nil {
+		return nil, err
+	}
+	orderVersionB, err := GetOrderByVersion(orderId, versionB, customProvider)
+	if err != nil {
+		return nil, err
+	}
+	return DiffOrdersStructured(orderVersionA, orderVersionB)
+}
+
+// DiffOrdersStructured compares two orders, @see DiffOrderVersionsStructured
+func DiffOrdersStructured(orderA *Order, orderB *Order) (*version.StructuredDiff, error) {
+	return version.DiffVersionsStructured(orderA, orderB, OrderDiffOptions)
+}
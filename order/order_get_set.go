@@ -25,13 +25,13 @@ func (order *Order) GetVersion() *version.Version {
 	return order.Version
 }
 func (order *Order) GetReferenceVersion() int {
-	return order.referenceVersion
+	return order.ReferenceVersion
 }
 func (order *Order) SetReferenceVersion() error {
-	if order.referenceVersion != 0 {
+	if order.ReferenceVersion != 0 {
 		return errors.New("Reference version has already been set and cannot be overridden!")
 	}
-	order.referenceVersion = order.Version.Current
+	order.ReferenceVersion = order.Version.Current
 	return nil
 }
 
@@ -0,0 +1,45 @@
+package order
+
+import (
+	"errors"
+
+	"github.com/foomo/shop/payment"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// GetPaymentProcessor returns the payment processor which selects the payment provider of the order
+func (order *Order) GetPaymentProcessor() (PaymentProcessor, error) {
+	if order.Processing == nil || order.Processing.PaymentProcessor == "" {
+		return "", errors.New("order " + order.GetID() + " has no payment processor")
+	}
+	return order.Processing.PaymentProcessor, nil
+}
+
+// AuthorizePayment authorizes amount with the provider registered for the payment processor of the order.
+// custom is passed to the provider, e.g. a card token.
+func (order *Order) AuthorizePayment(service *payment.Service, amount float64, currency string, idempotencyKey string, custom interface{}) (*payment.Transaction, error) {
+	processor, err := order.GetPaymentProcessor()
+	if err != nil {
+		return nil, err
+	}
+	return service.Authorize(string(processor), &payment.AuthorizationRequest{
+		OrderId:        order.GetID(),
+		Amount:         amount,
+		Currency:       currency,
+		IdempotencyKey: idempotencyKey,
+		Custom:         custom,
+	})
+}
+
+// GetPaymentTransactions returns the payment transaction ledger of the order
+func (order *Order) GetPaymentTransactions(service *payment.Service) ([]*payment.Transaction, error) {
+	return service.GetTransactions(order.GetID())
+}
+
+// GetPaymentSummary sums up the successful payment transactions of the order
+func (order *Order) GetPaymentSummary(service *payment.Service) (*payment.OrderPaymentSummary, error) {
+	return service.GetSummary(order.GetID())
+}
@@ -0,0 +1,469 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/foomo/shop/balance"
+	"github.com/foomo/shop/payment"
+	"github.com/foomo/shop/pricerule"
+	"github.com/foomo/shop/unique"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	TenderTypeBonusVoucher TenderType = "bonusVoucher"
+	TenderTypeGiftCard     TenderType = "giftCard"
+	TenderTypeExternal     TenderType = "external" // payment through a payment.PaymentProvider, covers the remaining balance
+
+	TenderStatusPlanned  TenderStatus = "planned"
+	TenderStatusRedeemed TenderStatus = "redeemed"
+	TenderStatusFailed   TenderStatus = "failed"
+	TenderStatusRefunded TenderStatus = "refunded"
+)
+
+// amountEpsilon is used to compare amounts of a payment plan
+const amountEpsilon = 0.001
+
+// tenderPriority defines the order in which tenders are redeemed
+var tenderPriority = map[TenderType]int{
+	TenderTypeBonusVoucher: 0,
+	TenderTypeGiftCard:     1,
+	TenderTypeExternal:     2,
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type TenderType string
+type TenderStatus string
+
+// PaymentPlan allocates the grand total of an order across several tenders
+type PaymentPlan struct {
+	GrandTotal float64
+	Currency   string
+	Tenders    []*Tender
+	CreatedAt  time.Time
+	RedeemedAt time.Time // set when all tenders have been redeemed
+	RefundedAt time.Time // set when all tenders have been refunded
+}
+
+// Tender is a part of a payment plan
+type Tender struct {
+	Id            string
+	Type          TenderType
+	Reference     string // voucher code, gift card code or payment processor
+	Amount        float64
+	Status        TenderStatus
+	TransactionId string // reference of the redemption, e.g. the payment transaction of an external tender
+	Attempt       int    // number of redemptions, a retry after a refund must not be mistaken for a repetition of the refunded redemption
+	Message       string
+	RedeemedAt    time.Time
+	RefundedAt    time.Time
+}
+
+// TenderHandler redeems and refunds tenders of one type. Redeem and Refund may set tender.TransactionId.
+type TenderHandler interface {
+	// GetAvailableAmount returns the maximum amount tender may cover
+	GetAvailableAmount(order *Order, tender *Tender) (float64, error)
+	Redeem(order *Order, tender *Tender) error
+	Refund(order *Order, tender *Tender) error
+}
+
+// PartialTenderHandler is implemented by handlers of tenders which may cover less than their available amount,
+// e.g. bonus vouchers which allow partial redemption
+type PartialTenderHandler interface {
+	AllowsPartialRedemption(order *Order, tender *Tender) bool
+}
+
+// TenderHandlers maps tender types to their handlers
+type TenderHandlers map[TenderType]TenderHandler
+
+// TenderError is a validation error of a tender
+type TenderError struct {
+	Tender  *Tender
+	Message string
+}
+
+// BonusVoucherTenderHandler redeems bonus vouchers of package pricerule. A bonus voucher covers its full value,
+// unless its price rule allows partial redemption.
+type BonusVoucherTenderHandler struct{}
+
+// GiftCardTenderHandler debits gift cards of package balance. Tender.Reference is the gift card code.
+type GiftCardTenderHandler struct {
+	Ledger *balance.Ledger
+}
+
+// ExternalTenderHandler authorizes the remaining balance with the payment provider of Tender.Reference
+type ExternalTenderHandler struct {
+	Service *payment.Service
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON PAYMENT PLAN
+//------------------------------------------------------------------
+
+func (e *TenderError) Error() string {
+	return fmt.Sprintf("tender %s %s: %s", e.Tender.Type, e.Tender.Reference, e.Message)
+}
+
+// AddTender adds a tender to the plan
+func (plan *PaymentPlan) AddTender(tenderType TenderType, reference string, amount float64) *Tender {
+	tender := &Tender{
+		Id:        unique.GetNewID(),
+		Type:      tenderType,
+		Reference: reference,
+		Amount:    amount,
+		Status:    TenderStatusPlanned,
+	}
+	plan.Tenders = append(plan.Tenders, tender)
+	return tender
+}
+
+// GetAllocatedAmount returns the sum of all tenders
+func (plan *PaymentPlan) GetAllocatedAmount() float64 {
+	sum := 0.0
+	for _, tender := range plan.Tenders {
+		sum += tender.Amount
+	}
+	return sum
+}
+
+// GetRemainingAmount returns the part of the grand total which is not allocated to a tender
+func (plan *PaymentPlan) GetRemainingAmount() float64 {
+	return plan.GrandTotal - plan.GetAllocatedAmount()
+}
+
+// IsRedeemed returns true if all tenders have been redeemed
+func (plan *PaymentPlan) IsRedeemed() bool {
+	return !plan.RedeemedAt.IsZero()
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON ORDER
+//------------------------------------------------------------------
+
+// NewPaymentPlan creates an empty payment plan for grandTotal and sets it on the order
+func (order *Order) NewPaymentPlan(grandTotal float64, currency string) (*PaymentPlan, error) {
+	if order.PaymentPlan != nil && order.PaymentPlan.hasRedeemedTenders() {
+		return nil, errors.New("payment plan of order " + order.GetID() + " has redeemed tenders")
+	}
+	order.PaymentPlan = &PaymentPlan{
+		GrandTotal: grandTotal,
+		Currency:   currency,
+		Tenders:    []*Tender{},
+		CreatedAt:  time.Now(),
+	}
+	return order.PaymentPlan, nil
+}
+
+// ValidatePaymentPlan checks that the tenders cover exactly the grand total, that each tender is available
+// with its amount and that an external tender covers the remaining balance
+func (order *Order) ValidatePaymentPlan(handlers TenderHandlers) error {
+	plan := order.PaymentPlan
+	if plan == nil {
+		return errors.New("order " + order.GetID() + " has no payment plan")
+	}
+	var result *multierror.Error
+	if plan.GrandTotal < 0 {
+		result = multierror.Append(result, errors.New("grand total must not be negative"))
+	}
+	seen := map[string]bool{}
+	// a code applied as a coupon already discounts the order and must not pay for it as well
+	coupons := map[string]bool{}
+	for _, coupon := range order.Coupons {
+		coupons[coupon] = true
+	}
+	remaining := plan.GrandTotal
+	for i, tender := range plan.Tenders {
+		handler, ok := handlers[tender.Type]
+		switch {
+		case !ok:
+			result = multierror.Append(result, &TenderError{tender, "no handler for tender type"})
+			continue
+		case tender.Amount <= 0:
+			result = multierror.Append(result, &TenderError{tender, "amount must be positive"})
+			continue
+		case tender.Type != TenderTypeExternal && coupons[tender.Reference]:
+			result = multierror.Append(result, &TenderError{tender, "code is applied as a coupon"})
+			continue
+		case seen[string(tender.Type)+tender.Reference]:
+			result = multierror.Append(result, &TenderError{tender, "tender is used twice"})
+			continue
+		case tender.Type == TenderTypeExternal && i != len(plan.Tenders)-1:
+			result = multierror.Append(result, &TenderError{tender, "external payment must be the last tender"})
+			continue
+		}
+		seen[string(tender.Type)+tender.Reference] = true
+
+		available, err := handler.GetAvailableAmount(order, tender)
+		if err != nil {
+			result = multierror.Append(result, &TenderError{tender, err.Error()})
+			continue
+		}
+		partial := false
+		if partialHandler, ok := handler.(PartialTenderHandler); ok {
+			partial = partialHandler.AllowsPartialRedemption(order, tender)
+		}
+		// bonus vouchers cannot be redeemed partially, unless their price rule allows it
+		if tender.Type == TenderTypeBonusVoucher && !partial && available > remaining+amountEpsilon {
+			result = multierror.Append(result, &TenderError{tender, string(pricerule.ValidationPriceRuleBonusValueTooHigh)})
+			continue
+		}
+		if tender.Type == TenderTypeBonusVoucher && !partial && math.Abs(tender.Amount-available) > amountEpsilon {
+			result = multierror.Append(result, &TenderError{tender, fmt.Sprintf("bonus voucher must cover its full value %.2f", available)})
+			continue
+		}
+		if tender.Amount > available+amountEpsilon {
+			result = multierror.Append(result, &TenderError{tender, fmt.Sprintf("amount %.2f exceeds available amount %.2f", tender.Amount, available)})
+		}
+		remaining -= tender.Amount
+	}
+	if math.Abs(plan.GetRemainingAmount()) > amountEpsilon {
+		result = multierror.Append(result, fmt.Errorf("tenders cover %.2f of grand total %.2f", plan.GetAllocatedAmount(), plan.GrandTotal))
+	}
+	return result.ErrorOrNil()
+}
+
+// RedeemPaymentPlan validates the payment plan and redeems its tenders: bonus vouchers first, then gift cards, then the external payment.
+// If a tender fails, all tenders redeemed so far are refunded, so that either all or no tenders are redeemed.
+// The plan is stored after each step.
+func (order *Order) RedeemPaymentPlan(handlers TenderHandlers) error {
+	if err := order.ValidatePaymentPlan(handlers); err != nil {
+		return err
+	}
+	plan := order.PaymentPlan
+	if plan.IsRedeemed() {
+		return nil
+	}
+	for _, tender := range plan.getTendersByPriority() {
+		if tender.Status == TenderStatusRedeemed {
+			continue
+		}
+		// the attempt is only stored with the result of the redemption, a redemption whose result has not been stored is repeated
+		tender.Attempt++
+		if err := handlers[tender.Type].Redeem(order, tender); err != nil {
+			tender.Status = TenderStatusFailed
+			tender.Message = err.Error()
+			return order.rollbackPaymentPlan(handlers, err)
+		}
+		tender.Status = TenderStatusRedeemed
+		tender.RedeemedAt = time.Now()
+		tender.Message = ""
+		if err := order.Upsert(); err != nil {
+			// the redemption is not stored, the plan would not reflect the redeemed amount
+			return order.rollbackPaymentPlan(handlers, err)
+		}
+	}
+	plan.RedeemedAt = time.Now()
+	return order.Upsert()
+}
+
+// RefundPaymentPlan refunds all redeemed tenders of the payment plan in reverse order, e.g. when an order is canceled
+func (order *Order) RefundPaymentPlan(handlers TenderHandlers) error {
+	if order.PaymentPlan == nil {
+		return errors.New("order " + order.GetID() + " has no payment plan")
+	}
+	if err := order.refundTenders(handlers); err != nil {
+		return err
+	}
+	order.PaymentPlan.RefundedAt = time.Now()
+	return order.Upsert()
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON TENDER HANDLERS
+//------------------------------------------------------------------
+
+func (h *BonusVoucherTenderHandler) GetAvailableAmount(order *Order, tender *Tender) (float64, error) {
+	voucher, priceRule, err := pricerule.GetVoucherAndPriceRule(tender.Reference, nil)
+	if err != nil {
+		return 0, errors.New(string(pricerule.ValidationVoucherUnknown))
+	}
+	if priceRule.Type != pricerule.TypeBonusVoucher {
+		return 0, errors.New("voucher is not a bonus voucher")
+	}
+	if len(voucher.CustomerID) > 0 && voucher.CustomerID != order.GetCustomerId() {
+		return 0, errors.New(string(pricerule.ValidationVoucherPersonalized))
+	}
+	if !voucher.TimeRedeemed.IsZero() && tender.Status != TenderStatusRedeemed {
+		return 0, errors.New(string(pricerule.ValidationVoucherAlreadyUsed))
+	}
+	now := time.Now()
+	if now.After(priceRule.ValidTo) {
+		return 0, errors.New(string(pricerule.ValidationPriceRuleExpired))
+	}
+	if now.Before(priceRule.ValidFrom) {
+		return 0, errors.New(string(pricerule.ValidationPriceRuleNotValidYet))
+	}
+	if priceRule.AllowPartialRedemption && tender.Status != TenderStatusRedeemed {
+		return voucher.RemainingAmount, nil
+	}
+	return priceRule.Amount, nil
+}
+
+func (h *BonusVoucherTenderHandler) AllowsPartialRedemption(order *Order, tender *Tender) bool {
+	_, priceRule, err := pricerule.GetVoucherAndPriceRule(tender.Reference, nil)
+	return err == nil && priceRule.AllowPartialRedemption
+}
+
+func (h *BonusVoucherTenderHandler) Redeem(order *Order, tender *Tender) error {
+	voucher, priceRule, err := pricerule.GetVoucherAndPriceRule(tender.Reference, nil)
+	if err != nil {
+		return err
+	}
+	tender.TransactionId = voucher.ID
+	if priceRule.AllowPartialRedemption {
+		return voucher.RedeemPartially(order.GetCustomerId(), order.GetID(), tender.Amount, priceRule)
+	}
+	return voucher.Redeem(order.GetCustomerId())
+}
+
+func (h *BonusVoucherTenderHandler) Refund(order *Order, tender *Tender) error {
+	return pricerule.RevertVoucherRedemptionByCode(tender.Reference, order.GetCustomerId(), order.GetID())
+}
+
+func (h *GiftCardTenderHandler) GetAvailableAmount(order *Order, tender *Tender) (float64, error) {
+	account, err := h.Ledger.GetGiftCard(tender.Reference)
+	if err != nil {
+		return 0, err
+	}
+	if order.PaymentPlan.Currency != "" && account.Currency != order.PaymentPlan.Currency {
+		return 0, errors.New("gift card currency " + account.Currency + " does not match " + order.PaymentPlan.Currency)
+	}
+	available, err := h.Ledger.GetAvailableBalance(account.Id)
+	if err != nil {
+		return 0, err
+	}
+	// an already redeemed tender is part of the available amount
+	if tender.Status == TenderStatusRedeemed {
+		available += tender.Amount
+	}
+	return available, nil
+}
+
+func (h *GiftCardTenderHandler) Redeem(order *Order, tender *Tender) error {
+	account, err := h.Ledger.GetGiftCard(tender.Reference)
+	if err != nil {
+		return err
+	}
+	tender.TransactionId = account.Id
+	_, err = h.Ledger.Debit(account.Id, tender.Amount, tenderReference(order, tender), "order "+order.GetID())
+	return err
+}
+
+func (h *GiftCardTenderHandler) Refund(order *Order, tender *Tender) error {
+	_, err := h.Ledger.CreditBack(tender.TransactionId, tenderReference(order, tender), tender.Amount, "refund")
+	return err
+}
+
+// GetAvailableAmount returns the grand total, a provider is able to cover any remaining balance
+func (h *ExternalTenderHandler) GetAvailableAmount(order *Order, tender *Tender) (float64, error) {
+	if _, err := h.Service.GetProvider(tender.Reference); err != nil {
+		return 0, err
+	}
+	return order.PaymentPlan.GrandTotal, nil
+}
+
+func (h *ExternalTenderHandler) Redeem(order *Order, tender *Tender) error {
+	transaction, err := h.Service.Authorize(tender.Reference, &payment.AuthorizationRequest{
+		OrderId:        order.GetID(),
+		Amount:         tender.Amount,
+		Currency:       order.PaymentPlan.Currency,
+		IdempotencyKey: tenderReference(order, tender),
+	})
+	if transaction != nil {
+		tender.TransactionId = transaction.Id
+	}
+	return err
+}
+
+func (h *ExternalTenderHandler) Refund(order *Order, tender *Tender) error {
+	_, err := h.Service.Reverse(tender.TransactionId, tenderReference(order, tender))
+	return err
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// rollbackPaymentPlan refunds all redeemed tenders after err and stores the plan, so that the failed attempts are not repeated on retry
+func (order *Order) rollbackPaymentPlan(handlers TenderHandlers, err error) error {
+	var result *multierror.Error
+	if refundErr := order.refundTenders(handlers); refundErr != nil {
+		result = multierror.Append(result, refundErr)
+	}
+	if upsertErr := order.Upsert(); upsertErr != nil {
+		result = multierror.Append(result, upsertErr)
+	}
+	if result != nil {
+		return multierror.Append(err, result.Errors...)
+	}
+	return err
+}
+
+// refundTenders refunds all redeemed tenders in reverse order of redemption
+func (order *Order) refundTenders(handlers TenderHandlers) error {
+	tenders := order.PaymentPlan.getTendersByPriority()
+	var result *multierror.Error
+	for i := len(tenders) - 1; i >= 0; i-- {
+		tender := tenders[i]
+		if tender.Status != TenderStatusRedeemed {
+			continue
+		}
+		handler, ok := handlers[tender.Type]
+		if !ok {
+			result = multierror.Append(result, &TenderError{tender, "no handler for tender type"})
+			continue
+		}
+		if err := handler.Refund(order, tender); err != nil {
+			tender.Message = err.Error()
+			result = multierror.Append(result, &TenderError{tender, err.Error()})
+			continue
+		}
+		tender.Status = TenderStatusRefunded
+		tender.RefundedAt = time.Now()
+		if err := order.Upsert(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func (plan *PaymentPlan) getTendersByPriority() []*Tender {
+	tenders := make([]*Tender, len(plan.Tenders))
+	copy(tenders, plan.Tenders)
+	sort.SliceStable(tenders, func(i, j int) bool {
+		return tenderPriority[tenders[i].Type] < tenderPriority[tenders[j].Type]
+	})
+	return tenders
+}
+
+// tenderReference is the reference of a gift card debit or the idempotency key of a payment transaction.
+// It is unique per attempt, so that a retry after a refund or decline is not mistaken for a repetition.
+func tenderReference(order *Order, tender *Tender) string {
+	if tender.Attempt <= 1 {
+		// tenders redeemed before attempts were counted
+		return order.GetID() + "-tender-" + tender.Id
+	}
+	return order.GetID() + "-tender-" + tender.Id + "-" + strconv.Itoa(tender.Attempt)
+}
+
+func (plan *PaymentPlan) hasRedeemedTenders() bool {
+	for _, tender := range plan.Tenders {
+		if tender.Status == TenderStatusRedeemed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package order
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/balance"
+	"github.com/foomo/shop/payment"
+	"github.com/foomo/shop/pricerule"
+)
+
+// testTenderHandler covers fixed amounts per reference
+type testTenderHandler struct {
+	available map[string]float64
+	fail      map[string]bool
+	redeemed  map[string]bool
+	partial   bool
+}
+
+func (h *testTenderHandler) AllowsPartialRedemption(order *Order, tender *Tender) bool {
+	return h.partial
+}
+
+func newTestTenderHandler(available map[string]float64) *testTenderHandler {
+	return &testTenderHandler{available: available, fail: map[string]bool{}, redeemed: map[string]bool{}}
+}
+
+func (h *testTenderHandler) GetAvailableAmount(order *Order, tender *Tender) (float64, error) {
+	available, ok := h.available[tender.Reference]
+	if !ok {
+		return 0, errors.New("unknown")
+	}
+	return available, nil
+}
+
+func (h *testTenderHandler) Redeem(order *Order, tender *Tender) error {
+	if h.fail[tender.Reference] {
+		return errors.New("redemption failed")
+	}
+	h.redeemed[tender.Reference] = true
+	return nil
+}
+
+func (h *testTenderHandler) Refund(order *Order, tender *Tender) error {
+	delete(h.redeemed, tender.Reference)
+	return nil
+}
+
+func newTestTenderHandlers() (TenderHandlers, *testTenderHandler, *testTenderHandler, *payment.Service) {
+	vouchers := newTestTenderHandler(map[string]float64{"bonus-20": 20, "bonus-500": 500})
+	giftCards := newTestTenderHandler(map[string]float64{"card-50": 50})
+	service := payment.NewService(payment.NewMemoryLedger())
+	service.RegisterProvider(string(PaymentProcessorWebshop), payment.NewFakeProvider("fake", 13))
+	return TenderHandlers{
+		TenderTypeBonusVoucher: vouchers,
+		TenderTypeGiftCard:     giftCards,
+		TenderTypeExternal:     &ExternalTenderHandler{Service: service},
+	}, vouchers, giftCards, service
+}
+
+func TestPaymentPlanValidation(t *testing.T) {
+	handlers, _, _, _ := newTestTenderHandlers()
+	order := newConfirmedTestOrder(t)
+
+	plan, err := order.NewPaymentPlan(100, "CHF")
+	assert.NoError(t, err)
+	plan.AddTender(TenderTypeBonusVoucher, "bonus-500", 500)
+	plan.AddTender(TenderTypeGiftCard, "card-50", 60)
+	err = order.ValidatePaymentPlan(handlers)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), string(pricerule.ValidationPriceRuleBonusValueTooHigh)))
+	assert.True(t, strings.Contains(err.Error(), "exceeds available amount"))
+	assert.True(t, strings.Contains(err.Error(), "tenders cover"))
+
+	plan, _ = order.NewPaymentPlan(100, "CHF")
+	plan.AddTender(TenderTypeExternal, string(PaymentProcessorWebshop), 80)
+	plan.AddTender(TenderTypeBonusVoucher, "bonus-20", 20)
+	assert.Error(t, order.ValidatePaymentPlan(handlers), "external payment must be last")
+
+	plan, _ = order.NewPaymentPlan(100, "CHF")
+	plan.AddTender(TenderTypeBonusVoucher, "bonus-20", 20)
+	plan.AddTender(TenderTypeGiftCard, "card-50", 50)
+	plan.AddTender(TenderTypeExternal, string(PaymentProcessorWebshop), 30)
+	assert.NoError(t, order.ValidatePaymentPlan(handlers))
+
+	order.Coupons = []string{"bonus-20"}
+	err = order.ValidatePaymentPlan(handlers)
+	assert.Error(t, err, "the voucher is applied as a coupon as well")
+	assert.True(t, strings.Contains(err.Error(), "code is applied as a coupon"))
+}
+
+func TestPaymentPlanPartialBonusVoucher(t *testing.T) {
+	handlers, vouchers, _, _ := newTestTenderHandlers()
+	vouchers.partial = true
+	order := newConfirmedTestOrder(t)
+
+	plan, _ := order.NewPaymentPlan(100, "CHF")
+	plan.AddTender(TenderTypeBonusVoucher, "bonus-500", 100)
+	assert.NoError(t, order.ValidatePaymentPlan(handlers), "the remaining value stays on the voucher")
+
+	plan, _ = order.NewPaymentPlan(100, "CHF")
+	plan.AddTender(TenderTypeBonusVoucher, "bonus-20", 10)
+	plan.AddTender(TenderTypeExternal, string(PaymentProcessorWebshop), 90)
+	assert.NoError(t, order.ValidatePaymentPlan(handlers))
+}
+
+func TestPaymentPlanRedeem(t *testing.T) {
+	handlers, vouchers, giftCards, service := newTestTenderHandlers()
+	order := newConfirmedTestOrder(t)
+
+	plan, _ := order.NewPaymentPlan(100, "CHF")
+	plan.AddTender(TenderTypeBonusVoucher, "bonus-20", 20)
+	plan.AddTender(TenderTypeGiftCard, "card-50", 50)
+	external := plan.AddTender(TenderTypeExternal, string(PaymentProcessorWebshop), 30)
+	assert.NoError(t, order.RedeemPaymentPlan(handlers))
+	assert.True(t, plan.IsRedeemed())
+	assert.True(t, vouchers.redeemed["bonus-20"])
+	assert.True(t, giftCards.redeemed["card-50"])
+	summary, err := order.GetPaymentSummary(service)
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, summary.Authorized)
+
+	_, err = order.NewPaymentPlan(100, "CHF")
+	assert.Error(t, err, "redeemed plan cannot be replaced")
+
+	assert.NoError(t, order.RefundPaymentPlan(handlers))
+	assert.Equal(t, TenderStatusRefunded, external.Status)
+	assert.Empty(t, vouchers.redeemed)
+	assert.Empty(t, giftCards.redeemed)
+	summary, err = order.GetPaymentSummary(service)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, summary.Authorized, "authorization has been voided")
+}
+
+func TestPaymentPlanRollback(t *testing.T) {
+	handlers, vouchers, giftCards, _ := newTestTenderHandlers()
+	order := newConfirmedTestOrder(t)
+
+	// the fake provider declines 13
+	plan, _ := order.NewPaymentPlan(83, "CHF")
+	plan.AddTender(TenderTypeBonusVoucher, "bonus-20", 20)
+	plan.AddTender(TenderTypeGiftCard, "card-50", 50)
+	external := plan.AddTender(TenderTypeExternal, string(PaymentProcessorWebshop), 13)
+	assert.Error(t, order.RedeemPaymentPlan(handlers))
+	assert.Equal(t, TenderStatusFailed, external.Status)
+	assert.Equal(t, TenderStatusRefunded, plan.Tenders[0].Status)
+	assert.Empty(t, vouchers.redeemed, "redeemed tenders are refunded")
+	assert.Empty(t, giftCards.redeemed)
+	assert.False(t, plan.IsRedeemed())
+}
+
+func TestPaymentPlanGiftCardPartialDebit(t *testing.T) {
+	ledger := balance.NewLedger(balance.NewMemoryStore())
+	card, err := ledger.IssueGiftCard("GIFT", 100, "CHF", time.Time{}, "")
+	assert.NoError(t, err)
+	handlers := TenderHandlers{TenderTypeGiftCard: &GiftCardTenderHandler{Ledger: ledger}}
+	order := newConfirmedTestOrder(t)
+
+	plan, _ := order.NewPaymentPlan(30, "CHF")
+	plan.AddTender(TenderTypeGiftCard, "GIFT", 30)
+	assert.NoError(t, order.RedeemPaymentPlan(handlers))
+	available, err := ledger.GetAvailableBalance(card.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 70.0, available, "remaining balance stays on the gift card")
+
+	assert.NoError(t, order.RefundPaymentPlan(handlers))
+	available, err = ledger.GetAvailableBalance(card.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, available)
+}
+
+func TestPaymentPlanGiftCardRetryAfterRefund(t *testing.T) {
+	ledger := balance.NewLedger(balance.NewMemoryStore())
+	card, err := ledger.IssueGiftCard("GIFT", 100, "CHF", time.Time{}, "")
+	assert.NoError(t, err)
+	external := newTestTenderHandler(map[string]float64{"psp": 100})
+	external.fail["psp"] = true
+	handlers := TenderHandlers{
+		TenderTypeGiftCard: &GiftCardTenderHandler{Ledger: ledger},
+		TenderTypeExternal: external,
+	}
+	order := newConfirmedTestOrder(t)
+
+	plan, _ := order.NewPaymentPlan(50, "CHF")
+	giftCard := plan.AddTender(TenderTypeGiftCard, "GIFT", 30)
+	plan.AddTender(TenderTypeExternal, "psp", 20)
+	assert.Error(t, order.RedeemPaymentPlan(handlers))
+	assert.Equal(t, TenderStatusRefunded, giftCard.Status)
+	available, err := ledger.GetAvailableBalance(card.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, available)
+
+	external.fail["psp"] = false
+	assert.NoError(t, order.RedeemPaymentPlan(handlers))
+	assert.Equal(t, TenderStatusRedeemed, giftCard.Status)
+	assert.Equal(t, 2, giftCard.Attempt)
+	available, err = ledger.GetAvailableBalance(card.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 70.0, available, "the retry debits the gift card again")
+}
+
+func TestPaymentPlanExternalRetryAfterDecline(t *testing.T) {
+	handlers, _, _, service := newTestTenderHandlers()
+	order := newConfirmedTestOrder(t)
+
+	// the fake provider declines 13
+	plan, _ := order.NewPaymentPlan(13, "CHF")
+	external := plan.AddTender(TenderTypeExternal, string(PaymentProcessorWebshop), 13)
+	assert.Error(t, order.RedeemPaymentPlan(handlers))
+	assert.Equal(t, TenderStatusFailed, external.Status)
+
+	plan.GrandTotal = 14
+	external.Amount = 14
+	assert.NoError(t, order.RedeemPaymentPlan(handlers), "the retry is a new payment transaction")
+	assert.Equal(t, 2, external.Attempt)
+	summary, err := order.GetPaymentSummary(service)
+	assert.NoError(t, err)
+	assert.Equal(t, 14.0, summary.Authorized)
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/foomo/shop/configuration"
+	"github.com/foomo/shop/crypto"
 	"github.com/foomo/shop/persistence"
 	"github.com/foomo/shop/version"
 	"github.com/mitchellh/mapstructure"
@@ -40,6 +41,13 @@ var (
 			Unique:     false,
 			Background: true,
 		},
+		{
+			Name:       "AddrKeyIndex",
+			Key:        []string{"customerdata." + KeyAddrKey + crypto.BlindIndexSuffix},
+			Unique:     false,
+			Sparse:     true,
+			Background: true,
+		},
 	}
 )
 
@@ -225,7 +233,7 @@ func GetOrderIdsOfCustomer(customerId string) ([]string, error) {
 }
 
 func GetCurrentOrderByIdFromVersionsHistory(orderId string, customProvider OrderCustomProvider) (*Order, error) {
-	return findOneOrder(&bson.M{"id": orderId}, nil, "-version.current", customProvider, true)
+	return findOneOrderFromHistory(&bson.M{"id": orderId}, "-version.current", customProvider)
 }
 func GetCurrentVersionOfOrderFromVersionsHistory(orderId string) (*version.Version, error) {
 	order, err := findOneOrder(&bson.M{"id": orderId}, &bson.M{"version": 1}, "-version.current", nil, true)
@@ -235,7 +243,7 @@ func GetCurrentVersionOfOrderFromVersionsHistory(orderId string) (*version.Versi
 	return order.GetVersion(), nil
 }
 func GetOrderByVersion(orderId string, version int, customProvider OrderCustomProvider) (*Order, error) {
-	return findOneOrder(&bson.M{"id": orderId, "version.current": version}, nil, "", customProvider, true)
+	return findOneOrderFromHistory(&bson.M{"id": orderId, "version.current": version}, "", customProvider)
 }
 
 func Rollback(orderId string, version int) error {
@@ -133,7 +133,7 @@ func storeOrderVersionInHistory(o *Order) error {
 	session, collection := GetOrderVersionsPersistor().GetCollection()
 	defer session.Close()
 
-	err := collection.Insert(o)
+	err := storeOrderHistoryEntry(collection, o)
 	o.BsonId = currentID
 	return err
 }
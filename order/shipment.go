@@ -0,0 +1,243 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/foomo/shop/state"
+	"github.com/foomo/shop/unique"
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+// quantityEpsilon is the tolerance used when comparing float quantities
+const quantityEpsilon = 0.000001
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Shipment is a parcel which has been sent to the customer.
+// An order may be shipped in several parcels, each containing (parts of) several positions.
+type Shipment struct {
+	Id             string
+	Carrier        string
+	TrackingNumber string
+	Status         ShipmentStatus // status of the latest tracking event, empty if there are none
+	CreatedAt      time.Time
+	ShippedAt      time.Time
+	DeliveredAt    time.Time
+	CanceledAt     time.Time // canceled shipments do not count as shipped
+	Items          []*ShipmentItem
+	TrackingEvents []*TrackingEvent
+}
+
+// ShipmentItem is the quantity of a position contained in a shipment
+type ShipmentItem struct {
+	ItemID   string
+	Quantity float64
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewShipment returns a new shipment without items. Items are added with AddItem().
+func NewShipment(carrier string, trackingNumber string) *Shipment {
+	return &Shipment{
+		Id:             unique.GetNewID(),
+		Carrier:        carrier,
+		TrackingNumber: trackingNumber,
+		CreatedAt:      utils.TimeNow(),
+		ShippedAt:      utils.TimeNow(),
+		Items:          []*ShipmentItem{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON SHIPMENT
+//------------------------------------------------------------------
+
+// AddItem adds quantity of itemID to the shipment. If the item is already part of the shipment, the quantity is accumulated.
+func (shipment *Shipment) AddItem(itemID string, quantity float64) {
+	for _, item := range shipment.Items {
+		if item.ItemID == itemID {
+			item.Quantity += quantity
+			return
+		}
+	}
+	shipment.Items = append(shipment.Items, &ShipmentItem{
+		ItemID:   itemID,
+		Quantity: quantity,
+	})
+}
+
+// IsCanceled returns true, if the shipment has been canceled before it was handed over to the carrier
+func (shipment *Shipment) IsCanceled() bool {
+	return !shipment.CanceledAt.IsZero()
+}
+
+// IsDelivered returns true, if the carrier has delivered the shipment
+func (shipment *Shipment) IsDelivered() bool {
+	return !shipment.DeliveredAt.IsZero()
+}
+
+// GetQuantity returns the quantity of itemID contained in the shipment
+func (shipment *Shipment) GetQuantity(itemID string) float64 {
+	quantity := 0.0
+	for _, item := range shipment.Items {
+		if item.ItemID == itemID {
+			quantity += item.Quantity
+		}
+	}
+	return quantity
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON ORDER
+//------------------------------------------------------------------
+
+// AddShipment validates the shipment against the ordered quantities, adds it to the order
+// and updates the states of the affected positions.
+func (order *Order) AddShipment(shipment *Shipment) error {
+	if err := order.ValidateShipment(shipment); err != nil {
+		return err
+	}
+	order.Shipments = append(order.Shipments, shipment)
+	if err := order.UpdatePositionStatesFromShipments(nil); err != nil {
+		return err
+	}
+	return order.Upsert()
+}
+
+// ValidateShipment returns an error if the shipment contains unknown items or if the shipped quantity
+// (including already existing shipments) would exceed the ordered quantity of a position.
+func (order *Order) ValidateShipment(shipment *Shipment) error {
+	if shipment == nil {
+		return errors.New("shipment is nil")
+	}
+	if len(shipment.Items) == 0 {
+		return errors.New("shipment " + shipment.Id + " does not contain any items")
+	}
+	if order.GetShipmentById(shipment.Id) != nil {
+		return errors.New("shipment " + shipment.Id + " has already been added to order " + order.GetID())
+	}
+	for _, item := range shipment.Items {
+		if item.Quantity <= 0 {
+			return fmt.Errorf("shipped quantity for %q must be greater than 0", item.ItemID)
+		}
+		pos := order.GetPositionByItemId(item.ItemID)
+		if pos == nil {
+			return fmt.Errorf("position with %q not found in order", item.ItemID)
+		}
+		shippedQuantity := order.GetShippedQuantity(item.ItemID) + shipment.GetQuantity(item.ItemID)
+		if shippedQuantity > pos.Quantity+quantityEpsilon {
+			return fmt.Errorf("shipped quantity %v for %q exceeds ordered quantity %v", shippedQuantity, item.ItemID, pos.Quantity)
+		}
+	}
+	return nil
+}
+
+// GetShipments returns all shipments of the order
+func (order *Order) GetShipments() []*Shipment {
+	return order.Shipments
+}
+
+// GetShipmentById returns the shipment with id or nil if it does not exist
+func (order *Order) GetShipmentById(id string) *Shipment {
+	for _, shipment := range order.Shipments {
+		if shipment.Id == id {
+			return shipment
+		}
+	}
+	return nil
+}
+
+// GetShippedQuantity returns the quantity of itemID summed up over all shipments which have not been canceled
+func (order *Order) GetShippedQuantity(itemID string) float64 {
+	quantity := 0.0
+	for _, shipment := range order.Shipments {
+		if shipment.IsCanceled() {
+			continue
+		}
+		quantity += shipment.GetQuantity(itemID)
+	}
+	return quantity
+}
+
+// GetOpenQuantity returns the quantity of itemID which has not been shipped yet
+func (order *Order) GetOpenQuantity(itemID string) float64 {
+	pos := order.GetPositionByItemId(itemID)
+	if pos == nil {
+		return 0
+	}
+	openQuantity := pos.Quantity - order.GetShippedQuantity(itemID)
+	if openQuantity < quantityEpsilon {
+		return 0
+	}
+	return openQuantity
+}
+
+// IsShipped returns true if all positions (except shipping costs) have been shipped completely
+func (order *Order) IsShipped() bool {
+	for _, pos := range order.Positions {
+		if pos.IsShipping {
+			continue
+		}
+		if order.GetOpenQuantity(pos.ItemID) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdatePositionStatesFromShipments derives the state of each position from the shipped and delivered quantities.
+// Positions which have not been shipped at all keep their current state, unless all their shipments have been canceled.
+// If stateMachine is nil, the default position state machine is used.
+// Changes are not persisted.
+func (order *Order) UpdatePositionStatesFromShipments(stateMachine *state.StateMachine) error {
+	if stateMachine == nil {
+		stateMachine = DefaultPositionStateMachine
+	}
+	for _, pos := range order.Positions {
+		if pos.IsShipping {
+			continue
+		}
+		shippedQuantity := order.GetShippedQuantity(pos.ItemID)
+		targetStates := []string{}
+		switch {
+		case shippedQuantity <= 0:
+			// all shipments have been canceled
+			if pos.GetStateKey() == PositionStatusPartiallyShipped || pos.GetStateKey() == PositionStatusShipped || pos.GetStateKey() == PositionStatusDelivered {
+				targetStates = append(targetStates, PositionStatusOpen)
+			}
+		case order.GetOpenQuantity(pos.ItemID) > 0:
+			targetStates = append(targetStates, PositionStatusPartiallyShipped)
+		case order.GetDeliveredQuantity(pos.ItemID) < pos.Quantity-quantityEpsilon:
+			targetStates = append(targetStates, PositionStatusShipped)
+		case pos.GetStateKey() != PositionStatusDelivered:
+			// a position is shipped before it is delivered
+			targetStates = append(targetStates, PositionStatusShipped, PositionStatusDelivered)
+		}
+		if len(targetStates) == 0 {
+			continue
+		}
+		// positions without a state or with a state unknown to the state machine start from the initial state
+		if _, ok := stateMachine.Transitions[pos.GetStateKey()]; !ok {
+			pos.SetInitialState(stateMachine)
+		}
+		for _, targetState := range targetStates {
+			if pos.State.IsState(targetState) {
+				continue
+			}
+			if err := stateMachine.TransitionToState(pos.State, targetState); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package order
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newUnlinkedTestOrder() *Order {
+	order := &Order{
+		Id:    "shipment-test",
+		Flags: &Flags{},
+		State: DefaultStateMachine.GetInitialState(),
+		Positions: []*Position{
+			{ItemID: "shirt", Quantity: 3},
+			{ItemID: "shoes", Quantity: 1},
+			{ItemID: "shipping", Quantity: 1, IsShipping: true},
+		},
+	}
+	order.UnlinkFromDB()
+	return order
+}
+
+func TestShipmentPartialFulfilment(t *testing.T) {
+	order := newUnlinkedTestOrder()
+
+	first := NewShipment("post", "99.00.123456.12345678")
+	first.AddItem("shirt", 2)
+	assert.NoError(t, order.AddShipment(first), "first shipment")
+	assert.Equal(t, PositionStatusPartiallyShipped, order.GetPositionByItemId("shirt").State.Key)
+	assert.Nil(t, order.GetPositionByItemId("shoes").State, "shoes have not been shipped")
+	assert.Equal(t, 1.0, order.GetOpenQuantity("shirt"))
+	assert.False(t, order.IsShipped())
+
+	second := NewShipment("dhl", "JD0000000001")
+	second.AddItem("shirt", 1)
+	second.AddItem("shoes", 1)
+	assert.NoError(t, order.AddShipment(second), "second shipment")
+	assert.Equal(t, PositionStatusShipped, order.GetPositionByItemId("shirt").State.Key)
+	assert.Equal(t, PositionStatusShipped, order.GetPositionByItemId("shoes").State.Key)
+	assert.Equal(t, 3.0, order.GetShippedQuantity("shirt"))
+	assert.True(t, order.IsShipped())
+	assert.Len(t, order.GetShipments(), 2)
+}
+
+func TestShipmentValidation(t *testing.T) {
+	order := newUnlinkedTestOrder()
+
+	tooMany := NewShipment("post", "1")
+	tooMany.AddItem("shirt", 4)
+	assert.Error(t, order.AddShipment(tooMany), "shipped quantity exceeds ordered quantity")
+
+	unknown := NewShipment("post", "2")
+	unknown.AddItem("hat", 1)
+	assert.Error(t, order.AddShipment(unknown), "unknown item")
+
+	empty := NewShipment("post", "3")
+	assert.Error(t, order.AddShipment(empty), "empty shipment")
+
+	ok := NewShipment("post", "4")
+	ok.AddItem("shirt", 3)
+	assert.NoError(t, order.AddShipment(ok))
+	assert.Error(t, order.AddShipment(ok), "shipment must not be added twice")
+
+	more := NewShipment("post", "5")
+	more.AddItem("shirt", 1)
+	assert.Error(t, order.AddShipment(more), "shirt has already been shipped completely")
+	assert.Len(t, order.GetShipments(), 1)
+}
+
+func TestShipmentDeliveredQuantityIncreased(t *testing.T) {
+	order := newUnlinkedTestOrder()
+
+	shipment := NewShipment("post", "99.00.123456.12345678")
+	shipment.AddItem("shirt", 3)
+	assert.NoError(t, order.AddShipment(shipment))
+	delivered := &TrackingEvent{Id: "1", Status: ShipmentStatusDelivered, OccurredAt: time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)}
+	assert.NoError(t, order.AddTrackingEvents(shipment.TrackingNumber, []*TrackingEvent{delivered}))
+	assert.Equal(t, PositionStatusDelivered, order.GetPositionByItemId("shirt").State.Key)
+
+	// the customer orders another shirt after the delivery
+	order.GetPositionByItemId("shirt").Quantity = 4
+	assert.NoError(t, order.UpdatePositionStatesFromShipments(nil))
+	assert.Equal(t, PositionStatusPartiallyShipped, order.GetPositionByItemId("shirt").State.Key)
+	assert.Equal(t, 1.0, order.GetOpenQuantity("shirt"))
+}
@@ -0,0 +1,72 @@
+package order
+
+import "github.com/foomo/shop/state"
+
+const (
+	PositionStateType              string = "PositionStatus"
+	PositionStatusOpen             string = "PositionStatusOpen"
+	PositionStatusPartiallyShipped string = "PositionStatusPartiallyShipped"
+	PositionStatusShipped          string = "PositionStatusShipped"
+	PositionStatusDelivered        string = "PositionStatusDelivered"
+	PositionStatusCanceled         string = "PositionStatusCanceled"
+	PositionStatusInvalid          string = "PositionStatusInvalid"
+)
+
+var positionTransitions = map[string][]string{
+	PositionStatusInvalid:          []string{state.WILDCARD},
+	PositionStatusOpen:             []string{PositionStatusPartiallyShipped, PositionStatusShipped, PositionStatusCanceled, PositionStatusInvalid},
+	PositionStatusPartiallyShipped: []string{PositionStatusShipped, PositionStatusOpen, PositionStatusInvalid},
+	PositionStatusShipped:          []string{PositionStatusDelivered, PositionStatusPartiallyShipped, PositionStatusOpen, PositionStatusInvalid},
+	PositionStatusDelivered:        []string{PositionStatusShipped, PositionStatusPartiallyShipped, PositionStatusOpen, PositionStatusInvalid},
+	PositionStatusCanceled:         []string{PositionStatusInvalid},
+}
+
+// blueprints for possible position states
+var positionBlueprints = map[string]state.BluePrint{
+	PositionStatusInvalid: state.BluePrint{
+		Type:        PositionStateType,
+		Key:         PositionStatusInvalid,
+		Description: "Something went wrong",
+		Initial:     false,
+	},
+	PositionStatusOpen: state.BluePrint{
+		Type:        PositionStateType,
+		Key:         PositionStatusOpen,
+		Description: "Position has not been shipped yet.",
+		Initial:     true,
+	},
+	PositionStatusPartiallyShipped: state.BluePrint{
+		Type:        PositionStateType,
+		Key:         PositionStatusPartiallyShipped,
+		Description: "Part of the ordered quantity has been shipped.",
+		Initial:     false,
+	},
+	PositionStatusShipped: state.BluePrint{
+		Type:        PositionStateType,
+		Key:         PositionStatusShipped,
+		Description: "The ordered quantity has been shipped completely.",
+		Initial:     false,
+	},
+	PositionStatusDelivered: state.BluePrint{
+		Type:        PositionStateType,
+		Key:         PositionStatusDelivered,
+		Description: "The ordered quantity has been delivered completely.",
+		Initial:     false,
+	},
+	PositionStatusCanceled: state.BluePrint{
+		Type:        PositionStateType,
+		Key:         PositionStatusCanceled,
+		Description: "Position has been canceled.",
+		Initial:     false,
+	},
+}
+
+func GetPositionStates() map[string]state.BluePrint {
+	return positionBlueprints
+}
+
+var DefaultPositionStateMachine = &state.StateMachine{
+	InitialState: PositionStatusOpen,
+	Transitions:  positionTransitions,
+	BluePrints:   positionBlueprints,
+}
@@ -0,0 +1,144 @@
+package order
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/foomo/shop/utils"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	ShipmentStatusLabelCreated     ShipmentStatus = "labelCreated"
+	ShipmentStatusInTransit        ShipmentStatus = "inTransit"
+	ShipmentStatusOutForDelivery   ShipmentStatus = "outForDelivery"
+	ShipmentStatusDelivered        ShipmentStatus = "delivered"
+	ShipmentStatusException        ShipmentStatus = "exception" // e.g. recipient not at home, the carrier will try again
+	ShipmentStatusReturnedToSender ShipmentStatus = "returnedToSender"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type ShipmentStatus string
+
+// TrackingEvent is a scan of a parcel reported by the carrier
+type TrackingEvent struct {
+	Id          string // unique per shipment, an event with a known id is ignored
+	Status      ShipmentStatus
+	Description string
+	Location    string
+	OccurredAt  time.Time
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS ON ORDER
+//------------------------------------------------------------------
+
+// GetShipmentByTrackingNumber returns the shipment with trackingNumber or nil if it does not exist
+func (order *Order) GetShipmentByTrackingNumber(trackingNumber string) *Shipment {
+	for _, shipment := range order.Shipments {
+		if shipment.TrackingNumber == trackingNumber {
+			return shipment
+		}
+	}
+	return nil
+}
+
+// GetDeliveredQuantity returns the quantity of itemID summed up over all delivered shipments
+func (order *Order) GetDeliveredQuantity(itemID string) float64 {
+	quantity := 0.0
+	for _, shipment := range order.Shipments {
+		if shipment.IsDelivered() && !shipment.IsCanceled() {
+			quantity += shipment.GetQuantity(itemID)
+		}
+	}
+	return quantity
+}
+
+// AddTrackingEvents adds the tracking events of the shipment with trackingNumber, updates its status
+// and the states of the affected positions. Events which have been added before are ignored.
+func (order *Order) AddTrackingEvents(trackingNumber string, events []*TrackingEvent) error {
+	shipment := order.GetShipmentByTrackingNumber(trackingNumber)
+	if shipment == nil {
+		return errors.New("no shipment with tracking number " + trackingNumber + " in order " + order.GetID())
+	}
+	if shipment.IsCanceled() {
+		return errors.New("shipment " + shipment.Id + " has been canceled")
+	}
+	changed := false
+	for _, event := range events {
+		if event.Id == "" {
+			return errors.New("tracking event without id")
+		}
+		if shipment.getTrackingEvent(event.Id) != nil {
+			continue
+		}
+		shipment.TrackingEvents = append(shipment.TrackingEvents, event)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	// carriers do not necessarily report events in order
+	sort.SliceStable(shipment.TrackingEvents, func(i, j int) bool {
+		return shipment.TrackingEvents[i].OccurredAt.Before(shipment.TrackingEvents[j].OccurredAt)
+	})
+	latest := shipment.TrackingEvents[len(shipment.TrackingEvents)-1]
+	shipment.Status = latest.Status
+	shipment.DeliveredAt = time.Time{}
+	if latest.Status == ShipmentStatusDelivered {
+		shipment.DeliveredAt = latest.OccurredAt
+	}
+	if err := order.UpdatePositionStatesFromShipments(nil); err != nil {
+		return err
+	}
+	return order.Upsert()
+}
+
+// CancelShipment cancels the shipment with id, e.g. if the label has been voided before the handover to the carrier.
+// The shipped quantities and position states are updated.
+func (order *Order) CancelShipment(id string) error {
+	shipment := order.GetShipmentById(id)
+	if shipment == nil {
+		return errors.New("no shipment with id " + id + " in order " + order.GetID())
+	}
+	if shipment.IsCanceled() {
+		return nil
+	}
+	if len(shipment.TrackingEvents) > 0 && shipment.Status != ShipmentStatusLabelCreated {
+		return errors.New("shipment " + id + " has already been handed over to the carrier")
+	}
+	shipment.CanceledAt = utils.TimeNow()
+	if err := order.UpdatePositionStatesFromShipments(nil); err != nil {
+		return err
+	}
+	return order.Upsert()
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func (shipment *Shipment) getTrackingEvent(id string) *TrackingEvent {
+	for _, event := range shipment.TrackingEvents {
+		if event.Id == id {
+			return event
+		}
+	}
+	return nil
+}
+
+func (order *Order) hasActiveShipments() bool {
+	for _, shipment := range order.Shipments {
+		if !shipment.IsCanceled() {
+			return true
+		}
+	}
+	return false
+}
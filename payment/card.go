@@ -0,0 +1,176 @@
+package payment
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	CardBrandVisa       CardBrand = "visa"
+	CardBrandMastercard CardBrand = "mastercard"
+	CardBrandAmex       CardBrand = "amex"
+	CardBrandDiners     CardBrand = "diners"
+	CardBrandDiscover   CardBrand = "discover"
+	CardBrandJCB        CardBrand = "jcb"
+	CardBrandMaestro    CardBrand = "maestro"
+	CardBrandUnknown    CardBrand = "unknown"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type CardBrand string
+
+// cardBrandRule matches a brand by prefix range and number length
+type cardBrandRule struct {
+	brand    CardBrand
+	from, to int // prefix range, inclusive
+	digits   int // number of digits of the prefix
+	lengths  []int
+}
+
+// rules are checked in order, more specific ranges first
+var cardBrandRules = []*cardBrandRule{
+	{CardBrandAmex, 34, 34, 2, []int{15}},
+	{CardBrandAmex, 37, 37, 2, []int{15}},
+	{CardBrandJCB, 3528, 3589, 4, []int{16, 17, 18, 19}},
+	{CardBrandDiners, 300, 305, 3, []int{14, 15, 16, 17, 18, 19}},
+	{CardBrandDiners, 36, 36, 2, []int{14, 15, 16, 17, 18, 19}},
+	{CardBrandDiners, 38, 39, 2, []int{14, 15, 16, 17, 18, 19}},
+	{CardBrandVisa, 4, 4, 1, []int{13, 16, 19}},
+	{CardBrandMastercard, 51, 55, 2, []int{16}},
+	{CardBrandMastercard, 2221, 2720, 4, []int{16}},
+	{CardBrandDiscover, 6011, 6011, 4, []int{16, 17, 18, 19}},
+	{CardBrandDiscover, 644, 649, 3, []int{16, 17, 18, 19}},
+	{CardBrandDiscover, 65, 65, 2, []int{16, 17, 18, 19}},
+	{CardBrandMaestro, 50, 50, 2, []int{12, 13, 14, 15, 16, 17, 18, 19}},
+	{CardBrandMaestro, 56, 69, 2, []int{12, 13, 14, 15, 16, 17, 18, 19}},
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// NormalizePAN removes spaces and dashes from a card number
+func NormalizePAN(pan string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(pan))
+}
+
+// ValidateLuhn returns true if pan consists of digits only and has a valid Luhn check digit
+func ValidateLuhn(pan string) bool {
+	if len(pan) < 2 || !isDigits(pan) {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(pan) - 1; i >= 0; i-- {
+		digit := int(pan[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// DetectCardBrand returns the brand of pan by its prefix and length
+func DetectCardBrand(pan string) CardBrand {
+	pan = NormalizePAN(pan)
+	if !isDigits(pan) {
+		return CardBrandUnknown
+	}
+	for _, rule := range cardBrandRules {
+		if len(pan) < rule.digits {
+			continue
+		}
+		prefix, _ := strconv.Atoi(pan[:rule.digits])
+		if prefix < rule.from || prefix > rule.to {
+			continue
+		}
+		for _, length := range rule.lengths {
+			if len(pan) == length {
+				return rule.brand
+			}
+		}
+	}
+	return CardBrandUnknown
+}
+
+// MaskPAN keeps the first six and the last four digits of pan, e.g. 411111******1111
+func MaskPAN(pan string) string {
+	pan = NormalizePAN(pan)
+	if len(pan) <= 10 {
+		return strings.Repeat("*", len(pan))
+	}
+	return pan[:6] + strings.Repeat("*", len(pan)-10) + pan[len(pan)-4:]
+}
+
+// ValidateCardNumber normalizes pan, checks its Luhn digit and detects its brand
+func ValidateCardNumber(pan string) (string, CardBrand, error) {
+	pan = NormalizePAN(pan)
+	if len(pan) < 12 || len(pan) > 19 || !isDigits(pan) {
+		return "", CardBrandUnknown, errors.New("invalid card number length or characters")
+	}
+	if !ValidateLuhn(pan) {
+		return "", CardBrandUnknown, errors.New("invalid card number check digit")
+	}
+	brand := DetectCardBrand(pan)
+	if brand == CardBrandUnknown {
+		return "", CardBrandUnknown, errors.New("unsupported card brand")
+	}
+	return pan, brand, nil
+}
+
+// ValidateExpirationDate checks that expirationDate has the format MM/YY or MM/YYYY and has not passed at now
+func ValidateExpirationDate(expirationDate string, now time.Time) error {
+	parts := strings.Split(strings.TrimSpace(expirationDate), "/")
+	if len(parts) != 2 || len(parts[0]) != 2 || (len(parts[1]) != 2 && len(parts[1]) != 4) {
+		return errors.New("invalid expiration date " + expirationDate + ", expected MM/YY")
+	}
+	month, errMonth := strconv.Atoi(parts[0])
+	year, errYear := strconv.Atoi(parts[1])
+	if errMonth != nil || errYear != nil || month < 1 || month > 12 {
+		return errors.New("invalid expiration date " + expirationDate + ", expected MM/YY")
+	}
+	if year < 100 {
+		year += 2000
+	}
+	// a card is valid until the end of its expiration month
+	if !now.Before(time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, now.Location())) {
+		return errors.New("card expired " + expirationDate)
+	}
+	return nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// maskIfPAN masks s if it looks like an unmasked card number
+func maskIfPAN(s string) string {
+	normalized := NormalizePAN(s)
+	if len(normalized) >= 12 && isDigits(normalized) {
+		return MaskPAN(normalized)
+	}
+	return s
+}
@@ -0,0 +1,96 @@
+package payment
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var testVaultKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestCardValidation(t *testing.T) {
+	assert.True(t, ValidateLuhn("4111111111111111"))
+	assert.False(t, ValidateLuhn("4111111111111112"))
+	assert.Equal(t, CardBrandVisa, DetectCardBrand("4111 1111 1111 1111"))
+	assert.Equal(t, CardBrandMastercard, DetectCardBrand("5555555555554444"))
+	assert.Equal(t, CardBrandMastercard, DetectCardBrand("2221000000000009"))
+	assert.Equal(t, CardBrandAmex, DetectCardBrand("378282246310005"))
+	assert.Equal(t, CardBrandDiscover, DetectCardBrand("6011111111111117"))
+	assert.Equal(t, CardBrandUnknown, DetectCardBrand("1234567890123"))
+	assert.Equal(t, "411111******1111", MaskPAN("4111-1111-1111-1111"))
+
+	_, _, err := ValidateCardNumber("4111111111111112")
+	assert.Error(t, err, "luhn")
+	now := time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, ValidateExpirationDate("03/20", now))
+	assert.Error(t, ValidateExpirationDate("02/20", now))
+	assert.Error(t, ValidateExpirationDate("13/25", now))
+}
+
+func TestCreditCardNeverEmitsPAN(t *testing.T) {
+	vault, err := NewLocalVault("", testVaultKey)
+	assert.NoError(t, err)
+	card, err := NewCreditCard(vault, "Max", "Muster", "4111 1111 1111 1111", "12/99")
+	assert.NoError(t, err)
+	assert.Equal(t, CardBrandVisa, card.Brand)
+	assert.Equal(t, "411111******1111", card.MaskedPAN)
+
+	pan, err := vault.Detokenize(card.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, "4111111111111111", pan)
+
+	// even if a full card number is assigned by mistake, it is not marshalled
+	card.MaskedPAN = "4111111111111111"
+	data, err := json.Marshal(&Payment{CreditCard: card})
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "4111111111111111"))
+	data, err = bson.Marshal(&Payment{CreditCard: card})
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "4111111111111111"))
+
+	decoded := &Payment{}
+	assert.NoError(t, bson.Unmarshal(data, decoded))
+	assert.Equal(t, "411111******1111", decoded.CreditCard.MaskedPAN)
+	assert.Equal(t, card.Token, decoded.CreditCard.Token)
+}
+
+func TestLocalVaultPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vault.json")
+
+	vault, err := NewLocalVault(path, testVaultKey)
+	assert.NoError(t, err)
+	token, err := vault.Tokenize("5555555555554444")
+	assert.NoError(t, err)
+	again, err := vault.Tokenize("5555 5555 5555 4444")
+	assert.NoError(t, err)
+	assert.Equal(t, token, again, "same card number yields same token")
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "5555555555554444"), "vault file is encrypted")
+
+	reopened, err := NewLocalVault(path, testVaultKey)
+	assert.NoError(t, err)
+	pan, err := reopened.Detokenize(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "5555555555554444", pan)
+
+	wrongKey, err := NewLocalVault(path, []byte("fedcba9876543210fedcba9876543210"))
+	assert.NoError(t, err)
+	_, err = wrongKey.Detokenize(token)
+	assert.Error(t, err, "wrong key cannot decrypt")
+
+	assert.NoError(t, reopened.Delete(token))
+	_, err = reopened.Detokenize(token)
+	assert.Equal(t, ErrorTokenNotFound, err)
+}
@@ -0,0 +1,250 @@
+package payment
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/configuration"
+	"github.com/foomo/shop/persistence"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// ErrorDuplicateIdempotencyKey is returned by Ledger.Insert, if a transaction with the same idempotency key exists
+var ErrorDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+
+var (
+	globalTransactionPersistor *persistence.Persistor
+
+	transactionEnsuredIndexes = []mgo.Index{
+		{
+			Name:   "id",
+			Key:    []string{"id"},
+			Unique: true,
+		},
+		{
+			Name:   "idempotencykey",
+			Key:    []string{"idempotencykey"},
+			Unique: true,
+		},
+		{
+			Name:       "orderid",
+			Key:        []string{"orderid"},
+			Unique:     false,
+			Background: true,
+		},
+		{
+			Name:       "status",
+			Key:        []string{"status", "createdat"},
+			Unique:     false,
+			Background: true,
+		},
+	}
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Ledger stores payment transactions. Transactions are never deleted.
+type Ledger interface {
+	// Insert stores a new transaction and returns ErrorDuplicateIdempotencyKey if the key is taken
+	Insert(transaction *Transaction) error
+	// Update stores the result of a transaction
+	Update(transaction *Transaction) error
+	// GetById returns the transaction with id or mgo.ErrNotFound
+	GetById(id string) (*Transaction, error)
+	// GetByIdempotencyKey returns the transaction with key or mgo.ErrNotFound
+	GetByIdempotencyKey(key string) (*Transaction, error)
+	// GetByOrderId returns all transactions of an order sorted by creation date
+	GetByOrderId(orderId string) ([]*Transaction, error)
+	// GetPending returns all pending transactions created before createdBefore sorted by creation date
+	GetPending(createdBefore time.Time) ([]*Transaction, error)
+}
+
+// MongoLedger stores transactions in configuration.MONGO_COLLECTION_PAYMENT_TRANSACTIONS
+type MongoLedger struct{}
+
+// MemoryLedger is a Ledger backed by a slice, used by the tests of the payment and order packages
+type MemoryLedger struct {
+	sync.Mutex
+	transactions []*Transaction
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewMongoLedger constructor
+func NewMongoLedger() *MongoLedger {
+	return &MongoLedger{}
+}
+
+// NewMemoryLedger constructor
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{transactions: []*Transaction{}}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// GetTransactionPersistor will return a singleton instance of a payment transaction mongo persistor
+func GetTransactionPersistor() *persistence.Persistor {
+	url := configuration.GetMongoURL()
+	collection := configuration.MONGO_COLLECTION_PAYMENT_TRANSACTIONS
+	if globalTransactionPersistor == nil {
+		p, err := persistence.NewPersistorWithIndexes(url, collection, transactionEnsuredIndexes)
+		if err != nil || p == nil {
+			panic(errors.New("failed to create mongoDB payment transaction persistor: " + err.Error()))
+		}
+		globalTransactionPersistor = p
+		return globalTransactionPersistor
+	}
+
+	if url == globalTransactionPersistor.GetURL() && collection == globalTransactionPersistor.GetCollectionName() {
+		return globalTransactionPersistor
+	}
+
+	p, err := persistence.NewPersistorWithIndexes(url, collection, transactionEnsuredIndexes)
+	if err != nil || p == nil {
+		panic(err)
+	}
+	globalTransactionPersistor = p
+	return globalTransactionPersistor
+}
+
+func (l *MongoLedger) Insert(transaction *Transaction) error {
+	session, collection := GetTransactionPersistor().GetCollection()
+	defer session.Close()
+	err := collection.Insert(transaction)
+	if mgo.IsDup(err) {
+		return ErrorDuplicateIdempotencyKey
+	}
+	return err
+}
+
+func (l *MongoLedger) Update(transaction *Transaction) error {
+	session, collection := GetTransactionPersistor().GetCollection()
+	defer session.Close()
+	return collection.Update(&bson.M{"id": transaction.Id}, transaction)
+}
+
+func (l *MongoLedger) GetById(id string) (*Transaction, error) {
+	return l.findOne(&bson.M{"id": id})
+}
+
+func (l *MongoLedger) GetByIdempotencyKey(key string) (*Transaction, error) {
+	return l.findOne(&bson.M{"idempotencykey": key})
+}
+
+func (l *MongoLedger) GetByOrderId(orderId string) ([]*Transaction, error) {
+	session, collection := GetTransactionPersistor().GetCollection()
+	defer session.Close()
+	transactions := []*Transaction{}
+	err := collection.Find(&bson.M{"orderid": orderId}).Sort("createdat").All(&transactions)
+	return transactions, err
+}
+
+func (l *MongoLedger) GetPending(createdBefore time.Time) ([]*Transaction, error) {
+	session, collection := GetTransactionPersistor().GetCollection()
+	defer session.Close()
+	transactions := []*Transaction{}
+	err := collection.Find(&bson.M{"status": TransactionStatusPending, "createdat": bson.M{"$lt": createdBefore}}).Sort("createdat").All(&transactions)
+	return transactions, err
+}
+
+func (l *MemoryLedger) Insert(transaction *Transaction) error {
+	l.Lock()
+	defer l.Unlock()
+	for _, existing := range l.transactions {
+		if existing.IdempotencyKey == transaction.IdempotencyKey {
+			return ErrorDuplicateIdempotencyKey
+		}
+	}
+	copied := *transaction
+	l.transactions = append(l.transactions, &copied)
+	return nil
+}
+
+func (l *MemoryLedger) Update(transaction *Transaction) error {
+	l.Lock()
+	defer l.Unlock()
+	for i, existing := range l.transactions {
+		if existing.Id == transaction.Id {
+			copied := *transaction
+			l.transactions[i] = &copied
+			return nil
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (l *MemoryLedger) GetById(id string) (*Transaction, error) {
+	return l.find(func(t *Transaction) bool { return t.Id == id })
+}
+
+func (l *MemoryLedger) GetByIdempotencyKey(key string) (*Transaction, error) {
+	return l.find(func(t *Transaction) bool { return t.IdempotencyKey == key })
+}
+
+func (l *MemoryLedger) GetByOrderId(orderId string) ([]*Transaction, error) {
+	return l.filter(func(t *Transaction) bool { return t.OrderId == orderId }), nil
+}
+
+func (l *MemoryLedger) GetPending(createdBefore time.Time) ([]*Transaction, error) {
+	return l.filter(func(t *Transaction) bool {
+		return t.Status == TransactionStatusPending && t.CreatedAt.Before(createdBefore)
+	}), nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func (l *MongoLedger) findOne(find *bson.M) (*Transaction, error) {
+	session, collection := GetTransactionPersistor().GetCollection()
+	defer session.Close()
+	transaction := &Transaction{}
+	err := collection.Find(find).One(transaction)
+	if err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+func (l *MemoryLedger) find(match func(t *Transaction) bool) (*Transaction, error) {
+	l.Lock()
+	defer l.Unlock()
+	for _, transaction := range l.transactions {
+		if match(transaction) {
+			copied := *transaction
+			return &copied, nil
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+// filter returns copies of the matching transactions sorted by creation date
+func (l *MemoryLedger) filter(match func(t *Transaction) bool) []*Transaction {
+	l.Lock()
+	defer l.Unlock()
+	transactions := []*Transaction{}
+	for _, transaction := range l.transactions {
+		if match(transaction) {
+			copied := *transaction
+			transactions = append(transactions, &copied)
+		}
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt.Before(transactions[j].CreatedAt)
+	})
+	return transactions
+}
@@ -3,4 +3,5 @@ package payment
 type Payment struct {
 	StateOfPayment string
 	Currency       string
+	CreditCard     *CreditCard `bson:",omitempty"` // tokenized card, never contains the full card number
 }
@@ -0,0 +1,213 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// PaymentProvider is implemented by payment service providers.
+// Every call carries the idempotency key of the transaction. Providers must pass it on to the payment service provider,
+// so that a call which is repeated with the same key, e.g. by Service.ReconcilePending(), is not processed twice.
+type PaymentProvider interface {
+	// Authorize reserves amount on the payment instrument of the order
+	Authorize(request *AuthorizationRequest) (*ProviderResult, error)
+	// Capture collects amount of an authorization. It may be called several times for partial captures.
+	Capture(authorizationReference string, amount float64, currency string, idempotencyKey string) (*ProviderResult, error)
+	// Void releases an authorization which has not been captured
+	Void(authorizationReference string, idempotencyKey string) (*ProviderResult, error)
+	// Refund pays back amount of a capture
+	Refund(captureReference string, amount float64, currency string, idempotencyKey string) (*ProviderResult, error)
+}
+
+// TransactionLookup is implemented by providers, which can look up the result of a transaction by its idempotency key.
+// It is used by Service.ReconcilePending() to complete transactions whose result has not been recorded.
+type TransactionLookup interface {
+	// LookupTransaction returns the result of the transaction with idempotencyKey or nil if the provider does not know it
+	LookupTransaction(transactionType TransactionType, idempotencyKey string) (*ProviderResult, error)
+}
+
+// AuthorizationRequest is passed to PaymentProvider.Authorize
+type AuthorizationRequest struct {
+	OrderId        string
+	Amount         float64
+	Currency       string
+	IdempotencyKey string
+	Custom         interface{} // provider specific data, e.g. a card token
+}
+
+// ProviderResult is returned by a PaymentProvider. Declined transactions are not errors.
+type ProviderResult struct {
+	Approved  bool
+	Reference string // reference of the transaction at the provider
+	Message   string
+}
+
+// FakeProvider is a deterministic PaymentProvider for tests.
+// References are numbered sequentially and amounts listed in DeclinedAmounts are declined.
+// Repeated calls with the same idempotency key return the first result.
+type FakeProvider struct {
+	sync.Mutex
+	Name            string
+	DeclinedAmounts []float64
+	sequence        int
+	authorizations  map[string]*fakeAuthorization
+	captures        map[string]*fakeCapture
+	results         map[string]*ProviderResult
+}
+
+type fakeAuthorization struct {
+	amount   float64
+	captured float64
+	voided   bool
+}
+
+type fakeCapture struct {
+	amount   float64
+	refunded float64
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewFakeProvider constructor
+func NewFakeProvider(name string, declinedAmounts ...float64) *FakeProvider {
+	return &FakeProvider{
+		Name:            name,
+		DeclinedAmounts: declinedAmounts,
+		authorizations:  map[string]*fakeAuthorization{},
+		captures:        map[string]*fakeCapture{},
+		results:         map[string]*ProviderResult{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+func (p *FakeProvider) Authorize(request *AuthorizationRequest) (*ProviderResult, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.once(TransactionTypeAuthorization, request.IdempotencyKey, func() (*ProviderResult, error) {
+		if p.isDeclined(request.Amount) {
+			return &ProviderResult{Approved: false, Message: "declined"}, nil
+		}
+		reference := p.nextReference("auth")
+		p.authorizations[reference] = &fakeAuthorization{amount: request.Amount}
+		return &ProviderResult{Approved: true, Reference: reference}, nil
+	})
+}
+
+func (p *FakeProvider) Capture(authorizationReference string, amount float64, currency string, idempotencyKey string) (*ProviderResult, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.once(TransactionTypeCapture, idempotencyKey, func() (*ProviderResult, error) {
+		return p.capture(authorizationReference, amount)
+	})
+}
+
+func (p *FakeProvider) Void(authorizationReference string, idempotencyKey string) (*ProviderResult, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.once(TransactionTypeVoid, idempotencyKey, func() (*ProviderResult, error) {
+		return p.void(authorizationReference)
+	})
+}
+
+func (p *FakeProvider) Refund(captureReference string, amount float64, currency string, idempotencyKey string) (*ProviderResult, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.once(TransactionTypeRefund, idempotencyKey, func() (*ProviderResult, error) {
+		return p.refund(captureReference, amount)
+	})
+}
+
+// LookupTransaction returns the result of the first call with idempotencyKey
+func (p *FakeProvider) LookupTransaction(transactionType TransactionType, idempotencyKey string) (*ProviderResult, error) {
+	p.Lock()
+	defer p.Unlock()
+	result, ok := p.results[string(transactionType)+"/"+idempotencyKey]
+	if !ok {
+		return nil, nil
+	}
+	copied := *result
+	return &copied, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// once calls process for the first call with idempotencyKey and records its result
+func (p *FakeProvider) once(transactionType TransactionType, idempotencyKey string, process func() (*ProviderResult, error)) (*ProviderResult, error) {
+	key := string(transactionType) + "/" + idempotencyKey
+	if result, ok := p.results[key]; ok {
+		copied := *result
+		return &copied, nil
+	}
+	result, err := process()
+	if err != nil {
+		return nil, err
+	}
+	p.results[key] = result
+	copied := *result
+	return &copied, nil
+}
+
+func (p *FakeProvider) capture(authorizationReference string, amount float64) (*ProviderResult, error) {
+	authorization, ok := p.authorizations[authorizationReference]
+	if !ok {
+		return nil, errors.New("unknown authorization " + authorizationReference)
+	}
+	if authorization.voided || authorization.captured+amount > authorization.amount+amountEpsilon || p.isDeclined(amount) {
+		return &ProviderResult{Approved: false, Message: "declined"}, nil
+	}
+	authorization.captured += amount
+	reference := p.nextReference("capture")
+	p.captures[reference] = &fakeCapture{amount: amount}
+	return &ProviderResult{Approved: true, Reference: reference}, nil
+}
+
+func (p *FakeProvider) void(authorizationReference string) (*ProviderResult, error) {
+	authorization, ok := p.authorizations[authorizationReference]
+	if !ok {
+		return nil, errors.New("unknown authorization " + authorizationReference)
+	}
+	if authorization.voided || authorization.captured > 0 {
+		return &ProviderResult{Approved: false, Message: "declined"}, nil
+	}
+	authorization.voided = true
+	return &ProviderResult{Approved: true, Reference: p.nextReference("void")}, nil
+}
+
+func (p *FakeProvider) refund(captureReference string, amount float64) (*ProviderResult, error) {
+	capture, ok := p.captures[captureReference]
+	if !ok {
+		return nil, errors.New("unknown capture " + captureReference)
+	}
+	if capture.refunded+amount > capture.amount+amountEpsilon || p.isDeclined(amount) {
+		return &ProviderResult{Approved: false, Message: "declined"}, nil
+	}
+	capture.refunded += amount
+	return &ProviderResult{Approved: true, Reference: p.nextReference("refund")}, nil
+}
+
+func (p *FakeProvider) nextReference(prefix string) string {
+	p.sequence++
+	return fmt.Sprintf("%s-%s-%04d", p.Name, prefix, p.sequence)
+}
+
+func (p *FakeProvider) isDeclined(amount float64) bool {
+	for _, declined := range p.DeclinedAmounts {
+		if math.Abs(declined-amount) < amountEpsilon {
+			return true
+		}
+	}
+	return false
+}
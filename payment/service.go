@@ -0,0 +1,435 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/foomo/shop/unique"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var (
+	// ErrorTransactionDeclined is returned together with the failed transaction if the provider declined it
+	ErrorTransactionDeclined = errors.New("payment transaction declined")
+	// ErrorTransactionPending is returned if a transaction with the same idempotency key has not been completed yet
+	ErrorTransactionPending = errors.New("payment transaction with same idempotency key is pending")
+	// ErrorIdempotencyKeyMismatch is returned if an idempotency key is reused for a different request
+	ErrorIdempotencyKeyMismatch = errors.New("idempotency key has been used for a different payment transaction")
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Service processes payments through registered providers and records every step in a Ledger.
+// Every operation requires an idempotency key. Repeating an operation with the same key returns the recorded
+// transaction without calling the provider again. The key is passed on to the provider.
+// Transactions which remain pending, e.g. because the process crashed during the provider call, are completed with ReconcilePending().
+//
+// The amount checks of captures, voids and refunds are serialized with a process local lock. Several services
+// sharing a ledger, e.g. in several instances of the shop, do not lock each other out, concurrent captures of the
+// same authorization are then only prevented by the provider.
+type Service struct {
+	sync.Mutex
+	ledger    Ledger
+	providers map[string]PaymentProvider
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewService constructor. If ledger is nil, transactions are stored in mongo.
+func NewService(ledger Ledger) *Service {
+	if ledger == nil {
+		ledger = NewMongoLedger()
+	}
+	return &Service{
+		ledger:    ledger,
+		providers: map[string]PaymentProvider{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// RegisterProvider registers provider for processor, e.g. order.PaymentProcessorWebshop
+func (s *Service) RegisterProvider(processor string, provider PaymentProvider) {
+	s.Lock()
+	defer s.Unlock()
+	s.providers[processor] = provider
+}
+
+// GetProvider returns the provider registered for processor
+func (s *Service) GetProvider(processor string) (PaymentProvider, error) {
+	s.Lock()
+	defer s.Unlock()
+	provider, ok := s.providers[processor]
+	if !ok {
+		return nil, errors.New("no payment provider registered for processor " + processor)
+	}
+	return provider, nil
+}
+
+// GetLedger returns the ledger of the service
+func (s *Service) GetLedger() Ledger {
+	return s.ledger
+}
+
+// Authorize reserves request.Amount with the provider registered for processor
+func (s *Service) Authorize(processor string, request *AuthorizationRequest) (*Transaction, error) {
+	if request.Amount <= 0 {
+		return nil, errors.New("authorization amount must be positive")
+	}
+	provider, err := s.GetProvider(processor)
+	if err != nil {
+		return nil, err
+	}
+	transaction := newTransaction(TransactionTypeAuthorization, request.IdempotencyKey, request.OrderId, processor, "", request.Amount, request.Currency)
+	transaction, done, err := s.begin(transaction, nil)
+	if done || err != nil {
+		return transaction, err
+	}
+	result, err := provider.Authorize(request)
+	return s.complete(transaction, result, err)
+}
+
+// Capture collects amount of the authorization with authorizationId. A capture below the open authorized amount is a partial capture.
+func (s *Service) Capture(authorizationId string, amount float64, idempotencyKey string) (*Transaction, error) {
+	if amount <= 0 {
+		return nil, errors.New("capture amount must be positive")
+	}
+	authorization, provider, err := s.getParent(authorizationId, TransactionTypeAuthorization)
+	if err != nil {
+		return nil, err
+	}
+	transaction := newTransaction(TransactionTypeCapture, idempotencyKey, authorization.OrderId, authorization.Processor, authorization.Id, amount, authorization.Currency)
+	transaction, done, err := s.begin(transaction, func(siblings []*Transaction) error {
+		if isVoided(authorization.Id, siblings) {
+			return errors.New("authorization " + authorization.Id + " has been voided")
+		}
+		open := authorization.Amount - sumChildren(authorization.Id, TransactionTypeCapture, siblings)
+		if amount > open+amountEpsilon {
+			return errors.New("capture amount " + formatAmount(amount) + " exceeds open authorized amount " + formatAmount(open))
+		}
+		return nil
+	})
+	if done || err != nil {
+		return transaction, err
+	}
+	result, err := callProvider(provider, transaction, authorization)
+	return s.complete(transaction, result, err)
+}
+
+// Void releases the authorization with authorizationId. Authorizations which have been captured cannot be voided.
+func (s *Service) Void(authorizationId string, idempotencyKey string) (*Transaction, error) {
+	authorization, provider, err := s.getParent(authorizationId, TransactionTypeAuthorization)
+	if err != nil {
+		return nil, err
+	}
+	transaction := newTransaction(TransactionTypeVoid, idempotencyKey, authorization.OrderId, authorization.Processor, authorization.Id, authorization.Amount, authorization.Currency)
+	transaction, done, err := s.begin(transaction, func(siblings []*Transaction) error {
+		if isVoided(authorization.Id, siblings) {
+			return errors.New("authorization " + authorization.Id + " has already been voided")
+		}
+		if sumChildren(authorization.Id, TransactionTypeCapture, siblings) > 0 {
+			return errors.New("authorization " + authorization.Id + " has been captured and cannot be voided")
+		}
+		return nil
+	})
+	if done || err != nil {
+		return transaction, err
+	}
+	result, err := callProvider(provider, transaction, authorization)
+	return s.complete(transaction, result, err)
+}
+
+// Refund pays back amount of the capture with captureId
+func (s *Service) Refund(captureId string, amount float64, idempotencyKey string) (*Transaction, error) {
+	if amount <= 0 {
+		return nil, errors.New("refund amount must be positive")
+	}
+	capture, provider, err := s.getParent(captureId, TransactionTypeCapture)
+	if err != nil {
+		return nil, err
+	}
+	transaction := newTransaction(TransactionTypeRefund, idempotencyKey, capture.OrderId, capture.Processor, capture.Id, amount, capture.Currency)
+	transaction, done, err := s.begin(transaction, func(siblings []*Transaction) error {
+		open := capture.Amount - sumChildren(capture.Id, TransactionTypeRefund, siblings)
+		if amount > open+amountEpsilon {
+			return errors.New("refund amount " + formatAmount(amount) + " exceeds refundable amount " + formatAmount(open))
+		}
+		return nil
+	})
+	if done || err != nil {
+		return transaction, err
+	}
+	result, err := callProvider(provider, transaction, capture)
+	return s.complete(transaction, result, err)
+}
+
+// Reverse gives back the money of the authorization with authorizationId: an uncaptured authorization is voided,
+// otherwise the refundable amount of each capture is refunded. The idempotency keys of the individual steps are derived from idempotencyKey.
+func (s *Service) Reverse(authorizationId string, idempotencyKey string) ([]*Transaction, error) {
+	authorization, _, err := s.getParent(authorizationId, TransactionTypeAuthorization)
+	if err != nil {
+		return nil, err
+	}
+	siblings, err := s.ledger.GetByOrderId(authorization.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	if sumChildren(authorization.Id, TransactionTypeCapture, siblings) == 0 {
+		if isVoided(authorization.Id, siblings) {
+			return []*Transaction{}, nil
+		}
+		transaction, err := s.Void(authorization.Id, idempotencyKey+"-void")
+		return []*Transaction{transaction}, err
+	}
+	transactions := []*Transaction{}
+	for _, capture := range siblings {
+		if capture.ParentId != authorization.Id || capture.Type != TransactionTypeCapture || !capture.IsSucceeded() {
+			continue
+		}
+		open := capture.Amount - sumChildren(capture.Id, TransactionTypeRefund, siblings)
+		if open < amountEpsilon {
+			continue
+		}
+		transaction, err := s.Refund(capture.Id, open, idempotencyKey+"-refund-"+capture.Id)
+		if err != nil {
+			return transactions, err
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, nil
+}
+
+// ReconcilePending completes transactions which have been pending for longer than olderThan, because their result
+// has not been recorded. If the provider implements TransactionLookup, the result is looked up with the idempotency key.
+// Otherwise captures, voids and refunds are sent to the provider again with the same idempotency key and pending
+// authorizations are marked as failed, as the request to repeat them is not stored. Their reservation expires at the provider.
+// The reconciled transactions are returned. Transactions which could not be reconciled remain pending and are reported in the error.
+func (s *Service) ReconcilePending(olderThan time.Duration) ([]*Transaction, error) {
+	pending, err := s.ledger.GetPending(time.Now().Add(-olderThan))
+	if err != nil {
+		return nil, err
+	}
+	var mErr *multierror.Error
+	reconciled := []*Transaction{}
+	for _, transaction := range pending {
+		if err := s.reconcile(transaction); err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf("could not reconcile transaction %s: %v", transaction.Id, err))
+			continue
+		}
+		reconciled = append(reconciled, transaction)
+	}
+	return reconciled, mErr.ErrorOrNil()
+}
+
+// GetTransactions returns all transactions of an order
+func (s *Service) GetTransactions(orderId string) ([]*Transaction, error) {
+	return s.ledger.GetByOrderId(orderId)
+}
+
+// GetSummary sums up the successful transactions of an order
+func (s *Service) GetSummary(orderId string) (*OrderPaymentSummary, error) {
+	transactions, err := s.ledger.GetByOrderId(orderId)
+	if err != nil {
+		return nil, err
+	}
+	return SummarizeTransactions(orderId, transactions), nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func newTransaction(transactionType TransactionType, idempotencyKey string, orderId string, processor string, parentId string, amount float64, currency string) *Transaction {
+	return &Transaction{
+		Id:             unique.GetNewID(),
+		IdempotencyKey: idempotencyKey,
+		OrderId:        orderId,
+		Processor:      processor,
+		Type:           transactionType,
+		Status:         TransactionStatusPending,
+		Amount:         amount,
+		Currency:       currency,
+		ParentId:       parentId,
+		CreatedAt:      time.Now(),
+	}
+}
+
+// begin validates and records a pending transaction. If a transaction with the same idempotency key exists,
+// it is returned and done is true.
+func (s *Service) begin(transaction *Transaction, validate func(siblings []*Transaction) error) (result *Transaction, done bool, err error) {
+	if transaction.IdempotencyKey == "" {
+		return nil, false, errors.New("idempotency key must not be empty")
+	}
+	if existing, err := s.ledger.GetByIdempotencyKey(transaction.IdempotencyKey); err == nil {
+		existing, err = checkExisting(existing, transaction)
+		return existing, true, err
+	}
+
+	// validation and insert must not interleave, otherwise concurrent captures could exceed the authorization
+	s.Lock()
+	defer s.Unlock()
+	if validate != nil {
+		siblings, err := s.ledger.GetByOrderId(transaction.OrderId)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := validate(siblings); err != nil {
+			return nil, false, err
+		}
+	}
+	err = s.ledger.Insert(transaction)
+	if err == ErrorDuplicateIdempotencyKey {
+		existing, err := s.ledger.GetByIdempotencyKey(transaction.IdempotencyKey)
+		if err != nil {
+			return nil, false, err
+		}
+		existing, err = checkExisting(existing, transaction)
+		return existing, true, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return transaction, false, nil
+}
+
+// callProvider sends transaction to provider. parent is the authorization of a capture or void or the capture of a refund.
+func callProvider(provider PaymentProvider, transaction *Transaction, parent *Transaction) (*ProviderResult, error) {
+	switch transaction.Type {
+	case TransactionTypeCapture:
+		return provider.Capture(parent.ProviderReference, transaction.Amount, transaction.Currency, transaction.IdempotencyKey)
+	case TransactionTypeVoid:
+		return provider.Void(parent.ProviderReference, transaction.IdempotencyKey)
+	case TransactionTypeRefund:
+		return provider.Refund(parent.ProviderReference, transaction.Amount, transaction.Currency, transaction.IdempotencyKey)
+	}
+	return nil, errors.New("transaction of type " + string(transaction.Type) + " cannot be sent to the provider again")
+}
+
+// reconcile records the result of a pending transaction, see ReconcilePending()
+func (s *Service) reconcile(transaction *Transaction) error {
+	provider, err := s.GetProvider(transaction.Processor)
+	if err != nil {
+		return err
+	}
+	var result *ProviderResult
+	if lookup, ok := provider.(TransactionLookup); ok {
+		result, err = lookup.LookupTransaction(transaction.Type, transaction.IdempotencyKey)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			result = &ProviderResult{Approved: false, Message: "transaction is unknown to the provider"}
+		}
+	} else if transaction.Type == TransactionTypeAuthorization {
+		result = &ProviderResult{Approved: false, Message: "pending authorization expired"}
+	} else {
+		parent, err := s.ledger.GetById(transaction.ParentId)
+		if err != nil {
+			return err
+		}
+		result, err = callProvider(provider, transaction, parent)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.complete(transaction, result, nil)
+	if err == ErrorTransactionDeclined {
+		return nil
+	}
+	return err
+}
+
+// complete records the result of the provider call
+func (s *Service) complete(transaction *Transaction, result *ProviderResult, providerErr error) (*Transaction, error) {
+	transaction.CompletedAt = time.Now()
+	switch {
+	case providerErr != nil:
+		transaction.Status = TransactionStatusFailed
+		transaction.Message = providerErr.Error()
+	case result.Approved:
+		transaction.Status = TransactionStatusSucceeded
+		transaction.ProviderReference = result.Reference
+		transaction.Message = result.Message
+	default:
+		transaction.Status = TransactionStatusFailed
+		transaction.ProviderReference = result.Reference
+		transaction.Message = result.Message
+	}
+	if err := s.ledger.Update(transaction); err != nil {
+		return transaction, err
+	}
+	if providerErr != nil {
+		return transaction, providerErr
+	}
+	if !transaction.IsSucceeded() {
+		return transaction, ErrorTransactionDeclined
+	}
+	return transaction, nil
+}
+
+// getParent returns the successful transaction with id and the provider which processed it
+func (s *Service) getParent(id string, transactionType TransactionType) (*Transaction, PaymentProvider, error) {
+	parent, err := s.ledger.GetById(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parent.Type != transactionType {
+		return nil, nil, errors.New("transaction " + id + " is not of type " + string(transactionType))
+	}
+	if !parent.IsSucceeded() {
+		return nil, nil, errors.New("transaction " + id + " has not succeeded")
+	}
+	provider, err := s.GetProvider(parent.Processor)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parent, provider, nil
+}
+
+// checkExisting makes sure that an idempotency key is not reused for a different request
+func checkExisting(existing *Transaction, requested *Transaction) (*Transaction, error) {
+	if existing.Type != requested.Type || existing.OrderId != requested.OrderId || existing.ParentId != requested.ParentId || math.Abs(existing.Amount-requested.Amount) > amountEpsilon {
+		return nil, ErrorIdempotencyKeyMismatch
+	}
+	switch existing.Status {
+	case TransactionStatusPending:
+		return existing, ErrorTransactionPending
+	case TransactionStatusFailed:
+		return existing, ErrorTransactionDeclined
+	}
+	return existing, nil
+}
+
+// sumChildren sums up pending and successful transactions of transactionType with parentId
+func sumChildren(parentId string, transactionType TransactionType, transactions []*Transaction) float64 {
+	sum := 0.0
+	for _, transaction := range transactions {
+		if transaction.ParentId == parentId && transaction.Type == transactionType && transaction.Status != TransactionStatusFailed {
+			sum += transaction.Amount
+		}
+	}
+	return sum
+}
+
+func isVoided(authorizationId string, transactions []*Transaction) bool {
+	return sumChildren(authorizationId, TransactionTypeVoid, transactions) > 0
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -0,0 +1,135 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testProcessor = "PaymentProcessorWebshop"
+
+func newTestService(declinedAmounts ...float64) *Service {
+	service := NewService(NewMemoryLedger())
+	service.RegisterProvider(testProcessor, NewFakeProvider("fake", declinedAmounts...))
+	return service
+}
+
+func TestServicePartialCaptureAndRefund(t *testing.T) {
+	service := newTestService()
+	authorization, err := service.Authorize(testProcessor, &AuthorizationRequest{OrderId: "order", Amount: 100, Currency: "CHF", IdempotencyKey: "auth"})
+	assert.NoError(t, err)
+	assert.Equal(t, "fake-auth-0001", authorization.ProviderReference)
+
+	first, err := service.Capture(authorization.Id, 60, "capture-1")
+	assert.NoError(t, err)
+	_, err = service.Capture(authorization.Id, 50, "capture-2")
+	assert.Error(t, err, "capture exceeds open authorized amount")
+	_, err = service.Capture(authorization.Id, 40, "capture-2")
+	assert.NoError(t, err)
+
+	_, err = service.Refund(first.Id, 70, "refund-1")
+	assert.Error(t, err, "refund exceeds capture")
+	_, err = service.Refund(first.Id, 10, "refund-1")
+	assert.NoError(t, err)
+
+	_, err = service.Void(authorization.Id, "void")
+	assert.Error(t, err, "captured authorization cannot be voided")
+
+	summary, err := service.GetSummary("order")
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, summary.Authorized)
+	assert.Equal(t, 100.0, summary.Captured)
+	assert.Equal(t, 10.0, summary.Refunded)
+	assert.Equal(t, 90.0, summary.GetBalance())
+	assert.Equal(t, 0.0, summary.GetOpenAuthorizedAmount())
+}
+
+func TestServiceIdempotency(t *testing.T) {
+	service := newTestService()
+	request := &AuthorizationRequest{OrderId: "order", Amount: 100, Currency: "CHF", IdempotencyKey: "auth"}
+	first, err := service.Authorize(testProcessor, request)
+	assert.NoError(t, err)
+	second, err := service.Authorize(testProcessor, request)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Id, second.Id, "repeated request returns the recorded transaction")
+
+	_, err = service.Authorize(testProcessor, &AuthorizationRequest{OrderId: "order", Amount: 99, Currency: "CHF", IdempotencyKey: "auth"})
+	assert.Equal(t, ErrorIdempotencyKeyMismatch, err)
+
+	transactions, err := service.GetTransactions("order")
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+}
+
+func TestServiceDeclineAndVoid(t *testing.T) {
+	service := newTestService(13.0)
+	declined, err := service.Authorize(testProcessor, &AuthorizationRequest{OrderId: "order", Amount: 13, Currency: "CHF", IdempotencyKey: "declined"})
+	assert.Equal(t, ErrorTransactionDeclined, err)
+	assert.Equal(t, TransactionStatusFailed, declined.Status)
+
+	authorization, err := service.Authorize(testProcessor, &AuthorizationRequest{OrderId: "order", Amount: 20, Currency: "CHF", IdempotencyKey: "auth"})
+	assert.NoError(t, err)
+	_, err = service.Void(authorization.Id, "void")
+	assert.NoError(t, err)
+	_, err = service.Capture(authorization.Id, 20, "capture")
+	assert.Error(t, err, "voided authorization cannot be captured")
+
+	summary, err := service.GetSummary("order")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, summary.Authorized)
+
+	_, err = service.Authorize("unknown", &AuthorizationRequest{OrderId: "order", Amount: 20, IdempotencyKey: "x"})
+	assert.Error(t, err, "no provider for processor")
+}
+
+// noLookupProvider hides the TransactionLookup of the wrapped provider
+type noLookupProvider struct {
+	PaymentProvider
+}
+
+// insertPending records a pending transaction as if the process crashed during the provider call
+func insertPending(t *testing.T, service *Service, transactionType TransactionType, key string, parentId string, amount float64, createdAt time.Time) *Transaction {
+	transaction := newTransaction(transactionType, key, "order", testProcessor, parentId, amount, "CHF")
+	transaction.CreatedAt = createdAt
+	assert.NoError(t, service.GetLedger().Insert(transaction))
+	return transaction
+}
+
+func TestServiceReconcilePending(t *testing.T) {
+	for _, lookup := range []bool{true, false} {
+		provider := NewFakeProvider("fake")
+		service := NewService(NewMemoryLedger())
+		if lookup {
+			service.RegisterProvider(testProcessor, provider)
+		} else {
+			service.RegisterProvider(testProcessor, &noLookupProvider{provider})
+		}
+		authorization, err := service.Authorize(testProcessor, &AuthorizationRequest{OrderId: "order", Amount: 100, Currency: "CHF", IdempotencyKey: "auth"})
+		assert.NoError(t, err)
+
+		past := time.Now().Add(-time.Hour)
+		_, err = provider.Capture(authorization.ProviderReference, 60, "CHF", "capture-1")
+		assert.NoError(t, err)
+		capture := insertPending(t, service, TransactionTypeCapture, "capture-1", authorization.Id, 60, past)
+		pendingAuthorization := insertPending(t, service, TransactionTypeAuthorization, "auth-2", "", 50, past)
+		insertPending(t, service, TransactionTypeCapture, "capture-2", authorization.Id, 10, time.Now())
+
+		reconciled, err := service.ReconcilePending(time.Minute)
+		assert.NoError(t, err)
+		assert.Len(t, reconciled, 2, "recent transactions may still be in progress")
+
+		capture, err = service.GetLedger().GetById(capture.Id)
+		assert.NoError(t, err)
+		assert.True(t, capture.IsSucceeded())
+		assert.Equal(t, "fake-capture-0002", capture.ProviderReference, "the provider captured once")
+		pendingAuthorization, err = service.GetLedger().GetById(pendingAuthorization.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, TransactionStatusFailed, pendingAuthorization.Status)
+
+		repeated, err := service.Capture(authorization.Id, 60, "capture-1")
+		assert.NoError(t, err)
+		assert.Equal(t, capture.Id, repeated.Id)
+		assert.Equal(t, 60.0, provider.authorizations[authorization.ProviderReference].captured)
+	}
+}
@@ -0,0 +1,112 @@
+package payment
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	TransactionTypeAuthorization TransactionType = "authorization"
+	TransactionTypeCapture       TransactionType = "capture"
+	TransactionTypeVoid          TransactionType = "void"
+	TransactionTypeRefund        TransactionType = "refund"
+
+	TransactionStatusPending   TransactionStatus = "pending"   // provider has been called, result is not yet recorded
+	TransactionStatusSucceeded TransactionStatus = "succeeded" // provider approved the transaction
+	TransactionStatusFailed    TransactionStatus = "failed"    // provider declined the transaction or returned an error
+)
+
+// amountEpsilon is used to compare amounts
+const amountEpsilon = 0.001
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type TransactionType string
+type TransactionStatus string
+
+// Transaction is a single entry of the payment transaction ledger
+type Transaction struct {
+	BsonId            bson.ObjectId `bson:"_id,omitempty"`
+	Id                string
+	IdempotencyKey    string // a repeated request with the same key returns this transaction instead of calling the provider again
+	OrderId           string
+	Processor         string // provider which processed the transaction, see order.Processing.PaymentProcessor
+	Type              TransactionType
+	Status            TransactionStatus
+	Amount            float64
+	Currency          string
+	ParentId          string // authorization of a capture or void, capture of a refund
+	ProviderReference string // reference of the transaction at the provider
+	Message           string // provider message or error
+	CreatedAt         time.Time
+	CompletedAt       time.Time
+}
+
+// OrderPaymentSummary sums up the successful transactions of an order
+type OrderPaymentSummary struct {
+	OrderId    string
+	Currency   string
+	Authorized float64 // authorized amount which has not been voided
+	Captured   float64
+	Refunded   float64
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// IsSucceeded returns true if the provider approved the transaction
+func (t *Transaction) IsSucceeded() bool {
+	return t.Status == TransactionStatusSucceeded
+}
+
+// GetOpenAuthorizedAmount returns the authorized amount which has neither been captured nor voided
+func (s *OrderPaymentSummary) GetOpenAuthorizedAmount() float64 {
+	open := s.Authorized - s.Captured
+	if open < amountEpsilon {
+		return 0
+	}
+	return open
+}
+
+// GetBalance returns the amount the merchant effectively received
+func (s *OrderPaymentSummary) GetBalance() float64 {
+	return s.Captured - s.Refunded
+}
+
+// SummarizeTransactions sums up the successful transactions in transactions
+func SummarizeTransactions(orderId string, transactions []*Transaction) *OrderPaymentSummary {
+	summary := &OrderPaymentSummary{OrderId: orderId}
+	voided := map[string]bool{}
+	for _, transaction := range transactions {
+		if transaction.IsSucceeded() && transaction.Type == TransactionTypeVoid {
+			voided[transaction.ParentId] = true
+		}
+	}
+	for _, transaction := range transactions {
+		if !transaction.IsSucceeded() {
+			continue
+		}
+		if summary.Currency == "" {
+			summary.Currency = transaction.Currency
+		}
+		switch transaction.Type {
+		case TransactionTypeAuthorization:
+			if !voided[transaction.Id] {
+				summary.Authorized += transaction.Amount
+			}
+		case TransactionTypeCapture:
+			summary.Captured += transaction.Amount
+		case TransactionTypeRefund:
+			summary.Refunded += transaction.Amount
+		}
+	}
+	return summary
+}
@@ -0,0 +1,210 @@
+package payment
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// TokenPrefix is the prefix of all tokens issued by a LocalVault
+const TokenPrefix = "tok_"
+
+// ErrorTokenNotFound is returned if a vault does not know a token
+var ErrorTokenNotFound = errors.New("token not found in vault")
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Vault swaps card numbers for tokens. Only the vault is able to resolve a token to its card number.
+type Vault interface {
+	// Tokenize stores pan and returns its token. Tokenizing the same pan twice returns the same token.
+	Tokenize(pan string) (string, error)
+	// Detokenize returns the card number of token
+	Detokenize(token string) (string, error)
+	// Delete removes token and its card number from the vault
+	Delete(token string) error
+}
+
+// LocalVault keeps AES-GCM encrypted card numbers in a local file.
+// Card numbers are looked up by a keyed hash, so neither tokens nor the file reveal them.
+type LocalVault struct {
+	sync.Mutex
+	path    string // if empty, entries are kept in memory only
+	aead    cipher.AEAD
+	hashKey []byte
+	entries map[string]*vaultEntry // token => entry
+	index   map[string]string      // keyed hash of pan => token
+}
+
+type vaultEntry struct {
+	Hash       string // keyed hash of the card number
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewLocalVault opens or creates the vault file at path. key must be 32 bytes long.
+// If path is empty, the vault is not persisted.
+func NewLocalVault(path string, key []byte) (*LocalVault, error) {
+	if len(key) != 32 {
+		return nil, errors.New("vault key must be 32 bytes long")
+	}
+	// separate keys for encryption and lookup hashes
+	encryptionKey := deriveKey(key, "encryption")
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	vault := &LocalVault{
+		path:    path,
+		aead:    aead,
+		hashKey: deriveKey(key, "index"),
+		entries: map[string]*vaultEntry{},
+		index:   map[string]string{},
+	}
+	if path == "" {
+		return vault, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return vault, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &vault.entries); err != nil {
+		return nil, err
+	}
+	for token, entry := range vault.entries {
+		vault.index[entry.Hash] = token
+	}
+	return vault, nil
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+func (v *LocalVault) Tokenize(pan string) (string, error) {
+	pan = NormalizePAN(pan)
+	if !isDigits(pan) {
+		return "", errors.New("invalid card number")
+	}
+	v.Lock()
+	defer v.Unlock()
+
+	hash := v.hash(pan)
+	if token, ok := v.index[hash]; ok {
+		return token, nil
+	}
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := TokenPrefix + hex.EncodeToString(tokenBytes)
+	// the token is authenticated as additional data, so that entries cannot be swapped in the file
+	v.entries[token] = &vaultEntry{
+		Hash:       hash,
+		Nonce:      nonce,
+		Ciphertext: v.aead.Seal(nil, nonce, []byte(pan), []byte(token)),
+	}
+	v.index[hash] = token
+	if err := v.save(); err != nil {
+		delete(v.entries, token)
+		delete(v.index, hash)
+		return "", err
+	}
+	return token, nil
+}
+
+func (v *LocalVault) Detokenize(token string) (string, error) {
+	v.Lock()
+	defer v.Unlock()
+	entry, ok := v.entries[token]
+	if !ok {
+		return "", ErrorTokenNotFound
+	}
+	pan, err := v.aead.Open(nil, entry.Nonce, entry.Ciphertext, []byte(token))
+	if err != nil {
+		return "", err
+	}
+	return string(pan), nil
+}
+
+func (v *LocalVault) Delete(token string) error {
+	v.Lock()
+	defer v.Unlock()
+	entry, ok := v.entries[token]
+	if !ok {
+		return ErrorTokenNotFound
+	}
+	delete(v.entries, token)
+	delete(v.index, entry.Hash)
+	return v.save()
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func (v *LocalVault) hash(pan string) string {
+	mac := hmac.New(sha256.New, v.hashKey)
+	mac.Write([]byte(pan))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// save writes the vault to a temporary file and renames it, so that the file is never half written
+func (v *LocalVault) save() error {
+	if v.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(v.entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(v.path), filepath.Base(v.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), v.path)
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
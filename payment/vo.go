@@ -1,12 +1,21 @@
 package payment
 
-import "time"
+import (
+	"encoding/json"
+	"time"
 
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CreditCard holds the card data which may be stored with an order.
+// The card number itself is kept in a Vault and referenced by Token.
 type CreditCard struct {
 	FirstName         string
 	LastName          string
-	CardNumber        string
-	ExpirationDate    string
+	Token             string    // vault token of the card number
+	MaskedPAN         string    // e.g. 411111******1111
+	Brand             CardBrand // detected from the card number
+	ExpirationDate    string    // MM/YY
 	AuthorizationCode string
 }
 
@@ -23,3 +32,57 @@ type Voucher struct {
 	Value          float64
 	ExpirationDate time.Time
 }
+
+// creditCardAlias has the fields of CreditCard without its marshal methods
+type creditCardAlias CreditCard
+
+// NewCreditCard validates cardNumber and expirationDate, stores the card number in vault
+// and returns a CreditCard which only holds token, masked card number, brand and expiry.
+func NewCreditCard(vault Vault, firstName string, lastName string, cardNumber string, expirationDate string) (*CreditCard, error) {
+	pan, brand, err := ValidateCardNumber(cardNumber)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateExpirationDate(expirationDate, time.Now()); err != nil {
+		return nil, err
+	}
+	token, err := vault.Tokenize(pan)
+	if err != nil {
+		return nil, err
+	}
+	return &CreditCard{
+		FirstName:      firstName,
+		LastName:       lastName,
+		Token:          token,
+		MaskedPAN:      MaskPAN(pan),
+		Brand:          brand,
+		ExpirationDate: expirationDate,
+	}, nil
+}
+
+// MarshalJSON masks MaskedPAN, in case a full card number has been assigned to it
+func (card CreditCard) MarshalJSON() ([]byte, error) {
+	return json.Marshal(card.sanitized())
+}
+
+// GetBSON masks MaskedPAN, in case a full card number has been assigned to it
+func (card CreditCard) GetBSON() (interface{}, error) {
+	return card.sanitized(), nil
+}
+
+// SetBSON masks MaskedPAN of stored documents, which might have been written before
+func (card *CreditCard) SetBSON(raw bson.Raw) error {
+	alias := &creditCardAlias{}
+	if err := raw.Unmarshal(alias); err != nil {
+		return err
+	}
+	*card = CreditCard(*alias)
+	card.MaskedPAN = maskIfPAN(card.MaskedPAN)
+	return nil
+}
+
+func (card CreditCard) sanitized() *creditCardAlias {
+	alias := creditCardAlias(card)
+	alias.MaskedPAN = maskIfPAN(alias.MaskedPAN)
+	return &alias
+}
@@ -0,0 +1,45 @@
+package persistence
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/foomo/shop/crypto"
+)
+
+// RewrapEncryptedFields rewraps the data keys of all encrypted values in the collection with the current key
+// of the field encryption and returns the number of changed documents. Run it for every collection with encrypted
+// fields after a key rotation, before the old key is removed from the key provider.
+// Versioned documents are only replaced if their version has not changed. Otherwise they have been stored
+// concurrently and thereby been encrypted with the current key.
+func (p *Persistor) RewrapEncryptedFields() (int, error) {
+	session, collection := p.GetCollection()
+	defer session.Close()
+
+	iter := collection.Find(nil).Iter()
+	document := bson.M{}
+	n := 0
+	for iter.Next(&document) {
+		changed, err := crypto.RewrapDocument(document)
+		if err != nil {
+			iter.Close()
+			return n, err
+		}
+		if changed > 0 {
+			selector := bson.M{"_id": document["_id"]}
+			if version, ok := document["version"].(bson.M); ok {
+				selector["version.current"] = version["current"]
+			}
+			err := collection.Update(selector, document)
+			if err != nil && err != mgo.ErrNotFound {
+				iter.Close()
+				return n, err
+			}
+			if err == nil {
+				n++
+			}
+		}
+		document = bson.M{}
+	}
+	return n, iter.Close()
+}
@@ -23,8 +23,18 @@ func calculateDiscountsCartByAbsolute(priceRuleVoucherPair RuleVoucherPair, orde
 	amountsMap := getAmountsOfApplicablePositions(priceRuleVoucherPair.Rule, calculationParameters, orderDiscounts)
 	itemIDs, amounts := getMapValues(amountsMap)
 
+	// a bonus voucher with partial redemption can not discount more than the applicable amounts
+	totalReduction := priceRuleVoucherPair.Rule.Amount
+	if priceRuleVoucherPair.Rule.AllowPartialRedemption {
+		applicableTotal := 0.0
+		for _, amount := range amounts {
+			applicableTotal += amount
+		}
+		totalReduction = math.Min(totalReduction, applicableTotal)
+	}
+
 	// the tricky part - stolen code from Florian - distribute the amount proportional to the price
-	distributedAmounts, err := Distribute(amounts, priceRuleVoucherPair.Rule.Amount)
+	distributedAmounts, err := Distribute(amounts, totalReduction)
 	distribution := map[string]float64{}
 
 	for i, itemID := range itemIDs {
@@ -468,6 +468,13 @@ func ApplyDiscounts(articleCollection *ArticleCollection, existingDiscounts Orde
 				continue
 			}
 
+			// only the remaining amount of a partially redeemed voucher can be applied
+			if voucherPriceRule.AllowPartialRedemption {
+				remainingAmountRule := *voucherPriceRule
+				remainingAmountRule.Amount = voucherVo.RemainingAmount
+				voucherPriceRule = &remainingAmountRule
+			}
+
 			pair := RuleVoucherPair{
 				Rule:    voucherPriceRule,
 				Voucher: voucherVo,
@@ -830,7 +837,8 @@ func validatePriceRule(priceRule PriceRule, checkedPosition *Article, calculatio
 		}
 	}
 
-	if priceRule.Type == TypeBonusVoucher {
+	// a bonus voucher with partial redemption keeps the part of its value which is not used
+	if priceRule.Type == TypeBonusVoucher && !priceRule.AllowPartialRedemption {
 		bonusApplicableAmount := getOrderTotalForPriceRule(&priceRule, calculationParameters, orderDiscounts)
 		if priceRule.Amount > bonusApplicableAmount {
 			return false, ValidationPriceRuleBonusValueTooHigh
@@ -1,5 +1,6 @@
 package pricerule
 
+import "errors"
 import "sort"
 import "time"
 
@@ -92,6 +93,13 @@ func PickApplicableVouchers(candidateVoucherCodes []string, articleCollection *A
 //
 // - ValidationPreviouslyAppliedRuleBlock - a previously applied rule (with priority number higher) has a property set to true ... no further rules can be applied
 func ValidateVoucher(voucherCode string, articleCollection *ArticleCollection, checkoutAttributes []string) (ok bool, validationMessage TypeRuleValidationMsg) {
+	ok, validationMessage, _ = ValidateVoucherWithRemainingAmount(voucherCode, articleCollection, checkoutAttributes)
+	return ok, validationMessage
+}
+
+// ValidateVoucherWithRemainingAmount - same as ValidateVoucher, but returns the value of a bonus voucher which can still be used as well.
+// A bonus voucher with partial redemption is only used up, when nothing remains.
+func ValidateVoucherWithRemainingAmount(voucherCode string, articleCollection *ArticleCollection, checkoutAttributes []string) (ok bool, validationMessage TypeRuleValidationMsg, remainingAmount float64) {
 	//check if voucher is for customer or generic/guest
 	//get voucher
 	calculationParameters := &CalculationParameters{}
@@ -103,28 +111,33 @@ func ValidateVoucher(voucherCode string, articleCollection *ArticleCollection, c
 
 	//check if exists
 	if err != nil || voucher.VoucherCode != voucherCode {
-		return false, ValidationVoucherUnknown
+		return false, ValidationVoucherUnknown, 0
 	}
 
 	//check if voucher personalized
 	if voucher.VoucherType == VoucherTypePersonalized {
 		//no customer info atm, guest or not logged in yet ...
 		if len(customerID) == 0 && len(voucher.CustomerID) != 0 {
-			return false, ValidationVoucherPersonalized
+			return false, ValidationVoucherPersonalized, 0
 		}
 	}
 
 	//check if voucher was redeemed already
 	if !voucher.TimeRedeemed.IsZero() && voucher.VoucherType == VoucherTypePersonalized {
-		return false, ValidationVoucherAlreadyUsed
+		return false, ValidationVoucherAlreadyUsed, 0
+	}
+
+	//a partially redeemed bonus voucher is used up, when nothing remains
+	if voucherPriceRule.AllowPartialRedemption && !voucher.TimeRedeemed.IsZero() {
+		return false, ValidationVoucherAlreadyUsed, 0
 	}
 
 	if time.Now().After(voucherPriceRule.ValidTo) {
-		return false, ValidationPriceRuleExpired
+		return false, ValidationPriceRuleExpired, 0
 	}
 
 	if time.Now().Before(voucherPriceRule.ValidFrom) {
-		return false, ValidationPriceRuleNotValidYet
+		return false, ValidationPriceRuleNotValidYet, 0
 	}
 
 	//--------------------------------------------------------------
@@ -152,25 +165,35 @@ func ValidateVoucher(voucherCode string, articleCollection *ArticleCollection, c
 
 	ok, priceRuleFailReason := validatePriceRuleForOrder(*voucherPriceRule, calculationParameters, OrderDiscounts{})
 	if !ok {
-		return false, priceRuleFailReason
+		return false, priceRuleFailReason, 0
 	}
 
 	ok, priceRuleFailReason = checkPreviouslyAppliedRules(voucherPriceRule, voucher, calculationParameters)
 	if !ok {
-		return false, priceRuleFailReason
+		return false, priceRuleFailReason, 0
 	}
-	return true, ValidationPriceRuleOK
+	return true, ValidationPriceRuleOK, voucher.RemainingAmount
 }
 
 // CommitDiscounts is called when and articleCollection is finalized - it redeems all personalized vouchers
 // and updates the pricerule/voucher usage history
 // IT IS IRREVERSIBLE!!!
 //
-// alternatively use CommitOrderDiscounts
+// bonus vouchers with partial redemption require an order, use CommitDiscountsForOrder or CommitOrderDiscountsForOrder
 func CommitDiscounts(orderDiscounts *OrderDiscounts, customerID string) error {
+	_, err := CommitDiscountsForOrder(orderDiscounts, customerID, "")
+	return err
+}
+
+// CommitDiscountsForOrder is CommitDiscounts for the order with orderID.
+// Partial redemptions of bonus vouchers reference the order, so that they can be reverted for it.
+// If a bonus voucher with partial redemption is applied and orderID is empty, nothing is committed.
+// The ids of the committed price rules without voucher are returned, RevertPriceRuleUsageHistoryAtomic reverts their usage.
+func CommitDiscountsForOrder(orderDiscounts *OrderDiscounts, customerID string, orderID string) (priceRuleIDs []string, err error) {
 	var appliedRuleIDs []string
 	var appliedVoucherRuleIDs []string
 	var appliedVoucherCodes []string
+	voucherAmounts := map[string]float64{}
 
 	for _, orderDiscount := range *orderDiscounts {
 		for _, appliedDiscount := range orderDiscount.AppliedDiscounts {
@@ -178,6 +201,7 @@ func CommitDiscounts(orderDiscounts *OrderDiscounts, customerID string) error {
 			if len(appliedDiscount.VoucherCode) > 0 {
 				appliedVoucherCodes = append(appliedVoucherCodes, appliedDiscount.VoucherCode)
 				appliedVoucherRuleIDs = append(appliedVoucherRuleIDs, appliedDiscount.PriceRuleID)
+				voucherAmounts[appliedDiscount.VoucherCode] += appliedDiscount.DiscountAmountApplicable
 			} else {
 				//else normal rule
 				appliedRuleIDs = append(appliedRuleIDs, appliedDiscount.PriceRuleID)
@@ -189,51 +213,84 @@ func CommitDiscounts(orderDiscounts *OrderDiscounts, customerID string) error {
 	appliedVoucherRuleIDs = RemoveDuplicates(appliedVoucherRuleIDs)
 	appliedVoucherCodes = RemoveDuplicates(appliedVoucherCodes)
 
+	//load all vouchers first, nothing is committed if one of them cannot be redeemed
+	vouchers := make([]*Voucher, len(appliedVoucherCodes))
+	priceRules := make([]*PriceRule, len(appliedVoucherCodes))
+	for i, voucherCode := range appliedVoucherCodes {
+		voucher, priceRule, err := GetVoucherAndPriceRule(voucherCode, nil)
+		if err != nil {
+			return nil, err
+		}
+		if isPartialRedemption(priceRule) && len(orderID) == 0 {
+			return nil, errors.New("partial redemption of voucher " + voucherCode + " requires an order id")
+		}
+		vouchers[i] = voucher
+		priceRules[i] = priceRule
+	}
+
 	//redeem vouchers first
 	//NOTE: redeem internaly manipulates the associated pricerule as well
-	for _, voucherCode := range appliedVoucherCodes {
-
-		err := redeemVoucherByCode(voucherCode, customerID)
+	for i, voucher := range vouchers {
+		err := redeemVoucher(voucher, priceRules[i], customerID, orderID, voucherAmounts[voucher.VoucherCode])
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	for _, ruleID := range appliedRuleIDs {
 		err := UpdatePriceRuleUsageHistoryAtomic(ruleID, customerID)
 		if err != nil {
-			return err
+			return priceRuleIDs, err
 		}
+		priceRuleIDs = append(priceRuleIDs, ruleID)
 	}
-	return nil
+	return priceRuleIDs, nil
 }
 
 // CommitOrderDiscounts is called when and articleCollection is finalized - it redeems all personalized vouchers
 // and updates the pricerule/voucher usage history
 // IT IS IRREVERSIBLE!!!
 //
-// alternatively use CommitDiscounts
+// alternatively use CommitDiscounts, bonus vouchers with partial redemption require CommitOrderDiscountsForOrder
 func CommitOrderDiscounts(customerID string, articleCollection *ArticleCollection, voucherCodes []string, checkoutAttributes []string, roundTo float64) error {
-	orderDiscounts, _, err := ApplyDiscounts(articleCollection, nil, voucherCodes, checkoutAttributes, roundTo, nil)
+	_, err := CommitOrderDiscountsForOrder(customerID, "", articleCollection, voucherCodes, checkoutAttributes, roundTo, nil)
+	return err
+}
+
+// CommitOrderDiscountsForOrder is CommitOrderDiscounts for the order with orderID, see CommitDiscountsForOrder
+func CommitOrderDiscountsForOrder(customerID string, orderID string, articleCollection *ArticleCollection, voucherCodes []string, checkoutAttributes []string, roundTo float64, customProvider PriceRuleCustomProvider) (priceRuleIDs []string, err error) {
+	orderDiscounts, _, err := ApplyDiscounts(articleCollection, nil, voucherCodes, checkoutAttributes, roundTo, customProvider)
+	if err != nil {
+		return nil, err
+	}
+	return CommitDiscountsForOrder(&orderDiscounts, customerID, orderID)
+}
+
+// RevertVoucherRedemptionByCode - reverts the redemption of the voucher with the given code by the order with orderID
+func RevertVoucherRedemptionByCode(voucherCode string, customerID string, orderID string) error {
+	voucher, err := GetVoucherByCode(voucherCode, nil)
 	if err != nil {
 		return err
 	}
-	return CommitDiscounts(&orderDiscounts, customerID)
+	return voucher.RevertRedemption(customerID, orderID)
 }
 
 //------------------------------------------------------------------
 // ~ PRIVATE FUNCTIONS
 //------------------------------------------------------------------
 
-// redeemVoucherByCode -
-func redeemVoucherByCode(voucherCode string, customerID string) error {
-	voucher, err := GetVoucherByCode(voucherCode, nil)
-	if err != nil {
-		return err
+// redeemVoucher - bonus vouchers with partial redemption are redeemed by amount, all others completely
+func redeemVoucher(voucher *Voucher, priceRule *PriceRule, customerID string, orderID string, amount float64) error {
+	if isPartialRedemption(priceRule) {
+		return voucher.RedeemPartially(customerID, orderID, amount, priceRule)
 	}
 	return voucher.Redeem(customerID)
 }
 
+func isPartialRedemption(priceRule *PriceRule) bool {
+	return priceRule.Type == TypeBonusVoucher && priceRule.AllowPartialRedemption
+}
+
 // Returns false, ValidationPreviouslyAppliedRuleBlock if a previous rule blocks application
 func checkPreviouslyAppliedRules(voucherPriceRule *PriceRule, voucher *Voucher, calculationParameters *CalculationParameters) (ok bool, reason TypeRuleValidationMsg) {
 	// find applicable pricerules - auto promotions
@@ -3,9 +3,9 @@ package pricerule
 import (
 	"errors"
 	"log"
-	"sync"
 	"time"
 
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -38,6 +38,15 @@ const (
 
 const Verbose = false
 
+// fields of the usage history in the database
+const (
+	usageHistoryFieldTotalUsages               = "usagehistory.totalusages"
+	usageHistoryFieldUsagesPerCustomer         = "usagehistory.usagespercustomer"
+	usageHistoryFieldPartialUsages             = "usagehistory.partialusages"
+	usageHistoryFieldRedeemedAmount            = "usagehistory.redeemedamount"
+	usageHistoryFieldRedeemedAmountPerCustomer = "usagehistory.redeemedamountpercustomer"
+)
+
 // MaxUint const
 const MaxUint = ^uint(0)
 
@@ -119,10 +128,15 @@ type PriceRule struct {
 	MaxUsesPerCustomer int //maximum number of usages per customer
 
 	UsageHistory struct {
-		TotalUsages       int            //total times this was applied
-		UsagesPerCustomer map[string]int //times a customer used this rule customerId => times
+		TotalUsages               int                //total times this was applied
+		UsagesPerCustomer         map[string]int     //times a customer used this rule customerId => times
+		PartialUsages             int                //bonus vouchers with partial redemption: total times a voucher was used partially
+		RedeemedAmount            float64            //bonus vouchers with partial redemption: total redeemed amount
+		RedeemedAmountPerCustomer map[string]float64 //bonus vouchers with partial redemption: customerId => redeemed amount
 	}
 
+	AllowPartialRedemption bool // bonus vouchers only: the value not used in an order remains on the voucher
+
 	CreatedAt time.Time //created at
 
 	LastModifiedAt time.Time // updated at
@@ -313,16 +327,7 @@ func (pricerule *PriceRule) checkIfBonusVoucher() {
 
 // UpdatePriceRuleUsageHistoryAtomic - atomicaly update times used and times used per customer if customer id provided
 func UpdatePriceRuleUsageHistoryAtomic(ID string, customerID string) error {
-	mutex := sync.Mutex{}
-
-	mutex.Lock()
-	defer mutex.Unlock()
-	priceRule, err := LoadPriceRule(ID, nil)
-	if err != nil {
-		return err
-	}
-	return priceRule.UpdateUsageHistory(customerID)
-
+	return incUsageHistory(ID, nil, getUsageIncrements(customerID, 1))
 }
 
 // UpdateUsageHistory -
@@ -342,6 +347,103 @@ func (pricerule *PriceRule) UpdateUsageHistory(customerID string) error {
 	return pricerule.Upsert()
 }
 
+// RevertPriceRuleUsageHistoryAtomic - atomicaly revert a previous usage of the rule, e.g. if an order is canceled.
+// Times used and times used per customer are reverted in a single update, which is skipped if one of them would drop below zero.
+func RevertPriceRuleUsageHistoryAtomic(ID string, customerID string) error {
+	decrements := getUsageIncrements(customerID, -1)
+	err := incUsageHistory(ID, getRevertConditions(decrements), decrements)
+	if err != nil {
+		return err
+	}
+	if Verbose {
+		log.Println("reverted rule usage history: " + ID)
+	}
+	return nil
+}
+
+// UpdatePriceRuleUsageHistoryPartialAtomic - atomicaly add a partial redemption of amount to the usage history.
+// Times used are only incremented, when the voucher has been fully redeemed.
+func UpdatePriceRuleUsageHistoryPartialAtomic(ID string, customerID string, amount float64, fullyRedeemed bool) error {
+	increments := getPartialUsageIncrements(customerID, amount)
+	if fullyRedeemed {
+		for field, increment := range getUsageIncrements(customerID, 1) {
+			increments[field] = increment
+		}
+	}
+	return incUsageHistory(ID, nil, increments)
+}
+
+// RevertPriceRuleUsageHistoryPartialAtomic - atomicaly revert a partial redemption of amount in a single update.
+// The update is skipped if a partial usage, a redeemed amount or, if the voucher was fully redeemed, a times used would drop below zero.
+func RevertPriceRuleUsageHistoryPartialAtomic(ID string, customerID string, amount float64, wasFullyRedeemed bool) error {
+	decrements := getPartialUsageIncrements(customerID, -amount)
+	if wasFullyRedeemed {
+		for field, decrement := range getUsageIncrements(customerID, -1) {
+			decrements[field] = decrement
+		}
+	}
+	return incUsageHistory(ID, getRevertConditions(decrements), decrements)
+}
+
+// getUsageIncrements - $inc of times used and times used per customer if customer id provided
+func getUsageIncrements(customerID string, increment int) bson.M {
+	increments := bson.M{usageHistoryFieldTotalUsages: increment}
+	if len(customerID) > 0 {
+		increments[usageHistoryFieldUsagesPerCustomer+"."+customerID] = increment
+	}
+	return increments
+}
+
+// getPartialUsageIncrements - $inc of a partial redemption of amount, a negative amount reverts it
+func getPartialUsageIncrements(customerID string, amount float64) bson.M {
+	increment := 1
+	if amount < 0 {
+		increment = -1
+	}
+	increments := bson.M{
+		usageHistoryFieldPartialUsages:  increment,
+		usageHistoryFieldRedeemedAmount: amount,
+	}
+	if len(customerID) > 0 {
+		increments[usageHistoryFieldRedeemedAmountPerCustomer+"."+customerID] = amount
+	}
+	return increments
+}
+
+// getRevertConditions - conditions which only match, if none of the decrements makes its counter negative
+func getRevertConditions(decrements bson.M) bson.M {
+	conditions := bson.M{}
+	for field, decrement := range decrements {
+		switch value := decrement.(type) {
+		case int:
+			conditions[field] = bson.M{"$gte": -value}
+		case float64:
+			conditions[field] = bson.M{"$gte": -value - partialRedemptionEpsilon}
+		}
+	}
+	return conditions
+}
+
+// incUsageHistory - increments the usage history of the price rule with ID in a single update.
+// If the price rule does not match the conditions, it is not updated, e.g. a counter which is zero already.
+func incUsageHistory(ID string, conditions bson.M, increments bson.M) error {
+	session, collection := GetPersistorForObject(new(PriceRule)).GetCollection()
+	defer session.Close()
+
+	selector := bson.M{"id": ID}
+	for field, condition := range conditions {
+		selector[field] = condition
+	}
+	err := collection.Update(selector, bson.M{
+		"$inc": increments,
+		"$set": bson.M{"lastmodifiedat": time.Now()},
+	})
+	if err == mgo.ErrNotFound && len(conditions) > 0 {
+		return nil
+	}
+	return err
+}
+
 // Delete - delete PriceRule - ID must be set
 func (pricerule *PriceRule) Delete() error {
 	session, collection := GetPersistorForObject(new(PriceRule)).GetCollection()
@@ -2,10 +2,12 @@ package pricerule
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -17,6 +19,9 @@ const (
 	VoucherTypePersonalized VoucherType = "personalized" // when customer is known
 )
 
+// partialRedemptionEpsilon - amounts below are considered zero
+const partialRedemptionEpsilon = 0.001
+
 //------------------------------------------------------------------
 // ~ PUBLIC TYPES
 //------------------------------------------------------------------
@@ -33,6 +38,10 @@ type Voucher struct {
 	TimeApplied  time.Time //used in cart but can still be used until redeemed
 	TimeRedeemed time.Time //used on articleCollection - this is a redeem
 
+	RedeemedAmount  float64              // bonus vouchers with partial redemption: sum of all partial redemptions
+	Redemptions     []*VoucherRedemption // bonus vouchers with partial redemption: one entry per partial use
+	RemainingAmount float64              `bson:"-"` // bonus vouchers: value which can still be used, set by GetVoucherAndPriceRule
+
 	CreatedAt      time.Time //created at
 	LastModifiedAt time.Time //updated at
 
@@ -42,6 +51,14 @@ type Voucher struct {
 //VoucherType - voucher type
 type VoucherType string
 
+// VoucherRedemption - a partial use of a bonus voucher
+type VoucherRedemption struct {
+	Amount       float64
+	CustomerID   string
+	OrderID      string // the order which used the amount, a redemption is reverted by its order
+	TimeRedeemed time.Time
+}
+
 //------------------------------------------------------------------
 // ~ CONSTRUCTOR
 //------------------------------------------------------------------
@@ -152,6 +169,73 @@ func (voucher *Voucher) Redeem(customerID string) error {
 	return UpdatePriceRuleUsageHistoryAtomic(voucher.PriceRuleID, customerID)
 }
 
+// GetRemainingAmount - returns the value of a bonus voucher which can still be used
+func (voucher *Voucher) GetRemainingAmount(priceRule *PriceRule) float64 {
+	if priceRule == nil || priceRule.Type != TypeBonusVoucher || !voucher.TimeRedeemed.IsZero() {
+		return 0
+	}
+	remaining := priceRule.Amount - voucher.RedeemedAmount
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// RedeemPartially - uses amount of a bonus voucher whose price rule allows partial redemption for orderID.
+// The remaining amount stays available, the voucher is redeemed once nothing remains.
+// The voucher is only updated if it has not been redeemed concurrently. Repeating the redemption of an order is a no-op.
+// orderID is required, a redemption without order could neither be repeated safely nor be reverted.
+func (voucher *Voucher) RedeemPartially(customerID string, orderID string, amount float64, priceRule *PriceRule) error {
+	if len(orderID) == 0 {
+		return errors.New("partial redemption of voucher " + voucher.VoucherCode + " requires an order id")
+	}
+	if voucher.getRedemption(customerID, orderID) != nil {
+		return nil
+	}
+	previousRedeemedAmount := voucher.RedeemedAmount
+	err := voucher.addRedemption(customerID, orderID, amount, priceRule, time.Now())
+	if err != nil {
+		return err
+	}
+	err = voucher.updateIfRedeemedAmount(previousRedeemedAmount)
+	if Verbose {
+		log.Println("partially redeemed voucher " + voucher.VoucherCode)
+	}
+	if err != nil {
+		return err
+	}
+	return UpdatePriceRuleUsageHistoryPartialAtomic(priceRule.ID, customerID, amount, !voucher.TimeRedeemed.IsZero())
+}
+
+// RevertRedemption - reset redeem time and revert the usage history of the associated price rule.
+// For partially redeemed vouchers the partial redemption of orderID is reverted.
+// Reverting a voucher which has not been redeemed is a no-op.
+func (voucher *Voucher) RevertRedemption(customerID string, orderID string) error {
+	if len(voucher.Redemptions) > 0 {
+		return voucher.revertPartialRedemption(customerID, orderID)
+	}
+	if voucher.TimeRedeemed.IsZero() {
+		return nil
+	}
+	// the usage history is reverted first, the voucher stays redeemed until it has been reverted
+	err := RevertPriceRuleUsageHistoryAtomic(voucher.PriceRuleID, customerID)
+	if err != nil {
+		return err
+	}
+	voucher.TimeRedeemed = time.Time{}
+	err = voucher.Upsert()
+	if Verbose {
+		log.Println("reverted redemption of voucher " + voucher.VoucherCode)
+	}
+	if err != nil {
+		if errUsage := UpdatePriceRuleUsageHistoryAtomic(voucher.PriceRuleID, customerID); errUsage != nil {
+			log.Println("could not restore usage history of price rule " + voucher.PriceRuleID + ": " + errUsage.Error())
+		}
+		return err
+	}
+	return nil
+}
+
 // Delete - delete voucher - ID must be set
 func (voucher *Voucher) Delete() error {
 	session, collection := GetPersistorForObject(voucher).GetCollection()
@@ -180,7 +264,7 @@ func RemoveAllVouchers() error {
 	return err
 }
 
-// GetVoucherAndPriceRule -
+// GetVoucherAndPriceRule - for bonus vouchers the voucher's RemainingAmount is set
 func GetVoucherAndPriceRule(voucherCode string, customProvider PriceRuleCustomProvider) (*Voucher, *PriceRule, error) {
 	voucher, err := GetVoucherByCode(voucherCode, customProvider)
 	if err != nil {
@@ -194,7 +278,112 @@ func GetVoucherAndPriceRule(voucherCode string, customProvider PriceRuleCustomPr
 		if err != nil {
 			return voucher, nil, err
 		}
+		voucher.RemainingAmount = voucher.GetRemainingAmount(priceRule)
 		return voucher, priceRule, nil
 	}
 	return nil, nil, errors.New("voucher with code " + voucherCode + " not found")
 }
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// addRedemption - validates and records a partial redemption on the voucher without storing it
+func (voucher *Voucher) addRedemption(customerID string, orderID string, amount float64, priceRule *PriceRule, now time.Time) error {
+	if priceRule == nil || priceRule.Type != TypeBonusVoucher || !priceRule.AllowPartialRedemption {
+		return errors.New("voucher " + voucher.ID + " can not be redeemed partially")
+	}
+	if voucher.VoucherType == VoucherTypePersonalized && len(voucher.CustomerID) > 0 && voucher.CustomerID != customerID {
+		return errors.New("voucher with ID " + voucher.ID + " not assigned to " + customerID + ". can not redeem")
+	}
+	if !voucher.TimeRedeemed.IsZero() {
+		return errors.New("voucher " + voucher.ID + " redeemed already")
+	}
+	remaining := voucher.GetRemainingAmount(priceRule)
+	if amount <= 0 || amount > remaining+partialRedemptionEpsilon {
+		return fmt.Errorf("can not redeem %.2f of voucher %s, remaining amount is %.2f", amount, voucher.ID, remaining)
+	}
+	voucher.RedeemedAmount += amount
+	voucher.Redemptions = append(voucher.Redemptions, &VoucherRedemption{
+		Amount:       amount,
+		CustomerID:   customerID,
+		OrderID:      orderID,
+		TimeRedeemed: now,
+	})
+	if voucher.GetRemainingAmount(priceRule) <= partialRedemptionEpsilon {
+		voucher.TimeRedeemed = now
+	}
+	return nil
+}
+
+// getRedemption - returns the partial redemption of customerID for orderID or nil
+func (voucher *Voucher) getRedemption(customerID string, orderID string) *VoucherRedemption {
+	for _, redemption := range voucher.Redemptions {
+		if redemption.CustomerID == customerID && redemption.OrderID == orderID {
+			return redemption
+		}
+	}
+	return nil
+}
+
+// removeRedemption - removes the partial redemption of customerID for orderID without storing the voucher
+func (voucher *Voucher) removeRedemption(customerID string, orderID string) (redemption *VoucherRedemption, wasFullyRedeemed bool) {
+	for i := len(voucher.Redemptions) - 1; i >= 0; i-- {
+		if voucher.Redemptions[i].CustomerID != customerID || voucher.Redemptions[i].OrderID != orderID {
+			continue
+		}
+		redemption = voucher.Redemptions[i]
+		voucher.Redemptions = append(voucher.Redemptions[:i], voucher.Redemptions[i+1:]...)
+		voucher.RedeemedAmount -= redemption.Amount
+		if voucher.RedeemedAmount < partialRedemptionEpsilon {
+			voucher.RedeemedAmount = 0
+		}
+		wasFullyRedeemed = !voucher.TimeRedeemed.IsZero()
+		voucher.TimeRedeemed = time.Time{}
+		return redemption, wasFullyRedeemed
+	}
+	return nil, false
+}
+
+func (voucher *Voucher) revertPartialRedemption(customerID string, orderID string) error {
+	previousRedeemedAmount := voucher.RedeemedAmount
+	redemption, wasFullyRedeemed := voucher.removeRedemption(customerID, orderID)
+	if redemption == nil {
+		return nil
+	}
+	// the usage history is reverted first, the redemption is kept until it has been reverted
+	err := RevertPriceRuleUsageHistoryPartialAtomic(voucher.PriceRuleID, customerID, redemption.Amount, wasFullyRedeemed)
+	if err != nil {
+		return err
+	}
+	err = voucher.updateIfRedeemedAmount(previousRedeemedAmount)
+	if Verbose {
+		log.Println("reverted partial redemption of voucher " + voucher.VoucherCode)
+	}
+	if err != nil {
+		if errUsage := UpdatePriceRuleUsageHistoryPartialAtomic(voucher.PriceRuleID, customerID, redemption.Amount, wasFullyRedeemed); errUsage != nil {
+			log.Println("could not restore usage history of price rule " + voucher.PriceRuleID + ": " + errUsage.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// updateIfRedeemedAmount - stores the voucher if its redeemed amount in the database is still previousRedeemedAmount
+func (voucher *Voucher) updateIfRedeemedAmount(previousRedeemedAmount float64) error {
+	voucher.LastModifiedAt = time.Now()
+	session, collection := GetPersistorForObject(voucher).GetCollection()
+	defer session.Close()
+
+	selector := bson.M{"id": voucher.ID, "redeemedamount": previousRedeemedAmount}
+	if previousRedeemedAmount == 0 {
+		// vouchers stored before partial redemption was introduced have no redeemed amount
+		delete(selector, "redeemedamount")
+		selector["$or"] = []bson.M{{"redeemedamount": 0}, {"redeemedamount": bson.M{"$exists": false}}}
+	}
+	err := collection.Update(selector, voucher)
+	if err == mgo.ErrNotFound {
+		return errors.New("voucher " + voucher.ID + " has been redeemed concurrently")
+	}
+	return err
+}
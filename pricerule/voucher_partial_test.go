@@ -0,0 +1,141 @@
+package pricerule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func newPartialBonusVoucher() (*Voucher, *PriceRule) {
+	priceRule := NewBonusPriceRule("partial-bonus", 100, nil, nil, time.Now(), time.Now().AddDate(1, 0, 0))
+	priceRule.AllowPartialRedemption = true
+	return NewVoucher("partial-voucher", "partial-code", priceRule, ""), priceRule
+}
+
+func TestVoucherPartialRedemption(t *testing.T) {
+	voucher, priceRule := newPartialBonusVoucher()
+	now := time.Now()
+	assert.Equal(t, 100.0, voucher.GetRemainingAmount(priceRule))
+
+	assert.NoError(t, voucher.addRedemption("customer-1", "order-1", 30, priceRule, now))
+	assert.NoError(t, voucher.addRedemption("customer-2", "order-2", 50, priceRule, now))
+	assert.Equal(t, 20.0, voucher.GetRemainingAmount(priceRule))
+	assert.True(t, voucher.TimeRedeemed.IsZero(), "value remains on the voucher")
+	assert.Len(t, voucher.Redemptions, 2)
+
+	assert.Error(t, voucher.addRedemption("customer-1", "order-3", 20.01, priceRule, now), "exceeds remaining amount")
+	assert.NoError(t, voucher.addRedemption("customer-1", "order-3", 20, priceRule, now))
+	assert.False(t, voucher.TimeRedeemed.IsZero(), "voucher is used up")
+	assert.Equal(t, 0.0, voucher.GetRemainingAmount(priceRule))
+	assert.Error(t, voucher.addRedemption("customer-1", "order-4", 1, priceRule, now))
+	assert.Equal(t, 30.0, voucher.getRedemption("customer-1", "order-1").Amount)
+	assert.Nil(t, voucher.getRedemption("customer-2", "order-1"))
+
+	redemption, wasFullyRedeemed := voucher.removeRedemption("customer-2", "order-2")
+	assert.Equal(t, 50.0, redemption.Amount)
+	assert.True(t, wasFullyRedeemed)
+	assert.True(t, voucher.TimeRedeemed.IsZero())
+	assert.Equal(t, 50.0, voucher.GetRemainingAmount(priceRule))
+	assert.Len(t, voucher.Redemptions, 2)
+
+	// the redemption of an other order of the same customer is kept
+	redemption, _ = voucher.removeRedemption("customer-1", "order-1")
+	assert.Equal(t, 30.0, redemption.Amount)
+	assert.Equal(t, "order-3", voucher.Redemptions[0].OrderID)
+	assert.Equal(t, 80.0, voucher.GetRemainingAmount(priceRule))
+
+	// reverting again is a no-op
+	redemption, _ = voucher.removeRedemption("customer-1", "order-1")
+	assert.Nil(t, redemption)
+	assert.Equal(t, 80.0, voucher.GetRemainingAmount(priceRule))
+
+	redemption, _ = voucher.removeRedemption("unknown", "order-3")
+	assert.Nil(t, redemption)
+
+	priceRule.AllowPartialRedemption = false
+	assert.Error(t, voucher.addRedemption("customer-1", "order-5", 10, priceRule, now), "price rule does not allow partial redemption")
+}
+
+func TestPriceRulePartialUsageHistory(t *testing.T) {
+	assert.Equal(t, bson.M{
+		"usagehistory.partialusages":                        1,
+		"usagehistory.redeemedamount":                       30.0,
+		"usagehistory.redeemedamountpercustomer.customer-1": 30.0,
+	}, getPartialUsageIncrements("customer-1", 30))
+	assert.Equal(t, bson.M{
+		"usagehistory.partialusages":  -1,
+		"usagehistory.redeemedamount": -10.0,
+	}, getPartialUsageIncrements("", -10))
+	assert.Equal(t, bson.M{
+		"usagehistory.totalusages":                  -1,
+		"usagehistory.usagespercustomer.customer-1": -1,
+	}, getUsageIncrements("customer-1", -1))
+	assert.Equal(t, bson.M{"usagehistory.totalusages": 1}, getUsageIncrements("", 1))
+	assert.Equal(t, bson.M{
+		"usagehistory.totalusages":    bson.M{"$gte": 1},
+		"usagehistory.redeemedamount": bson.M{"$gte": 10.0 - partialRedemptionEpsilon},
+	}, getRevertConditions(bson.M{"usagehistory.totalusages": -1, "usagehistory.redeemedamount": -10.0}))
+}
+
+func TestPriceRuleRevertUsageHistory(t *testing.T) {
+	assert.NoError(t, RemoveAllPriceRules())
+	priceRule := NewPriceRule("usage-history")
+	assert.NoError(t, priceRule.Upsert())
+
+	assert.NoError(t, UpdatePriceRuleUsageHistoryAtomic(priceRule.ID, "customer-1"))
+	assert.NoError(t, UpdatePriceRuleUsageHistoryAtomic(priceRule.ID, ""))
+	assert.NoError(t, UpdatePriceRuleUsageHistoryPartialAtomic(priceRule.ID, "customer-1", 30, false))
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, RevertPriceRuleUsageHistoryAtomic(priceRule.ID, "customer-1"))
+		assert.NoError(t, RevertPriceRuleUsageHistoryPartialAtomic(priceRule.ID, "customer-1", 30, false))
+	}
+	loaded, err := GetPriceRuleByID(priceRule.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded.UsageHistory.TotalUsages, "times used and times used per customer are reverted together")
+	assert.Equal(t, 0, loaded.UsageHistory.UsagesPerCustomer["customer-1"])
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, RevertPriceRuleUsageHistoryAtomic(priceRule.ID, ""))
+	}
+	loaded, err = GetPriceRuleByID(priceRule.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, loaded.UsageHistory.TotalUsages, "never drops below zero")
+	assert.Equal(t, 0, loaded.UsageHistory.UsagesPerCustomer["customer-1"])
+	assert.Equal(t, 0, loaded.UsageHistory.PartialUsages)
+	assert.Equal(t, 0.0, loaded.UsageHistory.RedeemedAmount)
+	assert.Equal(t, 0.0, loaded.UsageHistory.RedeemedAmountPerCustomer["customer-1"])
+}
+
+func TestCommitDiscountsPartialRedemption(t *testing.T) {
+	assert.NoError(t, RemoveAllPriceRules())
+	assert.NoError(t, RemoveAllVouchers())
+	voucher, priceRule := newPartialBonusVoucher()
+	assert.NoError(t, priceRule.Upsert())
+	assert.NoError(t, voucher.Upsert())
+	orderDiscounts := &OrderDiscounts{
+		"shirt": DiscountCalculationData{
+			AppliedDiscounts: []DiscountApplied{{PriceRuleID: priceRule.ID, VoucherCode: voucher.VoucherCode, DiscountAmountApplicable: 30}},
+		},
+	}
+	getRemainingAmount := func() float64 {
+		loaded, err := GetVoucherByCode(voucher.VoucherCode, nil)
+		assert.NoError(t, err)
+		return loaded.GetRemainingAmount(priceRule)
+	}
+
+	assert.Error(t, CommitDiscounts(orderDiscounts, "customer-1"), "a partial redemption requires an order")
+	assert.Equal(t, 100.0, getRemainingAmount())
+
+	for i := 0; i < 2; i++ {
+		priceRuleIDs, err := CommitDiscountsForOrder(orderDiscounts, "customer-1", "order-1")
+		assert.NoError(t, err)
+		assert.Empty(t, priceRuleIDs, "the usage of voucher price rules is reverted with the voucher")
+	}
+	assert.Equal(t, 70.0, getRemainingAmount(), "the redemption of an order is committed once")
+
+	assert.NoError(t, RevertVoucherRedemptionByCode(voucher.VoucherCode, "customer-1", "order-1"))
+	assert.Equal(t, 100.0, getRemainingAmount())
+}
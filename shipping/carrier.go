@@ -0,0 +1,116 @@
+package shipping
+
+import (
+	"errors"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/order"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Carrier creates parcel labels and reports tracking events
+type Carrier interface {
+	// GetName returns the name which is stored as Shipment.Carrier
+	GetName() string
+	// CreateShipment registers a parcel with the carrier and returns its label
+	CreateShipment(request *ShipmentRequest) (*Label, error)
+	// CancelShipment voids the label of a parcel which has not been handed over yet
+	CancelShipment(trackingNumber string) error
+	// GetTrackingEvents returns all tracking events of a parcel known to the carrier
+	GetTrackingEvents(trackingNumber string) ([]*order.TrackingEvent, error)
+}
+
+// ShipmentRequest describes a parcel to be sent
+type ShipmentRequest struct {
+	OrderId      string
+	Address      *address.Address
+	ShippingMode string
+	Weight       float64 // kg
+	Items        []*order.ShipmentItem
+}
+
+// Label is the parcel label created by a carrier
+type Label struct {
+	TrackingNumber string
+	Format         string // e.g. application/pdf
+	Data           []byte
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// CreateOrderShipment creates a parcel for items of o with carrier and adds the shipment to o
+func CreateOrderShipment(carrier Carrier, o *order.Order, items []*order.ShipmentItem, shippingMode string, weight float64) (*order.Shipment, *Label, error) {
+	if o.CustomerData == nil || o.CustomerData.ShippingAddress == nil {
+		return nil, nil, errors.New("order " + o.GetID() + " has no shipping address")
+	}
+	shipment := order.NewShipment(carrier.GetName(), "")
+	for _, item := range items {
+		shipment.AddItem(item.ItemID, item.Quantity)
+	}
+	// validate before the label is created, so that no label is created for an invalid shipment
+	if err := o.ValidateShipment(shipment); err != nil {
+		return nil, nil, err
+	}
+	label, err := carrier.CreateShipment(&ShipmentRequest{
+		OrderId:      o.GetID(),
+		Address:      o.CustomerData.ShippingAddress,
+		ShippingMode: shippingMode,
+		Weight:       weight,
+		Items:        shipment.Items,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	shipment.TrackingNumber = label.TrackingNumber
+	if err := o.AddShipment(shipment); err != nil {
+		// do not leave a label behind which no shipment refers to
+		if errCancel := carrier.CancelShipment(label.TrackingNumber); errCancel != nil {
+			return nil, nil, multierror.Append(err, errCancel)
+		}
+		return nil, nil, err
+	}
+	return shipment, label, nil
+}
+
+// CancelOrderShipment voids the label of the shipment with id and cancels the shipment in o
+func CancelOrderShipment(carrier Carrier, o *order.Order, id string) error {
+	shipment := o.GetShipmentById(id)
+	if shipment == nil {
+		return errors.New("no shipment with id " + id + " in order " + o.GetID())
+	}
+	if shipment.Carrier != carrier.GetName() {
+		return errors.New("shipment " + id + " has been created with carrier " + shipment.Carrier)
+	}
+	if err := carrier.CancelShipment(shipment.TrackingNumber); err != nil {
+		return err
+	}
+	return o.CancelShipment(id)
+}
+
+// IngestTrackingEvents fetches the tracking events of all shipments of o which have been created with carrier
+// and have not been canceled or returned to the sender, and adds them to o.
+// Delivered shipments are included, because a delivered parcel may still be returned.
+func IngestTrackingEvents(carrier Carrier, o *order.Order) error {
+	var result *multierror.Error
+	for _, shipment := range o.GetShipments() {
+		if shipment.Carrier != carrier.GetName() || shipment.IsCanceled() || shipment.Status == order.ShipmentStatusReturnedToSender {
+			continue
+		}
+		events, err := carrier.GetTrackingEvents(shipment.TrackingNumber)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if err := o.AddTrackingEvents(shipment.TrackingNumber, events); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,104 @@
+package shipping
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/order"
+)
+
+func newTestFileCarrier(t *testing.T) (*FileCarrier, func()) {
+	dir, err := ioutil.TempDir("", "shop-carrier")
+	assert.NoError(t, err)
+	carrier, err := NewFileCarrier("fake", dir)
+	assert.NoError(t, err)
+	carrier.now = func() time.Time { return time.Date(2026, 3, 1, 16, 0, 0, 0, time.UTC) }
+	return carrier, func() { os.RemoveAll(dir) }
+}
+
+func newCarrierTestOrder() *order.Order {
+	o := &order.Order{
+		Id:    "carrier-test",
+		Flags: &order.Flags{},
+		State: order.DefaultStateMachine.GetInitialState(),
+		Positions: []*order.Position{
+			{ItemID: "shirt", Quantity: 2},
+			{ItemID: "shoes", Quantity: 1},
+		},
+		CustomerData: &order.CustomerData{ShippingAddress: &address.Address{CountryCode: "CH"}},
+	}
+	o.UnlinkFromDB()
+	return o
+}
+
+func TestCarrierTracking(t *testing.T) {
+	carrier, cleanup := newTestFileCarrier(t)
+	defer cleanup()
+	o := newCarrierTestOrder()
+
+	shipment, label, err := CreateOrderShipment(carrier, o, []*order.ShipmentItem{{ItemID: "shirt", Quantity: 2}, {ItemID: "shoes", Quantity: 1}}, "standard", 1.8)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, label.TrackingNumber)
+	assert.Equal(t, label.TrackingNumber, shipment.TrackingNumber)
+	assert.Equal(t, "fake", shipment.Carrier)
+	assert.Equal(t, order.PositionStatusShipped, o.GetPositionByItemId("shirt").GetStateKey())
+
+	_, _, err = CreateOrderShipment(carrier, o, []*order.ShipmentItem{{ItemID: "shirt", Quantity: 1}}, "standard", 0.3)
+	assert.Error(t, err, "shirts have been shipped completely")
+
+	start := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	assert.NoError(t, carrier.AddTrackingEvent(label.TrackingNumber, order.ShipmentStatusInTransit, "handed over", "Zurich", start))
+	assert.NoError(t, IngestTrackingEvents(carrier, o))
+	assert.Equal(t, order.ShipmentStatusInTransit, shipment.Status)
+	assert.Len(t, shipment.TrackingEvents, 2, "label created and in transit")
+
+	assert.NoError(t, IngestTrackingEvents(carrier, o))
+	assert.Len(t, shipment.TrackingEvents, 2, "known events are ignored")
+	assert.Error(t, CancelOrderShipment(carrier, o, shipment.Id), "parcel has been handed over")
+
+	assert.NoError(t, carrier.AddTrackingEvent(label.TrackingNumber, order.ShipmentStatusDelivered, "delivered", "Bern", start.Add(20*time.Hour)))
+	assert.NoError(t, IngestTrackingEvents(carrier, o))
+	assert.True(t, shipment.IsDelivered())
+	assert.Equal(t, start.Add(20*time.Hour), shipment.DeliveredAt)
+	assert.Equal(t, 2.0, o.GetDeliveredQuantity("shirt"))
+	assert.Equal(t, order.PositionStatusDelivered, o.GetPositionByItemId("shirt").GetStateKey())
+	assert.Equal(t, order.PositionStatusDelivered, o.GetPositionByItemId("shoes").GetStateKey())
+
+	// the parcel comes back, e.g. because the recipient refused it
+	assert.NoError(t, carrier.AddTrackingEvent(label.TrackingNumber, order.ShipmentStatusReturnedToSender, "refused", "Zurich", start.Add(48*time.Hour)))
+	assert.NoError(t, IngestTrackingEvents(carrier, o))
+	assert.False(t, shipment.IsDelivered())
+	assert.Equal(t, 0.0, o.GetDeliveredQuantity("shirt"))
+	assert.Equal(t, order.PositionStatusShipped, o.GetPositionByItemId("shirt").GetStateKey())
+	assert.Equal(t, order.PositionStatusShipped, o.GetPositionByItemId("shoes").GetStateKey())
+}
+
+func TestCarrierCancelShipment(t *testing.T) {
+	carrier, cleanup := newTestFileCarrier(t)
+	defer cleanup()
+	o := newCarrierTestOrder()
+
+	shipment, label, err := CreateOrderShipment(carrier, o, []*order.ShipmentItem{{ItemID: "shirt", Quantity: 1}}, "standard", 0.3)
+	assert.NoError(t, err)
+	assert.Equal(t, order.PositionStatusPartiallyShipped, o.GetPositionByItemId("shirt").GetStateKey())
+
+	assert.NoError(t, CancelOrderShipment(carrier, o, shipment.Id))
+	assert.True(t, shipment.IsCanceled())
+	assert.Equal(t, 0.0, o.GetShippedQuantity("shirt"))
+	assert.Equal(t, order.PositionStatusOpen, o.GetPositionByItemId("shirt").GetStateKey())
+	assert.Error(t, carrier.AddTrackingEvent(label.TrackingNumber, order.ShipmentStatusInTransit, "", "", time.Now()), "label has been voided")
+	assert.NoError(t, IngestTrackingEvents(carrier, o), "canceled shipments are skipped")
+
+	_, err = carrier.GetTrackingEvents("unknown")
+	assert.Equal(t, ErrorParcelNotFound, err)
+
+	second, _, err := CreateOrderShipment(carrier, o, []*order.ShipmentItem{{ItemID: "shirt", Quantity: 2}}, "standard", 0.6)
+	assert.NoError(t, err)
+	assert.NotEqual(t, label.TrackingNumber, second.TrackingNumber)
+	assert.Equal(t, order.PositionStatusShipped, o.GetPositionByItemId("shirt").GetStateKey())
+}
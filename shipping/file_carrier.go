@@ -0,0 +1,189 @@
+package shipping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/order"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+var ErrorParcelNotFound = errors.New("parcel not found")
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// FileCarrier is a fake carrier for tests and development. Each parcel is a json file in a directory,
+// tracking events are added with AddTrackingEvent or by editing the file.
+type FileCarrier struct {
+	name  string
+	dir   string
+	mutex sync.Mutex
+	now   func() time.Time
+}
+
+type fileParcel struct {
+	TrackingNumber string
+	OrderId        string
+	ShippingMode   string
+	Address        *address.Address
+	Weight         float64
+	Items          []*order.ShipmentItem
+	CreatedAt      time.Time
+	CanceledAt     time.Time
+	Events         []*order.TrackingEvent
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewFileCarrier constructor. dir is created, if it does not exist.
+func NewFileCarrier(name string, dir string) (*FileCarrier, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileCarrier{
+		name: name,
+		dir:  dir,
+		now:  time.Now,
+	}, nil
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+func (c *FileCarrier) GetName() string {
+	return c.name
+}
+
+func (c *FileCarrier) CreateShipment(request *ShipmentRequest) (*Label, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	now := c.now()
+	parcel := &fileParcel{
+		TrackingNumber: fmt.Sprintf("%010d", len(files)+1),
+		OrderId:        request.OrderId,
+		ShippingMode:   request.ShippingMode,
+		Address:        request.Address,
+		Weight:         request.Weight,
+		Items:          request.Items,
+		CreatedAt:      now,
+	}
+	parcel.addEvent(order.ShipmentStatusLabelCreated, "label created", "", now)
+	if err := c.save(parcel); err != nil {
+		return nil, err
+	}
+	return &Label{
+		TrackingNumber: parcel.TrackingNumber,
+		Format:         "text/plain",
+		Data:           []byte(c.name + " " + parcel.TrackingNumber + " order " + parcel.OrderId),
+	}, nil
+}
+
+func (c *FileCarrier) CancelShipment(trackingNumber string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	parcel, err := c.load(trackingNumber)
+	if err != nil {
+		return err
+	}
+	if !parcel.CanceledAt.IsZero() {
+		return nil
+	}
+	for _, event := range parcel.Events {
+		if event.Status != order.ShipmentStatusLabelCreated {
+			return errors.New("parcel " + trackingNumber + " has already been handed over")
+		}
+	}
+	parcel.CanceledAt = c.now()
+	return c.save(parcel)
+}
+
+func (c *FileCarrier) GetTrackingEvents(trackingNumber string) ([]*order.TrackingEvent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	parcel, err := c.load(trackingNumber)
+	if err != nil {
+		return nil, err
+	}
+	return parcel.Events, nil
+}
+
+// AddTrackingEvent simulates a scan of the parcel with trackingNumber
+func (c *FileCarrier) AddTrackingEvent(trackingNumber string, status order.ShipmentStatus, description string, location string, occurredAt time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	parcel, err := c.load(trackingNumber)
+	if err != nil {
+		return err
+	}
+	if !parcel.CanceledAt.IsZero() {
+		return errors.New("parcel " + trackingNumber + " has been canceled")
+	}
+	parcel.addEvent(status, description, location, occurredAt)
+	return c.save(parcel)
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+func (parcel *fileParcel) addEvent(status order.ShipmentStatus, description string, location string, occurredAt time.Time) {
+	parcel.Events = append(parcel.Events, &order.TrackingEvent{
+		Id:          parcel.TrackingNumber + "-" + strconv.Itoa(len(parcel.Events)+1),
+		Status:      status,
+		Description: description,
+		Location:    location,
+		OccurredAt:  occurredAt,
+	})
+}
+
+func (c *FileCarrier) getFilename(trackingNumber string) string {
+	return filepath.Join(c.dir, trackingNumber+".json")
+}
+
+func (c *FileCarrier) load(trackingNumber string) (*fileParcel, error) {
+	data, err := ioutil.ReadFile(c.getFilename(filepath.Base(trackingNumber)))
+	if os.IsNotExist(err) {
+		return nil, ErrorParcelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	parcel := &fileParcel{}
+	if err := json.Unmarshal(data, parcel); err != nil {
+		return nil, err
+	}
+	return parcel, nil
+}
+
+// save writes to a temporary file first, so that a parcel file is never partially written
+func (c *FileCarrier) save(parcel *fileParcel) error {
+	data, err := json.MarshalIndent(parcel, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.getFilename(parcel.TrackingNumber) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.getFilename(parcel.TrackingNumber))
+}
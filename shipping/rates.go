@@ -0,0 +1,234 @@
+package shipping
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/foomo/shop/order"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS / VARS
+//------------------------------------------------------------------
+
+// DefaultShippingItemID is the item id of the shipping position created by ApplyToOrder
+const DefaultShippingItemID = "shipping"
+
+var (
+	ErrorNoZone = errors.New("no shipping zone for country")
+	ErrorNoRate = errors.New("no shipping rate for weight and volume")
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+// Zone groups countries with the same shipping rates
+type Zone struct {
+	Id           string
+	CountryCodes []string
+}
+
+// RateBracket is the price of a parcel up to a weight and a volume
+type RateBracket struct {
+	MaxWeight float64 // kg, zero if unlimited
+	MaxVolume float64 // liters, zero if unlimited
+	Price     float64
+}
+
+// RateTable holds the shipping rates of a zone
+type RateTable struct {
+	Zone                  string
+	Brackets              []*RateBracket     // the cheapest matching bracket is used
+	Surcharges            map[string]float64 // shipping mode => surcharge, e.g. for express delivery. Other modes are not available.
+	FreeShippingThreshold float64            // order total after discounts, without shipping, from which the bracket price is waived. Zero if there is no free shipping.
+}
+
+// RateRequest describes a parcel to be shipped
+type RateRequest struct {
+	CountryCode  string
+	ShippingMode string
+	Weight       float64
+	Volume       float64
+	OrderTotal   float64 // total of the items after discounts, used for the free shipping threshold
+}
+
+// Rate is the calculated shipping cost. Surcharges are charged on free shipping as well.
+type Rate struct {
+	Zone         string
+	ShippingMode string
+	BasePrice    float64 // price of the bracket, even if it is waived
+	Surcharge    float64
+	Price        float64 // price to be paid
+	IsFree       bool    // the base price is waived
+}
+
+// ItemDimensionsProvider returns the weight (kg) and volume (liters) of a single item
+type ItemDimensionsProvider interface {
+	GetItemDimensions(itemID string) (weight float64, volume float64, err error)
+}
+
+// RateEngine calculates shipping costs from rate tables per zone
+type RateEngine struct {
+	ShippingItemID string // item id of the shipping position, DefaultShippingItemID if empty
+	zones          map[string]*Zone
+	countryZones   map[string]string
+	tables         map[string]*RateTable
+}
+
+//------------------------------------------------------------------
+// ~ CONSTRUCTOR
+//------------------------------------------------------------------
+
+// NewRateEngine constructor
+func NewRateEngine() *RateEngine {
+	return &RateEngine{
+		zones:        map[string]*Zone{},
+		countryZones: map[string]string{},
+		tables:       map[string]*RateTable{},
+	}
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// AddZone registers zone. A country belongs to the zone which has been added last for it.
+func (e *RateEngine) AddZone(zone *Zone) {
+	e.zones[zone.Id] = zone
+	for _, countryCode := range zone.CountryCodes {
+		e.countryZones[strings.ToUpper(countryCode)] = zone.Id
+	}
+}
+
+// AddRateTable registers the rate table of a zone
+func (e *RateEngine) AddRateTable(table *RateTable) error {
+	if _, ok := e.zones[table.Zone]; !ok {
+		return errors.New("unknown shipping zone " + table.Zone)
+	}
+	e.tables[table.Zone] = table
+	return nil
+}
+
+// GetZone returns the zone of countryCode
+func (e *RateEngine) GetZone(countryCode string) (*Zone, error) {
+	zoneId, ok := e.countryZones[strings.ToUpper(countryCode)]
+	if !ok {
+		return nil, ErrorNoZone
+	}
+	return e.zones[zoneId], nil
+}
+
+// CalculateRate returns the shipping cost of request
+func (e *RateEngine) CalculateRate(request *RateRequest) (*Rate, error) {
+	zone, err := e.GetZone(request.CountryCode)
+	if err != nil {
+		return nil, err
+	}
+	table, ok := e.tables[zone.Id]
+	if !ok {
+		return nil, ErrorNoRate
+	}
+	bracket := table.getBracket(request.Weight, request.Volume)
+	if bracket == nil {
+		return nil, ErrorNoRate
+	}
+	rate := &Rate{
+		Zone:         zone.Id,
+		ShippingMode: request.ShippingMode,
+		BasePrice:    bracket.Price,
+	}
+	if request.ShippingMode != "" {
+		surcharge, ok := table.Surcharges[request.ShippingMode]
+		if !ok {
+			return nil, errors.New("shipping mode " + request.ShippingMode + " not available in zone " + zone.Id)
+		}
+		rate.Surcharge = surcharge
+	}
+	rate.Price = rate.BasePrice + rate.Surcharge
+	if table.FreeShippingThreshold > 0 && request.OrderTotal >= table.FreeShippingThreshold {
+		rate.IsFree = true
+		rate.Price = rate.Surcharge
+	}
+	return rate, nil
+}
+
+// ApplyToOrder calculates the shipping cost of all positions of o to its shipping address and sets the price and cross price of
+// the shipping position, which is created if o has none. The order total for the free shipping threshold is calculated
+// by pricer without the shipping position, e.g. with order.PriceRulePricer after discounts. If pricer is nil, the positions are summed up.
+// The order is not stored.
+func (e *RateEngine) ApplyToOrder(o *order.Order, dimensions ItemDimensionsProvider, shippingMode string, pricer order.Pricer) (*Rate, error) {
+	if o.CustomerData == nil || o.CustomerData.ShippingAddress == nil {
+		return nil, errors.New("order " + o.GetID() + " has no shipping address")
+	}
+	request := &RateRequest{
+		CountryCode:  o.CustomerData.ShippingAddress.CountryCode,
+		ShippingMode: shippingMode,
+	}
+	var shippingPosition *order.Position
+	itemPositions := []*order.Position{}
+	for _, position := range o.GetPositions() {
+		if position.IsShipping {
+			shippingPosition = position
+			continue
+		}
+		weight, volume, err := dimensions.GetItemDimensions(position.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		request.Weight += weight * position.Quantity
+		request.Volume += volume * position.Quantity
+		itemPositions = append(itemPositions, position)
+	}
+	orderTotal, err := getItemsTotal(o, itemPositions, pricer)
+	if err != nil {
+		return nil, err
+	}
+	request.OrderTotal = orderTotal
+	rate, err := e.CalculateRate(request)
+	if err != nil {
+		return nil, err
+	}
+	if shippingPosition == nil {
+		itemID := e.ShippingItemID
+		if itemID == "" {
+			itemID = DefaultShippingItemID
+		}
+		shippingPosition = &order.Position{ItemID: itemID, IsShipping: true, Quantity: 1}
+		o.Positions = append(o.Positions, shippingPosition)
+	}
+	shippingPosition.Price = rate.Price
+	shippingPosition.CrossPrice = rate.BasePrice + rate.Surcharge
+	return rate, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// getItemsTotal returns the total of o with itemPositions only, so that the shipping cost does not count towards the free shipping threshold
+func getItemsTotal(o *order.Order, itemPositions []*order.Position, pricer order.Pricer) (float64, error) {
+	if pricer == nil {
+		pricer = &order.PositionsPricer{}
+	}
+	positions := o.Positions
+	o.Positions = itemPositions
+	defer func() {
+		o.Positions = positions
+	}()
+	return pricer.CalculateTotal(o)
+}
+
+// getBracket returns the cheapest bracket for weight and volume
+func (table *RateTable) getBracket(weight float64, volume float64) *RateBracket {
+	brackets := make([]*RateBracket, len(table.Brackets))
+	copy(brackets, table.Brackets)
+	sort.SliceStable(brackets, func(i, j int) bool { return brackets[i].Price < brackets[j].Price })
+	for _, bracket := range brackets {
+		if (bracket.MaxWeight == 0 || weight <= bracket.MaxWeight) && (bracket.MaxVolume == 0 || volume <= bracket.MaxVolume) {
+			return bracket
+		}
+	}
+	return nil
+}
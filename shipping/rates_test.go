@@ -0,0 +1,120 @@
+package shipping
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foomo/shop/address"
+	"github.com/foomo/shop/order"
+)
+
+type testDimensions map[string][2]float64
+
+// testDiscountPricer subtracts a fixed discount from the positions total
+type testDiscountPricer float64
+
+func (p testDiscountPricer) CalculateTotal(o *order.Order) (float64, error) {
+	total, err := (&order.PositionsPricer{}).CalculateTotal(o)
+	return total - float64(p), err
+}
+
+func (d testDimensions) GetItemDimensions(itemID string) (float64, float64, error) {
+	dimensions, ok := d[itemID]
+	if !ok {
+		return 0, 0, errors.New("unknown item " + itemID)
+	}
+	return dimensions[0], dimensions[1], nil
+}
+
+func newTestRateEngine(t *testing.T) *RateEngine {
+	engine := NewRateEngine()
+	engine.AddZone(&Zone{Id: "domestic", CountryCodes: []string{"CH", "LI"}})
+	engine.AddZone(&Zone{Id: "europe", CountryCodes: []string{"DE", "AT", "FR", "IT"}})
+	assert.NoError(t, engine.AddRateTable(&RateTable{
+		Zone: "domestic",
+		Brackets: []*RateBracket{
+			{MaxWeight: 2, MaxVolume: 10, Price: 7},
+			{MaxWeight: 10, MaxVolume: 50, Price: 9},
+			{MaxWeight: 30, Price: 20},
+		},
+		Surcharges:            map[string]float64{"standard": 0, "express": 12},
+		FreeShippingThreshold: 100,
+	}))
+	assert.NoError(t, engine.AddRateTable(&RateTable{
+		Zone:       "europe",
+		Brackets:   []*RateBracket{{MaxWeight: 30, Price: 35}},
+		Surcharges: map[string]float64{"standard": 0},
+	}))
+	assert.Error(t, engine.AddRateTable(&RateTable{Zone: "world"}))
+	return engine
+}
+
+func TestCalculateRate(t *testing.T) {
+	engine := newTestRateEngine(t)
+
+	rate, err := engine.CalculateRate(&RateRequest{CountryCode: "ch", ShippingMode: "standard", Weight: 1, Volume: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, "domestic", rate.Zone)
+	assert.Equal(t, 7.0, rate.Price)
+
+	rate, err = engine.CalculateRate(&RateRequest{CountryCode: "CH", ShippingMode: "standard", Weight: 1, Volume: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 9.0, rate.Price, "volume bracket")
+
+	rate, err = engine.CalculateRate(&RateRequest{CountryCode: "CH", ShippingMode: "express", Weight: 12, OrderTotal: 150})
+	assert.NoError(t, err)
+	assert.True(t, rate.IsFree)
+	assert.Equal(t, 20.0, rate.BasePrice)
+	assert.Equal(t, 12.0, rate.Price, "surcharge is charged on free shipping")
+
+	_, err = engine.CalculateRate(&RateRequest{CountryCode: "CH", Weight: 31})
+	assert.Equal(t, ErrorNoRate, err)
+	_, err = engine.CalculateRate(&RateRequest{CountryCode: "US", Weight: 1})
+	assert.Equal(t, ErrorNoZone, err)
+	_, err = engine.CalculateRate(&RateRequest{CountryCode: "DE", ShippingMode: "express", Weight: 1})
+	assert.Error(t, err, "express is not available in europe")
+}
+
+func TestApplyToOrder(t *testing.T) {
+	engine := newTestRateEngine(t)
+	dimensions := testDimensions{"shirt": {0.3, 1}, "shoes": {1.2, 9}}
+	o := &order.Order{
+		Id:    "shipping-test",
+		Flags: &order.Flags{},
+		Positions: []*order.Position{
+			{ItemID: "shirt", Quantity: 2, Price: 30},
+			{ItemID: "shoes", Quantity: 1, Price: 30},
+		},
+		CustomerData: &order.CustomerData{ShippingAddress: &address.Address{CountryCode: "CH"}},
+	}
+	o.UnlinkFromDB()
+
+	rate, err := engine.ApplyToOrder(o, dimensions, "standard", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 9.0, rate.Price, "1.8kg and 11l")
+	itemID, err := o.GetItemIDPositionShipping()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultShippingItemID, itemID)
+	assert.Equal(t, 9.0, o.GetPositionByItemId(itemID).Price)
+	o.GetPositionByItemId(itemID).Name = "Versand"
+
+	o.GetPositionByItemId("shirt").Quantity = 3
+	_, err = engine.ApplyToOrder(o, dimensions, "standard", nil)
+	assert.NoError(t, err)
+	assert.Len(t, o.Positions, 3, "shipping position is updated")
+	assert.Equal(t, 0.0, o.GetPositionByItemId(itemID).Price, "free shipping from 100")
+	assert.Equal(t, 9.0, o.GetPositionByItemId(itemID).CrossPrice)
+	assert.Equal(t, "Versand", o.GetPositionByItemId(itemID).Name, "only prices are set")
+
+	rate, err = engine.ApplyToOrder(o, dimensions, "express", testDiscountPricer(20))
+	assert.NoError(t, err)
+	assert.True(t, rate.IsFree, "120 before and 100 after discounts, without the shipping cost")
+	assert.Equal(t, 12.0, o.GetPositionByItemId(itemID).Price, "surcharge is charged on free shipping")
+	rate, err = engine.ApplyToOrder(o, dimensions, "express", testDiscountPricer(21))
+	assert.NoError(t, err)
+	assert.False(t, rate.IsFree, "the threshold applies after discounts")
+	assert.Equal(t, 21.0, o.GetPositionByItemId(itemID).Price)
+	assert.Len(t, o.Positions, 3, "positions are restored")
+}
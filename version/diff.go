@@ -0,0 +1,368 @@
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//------------------------------------------------------------------
+// ~ CONSTANTS
+//------------------------------------------------------------------
+
+const (
+	ChangeTypeAdded   ChangeType = "added"
+	ChangeTypeRemoved ChangeType = "removed"
+	ChangeTypeChanged ChangeType = "changed"
+
+	PatchOpAdd     = "add"
+	PatchOpRemove  = "remove"
+	PatchOpReplace = "replace"
+	PatchOpMove    = "move"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC TYPES
+//------------------------------------------------------------------
+
+type ChangeType string
+
+// DiffOptions control how two versions are compared.
+// Schema paths are field names joined by "." without array indices, e.g. "Positions" or "Shipments.Items".
+type DiffOptions struct {
+	ArrayKeys   map[string]string // schema path of an array => field used to match its elements, e.g. "Positions" => "ItemID"
+	IgnorePaths []string          // schema paths which are not compared, e.g. "Version"
+}
+
+// StructuredDiff is a machine readable diff between two versions
+type StructuredDiff struct {
+	Patch   []*PatchOperation // RFC 6902 JSON Patch which transforms version A into version B
+	Changes []*Change         // field level changes
+}
+
+// PatchOperation is a single RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// Change is a single field level change.
+// Elements of keyed arrays are addressed by their key, e.g. Positions[itemID=X].Quantity
+type Change struct {
+	Path     string
+	Type     ChangeType
+	OldValue interface{} `json:",omitempty"`
+	NewValue interface{} `json:",omitempty"`
+}
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// DiffVersionsStructured compares two structs and returns a JSON Patch and a list of field level changes.
+// The structs are compared by their JSON representation.
+func DiffVersionsStructured(versionA interface{}, versionB interface{}, options *DiffOptions) (*StructuredDiff, error) {
+	docA, err := toJSONDocument(versionA)
+	if err != nil {
+		return nil, err
+	}
+	docB, err := toJSONDocument(versionB)
+	if err != nil {
+		return nil, err
+	}
+	if options == nil {
+		options = &DiffOptions{}
+	}
+	d := &differ{
+		options: options,
+		diff: &StructuredDiff{
+			Patch:   []*PatchOperation{},
+			Changes: []*Change{},
+		},
+	}
+	d.compare(docA, docB, "", "", "")
+	return d.diff, nil
+}
+
+// IsEmpty returns true if there are no differences
+func (diff *StructuredDiff) IsEmpty() bool {
+	return len(diff.Patch) == 0
+}
+
+// MarshalJSON renders the operation as specified in RFC 6902
+func (op *PatchOperation) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{
+		"op":   op.Op,
+		"path": op.Path,
+	}
+	switch op.Op {
+	case PatchOpAdd, PatchOpReplace:
+		m["value"] = op.Value
+	case PatchOpMove:
+		m["from"] = op.From
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON reads an operation as specified in RFC 6902
+func (op *PatchOperation) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		From  string      `json:"from"`
+		Value interface{} `json:"value"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	op.Op, op.Path, op.From, op.Value = raw.Op, raw.Path, raw.From, raw.Value
+	return nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE TYPES
+//------------------------------------------------------------------
+
+type differ struct {
+	options *DiffOptions
+	diff    *StructuredDiff
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE METHODS
+//------------------------------------------------------------------
+
+// compare appends the differences of a and b.
+// pointer is the JSON pointer, path the human readable path and schemaPath the path without array indices.
+func (d *differ) compare(a interface{}, b interface{}, pointer string, path string, schemaPath string) {
+	if d.isIgnored(schemaPath) {
+		return
+	}
+	switch typedA := a.(type) {
+	case map[string]interface{}:
+		if typedB, ok := b.(map[string]interface{}); ok {
+			d.compareObjects(typedA, typedB, pointer, path, schemaPath)
+			return
+		}
+	case []interface{}:
+		if typedB, ok := b.([]interface{}); ok {
+			if key, ok := d.options.ArrayKeys[schemaPath]; ok && hasKeys(typedA, key) && hasKeys(typedB, key) {
+				d.compareKeyedArrays(typedA, typedB, key, pointer, path, schemaPath)
+			} else {
+				d.compareArrays(typedA, typedB, pointer, path, schemaPath)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		d.replace(pointer, path, a, b)
+	}
+}
+
+func (d *differ) compareObjects(a map[string]interface{}, b map[string]interface{}, pointer string, path string, schemaPath string) {
+	for _, key := range sortedKeys(a, b) {
+		childSchemaPath := joinPath(schemaPath, key)
+		if d.isIgnored(childSchemaPath) {
+			continue
+		}
+		childPointer := pointer + "/" + escapePointer(key)
+		childPath := joinPath(path, key)
+		valueA, okA := a[key]
+		valueB, okB := b[key]
+		switch {
+		case okA && !okB:
+			d.remove(childPointer, childPath, valueA)
+		case !okA && okB:
+			d.add(childPointer, childPath, valueB)
+		default:
+			d.compare(valueA, valueB, childPointer, childPath, childSchemaPath)
+		}
+	}
+}
+
+// compareArrays compares elements by their index
+func (d *differ) compareArrays(a []interface{}, b []interface{}, pointer string, path string, schemaPath string) {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		d.compare(a[i], b[i], pointer+"/"+strconv.Itoa(i), path+"["+strconv.Itoa(i)+"]", schemaPath)
+	}
+	// remove from the end, so that indices of the patch stay valid
+	for i := len(a) - 1; i >= len(b); i-- {
+		d.remove(pointer+"/"+strconv.Itoa(i), path+"["+strconv.Itoa(i)+"]", a[i])
+	}
+	for i := len(a); i < len(b); i++ {
+		d.add(pointer+"/"+strconv.Itoa(i), path+"["+strconv.Itoa(i)+"]", b[i])
+	}
+}
+
+// compareKeyedArrays matches elements by the value of key. The patch operations are generated in an order,
+// which keeps array indices valid when they are applied sequentially: removals, then moves and additions, then changes.
+func (d *differ) compareKeyedArrays(a []interface{}, b []interface{}, key string, pointer string, path string, schemaPath string) {
+	label := keyLabel(key)
+	keysB := map[string]bool{}
+	for _, element := range b {
+		keysB[elementKey(element, key)] = true
+	}
+
+	// removed elements, highest index first
+	current := []interface{}{}
+	for _, element := range a {
+		if keysB[elementKey(element, key)] {
+			current = append(current, element)
+		}
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		k := elementKey(a[i], key)
+		if !keysB[k] {
+			d.remove(pointer+"/"+strconv.Itoa(i), keyedPath(path, label, k), a[i])
+		}
+	}
+
+	for i, elementB := range b {
+		k := elementKey(elementB, key)
+		elementPath := keyedPath(path, label, k)
+		elementPointer := pointer + "/" + strconv.Itoa(i)
+		j := indexOfKey(current, key, k)
+		if j == -1 {
+			d.add(elementPointer, elementPath, elementB)
+			current = insertAt(current, i, elementB)
+			continue
+		}
+		if j != i {
+			d.diff.Patch = append(d.diff.Patch, &PatchOperation{Op: PatchOpMove, From: pointer + "/" + strconv.Itoa(j), Path: elementPointer})
+			element := current[j]
+			current = insertAt(removeAt(current, j), i, element)
+		}
+		d.compare(current[i], elementB, elementPointer, elementPath, schemaPath)
+	}
+}
+
+func (d *differ) add(pointer string, path string, value interface{}) {
+	d.diff.Patch = append(d.diff.Patch, &PatchOperation{Op: PatchOpAdd, Path: pointer, Value: value})
+	d.diff.Changes = append(d.diff.Changes, &Change{Path: path, Type: ChangeTypeAdded, NewValue: value})
+}
+
+func (d *differ) remove(pointer string, path string, value interface{}) {
+	d.diff.Patch = append(d.diff.Patch, &PatchOperation{Op: PatchOpRemove, Path: pointer})
+	d.diff.Changes = append(d.diff.Changes, &Change{Path: path, Type: ChangeTypeRemoved, OldValue: value})
+}
+
+func (d *differ) replace(pointer string, path string, oldValue interface{}, newValue interface{}) {
+	d.diff.Patch = append(d.diff.Patch, &PatchOperation{Op: PatchOpReplace, Path: pointer, Value: newValue})
+	d.diff.Changes = append(d.diff.Changes, &Change{Path: path, Type: ChangeTypeChanged, OldValue: oldValue, NewValue: newValue})
+}
+
+func (d *differ) isIgnored(schemaPath string) bool {
+	for _, ignored := range d.options.IgnorePaths {
+		if ignored == schemaPath {
+			return true
+		}
+	}
+	return false
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE FUNCTIONS
+//------------------------------------------------------------------
+
+// toJSONDocument converts v into a generic tree of maps, slices and values
+func toJSONDocument(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(data, &doc)
+	return doc, err
+}
+
+func sortedKeys(a map[string]interface{}, b map[string]interface{}) []string {
+	keys := []string{}
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasKeys returns true if all elements are objects with a unique value for key
+func hasKeys(elements []interface{}, key string) bool {
+	seen := map[string]bool{}
+	for _, element := range elements {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := object[key]; !ok {
+			return false
+		}
+		k := elementKey(element, key)
+		if seen[k] {
+			return false
+		}
+		seen[k] = true
+	}
+	return true
+}
+
+func elementKey(element interface{}, key string) string {
+	object, _ := element.(map[string]interface{})
+	return fmt.Sprint(object[key])
+}
+
+func indexOfKey(elements []interface{}, key string, value string) int {
+	for i, element := range elements {
+		if elementKey(element, key) == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func insertAt(elements []interface{}, i int, element interface{}) []interface{} {
+	elements = append(elements, nil)
+	copy(elements[i+1:], elements[i:])
+	elements[i] = element
+	return elements
+}
+
+func removeAt(elements []interface{}, i int) []interface{} {
+	return append(elements[:i:i], elements[i+1:]...)
+}
+
+func joinPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func keyedPath(path string, label string, value string) string {
+	return path + "[" + label + "=" + value + "]"
+}
+
+// keyLabel returns key with a lower case first character, e.g. ItemID => itemID
+func keyLabel(key string) string {
+	if key == "" {
+		return key
+	}
+	return strings.ToLower(key[:1]) + key[1:]
+}
+
+// escapePointer escapes a reference token as specified in RFC 6901
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+func unescapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+}
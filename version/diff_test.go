@@ -0,0 +1,91 @@
+package version
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPosition struct {
+	ItemID   string
+	Quantity float64
+}
+
+type testDocument struct {
+	Site      string
+	Coupons   []string
+	Positions []*testPosition
+	Custom    map[string]interface{}
+}
+
+var testDiffOptions = &DiffOptions{
+	ArrayKeys: map[string]string{"Positions": "ItemID"},
+}
+
+func TestDiffVersionsStructuredKeyedPositions(t *testing.T) {
+	a := &testDocument{
+		Site:    "globus",
+		Coupons: []string{"A", "B"},
+		Positions: []*testPosition{
+			{ItemID: "x", Quantity: 1},
+			{ItemID: "y", Quantity: 2},
+			{ItemID: "z", Quantity: 3},
+		},
+		Custom: map[string]interface{}{"a/b": 1.0, "gone": true},
+	}
+	b := &testDocument{
+		Site:    "navyboot",
+		Coupons: []string{"A"},
+		Positions: []*testPosition{
+			{ItemID: "n", Quantity: 5},
+			{ItemID: "z", Quantity: 3},
+			{ItemID: "x", Quantity: 4},
+		},
+		Custom: map[string]interface{}{"a/b": 2.0},
+	}
+
+	diff, err := DiffVersionsStructured(a, b, testDiffOptions)
+	assert.NoError(t, err)
+
+	changes := map[string]*Change{}
+	for _, change := range diff.Changes {
+		changes[change.Path] = change
+	}
+	assert.Equal(t, ChangeTypeChanged, changes["Positions[itemID=x].Quantity"].Type)
+	assert.Equal(t, 1.0, changes["Positions[itemID=x].Quantity"].OldValue)
+	assert.Equal(t, 4.0, changes["Positions[itemID=x].Quantity"].NewValue)
+	assert.Equal(t, ChangeTypeRemoved, changes["Positions[itemID=y]"].Type)
+	assert.Equal(t, ChangeTypeAdded, changes["Positions[itemID=n]"].Type)
+	assert.Nil(t, changes["Positions[itemID=z]"], "z was only moved")
+	assert.Equal(t, ChangeTypeRemoved, changes["Coupons[1]"].Type)
+	assert.Equal(t, ChangeTypeChanged, changes["Site"].Type)
+	assert.Equal(t, ChangeTypeRemoved, changes["Custom.gone"].Type)
+
+	// the patch must transform a into b
+	patched := &testDocument{}
+	assert.NoError(t, ApplyPatch(a, diff.Patch, patched))
+	assert.Equal(t, b, patched)
+
+	// and must survive a JSON round trip
+	data, err := json.Marshal(diff.Patch)
+	assert.NoError(t, err)
+	patch := []*PatchOperation{}
+	assert.NoError(t, json.Unmarshal(data, &patch))
+	patched = &testDocument{}
+	assert.NoError(t, ApplyPatch(a, patch, patched))
+	assert.Equal(t, b, patched)
+}
+
+func TestDiffVersionsStructuredIgnoreAndEqual(t *testing.T) {
+	a := &testDocument{Site: "a", Positions: []*testPosition{{ItemID: "x", Quantity: 1}}}
+	b := &testDocument{Site: "b", Positions: []*testPosition{{ItemID: "x", Quantity: 1}}}
+
+	diff, err := DiffVersionsStructured(a, b, &DiffOptions{IgnorePaths: []string{"Site"}})
+	assert.NoError(t, err)
+	assert.True(t, diff.IsEmpty())
+
+	diff, err = DiffVersionsStructured(a, a, nil)
+	assert.NoError(t, err)
+	assert.True(t, diff.IsEmpty())
+}
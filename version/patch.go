@@ -0,0 +1,184 @@
+package version
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//------------------------------------------------------------------
+// ~ PUBLIC METHODS
+//------------------------------------------------------------------
+
+// ApplyPatch applies a JSON Patch (operations add, remove, replace and move) on the JSON representation of versionA
+// and unmarshals the result into target.
+func ApplyPatch(versionA interface{}, patch []*PatchOperation, target interface{}) error {
+	doc, err := toJSONDocument(versionA)
+	if err != nil {
+		return err
+	}
+	doc, err = ApplyPatchToDocument(doc, patch)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// ApplyPatchToDocument applies a JSON Patch on a generic JSON document (maps, slices and values) and returns the result
+func ApplyPatchToDocument(doc interface{}, patch []*PatchOperation) (interface{}, error) {
+	var err error
+	for _, op := range patch {
+		switch op.Op {
+		case PatchOpAdd:
+			doc, err = setValue(doc, op.Path, op.Value, true)
+		case PatchOpReplace:
+			doc, err = setValue(doc, op.Path, op.Value, false)
+		case PatchOpRemove:
+			doc, _, err = removeValue(doc, op.Path)
+		case PatchOpMove:
+			var value interface{}
+			doc, value, err = removeValue(doc, op.From)
+			if err == nil {
+				doc, err = setValue(doc, op.Path, value, true)
+			}
+		default:
+			err = errors.New("unsupported patch operation " + op.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+//------------------------------------------------------------------
+// ~ PRIVATE FUNCTIONS
+//------------------------------------------------------------------
+
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("invalid JSON pointer " + pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = unescapePointer(token)
+	}
+	return tokens, nil
+}
+
+// setValue sets value at pointer. If insert is true, values are inserted into arrays, otherwise replaced.
+func setValue(doc interface{}, pointer string, value interface{}, insert bool) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return setValueRecursive(doc, tokens, value, insert, pointer)
+}
+
+func setValueRecursive(node interface{}, tokens []string, value interface{}, insert bool, pointer string) (interface{}, error) {
+	token := tokens[0]
+	last := len(tokens) == 1
+	switch typedNode := node.(type) {
+	case map[string]interface{}:
+		if last {
+			if _, ok := typedNode[token]; !ok && !insert {
+				return nil, errors.New("cannot replace missing value at " + pointer)
+			}
+			typedNode[token] = value
+			return typedNode, nil
+		}
+		child, ok := typedNode[token]
+		if !ok {
+			return nil, errors.New("path not found: " + pointer)
+		}
+		child, err := setValueRecursive(child, tokens[1:], value, insert, pointer)
+		if err != nil {
+			return nil, err
+		}
+		typedNode[token] = child
+		return typedNode, nil
+	case []interface{}:
+		if last && insert && token == "-" {
+			return append(typedNode, value), nil
+		}
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i > len(typedNode) || (i == len(typedNode) && !(last && insert)) {
+			return nil, errors.New("invalid array index in " + pointer)
+		}
+		if last {
+			if insert {
+				return insertAt(typedNode, i, value), nil
+			}
+			typedNode[i] = value
+			return typedNode, nil
+		}
+		child, err := setValueRecursive(typedNode[i], tokens[1:], value, insert, pointer)
+		if err != nil {
+			return nil, err
+		}
+		typedNode[i] = child
+		return typedNode, nil
+	}
+	return nil, errors.New("path not found: " + pointer)
+}
+
+// removeValue removes the value at pointer and returns the modified document and the removed value
+func removeValue(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	return removeValueRecursive(doc, tokens, pointer)
+}
+
+func removeValueRecursive(node interface{}, tokens []string, pointer string) (interface{}, interface{}, error) {
+	token := tokens[0]
+	last := len(tokens) == 1
+	switch typedNode := node.(type) {
+	case map[string]interface{}:
+		child, ok := typedNode[token]
+		if !ok {
+			return nil, nil, errors.New("path not found: " + pointer)
+		}
+		if last {
+			delete(typedNode, token)
+			return typedNode, child, nil
+		}
+		child, removed, err := removeValueRecursive(child, tokens[1:], pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		typedNode[token] = child
+		return typedNode, removed, nil
+	case []interface{}:
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= len(typedNode) {
+			return nil, nil, errors.New("invalid array index in " + pointer)
+		}
+		if last {
+			removed := typedNode[i]
+			return removeAt(typedNode, i), removed, nil
+		}
+		child, removed, err := removeValueRecursive(typedNode[i], tokens[1:], pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		typedNode[i] = child
+		return typedNode, removed, nil
+	}
+	return nil, nil, errors.New("path not found: " + pointer)
+}
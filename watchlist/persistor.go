@@ -147,12 +147,23 @@ func GetCustomerWatchListsBySessionID(sessionID string) (*CustomerWatchLists, er
 }
 
 func (cw *CustomerWatchLists) Upsert() error {
+	if cw.unlinkDB {
+		return nil
+	}
 	session, collection := GetWatchListPersistor().GetCollection()
 	defer session.Close()
 	_, err := collection.UpsertId(cw.BsonId, cw)
 	return err
 }
 
+// Unlinks watch lists from database. No peristent changes are performed until they are linked again.
+func (cw *CustomerWatchLists) UnlinkFromDB() {
+	cw.unlinkDB = true
+}
+func (cw *CustomerWatchLists) LinkDB() {
+	cw.unlinkDB = false
+}
+
 //------------------------------------------------------------------
 // ~ PRIVATE METHODS
 //------------------------------------------------------------------
@@ -14,6 +14,7 @@ type CustomerWatchLists struct {
 	AddrKey   string        `bson:"addrkey"`
 	SessionID string        `bson:"sessionID"`
 	Lists     []*WatchList  `bson:"lists"`
+	unlinkDB  bool          // if true, changes are not stored in database
 }
 
 type WatchList struct {